@@ -16,7 +16,7 @@ var addCmd = &cobra.Command{
 	Aliases: []string{"manage"},
 	Args:    cobra.MinimumNArgs(1),
 	Short:   "Add an existing file, directory, or symlink to the source state",
-	RunE:    makeRunE(config.runAddCmd),
+	RunE:    makeLockedRunE(config.runAddCmd),
 }
 
 type addCmdConfig struct {
@@ -30,6 +30,7 @@ func init() {
 
 	persistentFlags := addCmd.PersistentFlags()
 	persistentFlags.BoolVarP(&config.add.options.Empty, "empty", "e", false, "add empty files")
+	persistentFlags.BoolVar(&config.add.options.Encrypt, "encrypt", false, "encrypt files with gpg")
 	persistentFlags.BoolVarP(&config.add.options.Exact, "exact", "x", false, "add directories exactly")
 	persistentFlags.BoolVarP(&config.add.prompt, "prompt", "p", false, "prompt before adding")
 	persistentFlags.BoolVarP(&config.add.recursive, "recursive", "r", false, "recurse in to subdirectories")
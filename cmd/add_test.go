@@ -50,11 +50,12 @@ func TestAddAfterModification(t *testing.T) {
 
 func TestAddCommand(t *testing.T) {
 	for _, tc := range []struct {
-		name  string
-		args  []string
-		add   addCmdConfig
-		root  interface{}
-		tests interface{}
+		name       string
+		args       []string
+		add        addCmdConfig
+		encryption string
+		root       interface{}
+		tests      interface{}
 	}{
 		{
 			name: "add_first_file",
@@ -93,6 +94,53 @@ func TestAddCommand(t *testing.T) {
 				),
 			},
 		},
+		{
+			name: "add_encrypted",
+			args: []string{"/home/user/.netrc"},
+			add: addCmdConfig{
+				options: chezmoi.AddOptions{
+					Encrypt: true,
+				},
+			},
+			encryption: "reverse",
+			root: map[string]interface{}{
+				"/home/user":          &vfst.Dir{Perm: 0755},
+				"/home/user/.chezmoi": &vfst.Dir{Perm: 0700},
+				"/home/user/.netrc":   "machine example.com\n",
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.chezmoi/encrypted_dot_netrc",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("\nmoc.elpmaxe enihcam"),
+				),
+			},
+		},
+		{
+			name: "add_encrypted_recursive",
+			args: []string{"/home/user/.ssh"},
+			add: addCmdConfig{
+				options: chezmoi.AddOptions{
+					Encrypt: true,
+				},
+				recursive: true,
+			},
+			encryption: "reverse",
+			root: map[string]interface{}{
+				"/home/user":             &vfst.Dir{Perm: 0755},
+				"/home/user/.chezmoi":    &vfst.Dir{Perm: 0700},
+				"/home/user/.ssh":        &vfst.Dir{Perm: 0700},
+				"/home/user/.ssh/id_rsa": "key",
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.chezmoi/private_dot_ssh",
+					vfst.TestIsDir,
+				),
+				vfst.TestPath("/home/user/.chezmoi/private_dot_ssh/encrypted_id_rsa",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("yek"),
+				),
+			},
+		},
 		{
 			name: "add_recursive",
 			args: []string{"/home/user/.config"},
@@ -191,6 +239,40 @@ func TestAddCommand(t *testing.T) {
 				),
 			},
 		},
+		{
+			name: "add_whitespace_file",
+			args: []string{"/home/user/whitespace"},
+			add: addCmdConfig{
+				options: chezmoi.AddOptions{
+					Empty: true,
+				},
+			},
+			root: map[string]interface{}{
+				"/home/user":            &vfst.Dir{Perm: 0755},
+				"/home/user/.chezmoi":   &vfst.Dir{Perm: 0700},
+				"/home/user/whitespace": "\n",
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.chezmoi/empty_whitespace",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("\n"),
+				),
+			},
+		},
+		{
+			name: "add_whitespace_file_without_empty",
+			args: []string{"/home/user/whitespace"},
+			root: map[string]interface{}{
+				"/home/user":            &vfst.Dir{Perm: 0755},
+				"/home/user/.chezmoi":   &vfst.Dir{Perm: 0700},
+				"/home/user/whitespace": "\n",
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.chezmoi/whitespace",
+					vfst.TestDoesNotExist,
+				),
+			},
+		},
 		{
 			name: "add_symlink",
 			args: []string{"/home/user/foo"},
@@ -311,7 +393,8 @@ func TestAddCommand(t *testing.T) {
 					"name":  "John Smith",
 					"email": "john.smith@company.com",
 				},
-				add: tc.add,
+				Encryption: tc.encryption,
+				add:        tc.add,
 			}
 			fs, cleanup, err := vfst.NewTestFS(tc.root)
 			defer cleanup()
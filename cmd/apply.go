@@ -1,21 +1,103 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
 	vfs "github.com/twpayne/go-vfs"
 )
 
+type applyCmdConfig struct {
+	force       bool
+	interactive bool
+	summary     bool
+}
+
 var applyCmd = &cobra.Command{
 	Use:   "apply [targets...]",
 	Short: "Update the destination directory to match the target state",
-	RunE:  makeRunE(config.runApplyCmd),
+	RunE:  makeLockedRunE(config.runApplyCmd),
 }
 
 func init() {
 	rootCmd.AddCommand(applyCmd)
+
+	persistentFlags := applyCmd.PersistentFlags()
+	persistentFlags.BoolVar(&config.Backup.Enabled, "backup", false, "back up targets before replacing them")
+	viper.BindPFlag("backup.enabled", persistentFlags.Lookup("backup"))
+	persistentFlags.BoolVar(&config.apply.force, "force", false, "overwrite targets that have changed since they were last written")
+	persistentFlags.BoolVarP(&config.apply.interactive, "interactive", "i", false, "prompt before each change")
+	persistentFlags.IntVar(&config.Parallel, "parallel", 1, "maximum number of changes to make concurrently")
+	viper.BindPFlag("parallel", persistentFlags.Lookup("parallel"))
+	persistentFlags.BoolVar(&config.apply.summary, "summary", false, "print a summary of changes")
+	persistentFlags.StringVar(&config.entryTypes.include, "include", "", "include entry types (all, dirs, encrypted, files, remove, scripts, or symlinks)")
+	persistentFlags.StringVar(&config.entryTypes.exclude, "exclude", "", "exclude entry types")
 }
 
 func (c *Config) runApplyCmd(fs vfs.FS, args []string) error {
 	mutator := c.getDefaultMutator(fs)
-	return c.applyArgs(fs, args, mutator)
+	// In verbose mode every operation is already logged.
+	printOperations := c.DryRun && !c.Verbose
+	if !printOperations && !c.apply.summary {
+		return c.applyArgs(fs, args, mutator, true)
+	}
+	recordingMutator := chezmoi.NewRecordingMutator(mutator)
+	if err := c.applyArgs(fs, args, recordingMutator, true); err != nil {
+		return err
+	}
+	if printOperations {
+		for _, operation := range recordingMutator.Operations {
+			fmt.Println(operation.String())
+		}
+	}
+	if c.apply.summary {
+		fmt.Println(recordingMutator.Summary().String())
+	}
+	return nil
+}
+
+// promptApplyDecision returns an ApplyDecider that prompts for each operation,
+// optionally showing its diff first.
+func (c *Config) promptApplyDecision(fs vfs.FS) chezmoi.ApplyDecider {
+	all := false
+	return func(o *chezmoi.Operation) (chezmoi.ApplyDecision, error) {
+		if all {
+			return chezmoi.ApplyDecisionApply, nil
+		}
+		for {
+			choice, err := prompt(fmt.Sprintf("Apply %s", o), "ynqda")
+			if err != nil {
+				return chezmoi.ApplyDecisionQuit, err
+			}
+			switch choice {
+			case 'y':
+				return chezmoi.ApplyDecisionApply, nil
+			case 'n':
+				return chezmoi.ApplyDecisionSkip, nil
+			case 'q':
+				return chezmoi.ApplyDecisionQuit, nil
+			case 'd':
+				color, err := useColor(c.Diff.Color, os.Stdout)
+				if err != nil {
+					return chezmoi.ApplyDecisionQuit, err
+				}
+				if err := chezmoi.WriteDiff(secretRedactor.writer(os.Stdout), fs, []chezmoi.Operation{*o}, chezmoi.DiffOptions{
+					Format:        chezmoi.DiffFormat(strings.ToLower(c.Diff.Format)),
+					DestDir:       c.DestDir,
+					Color:         color,
+					Redact:        secretRedactor.redact,
+					MaxMemorySize: c.MaxMemorySize,
+				}); err != nil {
+					return chezmoi.ApplyDecisionQuit, err
+				}
+			case 'a':
+				all = true
+				return chezmoi.ApplyDecisionApply, nil
+			}
+		}
+	}
 }
@@ -1,10 +1,10 @@
 package cmd
 
 import (
-	"archive/tar"
 	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
 	vfs "github.com/twpayne/go-vfs"
 )
 
@@ -24,8 +24,8 @@ func (c *Config) runArchiveCmd(fs vfs.FS, args []string) error {
 	if err != nil {
 		return err
 	}
-	w := tar.NewWriter(os.Stdout)
-	if err := ts.Archive(w, os.FileMode(c.Umask)); err != nil {
+	w := chezmoi.NewTarWriter(os.Stdout)
+	if err := ts.ArchiveContext(c.getContext(), w, os.FileMode(c.Umask)); err != nil {
 		return err
 	}
 	return w.Close()
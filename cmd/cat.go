@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -32,6 +33,16 @@ func (c *Config) runCatCmd(fs vfs.FS, args []string) error {
 	for i, entry := range entries {
 		switch entry := entry.(type) {
 		case *chezmoi.File:
+			r, _, err := entry.Reader()
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(os.Stdout, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		case *chezmoi.Script:
 			contents, err := entry.Contents()
 			if err != nil {
 				return err
@@ -16,15 +16,18 @@ var chattrCmd = &cobra.Command{
 	Use:   "chattr attributes targets...",
 	Args:  cobra.MinimumNArgs(2),
 	Short: "Change the attributes of a target in the source state",
-	RunE:  makeRunE(config.runChattrCmd),
+	RunE:  makeLockedRunE(config.runChattrCmd),
 }
 
 type boolModifier int
 
 type attributeModifiers struct {
+	create     boolModifier
 	empty      boolModifier
 	exact      boolModifier
 	executable boolModifier
+	once       boolModifier
+	onChange   boolModifier
 	private    boolModifier
 	template   boolModifier
 }
@@ -70,9 +73,16 @@ func (c *Config) runChattrCmd(fs vfs.FS, args []string) error {
 				mode &= 0700
 			}
 			fa.Mode = mode
+			fa.Create = ams.create.modify(entry.Create)
 			fa.Empty = ams.empty.modify(entry.Empty)
 			fa.Template = ams.template.modify(entry.Template)
 			newBase = fa.SourceName()
+		case *chezmoi.Script:
+			fa := chezmoi.ParseFileAttributes(oldBase)
+			fa.Once = ams.once.modify(entry.Once)
+			fa.OnChange = ams.onChange.modify(entry.OnChange)
+			fa.Template = ams.template.modify(entry.Template)
+			newBase = fa.SourceName()
 		case *chezmoi.Symlink:
 			fa := chezmoi.ParseFileAttributes(oldBase)
 			fa.Template = ams.template.modify(entry.Template)
@@ -124,12 +134,18 @@ func parseAttributeModifiers(s string) (*attributeModifiers, error) {
 			attribute = attributeModifier
 		}
 		switch attribute {
+		case "create":
+			ams.create = modifier
 		case "empty", "e":
 			ams.empty = modifier
 		case "exact":
 			ams.exact = modifier
 		case "executable", "x":
 			ams.executable = modifier
+		case "once", "o":
+			ams.once = modifier
+		case "onchange":
+			ams.onChange = modifier
 		case "private", "p":
 			ams.private = modifier
 		case "template", "t":
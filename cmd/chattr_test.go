@@ -48,6 +48,42 @@ func TestChattrCommand(t *testing.T) {
 				),
 			},
 		},
+		{
+			name: "add_create",
+			args: []string{"+create", "/home/user/.bashrc"},
+			root: map[string]interface{}{
+				"/home/user/.config/share/chezmoi": map[string]interface{}{
+					"private_dot_bashrc": "# contents of .bashrc\n",
+				},
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.config/share/chezmoi/private_dot_bashrc",
+					vfst.TestDoesNotExist,
+				),
+				vfst.TestPath("/home/user/.config/share/chezmoi/create_private_dot_bashrc",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# contents of .bashrc\n"),
+				),
+			},
+		},
+		{
+			name: "add_once",
+			args: []string{"+once", "/home/user/install"},
+			root: map[string]interface{}{
+				"/home/user/.config/share/chezmoi": map[string]interface{}{
+					"run_install": "#!/bin/sh\n",
+				},
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.config/share/chezmoi/run_install",
+					vfst.TestDoesNotExist,
+				),
+				vfst.TestPath("/home/user/.config/share/chezmoi/run_once_install",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("#!/bin/sh\n"),
+				),
+			},
+		},
 		{
 			name: "dir_add_private",
 			args: []string{"+private", "/home/user/dir"},
@@ -18,10 +18,11 @@ func TestExercise(t *testing.T) {
 	}
 
 	c := &Config{
-		SourceDir: "/home/user/.chezmoi",
-		DestDir:   "/home/user",
-		Umask:     022,
-		Verbose:   true,
+		configFile: "/home/user/.config/chezmoi/chezmoi.toml",
+		SourceDir:  "/home/user/.chezmoi",
+		DestDir:    "/home/user",
+		Umask:      022,
+		Verbose:    true,
 	}
 
 	mustWriteFile := func(name, contents string, mode os.FileMode) {
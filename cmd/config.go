@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,19 +13,51 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"text/template"
-	"unicode"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/coreos/go-semver/semver"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/twpayne/chezmoi/lib/chezmoi"
 	vfs "github.com/twpayne/go-vfs"
 	xdg "github.com/twpayne/go-xdg"
+	"golang.org/x/crypto/ssh/terminal"
 	yaml "gopkg.in/yaml.v2"
 )
 
+type backupConfig struct {
+	Enabled bool
+	Dir     string
+	Keep    int
+}
+
+type dataCommandConfig struct {
+	Command string
+	Args    []string
+	Format  string
+}
+
+type freeSpaceConfig struct {
+	Check  bool
+	Margin uint64
+}
+
+// An entryTypesConfig holds the --include and --exclude flags of commands
+// that can be restricted to some entry types.
+type entryTypesConfig struct {
+	include string
+	exclude string
+}
+
+type retryConfig struct {
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
 type sourceVCSConfig struct {
 	Command string
 	Init    interface{}
@@ -33,31 +66,88 @@ type sourceVCSConfig struct {
 
 // A Config represents a configuration.
 type Config struct {
-	configFile    string
-	SourceDir     string
-	DestDir       string
-	Umask         permValue
-	DryRun        bool
-	Verbose       bool
-	SourceVCS     sourceVCSConfig
-	Bitwarden     bitwardenCmdConfig
-	GenericSecret genericSecretCmdConfig
-	Lastpass      lastpassCmdConfig
-	Onepassword   onepasswordCmdConfig
-	Vault         vaultCmdConfig
-	Pass          passCmdConfig
-	Data          map[string]interface{}
-	templateFuncs template.FuncMap
-	add           addCmdConfig
-	data          dataCmdConfig
-	dump          dumpCmdConfig
-	edit          editCmdConfig
-	init          initCmdConfig
-	_import       importCmdConfig
-	keyring       keyringCmdConfig
-	update        updateCmdConfig
+	ctx            context.Context
+	configFile     string
+	cacheDir       string
+	stateDir       string
+	SourceDir      string
+	DestDir        string
+	Umask          permValue
+	DryRun         bool
+	Safe           bool
+	KeepGoing      bool
+	Parallel       int
+	MaxMemorySize  int64
+	Verbose        bool
+	DataCommand    dataCommandConfig
+	Diff           diffConfig
+	Encryption     string
+	Age            ageConfig
+	Backup         backupConfig
+	FreeSpace      freeSpaceConfig
+	GitHub         gitHubConfig
+	GPG            gpgConfig
+	Merge          mergeConfig
+	Retry          retryConfig
+	SecretCache    secretCacheConfig
+	SecretCommand  secretCommandConfig
+	SourceVCS      sourceVCSConfig
+	TemplateEngine string
+	AWS            awsConfig
+	Azure          azureConfig
+	Bitwarden      bitwardenCmdConfig
+	GCP            gcpConfig
+	GenericSecret  genericSecretCmdConfig
+	Gopass         gopassCmdConfig
+	Keepassxc      keepassxcCmdConfig
+	Lastpass       lastpassCmdConfig
+	Onepassword    onepasswordCmdConfig
+	Vault          vaultCmdConfig
+	Pass           passCmdConfig
+	Data           map[string]interface{}
+	templateFuncs  template.FuncMap
+	tracer         *chezmoi.TemplateTracer
+	hashCache      *chezmoi.ContentHashCache
+	templateCache  *chezmoi.TemplateOutputCache
+	stateDB        *chezmoi.BoltPersistentState
+	readOnlyState  bool
+	encryption     chezmoi.Encryption
+	encryptionMu   sync.Mutex
+	entryTypes     entryTypesConfig
+	add            addCmdConfig
+	apply          applyCmdConfig
+	data           dataCmdConfig
+	dump           dumpCmdConfig
+	edit           editCmdConfig
+	init           initCmdConfig
+	_import        importCmdConfig
+	keyring        keyringCmdConfig
+	move           moveCmdConfig
+	state          stateCmdConfig
+	update         updateCmdConfig
+	verify         verifyCmdConfig
 }
 
+// envPrefix is the prefix of environment variables that override config
+// settings, and dataEnvPrefix is the prefix of those that set template data.
+const (
+	envPrefix     = "CHEZMOI_"
+	dataEnvPrefix = envPrefix + "DATA_"
+)
+
+// persistentStateFileName is the name of the file, in the state directory,
+// that stores the persistent state, and legacyPersistentStateFileName is the
+// name of the JSON file that older versions stored it in, in the state
+// directory or in the same directory as the config file.
+const (
+	persistentStateFileName       = "chezmoistate.boltdb"
+	legacyPersistentStateFileName = "chezmoistate.json"
+)
+
+// lockFileName is the name of the file, in the state directory, that is locked
+// while commands that modify the source or destination directories run.
+const lockFileName = "chezmoi.lock"
+
 var (
 	formatMap = map[string]func(io.Writer, interface{}) error{
 		"json": func(w io.Writer, value interface{}) error {
@@ -72,13 +162,6 @@ var (
 			return yaml.NewEncoder(w).Encode(value)
 		},
 	}
-
-	wellKnownAbbreviations = map[string]struct{}{
-		"ANSI": struct{}{},
-		"CPE":  struct{}{},
-		"ID":   struct{}{},
-		"URL":  struct{}{},
-	}
 )
 
 func (c *Config) addTemplateFunc(key string, value interface{}) {
@@ -91,24 +174,126 @@ func (c *Config) addTemplateFunc(key string, value interface{}) {
 	c.templateFuncs[key] = value
 }
 
-func (c *Config) applyArgs(fs vfs.FS, args []string, mutator chezmoi.Mutator) error {
+// applyArgs applies the targets in args, or all targets if args is empty.
+// preflight is true for commands that change the destination directory. If it
+// is true then applyArgs checks that there is enough free space if free space
+// checks are enabled, prompts before each change if apply --interactive is
+// set, and, unless in dry run mode, backs up replaced targets so that chezmoi
+// undo can restore them and afterwards records the state of each applied
+// target for chezmoi status. With --keep-going, it applies everything that it
+// can and returns all errors together.
+func (c *Config) applyArgs(fs vfs.FS, args []string, mutator chezmoi.Mutator, preflight bool) error {
 	ts, err := c.getTargetState(fs)
 	if err != nil {
 		return err
 	}
+	checkFreeSpace := preflight && c.FreeSpace.Check && !c.DryRun
+	freeSpaceOptions := chezmoi.FreeSpaceOptions{
+		Margin: c.FreeSpace.Margin,
+	}
+	var decide chezmoi.ApplyDecider
+	if preflight && c.apply.interactive {
+		decide = c.promptApplyDecision(fs)
+	}
+	if preflight && !c.DryRun {
+		// Record how to undo the changes, keeping only the last run unless
+		// backups are enabled.
+		backupRoot, keep := c.getBackupRoot()
+		backupMutator := chezmoi.NewBackupMutator(mutator, fs, c.DestDir, chezmoi.BackupDirName(backupRoot, time.Now()))
+		mutator = backupMutator
+		defer func() {
+			if err := backupMutator.WriteUndoLog(); err != nil {
+				fmt.Fprintf(os.Stderr, "chezmoi: %s: %v\n", backupMutator.BackupDir(), err)
+			}
+			if err := chezmoi.PruneBackups(fs, backupRoot, keep, chezmoi.NewFSMutator(fs, c.DestDir)); err != nil {
+				fmt.Fprintf(os.Stderr, "chezmoi: %s: %v\n", backupRoot, err)
+			}
+		}()
+	}
+	var entries []chezmoi.Entry
+	var plan *chezmoi.ApplyPlan
+	var planErr error
 	if len(args) == 0 {
-		return ts.Apply(fs, mutator)
+		// Evaluate every template before applying anything, so that all
+		// template errors are reported together. When keeping going, the
+		// plan reports them instead.
+		if !c.KeepGoing {
+			if err := ts.Evaluate(); err != nil {
+				return err
+			}
+		}
+		if checkFreeSpace {
+			if err := ts.CheckFreeSpace(fs, freeSpaceOptions); err != nil {
+				return err
+			}
+		}
+		entries = make([]chezmoi.Entry, 0, len(ts.Entries))
+		for _, entry := range ts.Entries {
+			entries = append(entries, entry)
+		}
+		plan, planErr = ts.PlanContext(c.getContext(), fs)
+	} else {
+		if entries, err = c.getMatchingEntries(ts, args); err != nil {
+			return err
+		}
+		if checkFreeSpace {
+			if err := chezmoi.CheckFreeSpace(fs, ts.DestDir, entries, ts.TargetIgnore.Match, ts.Umask, freeSpaceOptions); err != nil {
+				return err
+			}
+		}
+		plan, planErr = ts.PlanEntriesContext(c.getContext(), fs, entries)
 	}
-	entries, err := c.getEntries(ts, args)
-	if err != nil {
-		return err
+	// When keeping going, a plan is returned even if some targets could not
+	// be planned.
+	if plan == nil {
+		return planErr
 	}
-	for _, entry := range entries {
-		if err := entry.Apply(fs, ts.DestDir, ts.TargetIgnore.Match, ts.Umask, mutator); err != nil {
+	var errs chezmoi.MultiError
+	if planErr != nil {
+		errs = append(errs, planErr)
+	}
+	if preflight && c.Safe && !c.apply.force {
+		changed, err := ts.ChangedTargets(fs, plan)
+		if err != nil {
 			return err
 		}
+		if len(changed) > 0 {
+			if decide == nil {
+				for _, err := range changed {
+					errs = append(errs, err)
+				}
+				if !c.KeepGoing {
+					return errs
+				}
+			}
+			decide = c.protectChangedTargets(changed, decide)
+		}
+	}
+	if _, err := ts.ExecutePlanFunc(c.getContext(), plan, mutator, decide); err != nil {
+		if !c.KeepGoing {
+			return err
+		}
+		errs = append(errs, err)
+	}
+	if preflight && !c.DryRun {
+		if err := ts.SaveEntryStates(fs, entries); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return errs
 	}
-	return nil
+}
+
+// cachesDirty returns true if the command computed new hashes of targets or
+// outputs of templates that have not been saved.
+func (c *Config) cachesDirty() bool {
+	return c.hashCache.Dirty() || c.templateCache.Dirty()
 }
 
 func (c *Config) ensureSourceDirectory(fs vfs.FS, mutator chezmoi.Mutator) error {
@@ -151,15 +336,43 @@ func (c *Config) execEditor(argv ...string) error {
 	return c.exec(append([]string{c.getEditor()}, argv...))
 }
 
+// getBackupRoot returns the directory that contains the backup directories and
+// the number of backup directories to keep. If backups are disabled then only
+// the last backup directory, which is needed by chezmoi undo, is kept in the
+// cache directory.
+func (c *Config) getBackupRoot() (string, int) {
+	if !c.Backup.Enabled {
+		return filepath.Join(c.cacheDir, "undo"), 1
+	}
+	if strings.HasPrefix(c.Backup.Dir, "~/") {
+		if homeDir, err := userHomeDir(); err == nil {
+			return filepath.Join(homeDir, c.Backup.Dir[2:]), c.Backup.Keep
+		}
+	}
+	return c.Backup.Dir, c.Backup.Keep
+}
+
+// getContext returns the context of the running command, which is cancelled
+// when chezmoi is interrupted.
+func (c *Config) getContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 func (c *Config) getDefaultMutator(fs vfs.FS) chezmoi.Mutator {
 	var mutator chezmoi.Mutator
 	if c.DryRun {
 		mutator = chezmoi.NullMutator
 	} else {
 		mutator = chezmoi.NewFSMutator(fs, c.DestDir)
+		if c.Retry.MaxAttempts > 1 {
+			mutator = chezmoi.NewRetryMutator(mutator, c.getRetryPolicy())
+		}
 	}
 	if c.Verbose {
-		mutator = chezmoi.NewLoggingMutator(os.Stdout, mutator)
+		mutator = chezmoi.NewLoggingMutator(secretRedactor.writer(os.Stdout), mutator)
 	}
 	return mutator
 }
@@ -193,20 +406,225 @@ func (c *Config) getEntries(ts *chezmoi.TargetState, args []string) ([]chezmoi.E
 	return entries, nil
 }
 
+// getEntryTypeFilter returns the filter given by the --include and --exclude
+// flags, or nil if neither is set.
+func (c *Config) getEntryTypeFilter() (*chezmoi.EntryTypeFilter, error) {
+	if c.entryTypes.include == "" && c.entryTypes.exclude == "" {
+		return nil, nil
+	}
+	include := chezmoi.EntryTypesAll
+	if c.entryTypes.include != "" {
+		var err error
+		if include, err = chezmoi.ParseEntryTypeSet(c.entryTypes.include); err != nil {
+			return nil, err
+		}
+	}
+	exclude, err := chezmoi.ParseEntryTypeSet(c.entryTypes.exclude)
+	if err != nil {
+		return nil, err
+	}
+	return chezmoi.NewEntryTypeFilter(include, exclude), nil
+}
+
+// getMatchingEntries is like getEntries, except that args may also be glob
+// patterns, like ~/.config/nvim/**, which select every matching target.
+func (c *Config) getMatchingEntries(ts *chezmoi.TargetState, args []string) ([]chezmoi.Entry, error) {
+	var entries []chezmoi.Entry
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		var argEntries []chezmoi.Entry
+		var err error
+		if chezmoi.HasMeta(arg) {
+			argEntries, err = c.getPatternEntries(ts, arg)
+		} else {
+			argEntries, err = c.getEntries(ts, []string{arg})
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range argEntries {
+			if !seen[entry.TargetName()] {
+				entries = append(entries, entry)
+				seen[entry.TargetName()] = true
+			}
+		}
+	}
+	return entries, nil
+}
+
+// getPatternEntries returns the entries matching the glob pattern arg.
+func (c *Config) getPatternEntries(ts *chezmoi.TargetState, arg string) ([]chezmoi.Entry, error) {
+	pattern := arg
+	if strings.HasPrefix(pattern, "~/") {
+		homeDir, err := userHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		pattern = filepath.Join(homeDir, pattern[2:])
+	}
+	pattern, err := filepath.Abs(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.HasPrefix(pattern, ts.DestDir) {
+		return nil, fmt.Errorf("%s: outside target directory", arg)
+	}
+	if pattern, err = filepath.Rel(ts.DestDir, pattern); err != nil {
+		return nil, err
+	}
+	patterns, err := chezmoi.NewPathPatterns(filepath.ToSlash(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", arg, err)
+	}
+	entries, err := ts.MatchEntries(patterns)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s: no matching targets in source state", arg)
+	}
+	return entries, nil
+}
+
+// getPersistentState returns the persistent state, which is stored in the
+// state directory, or next to the config file when there is no state directory.
+// It is opened once and stays open until closePersistentState is called. If it
+// does not exist yet then the JSON state written by an older version is
+// imported. Changes are not written in dry run mode or by commands, like diff
+// and verify, that set readOnlyState.
+func (c *Config) getPersistentState(fs vfs.FS) (chezmoi.PersistentState, error) {
+	if c.stateDB != nil {
+		return c.stateDB, nil
+	}
+	path := c.getPersistentStatePath()
+	stateDB := chezmoi.NewBoltPersistentState(fs, path, c.DryRun || c.readOnlyState)
+	if _, err := fs.Stat(path); os.IsNotExist(err) {
+		for _, legacyPath := range c.getLegacyPersistentStatePaths() {
+			if _, err := fs.Stat(legacyPath); err != nil {
+				continue
+			}
+			if err := chezmoi.ImportJSONPersistentState(fs, legacyPath, stateDB); err != nil {
+				stateDB.Close()
+				return nil, err
+			}
+			break
+		}
+	}
+	c.stateDB = stateDB
+	return stateDB, nil
+}
+
+// closePersistentState closes the persistent state, if it is open.
+func (c *Config) closePersistentState() error {
+	if c.stateDB == nil {
+		return nil
+	}
+	err := c.stateDB.Close()
+	c.stateDB = nil
+	return err
+}
+
+// getLegacyPersistentStatePaths returns the paths of the JSON files in which
+// older versions stored the persistent state, most recent first.
+func (c *Config) getLegacyPersistentStatePaths() []string {
+	var paths []string
+	if c.stateDir != "" {
+		paths = append(paths, filepath.Join(c.stateDir, legacyPersistentStateFileName))
+	}
+	return append(paths, filepath.Join(filepath.Dir(c.configFile), legacyPersistentStateFileName))
+}
+
+// getPersistentStatePath returns the path of the persistent state.
+func (c *Config) getPersistentStatePath() string {
+	if c.stateDir == "" {
+		return filepath.Join(filepath.Dir(c.configFile), persistentStateFileName)
+	}
+	return filepath.Join(c.stateDir, persistentStateFileName)
+}
+
+func (c *Config) getRetryPolicy() chezmoi.RetryPolicy {
+	policy := chezmoi.DefaultRetryPolicy
+	policy.MaxAttempts = c.Retry.MaxAttempts
+	if c.Retry.Backoff != 0 {
+		policy.Backoff = chezmoi.ExponentialBackoff(c.Retry.Backoff, 5*time.Second)
+	}
+	policy.OnRetry = func(op string, attempt int, err error) {
+		fmt.Fprintf(os.Stderr, "chezmoi: %s: attempt %d failed, retrying: %s\n", op, attempt, secretRedactor.redact(err.Error()))
+	}
+	return policy
+}
+
 func (c *Config) getTargetState(fs vfs.FS) (*chezmoi.TargetState, error) {
 	defaultData, err := getDefaultData(fs)
 	if err != nil {
 		return nil, err
 	}
-	data := map[string]interface{}{
+	data := make(map[string]interface{})
+	provenance := make(chezmoi.DataProvenance)
+	provenance.MergeData(data, map[string]interface{}{
 		"chezmoi": defaultData,
-	}
-	for key, value := range c.Data {
-		data[key] = value
+	}, "built-in")
+	if c.DataCommand.Command != "" {
+		commandData, err := chezmoi.DataFromCommand(exec.Command(c.DataCommand.Command, c.DataCommand.Args...), c.DataCommand.Format)
+		if err != nil {
+			return nil, err
+		}
+		provenance.MergeData(data, commandData, "data command "+strings.Join(append([]string{c.DataCommand.Command}, c.DataCommand.Args...), " "))
+	}
+	provenance.MergeData(data, c.Data, c.configFile)
+	// Data set by environment variables is merged into c.Data when the config
+	// is read, so record its source separately.
+	for key, name := range envDataKeys(os.Environ()) {
+		if _, ok := provenance[key]; ok {
+			provenance[key] = "environment variable " + name
+		}
 	}
 	ts := chezmoi.NewTargetState(c.DestDir, os.FileMode(c.Umask), c.SourceDir, data, c.templateFuncs)
+	ts.DataProvenance = provenance
+	ts.Secrets = secretRedactor
+	encryption, err := c.getEncryption(fs)
+	if err != nil {
+		return nil, err
+	}
+	c.encryption = encryption
+	ts.Decryptor = encryption
+	ts.Encryptor = encryption
+	ts.Encryptions, err = c.getEncryptions(fs, encryption)
+	if err != nil {
+		return nil, err
+	}
+	if c.TemplateEngine != "" {
+		engine, err := chezmoi.LookupTemplateEngine(c.TemplateEngine)
+		if err != nil {
+			return nil, err
+		}
+		ts.TemplateEngine = engine
+	}
+	ts.PersistentState, err = c.getPersistentState(fs)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.warnIfConfigTemplateChanged(fs, ts.PersistentState); err != nil {
+		return nil, err
+	}
+	c.hashCache = chezmoi.NewContentHashCache(ts.PersistentState)
+	ts.ContentHashCache = c.hashCache
+	c.templateCache = chezmoi.NewTemplateOutputCache(ts.PersistentState)
+	ts.TemplateOutputCache = c.templateCache
+	ts.Tracer = c.tracer
+	ts.KeepGoing = c.KeepGoing
+	ts.Parallelism = c.Parallel
+	ts.MaxMemorySize = c.MaxMemorySize
+	if ts.EntryTypeFilter, err = c.getEntryTypeFilter(); err != nil {
+		return nil, err
+	}
+	// Development builds do not have a version, so they can use any source
+	// state.
+	if v, err := semver.NewVersion(strings.TrimPrefix(version, "v")); err == nil {
+		ts.Version = v
+	}
 	readOnlyFS := vfs.NewReadOnlyFS(fs)
-	if err := ts.Populate(readOnlyFS); err != nil {
+	if err := ts.PopulateContext(c.getContext(), readOnlyFS); err != nil {
 		return nil, err
 	}
 	return ts, nil
@@ -220,6 +638,30 @@ func (c *Config) getVCSInfo() (*vcsInfo, error) {
 	return vcsInfo, nil
 }
 
+// protectChangedTargets returns an ApplyDecider that protects the targets in
+// changed, which have been changed since chezmoi last wrote them. If decide is
+// nil then they are skipped, otherwise decide is called for them after a
+// warning.
+func (c *Config) protectChangedTargets(changed []*chezmoi.ChangedTargetError, decide chezmoi.ApplyDecider) chezmoi.ApplyDecider {
+	changedPaths := make(map[string]bool)
+	for _, err := range changed {
+		changedPaths[filepath.Join(c.DestDir, err.TargetName)] = true
+	}
+	return func(o *chezmoi.Operation) (chezmoi.ApplyDecision, error) {
+		switch {
+		case !changedPaths[o.Name] && decide == nil:
+			return chezmoi.ApplyDecisionApply, nil
+		case !changedPaths[o.Name]:
+			return decide(o)
+		case decide == nil:
+			return chezmoi.ApplyDecisionSkip, nil
+		default:
+			fmt.Printf("%s has changed since chezmoi last wrote it\n", o.Name)
+			return decide(o)
+		}
+	}
+}
+
 // run runs name argv... in dir.
 func (c *Config) run(dir, name string, argv ...string) error {
 	if c.Verbose {
@@ -244,6 +686,75 @@ func (c *Config) runEditor(argv ...string) error {
 	return c.run("", c.getEditor(), argv...)
 }
 
+// saveCaches writes the new hashes of targets and outputs of templates computed
+// by the command to the persistent state. Nothing is written in dry run mode.
+func (c *Config) saveCaches(fs vfs.FS) error {
+	if c.DryRun || !c.cachesDirty() {
+		return nil
+	}
+	persistentState, err := c.getPersistentState(fs)
+	if err != nil {
+		return err
+	}
+	if err := c.hashCache.Flush(persistentState); err != nil {
+		return err
+	}
+	return c.templateCache.Flush(persistentState)
+}
+
+// withLock calls f while holding the lock in the state directory, so that
+// commands that modify the source or destination directories do not run
+// concurrently. The lock is not needed in dry run mode.
+func (c *Config) withLock(fs vfs.FS, f func() error) error {
+	if c.DryRun {
+		return f()
+	}
+	lockDir := c.stateDir
+	if lockDir == "" {
+		lockDir = filepath.Dir(c.configFile)
+	}
+	lock, err := chezmoi.AcquireLock(fs, filepath.Join(lockDir, lockFileName))
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return f()
+}
+
+// envDataKeys returns a map of the dot-separated template data keys set by the
+// CHEZMOI_DATA_* variables in environ to the names of the variables.
+func envDataKeys(environ []string) map[string]string {
+	keys := make(map[string]string)
+	for _, env := range environ {
+		i := strings.IndexByte(env, '=')
+		if i == -1 || !strings.HasPrefix(env, dataEnvPrefix) {
+			continue
+		}
+		if key, ok := envOverrideKey(env[:i]); ok {
+			keys[strings.TrimPrefix(key, "data.")] = env[:i]
+		}
+	}
+	return keys
+}
+
+// envOverrideKey returns the viper key set by the environment variable name,
+// and whether name sets a key.
+func envOverrideKey(name string) (string, bool) {
+	if !strings.HasPrefix(name, envPrefix) {
+		return "", false
+	}
+	var key string
+	if strings.HasPrefix(name, dataEnvPrefix) {
+		key = "data." + strings.Replace(strings.TrimPrefix(name, dataEnvPrefix), "__", ".", -1)
+	} else {
+		key = strings.Replace(strings.TrimPrefix(name, envPrefix), "_", ".", -1)
+	}
+	if strings.HasSuffix(key, ".") || strings.Contains(key, "..") {
+		return "", false
+	}
+	return strings.ToLower(key), true
+}
+
 func getDefaultConfigFile(bds *xdg.BaseDirectorySpecification) string {
 	// Search XDG Base Directory Specification config directories first.
 	for _, configDir := range bds.ConfigDirs {
@@ -259,30 +770,26 @@ func getDefaultConfigFile(bds *xdg.BaseDirectorySpecification) string {
 }
 
 func getDefaultData(fs vfs.FS) (map[string]interface{}, error) {
-	data := map[string]interface{}{
-		"arch": runtime.GOARCH,
-		"os":   runtime.GOOS,
-	}
-
-	currentUser, err := user.Current()
+	data, err := chezmoi.DefaultData()
 	if err != nil {
 		return nil, err
 	}
-	data["username"] = currentUser.Username
 
-	// user.LookupGroupId looks up a group by gid. If CGO is enabled, then this
-	// uses an underlying C library call (e.g. getgrgid_r on Linux) and is
-	// trustworthy. If CGO is disabled then the fallback implementation only
-	// searches /etc/group, which is typically empty if an external directory
-	// service is being used, and so the lookup fails. So, if
-	// user.LookupGroupId returns an error, only return an error if CGO is
+	// chezmoi.DefaultData omits the group if user.LookupGroupId fails. If CGO
+	// is enabled, then this uses an underlying C library call (e.g.
+	// getgrgid_r on Linux) and is trustworthy. If CGO is disabled then the
+	// fallback implementation only searches /etc/group, which is typically
+	// empty if an external directory service is being used, and so the lookup
+	// fails. So, if the group is missing, only return an error if CGO is
 	// enabled.
-	group, err := user.LookupGroupId(currentUser.Gid)
-	if err == nil {
-		data["group"] = group.Name
-	} else if err != nil && cgoEnabled {
-		// Only return an error if CGO is enabled.
-		return nil, err
+	if _, ok := data["group"]; !ok && cgoEnabled {
+		currentUser, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := user.LookupGroupId(currentUser.Gid); err != nil {
+			return nil, err
+		}
 	}
 
 	homedir, err := userHomeDir()
@@ -291,16 +798,9 @@ func getDefaultData(fs vfs.FS) (map[string]interface{}, error) {
 	}
 	data["homedir"] = homedir
 
-	hostname, err := os.Hostname()
-	if err != nil {
-		return nil, err
-	}
-	data["fullHostname"] = hostname
-	data["hostname"] = strings.SplitN(hostname, ".", 2)[0]
-
-	osRelease, err := getOSRelease(fs)
+	osRelease, err := chezmoi.OSRelease(fs)
 	if err == nil {
-		data["osRelease"] = upperSnakeCaseToCamelCaseMap(osRelease)
+		data["osRelease"] = osRelease
 	} else if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
@@ -320,20 +820,47 @@ func getDefaultSourceDir(bds *xdg.BaseDirectorySpecification) string {
 	return filepath.Join(bds.DataHome, "chezmoi")
 }
 
-// isWellKnownAbbreviation returns true if word is a well known abbreviation.
-func isWellKnownAbbreviation(word string) bool {
-	_, ok := wellKnownAbbreviations[word]
-	return ok
+// getStateHome returns the XDG Base Directory Specification state home, which
+// go-xdg does not yet support.
+func getStateHome(homeDir string) string {
+	if stateHome := os.Getenv("XDG_STATE_HOME"); stateHome != "" {
+		return stateHome
+	}
+	return filepath.Join(homeDir, ".local", "state")
 }
 
 func makeRunE(runCmd func(vfs.FS, []string) error) func(*cobra.Command, []string) error {
 	return func(cmd *cobra.Command, args []string) error {
-		return runCmd(vfs.OSFS, args)
+		err := runCmd(vfs.OSFS, args)
+		// Caches are only saved while holding the lock, and are computed
+		// again by a later command if another instance of chezmoi holds it.
+		if config.cachesDirty() {
+			saveErr := config.withLock(vfs.OSFS, func() error {
+				return config.saveCaches(vfs.OSFS)
+			})
+			if _, ok := saveErr.(*chezmoi.LockedError); !ok && err == nil {
+				err = saveErr
+			}
+		}
+		return err
+	}
+}
+
+// makeLockedRunE is like makeRunE, but runCmd is run while holding the lock.
+func makeLockedRunE(runCmd func(vfs.FS, []string) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		return config.withLock(vfs.OSFS, func() error {
+			err := runCmd(vfs.OSFS, args)
+			if saveErr := config.saveCaches(vfs.OSFS); err == nil {
+				err = saveErr
+			}
+			return err
+		})
 	}
 }
 
 func printErrorAndExit(err error) {
-	fmt.Printf("chezmoi: %v\n", err)
+	fmt.Printf("chezmoi: %s\n", secretRedactor.redact(err.Error()))
 	os.Exit(1)
 }
 
@@ -354,37 +881,32 @@ func prompt(s, choices string) (byte, error) {
 	}
 }
 
-// titilize returns s, titilized.
-func titilize(s string) string {
-	if s == "" {
-		return s
-	}
-	runes := []rune(s)
-	return string(append([]rune{unicode.ToTitle(runes[0])}, runes[1:]...))
+// readPassword prompts for and reads a password from the terminal without
+// echoing it. The prompt is written to stderr so that it does not mix with
+// the output of commands.
+func readPassword(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return password, err
 }
 
-// upperSnakeCaseToCamelCase converts a string in UPPER_SNAKE_CASE to
-// camelCase.
-func upperSnakeCaseToCamelCase(s string) string {
-	words := strings.Split(s, "_")
-	for i, word := range words {
-		if i == 0 {
-			words[i] = strings.ToLower(word)
-		} else if !isWellKnownAbbreviation(word) {
-			words[i] = titilize(strings.ToLower(word))
+// setEnvOverrides sets the config settings and template data given by the
+// CHEZMOI_* variables in environ in v. CHEZMOI_<SECTION>_<KEY> sets the config
+// setting section.key, for example CHEZMOI_SOURCEVCS_COMMAND sets
+// sourceVCS.command. CHEZMOI_DATA_<KEY> sets the template data key, converted
+// to lowercase, and __ separates the keys of nested data, for example
+// CHEZMOI_DATA_GIT__NAME sets git.name.
+func setEnvOverrides(v *viper.Viper, environ []string) {
+	for _, env := range environ {
+		i := strings.IndexByte(env, '=')
+		if i == -1 {
+			continue
+		}
+		if key, ok := envOverrideKey(env[:i]); ok {
+			v.Set(key, env[i+1:])
 		}
 	}
-	return strings.Join(words, "")
-}
-
-// upperSnakeCaseToCamelCaseKeys returns m with all keys converted from
-// UPPER_SNAKE_CASE to camelCase.
-func upperSnakeCaseToCamelCaseMap(m map[string]string) map[string]string {
-	result := make(map[string]string)
-	for k, v := range m {
-		result[upperSnakeCaseToCamelCase(k)] = v
-	}
-	return result
 }
 
 // userHomeDir returns the current user's home directory.
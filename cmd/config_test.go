@@ -1,18 +1,108 @@
 package cmd
 
-import "testing"
+import (
+	"runtime"
+	"testing"
 
-func TestUpperSnakeCaseToCamelCase(t *testing.T) {
-	for s, want := range map[string]string{
-		"BUG_REPORT_URL":   "bugReportURL",
-		"ID":               "id",
-		"ID_LIKE":          "idLike",
-		"NAME":             "name",
-		"VERSION_CODENAME": "versionCodename",
-		"VERSION_ID":       "versionID",
-	} {
-		if got := upperSnakeCaseToCamelCase(s); got != want {
-			t.Errorf("upperSnakeCaseToCamelCase(%q) == %q, want %q", s, got, want)
-		}
+	"github.com/d4l3k/messagediff"
+	"github.com/spf13/viper"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestSetEnvOverrides(t *testing.T) {
+	v := viper.New()
+	setEnvOverrides(v, []string{
+		"CHEZMOI_SOURCEDIR=/home/user/dotfiles",
+		"CHEZMOI_UMASK=077",
+		"CHEZMOI_VERBOSE=true",
+		"CHEZMOI_SOURCEVCS_COMMAND=hg",
+		"CHEZMOI_DATA_EMAIL=user@example.com",
+		"CHEZMOI_DATA_WORK_EMAIL=user@company.com",
+		"CHEZMOI_DATA_GIT__NAME=User",
+		"CHEZMOI_DATA_=ignored",
+		"CHEZMOI_=ignored",
+		"HOME=/home/user",
+	})
+	c := &Config{}
+	if err := v.Unmarshal(c); err != nil {
+		t.Fatalf("v.Unmarshal(_) == %v, want <nil>", err)
+	}
+	want := &Config{
+		SourceDir: "/home/user/dotfiles",
+		Umask:     077,
+		Verbose:   true,
+		SourceVCS: sourceVCSConfig{
+			Command: "hg",
+		},
+		Data: map[string]interface{}{
+			"email":      "user@example.com",
+			"work_email": "user@company.com",
+			"git": map[string]interface{}{
+				"name": "User",
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(want, c); !equal {
+		t.Errorf("setEnvOverrides(_, _) diff:\n%s", diff)
+	}
+}
+
+func TestEnvDataKeys(t *testing.T) {
+	got := envDataKeys([]string{
+		"CHEZMOI_DATA_EMAIL=user@example.com",
+		"CHEZMOI_DATA_GIT__NAME=User",
+		"CHEZMOI_DATA_=ignored",
+		"CHEZMOI_VERBOSE=true",
+		"HOME=/home/user",
+	})
+	want := map[string]string{
+		"email":    "CHEZMOI_DATA_EMAIL",
+		"git.name": "CHEZMOI_DATA_GIT__NAME",
+	}
+	if diff, equal := messagediff.PrettyDiff(want, got); !equal {
+		t.Errorf("envDataKeys(_) diff:\n%s", diff)
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("advisory locks only tested on Linux")
+	}
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": &vfst.Dir{Perm: 0755},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	c := &Config{
+		configFile: "/home/user/.config/chezmoi/chezmoi.toml",
+		stateDir:   "/home/user/.local/state/chezmoi",
+	}
+	called := false
+	f := func() error {
+		called = true
+		return nil
+	}
+	if err := c.withLock(fs, f); err != nil || !called {
+		t.Fatalf("c.withLock(_, _) == %v, called %t, want <nil>, called true", err, called)
+	}
+
+	lock, err := chezmoi.AcquireLock(fs, "/home/user/.local/state/chezmoi/chezmoi.lock")
+	if err != nil {
+		t.Fatalf("chezmoi.AcquireLock(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer lock.Release()
+	called = false
+	if err := c.withLock(fs, f); err == nil || called {
+		t.Errorf("c.withLock(_, _) == %v, called %t, want !<nil>, called false", err, called)
+	} else if _, ok := err.(*chezmoi.LockedError); !ok {
+		t.Errorf("c.withLock(_, _) == %v, want a *chezmoi.LockedError", err)
+	}
+
+	c.DryRun = true
+	if err := c.withLock(fs, f); err != nil || !called {
+		t.Errorf("c.withLock(_, _) in dry run mode == %v, called %t, want <nil>, called true", err, called)
 	}
 }
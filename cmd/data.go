@@ -10,7 +10,8 @@ import (
 )
 
 type dataCmdConfig struct {
-	format string
+	format     string
+	provenance bool
 }
 
 var dataCmd = &cobra.Command{
@@ -24,6 +25,7 @@ func init() {
 
 	persistentFlags := dataCmd.PersistentFlags()
 	persistentFlags.StringVarP(&config.data.format, "format", "f", "json", "format (JSON, TOML, or YAML)")
+	persistentFlags.BoolVarP(&config.data.provenance, "provenance", "p", false, "include the source of each value")
 }
 
 func (c *Config) runDataCmd(fs vfs.FS, args []string) error {
@@ -35,5 +37,11 @@ func (c *Config) runDataCmd(fs vfs.FS, args []string) error {
 	if err != nil {
 		return err
 	}
+	if c.data.provenance {
+		return format(os.Stdout, map[string]interface{}{
+			"data":       ts.Data,
+			"provenance": ts.DataProvenance,
+		})
+	}
 	return format(os.Stdout, ts.Data)
 }
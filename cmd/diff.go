@@ -1,13 +1,43 @@
 package cmd
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/twpayne/chezmoi/lib/chezmoi"
 	vfs "github.com/twpayne/go-vfs"
 )
 
+type diffConfig struct {
+	Command string
+	Args    []string
+	Format  string
+	Color   string
+	Pager   string
+	noPager bool
+}
+
+// A diffCommandData is the template data for the args of an external diff
+// command.
+type diffCommandData struct {
+	Name        string // Name is the path of the target relative to the destination directory.
+	Destination string // Destination is a file containing the current contents of the target.
+	Target      string // Target is a file containing the new contents of the target.
+}
+
+// defaultDiffArgs are the args passed to an external diff command when none
+// are configured.
+var defaultDiffArgs = []string{"{{ .Destination }}", "{{ .Target }}"}
+
 var diffCmd = &cobra.Command{
 	Use:   "diff [targets...]",
 	Short: "Write the diff between the target state and the destination state to stdout",
@@ -16,9 +46,156 @@ var diffCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(diffCmd)
+
+	persistentFlags := diffCmd.PersistentFlags()
+	persistentFlags.StringVarP(&config.Diff.Format, "format", "f", string(chezmoi.DiffFormatUnified), "format (unified or git)")
+	viper.BindPFlag("diff.format", persistentFlags.Lookup("format"))
+	persistentFlags.StringVar(&config.Diff.Color, "color", "auto", "color (auto, on, or off)")
+	viper.BindPFlag("diff.color", persistentFlags.Lookup("color"))
+	persistentFlags.BoolVar(&config.Diff.noPager, "no-pager", false, "do not use a pager")
+	persistentFlags.StringVar(&config.entryTypes.include, "include", "", "include entry types (all, dirs, encrypted, files, remove, scripts, or symlinks)")
+	persistentFlags.StringVar(&config.entryTypes.exclude, "exclude", "", "exclude entry types")
+
+	config.Diff.Pager = defaultPager()
 }
 
 func (c *Config) runDiffCmd(fs vfs.FS, args []string) error {
-	mutator := chezmoi.NewLoggingMutator(os.Stdout, chezmoi.NullMutator)
-	return c.applyArgs(fs, args, mutator)
+	c.readOnlyState = true
+	format := chezmoi.DiffFormat(strings.ToLower(c.Diff.Format))
+	switch format {
+	case chezmoi.DiffFormatUnified, chezmoi.DiffFormatGit:
+	default:
+		return fmt.Errorf("%s: unknown format", c.Diff.Format)
+	}
+	color, err := useColor(c.Diff.Color, os.Stdout)
+	if err != nil {
+		return err
+	}
+	mutator := chezmoi.NewDryRunMutator()
+	if err := c.applyArgs(fs, args, mutator, false); err != nil {
+		return err
+	}
+	diffOptions := chezmoi.DiffOptions{
+		Format:        format,
+		DestDir:       c.DestDir,
+		Color:         color,
+		Redact:        secretRedactor.redact,
+		MaxMemorySize: c.MaxMemorySize,
+	}
+	pager := c.Diff.Pager
+	if c.Diff.noPager {
+		pager = ""
+	}
+	return withPager(pager, func(w io.Writer) error {
+		if c.Diff.Command == "" {
+			return chezmoi.WriteDiff(secretRedactor.writer(w), fs, mutator.Operations, diffOptions)
+		}
+		return c.runExternalDiff(w, fs, mutator.Operations, diffOptions)
+	})
+}
+
+// runExternalDiff runs the external diff command, with its output written to
+// w, once for each operation in operations that changes the contents of a
+// file. Other operations, and changes to files that are too large to hold in
+// memory, are written to w with the built-in diff.
+func (c *Config) runExternalDiff(w io.Writer, fs vfs.FS, operations []chezmoi.Operation, diffOptions chezmoi.DiffOptions) error {
+	argTemplates := c.Diff.Args
+	if len(argTemplates) == 0 {
+		argTemplates = defaultDiffArgs
+	}
+	var tmpls []*template.Template
+	for i, argTemplate := range argTemplates {
+		tmpl, err := template.New(fmt.Sprintf("diff.args[%d]", i)).Option("missingkey=error").Parse(argTemplate)
+		if err != nil {
+			return err
+		}
+		tmpls = append(tmpls, tmpl)
+	}
+
+	// The external diff command can only read real files, so write both
+	// sides of each diff to a private temporary directory.
+	tempDir, err := ioutil.TempDir("", "chezmoi-diff")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, o := range operations {
+		var currData, data []byte
+		currExists, exists := true, true
+		switch {
+		case o.Type == chezmoi.OperationCreate && o.Source == "":
+			currExists, data = false, o.Data
+		case o.Type == chezmoi.OperationOverwrite && o.Source == "":
+			currData, data = o.CurrData, o.Data
+		case o.Type == chezmoi.OperationRemove:
+			info, err := fs.Lstat(o.Name)
+			if err == nil && info.Mode().IsRegular() && (diffOptions.MaxMemorySize <= 0 || info.Size() <= diffOptions.MaxMemorySize) {
+				if currData, err = fs.ReadFile(o.Name); err != nil {
+					return err
+				}
+				exists = false
+				break
+			}
+			fallthrough
+		default:
+			if err := chezmoi.WriteDiff(secretRedactor.writer(w), fs, []chezmoi.Operation{o}, diffOptions); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name, err := filepath.Rel(c.DestDir, o.Name)
+		if err != nil || strings.HasPrefix(name, "..") {
+			name = filepath.Base(o.Name)
+		}
+		cmdData := diffCommandData{
+			Name:        name,
+			Destination: os.DevNull,
+			Target:      os.DevNull,
+		}
+		if currExists {
+			if cmdData.Destination, err = writeDiffTempFile(tempDir, "destination", name, currData); err != nil {
+				return err
+			}
+		}
+		if exists {
+			if cmdData.Target, err = writeDiffTempFile(tempDir, "target", name, data); err != nil {
+				return err
+			}
+		}
+		var args []string
+		for _, tmpl := range tmpls {
+			b := &bytes.Buffer{}
+			if err := tmpl.Execute(b, cmdData); err != nil {
+				return err
+			}
+			args = append(args, b.String())
+		}
+		cmd := exec.Command(c.Diff.Command, args...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = w
+		cmd.Stderr = os.Stderr
+		// Like diff, many diff commands exit with status 1 when the files
+		// differ.
+		if err := cmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+				return fmt.Errorf("%s: %v", c.Diff.Command, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeDiffTempFile writes data to the file name in the subdirectory side of
+// tempDir and returns its path.
+func writeDiffTempFile(tempDir, side, name string, data []byte) (string, error) {
+	path := filepath.Join(tempDir, side, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
 }
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestRunExternalDiff(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake diff command requires a shell")
+	}
+	dir, err := ioutil.TempDir("", "chezmoi-test-diff")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(dir)
+	diff := filepath.Join(dir, "diff")
+	log := filepath.Join(dir, "log")
+	// Like diff, the fake diff command exits with status 1 when the files
+	// differ.
+	script := `#!/bin/sh
+echo "$1: $(cat "$2") -> $(cat "$3")" >> ` + log + `
+exit 1
+`
+	if err := ioutil.WriteFile(diff, []byte(script), 0755); err != nil {
+		t.Fatalf("ioutil.WriteFile(%q, _, 0755) == %v, want <nil>", diff, err)
+	}
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".old": "old",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	c := &Config{
+		DestDir: "/home/user",
+		Diff: diffConfig{
+			Command: diff,
+			Args:    []string{"{{ .Name }}", "{{ .Destination }}", "{{ .Target }}"},
+		},
+	}
+	operations := []chezmoi.Operation{
+		{
+			Type: chezmoi.OperationRemove,
+			Name: "/home/user/.old",
+		},
+		{
+			Type:     chezmoi.OperationOverwrite,
+			Name:     "/home/user/.bashrc",
+			Mode:     0644,
+			Data:     []byte("new"),
+			CurrData: []byte("current"),
+		},
+		{
+			Type: chezmoi.OperationCreate,
+			Name: "/home/user/.dir/file",
+			Mode: 0644,
+			Data: []byte("created"),
+		},
+	}
+	if err := c.runExternalDiff(ioutil.Discard, fs, operations, chezmoi.DiffOptions{}); err != nil {
+		t.Fatalf("c.runExternalDiff(...) == %v, want <nil>", err)
+	}
+	got, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile(%q) == _, %v, want _, <nil>", log, err)
+	}
+	want := "" +
+		".old: old -> \n" +
+		".bashrc: current -> new\n" +
+		".dir/file:  -> created\n"
+	if string(got) != want {
+		t.Errorf("c.runExternalDiff(...) ran\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDiffThenApplyRunOnChange(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi/run_onchange_count.tmpl": "#!/bin/sh\necho {{ .version }} >> count\n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	newConfig := func(version int) *Config {
+		return &Config{
+			configFile: "/home/user/.config/chezmoi/chezmoi.toml",
+			cacheDir:   "/home/user/.cache/chezmoi",
+			stateDir:   "/home/user/.local/state/chezmoi",
+			SourceDir:  "/home/user/.chezmoi",
+			DestDir:    "/home/user",
+			Umask:      022,
+			Data: map[string]interface{}{
+				"version": version,
+			},
+			Diff: diffConfig{
+				Format:  "unified",
+				noPager: true,
+			},
+		}
+	}
+	for _, tc := range []struct {
+		cmd     string
+		version int
+	}{
+		{cmd: "diff", version: 1},
+		{cmd: "verify", version: 1},
+		{cmd: "apply", version: 1},
+		{cmd: "diff", version: 2},
+		{cmd: "verify", version: 2},
+		{cmd: "apply", version: 2},
+		{cmd: "apply", version: 2},
+	} {
+		c := newConfig(tc.version)
+		var err error
+		switch tc.cmd {
+		case "apply":
+			err = c.runApplyCmd(fs, nil)
+		case "diff":
+			err = c.runDiffCmd(fs, nil)
+		case "verify":
+			err = c.runVerifyCmd(fs, nil)
+		}
+		if err != nil {
+			t.Fatalf("%s with version %d: %v, want <nil>", tc.cmd, tc.version, err)
+		}
+		if err := c.closePersistentState(); err != nil {
+			t.Fatalf("c.closePersistentState() == %v, want <nil>", err)
+		}
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/count",
+			vfst.TestContentsString("1\n2\n"),
+		),
+	)
+}
@@ -125,6 +125,7 @@ func (c *Config) runDoctorCmd(fs vfs.FS, args []string) error {
 			binaryName:    c.Onepassword.Op,
 			versionArgs:   []string{"--version"},
 			versionRegexp: regexp.MustCompile(`^(\d+\.\d+\.\d+)`),
+			minVersion:    &onepasswordMinVersion,
 		},
 		&doctorBinaryCheck{
 			name:          "Bitwarden CLI",
@@ -151,6 +152,18 @@ func (c *Config) runDoctorCmd(fs vfs.FS, args []string) error {
 			versionArgs:   []string{"version"},
 			versionRegexp: regexp.MustCompile(`^Vault\s+v(\d+\.\d+\.\d+)`),
 		},
+		&doctorBinaryCheck{
+			name:          "gopass CLI",
+			binaryName:    c.Gopass.Gopass,
+			versionArgs:   []string{"--version"},
+			versionRegexp: regexp.MustCompile(`(\d+\.\d+\.\d+)`),
+		},
+		&doctorBinaryCheck{
+			name:          "KeePassXC CLI",
+			binaryName:    c.Keepassxc.Command,
+			versionArgs:   []string{"--version"},
+			versionRegexp: regexp.MustCompile(`^(\d+\.\d+\.\d+)`),
+		},
 		&doctorBinaryCheck{
 			name:       "generic secret CLI",
 			binaryName: c.GenericSecret.Command,
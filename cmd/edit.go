@@ -14,7 +14,7 @@ var editCmd = &cobra.Command{
 	Use:   "edit targets...",
 	Args:  cobra.MinimumNArgs(1),
 	Short: "Edit the source state of a target",
-	RunE:  makeRunE(config.runEditCmd),
+	RunE:  makeLockedRunE(config.runEditCmd),
 }
 
 type editCmdConfig struct {
@@ -46,7 +46,7 @@ func (c *Config) runEditCmd(fs vfs.FS, args []string) error {
 	}
 	argv := []string{}
 	for _, entry := range entries {
-		argv = append(argv, filepath.Join(c.SourceDir, entry.SourceName()))
+		argv = append(argv, filepath.Join(ts.SourceDir, entry.SourceName()))
 	}
 	if !c.edit.diff && !c.edit.apply {
 		return c.execEditor(argv...)
@@ -60,7 +60,7 @@ func (c *Config) runEditCmd(fs vfs.FS, args []string) error {
 		anyMutator := chezmoi.NewAnyMutator(chezmoi.NullMutator)
 		var mutator chezmoi.Mutator = anyMutator
 		if c.edit.diff {
-			mutator = chezmoi.NewLoggingMutator(os.Stdout, mutator)
+			mutator = chezmoi.NewLoggingMutator(secretRedactor.writer(os.Stdout), mutator)
 		}
 		if err := entry.Apply(readOnlyFS, ts.DestDir, ts.TargetIgnore.Match, ts.Umask, mutator); err != nil {
 			return err
@@ -81,7 +81,11 @@ func (c *Config) runEditCmd(fs vfs.FS, args []string) error {
 					c.edit.prompt = false
 				}
 			}
-			if err := entry.Apply(readOnlyFS, ts.DestDir, ts.TargetIgnore.Match, ts.Umask, applyMutator); err != nil {
+			plan, err := ts.PlanEntriesContext(c.getContext(), readOnlyFS, []chezmoi.Entry{entry})
+			if err != nil {
+				return err
+			}
+			if err := ts.ExecutePlanContext(c.getContext(), plan, applyMutator); err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+type ageConfig struct {
+	Command         string
+	Args            []string
+	Identities      []string
+	Recipients      []string
+	RecipientsFiles []string
+}
+
+type gpgConfig struct {
+	Command         string
+	Args            []string
+	Recipient       string
+	Symmetric       bool
+	CachePassphrase bool
+}
+
+// encryptionTools are the encryption tools that can be selected with the
+// encryption config variable, keyed by name.
+var encryptionTools = map[string]func(*Config, vfs.FS) (chezmoi.Encryption, error){
+	"age": func(c *Config, fs vfs.FS) (chezmoi.Encryption, error) {
+		return c.getAge(fs)
+	},
+	"gpg": func(c *Config, fs vfs.FS) (chezmoi.Encryption, error) {
+		return c.getGPG(), nil
+	},
+}
+
+func init() {
+	config.addSecretTemplateFunc("decrypt", config.decryptFunc)
+	config.addTemplateFunc("encrypt", config.encryptFunc)
+}
+
+// defaultAgeSSHKeys are the SSH keys, relative to the home directory, that age
+// uses if no identities or recipients are configured.
+var defaultAgeSSHKeys = []string{
+	".ssh/id_ed25519",
+	".ssh/id_rsa",
+}
+
+// getAge returns the configured age. If no identities are configured then the
+// user's existing SSH private keys are used, and if no recipients are
+// configured then the corresponding public keys are used.
+func (c *Config) getAge(fs vfs.FS) (*chezmoi.Age, error) {
+	homeDir, err := userHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	age := &chezmoi.Age{
+		Command:         c.Age.Command,
+		Args:            c.Age.Args,
+		Identities:      expandTildes(c.Age.Identities, homeDir),
+		Recipients:      c.Age.Recipients,
+		RecipientsFiles: expandTildes(c.Age.RecipientsFiles, homeDir),
+	}
+	if len(age.Identities) != 0 && (len(age.Recipients) != 0 || len(age.RecipientsFiles) != 0) {
+		return age, nil
+	}
+	var identities, recipientsFiles []string
+	for _, key := range defaultAgeSSHKeys {
+		path := filepath.Join(homeDir, key)
+		if _, err := fs.Stat(path); err == nil {
+			identities = append(identities, path)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if _, err := fs.Stat(path + ".pub"); err == nil {
+			recipientsFiles = append(recipientsFiles, path+".pub")
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if len(age.Identities) == 0 {
+		age.Identities = identities
+	}
+	if len(age.Recipients) == 0 && len(age.RecipientsFiles) == 0 {
+		age.RecipientsFiles = recipientsFiles
+	}
+	return age, nil
+}
+
+// getEncryption returns the configured encryption tool, which is gpg by
+// default.
+func (c *Config) getEncryption(fs vfs.FS) (chezmoi.Encryption, error) {
+	name := c.encryptionName()
+	newEncryption, ok := encryptionTools[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown encryption tool", name)
+	}
+	return newEncryption(c, fs)
+}
+
+// getEncryptions returns all encryption tools, keyed by name, for selection
+// by .chezmoiencryption files. encryption is used for the configured tool so
+// that it shares any cached passphrase.
+func (c *Config) getEncryptions(fs vfs.FS, encryption chezmoi.Encryption) (map[string]chezmoi.Encryption, error) {
+	configuredName := c.encryptionName()
+	encryptions := make(map[string]chezmoi.Encryption)
+	for name, newEncryption := range encryptionTools {
+		if name == configuredName {
+			encryptions[name] = encryption
+			continue
+		}
+		e, err := newEncryption(c, fs)
+		if err != nil {
+			return nil, err
+		}
+		encryptions[name] = e
+	}
+	return encryptions, nil
+}
+
+// getGPG returns the configured gpg.
+func (c *Config) getGPG() *chezmoi.GPG {
+	gpg := &chezmoi.GPG{
+		Command:   c.GPG.Command,
+		Args:      c.GPG.Args,
+		Recipient: c.GPG.Recipient,
+		Symmetric: c.GPG.Symmetric,
+	}
+	if c.GPG.CachePassphrase {
+		gpg.Passphrase = func() ([]byte, error) {
+			return readPassword("Passphrase: ")
+		}
+	}
+	return gpg
+}
+
+func (c *Config) decryptFunc(ciphertext string) string {
+	encryption, err := c.getCachedEncryption()
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	plaintext, err := encryption.Decrypt("decrypt", []byte(ciphertext))
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	return string(plaintext)
+}
+
+func (c *Config) encryptFunc(plaintext string) string {
+	encryption, err := c.getCachedEncryption()
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	ciphertext, err := encryption.Encrypt("encrypt", []byte(plaintext))
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	return string(ciphertext)
+}
+
+// getCachedEncryption returns the encryption tool used by the target state,
+// so that its users share any cached passphrase, or the configured encryption
+// tool if there is no target state.
+func (c *Config) getCachedEncryption() (chezmoi.Encryption, error) {
+	c.encryptionMu.Lock()
+	defer c.encryptionMu.Unlock()
+	if c.encryption == nil {
+		encryption, err := c.getEncryption(vfs.OSFS)
+		if err != nil {
+			return nil, err
+		}
+		c.encryption = encryption
+	}
+	return c.encryption, nil
+}
+
+// encryptionName returns the name of the configured encryption tool.
+func (c *Config) encryptionName() string {
+	if c.Encryption == "" {
+		return "gpg"
+	}
+	return c.Encryption
+}
+
+// expandTildes returns paths with any leading ~/ replaced with homeDir.
+func expandTildes(paths []string, homeDir string) []string {
+	var result []string
+	for _, path := range paths {
+		if strings.HasPrefix(path, "~/") {
+			path = filepath.Join(homeDir, path[2:])
+		}
+		result = append(result, path)
+	}
+	return result
+}
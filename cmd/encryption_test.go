@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	vfs "github.com/twpayne/go-vfs"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestGetEncryption(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses $HOME")
+	}
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	if err := os.Setenv("HOME", "/home/user"); err != nil {
+		t.Fatalf("os.Setenv(...) == %v, want <nil>", err)
+	}
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.ssh/id_ed25519":     "# contents of .ssh/id_ed25519\n",
+		"/home/user/.ssh/id_ed25519.pub": "# contents of .ssh/id_ed25519.pub\n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	for _, tc := range []struct {
+		name    string
+		c       *Config
+		want    chezmoi.Encryption
+		wantErr bool
+	}{
+		{
+			name: "default",
+			c: &Config{
+				GPG: gpgConfig{
+					Recipient: "user@example.com",
+				},
+			},
+			want: &chezmoi.GPG{
+				Recipient: "user@example.com",
+			},
+		},
+		{
+			name: "age_ssh_keys",
+			c: &Config{
+				Encryption: "age",
+			},
+			want: &chezmoi.Age{
+				Identities:      []string{"/home/user/.ssh/id_ed25519"},
+				RecipientsFiles: []string{"/home/user/.ssh/id_ed25519.pub"},
+			},
+		},
+		{
+			name: "age_configured",
+			c: &Config{
+				Encryption: "age",
+				Age: ageConfig{
+					Command:    "rage",
+					Identities: []string{"~/.config/age/key.txt"},
+					Recipients: []string{"age1"},
+				},
+			},
+			want: &chezmoi.Age{
+				Command:    "rage",
+				Identities: []string{"/home/user/.config/age/key.txt"},
+				Recipients: []string{"age1"},
+			},
+		},
+		{
+			name: "unknown",
+			c: &Config{
+				Encryption: "rot13",
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.c.getEncryption(fs)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("c.getEncryption(fs) == _, <nil>, want _, !<nil>")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("c.getEncryption(fs) == _, %v, want _, <nil>", err)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.want, got); !equal {
+				t.Errorf("c.getEncryption(fs) diff:\n%s", diff)
+			}
+		})
+	}
+}
+
+// A reverseEncryption is a chezmoi.Encryption that reverses its input.
+type reverseEncryption struct{}
+
+func init() {
+	encryptionTools["reverse"] = func(*Config, vfs.FS) (chezmoi.Encryption, error) {
+		return reverseEncryption{}, nil
+	}
+}
+
+func (reverseEncryption) Decrypt(name string, ciphertext []byte) ([]byte, error) {
+	return reverseBytes(ciphertext), nil
+}
+
+func (reverseEncryption) DecryptFile(fs vfs.FS, path string) ([]byte, error) {
+	ciphertext, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return reverseBytes(ciphertext), nil
+}
+
+func (reverseEncryption) Encrypt(name string, plaintext []byte) ([]byte, error) {
+	return reverseBytes(plaintext), nil
+}
+
+func (reverseEncryption) EncryptFile(fs vfs.FS, path string) ([]byte, error) {
+	plaintext, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return reverseBytes(plaintext), nil
+}
+
+func reverseBytes(b []byte) []byte {
+	result := make([]byte, len(b))
+	for i, c := range b {
+		result[len(b)-1-i] = c
+	}
+	return result
+}
+
+func TestEncryptionTemplateFuncs(t *testing.T) {
+	c := &Config{
+		encryption: reverseEncryption{},
+	}
+	tmpl, err := template.New("token").Funcs(template.FuncMap{
+		"decrypt": c.decryptFunc,
+		"encrypt": c.encryptFunc,
+	}).Parse(`token = {{ decrypt "terces" }}{{ if ne (encrypt "secret") "terces" }} wrong{{ end }}`)
+	if err != nil {
+		t.Fatalf("template.Parse(_) == _, %v, want _, <nil>", err)
+	}
+	sb := &strings.Builder{}
+	if err := tmpl.Execute(sb, nil); err != nil {
+		t.Fatalf("tmpl.Execute(_, nil) == %v, want <nil>", err)
+	}
+	if got, want := sb.String(), "token = secret"; got != want {
+		t.Errorf("tmpl.Execute(_, nil) wrote %q, want %q", got, want)
+	}
+}
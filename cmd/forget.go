@@ -12,7 +12,7 @@ var forgetCmd = &cobra.Command{
 	Aliases: []string{"unmanage"},
 	Args:    cobra.MinimumNArgs(1),
 	Short:   "Remove a target from the source state",
-	RunE:    makeRunE(config.runForgetCmd),
+	RunE:    makeLockedRunE(config.runForgetCmd),
 }
 
 func init() {
@@ -30,7 +30,7 @@ func (c *Config) runForgetCmd(fs vfs.FS, args []string) error {
 	}
 	mutator := c.getDefaultMutator(fs)
 	for _, entry := range entries {
-		if err := mutator.RemoveAll(filepath.Join(c.SourceDir, entry.SourceName())); err != nil {
+		if err := mutator.RemoveAll(filepath.Join(ts.SourceDir, entry.SourceName())); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+)
+
+type gitHubConfig struct {
+	Token         string
+	RefreshPeriod time.Duration
+	baseURL       string
+}
+
+type gitHubRelease struct {
+	TagName     string               `json:"tag_name"`
+	Name        string               `json:"name"`
+	HTMLURL     string               `json:"html_url"`
+	Prerelease  bool                 `json:"prerelease"`
+	PublishedAt time.Time            `json:"published_at"`
+	Assets      []gitHubReleaseAsset `json:"assets"`
+}
+
+type gitHubReleaseAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+type gitHubTag struct {
+	Name   string `json:"name"`
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+var gitHubCache = make(map[string][]byte)
+
+// gitHubCacheMu guards gitHubCache. It is held while requests are made, so
+// each request is only made once.
+var gitHubCacheMu sync.Mutex
+
+func init() {
+	config.GitHub.RefreshPeriod = time.Minute
+	config.GitHub.baseURL = "https://api.github.com"
+	config.addTemplateFunc("gitHubLatestRelease", config.gitHubLatestReleaseFunc)
+	config.addTemplateFunc("gitHubLatestTag", config.gitHubLatestTagFunc)
+}
+
+func (c *Config) gitHubLatestReleaseFunc(ownerRepo string) *gitHubRelease {
+	release := &gitHubRelease{}
+	if err := c.gitHubGet("gitHubLatestRelease", ownerRepo, "releases/latest", release); err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	return release
+}
+
+func (c *Config) gitHubLatestTagFunc(ownerRepo string) *gitHubTag {
+	var tags []*gitHubTag
+	if err := c.gitHubGet("gitHubLatestTag", ownerRepo, "tags", &tags); err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	if len(tags) == 0 {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("gitHubLatestTag: %s: no tags", ownerRepo))
+	}
+	return tags[0]
+}
+
+// gitHubGet decodes the response to the GitHub API request for path in the
+// repo ownerRepo into value. Responses are cached in memory for the duration
+// of the command, and on disk for c.GitHub.RefreshPeriod.
+func (c *Config) gitHubGet(funcName, ownerRepo, path string, value interface{}) error {
+	components := strings.Split(ownerRepo, "/")
+	if len(components) != 2 || components[0] == "" || components[1] == "" {
+		return fmt.Errorf("%s: %q: not an owner/repo", funcName, ownerRepo)
+	}
+	urlPath := "/repos/" + ownerRepo + "/" + path
+	body, err := c.cachedGitHubBody(urlPath)
+	if err != nil {
+		return fmt.Errorf("%s: %v", funcName, err)
+	}
+	if err := json.Unmarshal(body, value); err != nil {
+		return fmt.Errorf("%s: %s: %v", funcName, urlPath, err)
+	}
+	return nil
+}
+
+// cachedGitHubBody returns the body of the response to the GitHub API request
+// for urlPath, from the in-memory or on-disk cache if possible.
+func (c *Config) cachedGitHubBody(urlPath string) ([]byte, error) {
+	gitHubCacheMu.Lock()
+	defer gitHubCacheMu.Unlock()
+	if body, ok := gitHubCache[urlPath]; ok {
+		return body, nil
+	}
+	body, err := c.readGitHubCache(urlPath)
+	if err == nil && body == nil {
+		body, err = c.fetchGitHub(urlPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	gitHubCache[urlPath] = body
+	return body, nil
+}
+
+// fetchGitHub returns the response to the GitHub API request for urlPath and
+// caches it on disk. The token is c.GitHub.Token or, if it is empty,
+// $GITHUB_TOKEN.
+func (c *Config) fetchGitHub(urlPath string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, c.GitHub.baseURL+urlPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(c.getContext())
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	token := c.GitHub.Token
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+	client := &http.Client{
+		Transport: &chezmoi.RetryTransport{
+			Policy: c.getRetryPolicy(),
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &chezmoi.HTTPStatusError{
+			URL:        req.URL.String(),
+			StatusCode: resp.StatusCode,
+		}
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("%s: invalid JSON", req.URL)
+	}
+	if err := c.writeGitHubCache(urlPath, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// gitHubCachePath returns the path of the on-disk cache of the response to
+// the GitHub API request for urlPath.
+func (c *Config) gitHubCachePath(urlPath string) string {
+	return filepath.Join(c.cacheDir, "github", filepath.FromSlash(strings.TrimPrefix(urlPath, "/"))+".json")
+}
+
+// readGitHubCache returns the cached response to the GitHub API request for
+// urlPath, or nil if there is no cached response or it is older than
+// c.GitHub.RefreshPeriod.
+func (c *Config) readGitHubCache(urlPath string) ([]byte, error) {
+	if c.cacheDir == "" {
+		return nil, nil
+	}
+	path := c.gitHubCachePath(urlPath)
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case time.Since(info.ModTime()) >= c.GitHub.RefreshPeriod:
+		return nil, nil
+	}
+	return ioutil.ReadFile(path)
+}
+
+// writeGitHubCache caches body as the response to the GitHub API request for
+// urlPath. Nothing is written in dry run mode.
+func (c *Config) writeGitHubCache(urlPath string, body []byte) error {
+	if c.cacheDir == "" || c.DryRun {
+		return nil
+	}
+	path := c.gitHubCachePath(urlPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, body, 0600)
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGitHubTemplateFuncs(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if got, want := r.Header.Get("Authorization"), "token secret"; got != want {
+			t.Errorf("r.Header.Get(\"Authorization\") == %q, want %q", got, want)
+		}
+		switch r.URL.Path {
+		case "/repos/owner/repo/releases/latest":
+			w.Write([]byte(`{"tag_name": "v1.2.3", "name": "Release 1.2.3", "assets": [{"name": "repo_linux_amd64.tar.gz"}]}`))
+		case "/repos/owner/repo/tags":
+			w.Write([]byte(`[{"name": "v1.2.4", "commit": {"sha": "abc123"}}, {"name": "v1.2.3"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "chezmoi-test-github")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	c := &Config{
+		cacheDir: cacheDir,
+		GitHub: gitHubConfig{
+			Token:         "secret",
+			RefreshPeriod: time.Minute,
+			baseURL:       server.URL,
+		},
+	}
+	clearGitHubCache := func() {
+		for key := range gitHubCache {
+			delete(gitHubCache, key)
+		}
+	}
+	clearGitHubCache()
+	defer clearGitHubCache()
+
+	release := c.gitHubLatestReleaseFunc("owner/repo")
+	if release.TagName != "v1.2.3" || len(release.Assets) != 1 || release.Assets[0].Name != "repo_linux_amd64.tar.gz" {
+		t.Errorf("c.gitHubLatestReleaseFunc(\"owner/repo\") == %+v, want TagName v1.2.3 and one asset", release)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "github", "repos", "owner", "repo", "releases", "latest.json")); err != nil {
+		t.Errorf("os.Stat(_) == _, %v, want _, <nil>", err)
+	}
+
+	// The response is cached in memory and on disk.
+	c.gitHubLatestReleaseFunc("owner/repo")
+	clearGitHubCache()
+	c.gitHubLatestReleaseFunc("owner/repo")
+	if requests != 1 {
+		t.Errorf("requests == %d, want 1", requests)
+	}
+
+	// Expired responses are fetched again.
+	c.GitHub.RefreshPeriod = 0
+	clearGitHubCache()
+	c.gitHubLatestReleaseFunc("owner/repo")
+	if requests != 2 {
+		t.Errorf("requests == %d, want 2", requests)
+	}
+
+	if tag := c.gitHubLatestTagFunc("owner/repo"); tag.Name != "v1.2.4" || tag.Commit.SHA != "abc123" {
+		t.Errorf("c.gitHubLatestTagFunc(\"owner/repo\") == %+v, want Name v1.2.4 and SHA abc123", tag)
+	}
+
+	for _, ownerRepo := range []string{"owner", "owner/", "owner/repo/extra", "owner/missing"} {
+		if err := c.gitHubGet("gitHubLatestRelease", ownerRepo, "releases/latest", &gitHubRelease{}); err == nil {
+			t.Errorf("c.gitHubGet(_, %q, _, _) == <nil>, want !<nil>", ownerRepo)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"archive/tar"
+	"bufio"
 	"compress/bzip2"
 	"compress/gzip"
 	"fmt"
@@ -18,13 +19,18 @@ import (
 var _importCmd = &cobra.Command{
 	Use:   "import [filename]",
 	Args:  cobra.MaximumNArgs(1),
-	Short: "Import a tar archive into the source state",
-	RunE:  makeRunE(config.runImportCmd),
+	Short: "Import a tar archive, stow directory, or list of paths into the source state",
+	RunE:  makeLockedRunE(config.runImportCmd),
 }
 
 type importCmdConfig struct {
 	removeDestination bool
 	importTAROptions  chezmoi.ImportTAROptions
+	stow              bool
+	stowPackages      []string
+	stowDotfiles      bool
+	conflict          string
+	paths             bool
 }
 
 func init() {
@@ -35,6 +41,11 @@ func init() {
 	persistentFlags.BoolVarP(&config._import.importTAROptions.Exact, "exact", "x", false, "import directories exactly")
 	persistentFlags.IntVar(&config._import.importTAROptions.StripComponents, "strip-components", 0, "strip components")
 	persistentFlags.BoolVarP(&config._import.removeDestination, "remove-destination", "r", false, "remove destination before import")
+	persistentFlags.BoolVar(&config._import.stow, "stow", false, "import a stow directory")
+	persistentFlags.StringSliceVar(&config._import.stowPackages, "package", nil, "stow packages to import")
+	persistentFlags.BoolVar(&config._import.stowDotfiles, "dotfiles", false, "convert stow dot- prefixes")
+	persistentFlags.StringVar(&config._import.conflict, "conflict", "error", "stow conflict policy (error, skip, or overwrite)")
+	persistentFlags.BoolVar(&config._import.paths, "paths", false, "import a newline-separated list of paths")
 }
 
 func (c *Config) runImportCmd(fs vfs.FS, args []string) error {
@@ -42,6 +53,12 @@ func (c *Config) runImportCmd(fs vfs.FS, args []string) error {
 	if err != nil {
 		return err
 	}
+	switch {
+	case c._import.stow:
+		return c.runImportStow(fs, ts, args)
+	case c._import.paths:
+		return c.runImportPaths(fs, ts, args)
+	}
 	var r io.Reader
 	if len(args) == 0 {
 		r = os.Stdin
@@ -71,7 +88,7 @@ func (c *Config) runImportCmd(fs vfs.FS, args []string) error {
 		entry, err := ts.Get(c._import.importTAROptions.DestinationDir)
 		switch {
 		case err == nil:
-			if err := mutator.RemoveAll(filepath.Join(c.SourceDir, entry.SourceName())); err != nil {
+			if err := mutator.RemoveAll(filepath.Join(ts.SourceDir, entry.SourceName())); err != nil {
 				return err
 			}
 		case os.IsNotExist(err):
@@ -81,3 +98,66 @@ func (c *Config) runImportCmd(fs vfs.FS, args []string) error {
 	}
 	return ts.ImportTAR(tar.NewReader(r), c._import.importTAROptions, mutator)
 }
+
+func (c *Config) runImportPaths(fs vfs.FS, ts *chezmoi.TargetState, args []string) error {
+	var r io.Reader
+	if len(args) == 0 {
+		r = os.Stdin
+	} else {
+		f, err := fs.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var targetPaths []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			targetPaths = append(targetPaths, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	report, err := ts.ImportPaths(fs, targetPaths, chezmoi.AddOptions{}, c.getDefaultMutator(fs))
+	if report != nil {
+		printImportReport(report)
+	}
+	return err
+}
+
+func (c *Config) runImportStow(fs vfs.FS, ts *chezmoi.TargetState, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("--stow requires a stow directory")
+	}
+	importStowOptions := chezmoi.ImportStowOptions{
+		Packages: c._import.stowPackages,
+		Dotfiles: c._import.stowDotfiles,
+	}
+	switch c._import.conflict {
+	case "error":
+		importStowOptions.ConflictPolicy = chezmoi.ConflictError
+	case "skip":
+		importStowOptions.ConflictPolicy = chezmoi.ConflictSkip
+	case "overwrite":
+		importStowOptions.ConflictPolicy = chezmoi.ConflictOverwrite
+	default:
+		return fmt.Errorf("%s: unknown conflict policy", c._import.conflict)
+	}
+	report, err := ts.ImportStow(fs, args[0], importStowOptions, c.getDefaultMutator(fs))
+	if report != nil {
+		printImportReport(report)
+	}
+	return err
+}
+
+func printImportReport(report *chezmoi.ImportReport) {
+	for _, targetName := range report.Skipped {
+		fmt.Printf("skipped %s\n", targetName)
+	}
+	for _, targetName := range report.Conflicted {
+		fmt.Printf("conflict %s\n", targetName)
+	}
+}
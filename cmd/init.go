@@ -1,11 +1,21 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/template"
 
+	"github.com/Masterminds/sprig"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
 	vfs "github.com/twpayne/go-vfs"
 )
 
@@ -19,6 +29,11 @@ This command is supposed to run once when you want to setup your dotfiles on a
 new host. It will clone the given repository into your source directory (see --source flag)
 and make sure that all directory permissions are correct.
 
+If the source directory contains a .chezmoi.<format>.tmpl file, where <format>
+is json, toml, or yaml, then it is executed as a template to create your config
+file. The template can ask questions with the promptString, promptBool,
+promptInt, promptChoice, and promptMultichoice functions.
+
 After your source directory was checked out and setup (e.g. git submodules) this
 command can automatically invoke the "apply" command to update the destination
 directory if you supply the flag.
@@ -30,7 +45,7 @@ directory if you supply the flag.
   # Checkout from github using your private key
   chezmoi init git@github.com:example/dotfiles.git
 `,
-	RunE: makeRunE(config.runInitCmd),
+	RunE: makeLockedRunE(config.runInitCmd),
 }
 
 type initCmdConfig struct {
@@ -96,12 +111,343 @@ func (c *Config) runInitCmd(fs vfs.FS, args []string) error {
 				}
 			}
 		}
-		if c.init.apply {
-			if err := c.applyArgs(fs, nil, mutator); err != nil {
-				return err
-			}
+	}
+
+	if err := c.createConfigFile(fs, mutator, os.Stdin, os.Stdout); err != nil {
+		return err
+	}
+
+	if c.init.apply {
+		if err := c.applyArgs(fs, nil, mutator, true); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
+
+// createConfigFile creates the config file by executing the first
+// .chezmoi.<format>.tmpl file in the source directory, if any, reading answers
+// to prompts from stdin, and then reads the new config file. The SHA256 of the
+// template is recorded in the persistent state so that later commands can warn
+// if it changes.
+func (c *Config) createConfigFile(fs vfs.FS, mutator chezmoi.Mutator, stdin io.Reader, stdout io.Writer) error {
+	name, format, source, err := c.findConfigTemplate(fs)
+	if err != nil || name == "" {
+		return err
+	}
+
+	defaultData, err := getDefaultData(fs)
+	if err != nil {
+		return err
+	}
+	funcs := sprig.HermeticTxtFuncMap()
+	for key, value := range c.templateFuncs {
+		funcs[key] = value
+	}
+	for key, value := range promptFuncs(bufio.NewReader(stdin), stdout) {
+		funcs[key] = value
+	}
+	contents, err := chezmoi.TextTemplateEngine.Execute(name, source, funcs, map[string]interface{}{
+		"chezmoi": defaultData,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+
+	configDir := filepath.Dir(c.configFile)
+	if err := vfs.MkdirAll(mutator, configDir, 0700); err != nil {
+		return err
+	}
+	configFile := filepath.Join(configDir, "chezmoi."+format)
+	currContents, err := fs.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := mutator.WriteFile(configFile, contents, 0600, currContents); err != nil {
+		return err
+	}
+	persistentState, err := c.getPersistentState(fs)
+	if err != nil {
+		return err
+	}
+	if err := chezmoi.RecordConfigTemplate(persistentState, configFile, source); err != nil {
+		return err
+	}
+
+	// Read the new config from contents, rather than from the file, so that it
+	// is also used in dry run mode.
+	c.configFile = configFile
+	viper.SetConfigType(format)
+	if err := viper.ReadConfig(bytes.NewReader(contents)); err != nil {
+		return fmt.Errorf("%s: %v", configFile, err)
+	}
+	return viper.Unmarshal(c)
+}
+
+// findConfigTemplate returns the name, format, and contents of the first
+// .chezmoi.<format>.tmpl file in the source directory, or an empty name if
+// there is none.
+func (c *Config) findConfigTemplate(fs vfs.FS) (string, string, []byte, error) {
+	for _, format := range []string{"json", "toml", "yaml"} {
+		name := ".chezmoi." + format + ".tmpl"
+		source, err := fs.ReadFile(filepath.Join(c.SourceDir, name))
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return "", "", nil, err
+		}
+		return name, format, source, nil
+	}
+	return "", "", nil, nil
+}
+
+// warnIfConfigTemplateChanged prints a warning if the config template has
+// changed since the config file was generated from it.
+func (c *Config) warnIfConfigTemplateChanged(fs vfs.FS, persistentState chezmoi.PersistentState) error {
+	name, _, source, err := c.findConfigTemplate(fs)
+	if err != nil || name == "" {
+		return err
+	}
+	changed, err := chezmoi.ConfigTemplateChanged(persistentState, c.configFile, source)
+	if err != nil {
+		return err
+	}
+	if changed {
+		fmt.Fprintf(os.Stderr, "chezmoi: warning: %s has changed since %s was generated from it, run chezmoi init to regenerate it\n", name, c.configFile)
+	}
+	return nil
+}
+
+// A prompter asks questions on w and reads the answers from r.
+type prompter struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+// promptFuncs returns template functions that prompt on w and read answers
+// from r. Each function takes an optional default value, which is used if the
+// answer is empty, and asks again if the answer is invalid.
+func promptFuncs(r *bufio.Reader, w io.Writer) template.FuncMap {
+	p := &prompter{
+		r: r,
+		w: w,
+	}
+	return template.FuncMap{
+		"promptBool":        p.promptBool,
+		"promptChoice":      p.promptChoice,
+		"promptInt":         p.promptInt,
+		"promptMultichoice": p.promptMultichoice,
+		"promptString":      p.promptString,
+	}
+}
+
+// ask writes prompt and passes the answer to parse until parse accepts it. If
+// parse rejects an answer then its error is written and the question is asked
+// again, unless there are no more answers.
+func (p *prompter) ask(prompt string, parse func(string) error) error {
+	for {
+		if _, err := fmt.Fprintf(p.w, "%s? ", prompt); err != nil {
+			return err
+		}
+		line, err := p.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return io.ErrUnexpectedEOF
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+		parseErr := parse(strings.TrimSpace(line))
+		if parseErr == nil || err == io.EOF {
+			return parseErr
+		}
+		if _, err := fmt.Fprintln(p.w, parseErr); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *prompter) promptBool(prompt string, defaultValue ...bool) (bool, error) {
+	if len(defaultValue) > 1 {
+		return false, errTooManyDefaults(prompt)
+	}
+	var result bool
+	if len(defaultValue) == 1 {
+		prompt = fmt.Sprintf("%s (default %t)", prompt, defaultValue[0])
+	}
+	err := p.ask(prompt, func(s string) error {
+		switch strings.ToLower(s) {
+		case "":
+			if len(defaultValue) == 0 {
+				return errors.New("answer yes or no")
+			}
+			result = defaultValue[0]
+		case "y", "yes", "on":
+			result = true
+		case "n", "no", "off":
+			result = false
+		default:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("%q: answer yes or no", s)
+			}
+			result = b
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (p *prompter) promptChoice(prompt string, choices interface{}, defaultValue ...string) (string, error) {
+	choiceStrs, err := toStrings(choices)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", prompt, err)
+	}
+	if len(defaultValue) > 1 {
+		return "", errTooManyDefaults(prompt)
+	}
+	prompt = fmt.Sprintf("%s (%s", prompt, strings.Join(choiceStrs, "/"))
+	if len(defaultValue) == 1 {
+		if !contains(choiceStrs, defaultValue[0]) {
+			return "", fmt.Errorf("%s: default %q is not a choice", prompt, defaultValue[0])
+		}
+		prompt += ", default " + defaultValue[0]
+	}
+	prompt += ")"
+	var result string
+	err = p.ask(prompt, func(s string) error {
+		switch {
+		case s == "" && len(defaultValue) == 1:
+			result = defaultValue[0]
+		case contains(choiceStrs, s):
+			result = s
+		default:
+			return fmt.Errorf("%q: answer one of %s", s, strings.Join(choiceStrs, ", "))
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (p *prompter) promptInt(prompt string, defaultValue ...int64) (int64, error) {
+	if len(defaultValue) > 1 {
+		return 0, errTooManyDefaults(prompt)
+	}
+	if len(defaultValue) == 1 {
+		prompt = fmt.Sprintf("%s (default %d)", prompt, defaultValue[0])
+	}
+	var result int64
+	err := p.ask(prompt, func(s string) error {
+		if s == "" && len(defaultValue) == 1 {
+			result = defaultValue[0]
+			return nil
+		}
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q: answer an integer", s)
+		}
+		result = i
+		return nil
+	})
+	return result, err
+}
+
+// promptMultichoice asks for any number of choices, separated by commas.
+func (p *prompter) promptMultichoice(prompt string, choices interface{}, defaultValue ...interface{}) ([]string, error) {
+	choiceStrs, err := toStrings(choices)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", prompt, err)
+	}
+	if len(defaultValue) > 1 {
+		return nil, errTooManyDefaults(prompt)
+	}
+	var defaults []string
+	prompt = fmt.Sprintf("%s (any of %s, separated by commas", prompt, strings.Join(choiceStrs, "/"))
+	if len(defaultValue) == 1 {
+		defaults, err = toStrings(defaultValue[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", prompt, err)
+		}
+		for _, d := range defaults {
+			if !contains(choiceStrs, d) {
+				return nil, fmt.Errorf("%s: default %q is not a choice", prompt, d)
+			}
+		}
+		prompt += ", default " + strings.Join(defaults, ",")
+	}
+	prompt += ")"
+	var result []string
+	err = p.ask(prompt, func(s string) error {
+		if s == "" && defaults != nil {
+			result = defaults
+			return nil
+		}
+		result = []string{}
+		for _, answer := range strings.Split(s, ",") {
+			answer = strings.TrimSpace(answer)
+			switch {
+			case answer == "":
+			case contains(choiceStrs, answer):
+				result = append(result, answer)
+			default:
+				return fmt.Errorf("%q: answer any of %s", answer, strings.Join(choiceStrs, ", "))
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (p *prompter) promptString(prompt string, defaultValue ...string) (string, error) {
+	if len(defaultValue) > 1 {
+		return "", errTooManyDefaults(prompt)
+	}
+	if len(defaultValue) == 1 {
+		prompt = fmt.Sprintf("%s (default %q)", prompt, defaultValue[0])
+	}
+	var result string
+	err := p.ask(prompt, func(s string) error {
+		if s == "" && len(defaultValue) == 1 {
+			s = defaultValue[0]
+		}
+		result = s
+		return nil
+	})
+	return result, err
+}
+
+// contains returns true if ss contains s.
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// errTooManyDefaults returns an error for a prompt with more than one default
+// value.
+func errTooManyDefaults(prompt string) error {
+	return fmt.Errorf("%s: too many default values", prompt)
+}
+
+// toStrings converts value, a list of strings, to a []string.
+func toStrings(value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case []string:
+		return value, nil
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, v := range value {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%v: not a string", v)
+			}
+			result = append(result, s)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("%v: not a list of strings", value)
+	}
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/sprig"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestCreateConfigFile(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi/.chezmoi.toml.tmpl": strings.Join([]string{
+			`[data]`,
+			`  email = "{{ promptString "email" }}"`,
+			`  personal = {{ promptBool "personal" }}`,
+			`  screens = {{ promptInt "screens" }}`,
+		}, "\n"),
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	c := &Config{
+		SourceDir:  "/home/user/.chezmoi",
+		DestDir:    "/home/user",
+		Umask:      022,
+		configFile: "/home/user/.config/chezmoi/chezmoi.yaml",
+	}
+	defer c.closePersistentState()
+	stdin := strings.NewReader("john.smith@company.com\nyes\n2\n")
+	stdout := &bytes.Buffer{}
+	if err := c.createConfigFile(fs, chezmoi.NewFSMutator(fs, c.DestDir), stdin, stdout); err != nil {
+		t.Fatalf("c.createConfigFile(...) == %v, want <nil>", err)
+	}
+	if got, want := stdout.String(), "email? personal? screens? "; got != want {
+		t.Errorf("stdout == %q, want %q", got, want)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.config/chezmoi",
+			vfst.TestIsDir,
+			vfst.TestModePerm(0700),
+		),
+		vfst.TestPath("/home/user/.config/chezmoi/chezmoi.toml",
+			vfst.TestModeIsRegular,
+			vfst.TestModePerm(0600),
+			vfst.TestContentsString(strings.Join([]string{
+				`[data]`,
+				`  email = "john.smith@company.com"`,
+				`  personal = true`,
+				`  screens = 2`,
+			}, "\n")),
+		),
+	)
+	if got, want := c.configFile, "/home/user/.config/chezmoi/chezmoi.toml"; got != want {
+		t.Errorf("c.configFile == %q, want %q", got, want)
+	}
+	// The template is recorded, so that later commands can tell when it
+	// changes.
+	persistentState, err := c.getPersistentState(fs)
+	if err != nil {
+		t.Fatalf("c.getPersistentState(_) == _, %v, want _, <nil>", err)
+	}
+	_, _, source, err := c.findConfigTemplate(fs)
+	if err != nil {
+		t.Fatalf("c.findConfigTemplate(_) == _, _, _, %v, want _, _, _, <nil>", err)
+	}
+	for _, tc := range []struct {
+		template []byte
+		want     bool
+	}{
+		{template: source, want: false},
+		{template: append(source, '\n'), want: true},
+	} {
+		if got, err := chezmoi.ConfigTemplateChanged(persistentState, c.configFile, tc.template); err != nil || got != tc.want {
+			t.Errorf("chezmoi.ConfigTemplateChanged(_, %q, %q) == %v, %v, want %v, <nil>", c.configFile, tc.template, got, err, tc.want)
+		}
+	}
+	for key, want := range map[string]interface{}{
+		"email":    "john.smith@company.com",
+		"personal": true,
+		"screens":  int64(2),
+	} {
+		if got := c.Data[key]; got != want {
+			t.Errorf("c.Data[%q] == %v (%T), want %v (%T)", key, got, got, want, want)
+		}
+	}
+}
+
+func TestPromptFuncs(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		source     string
+		stdin      string
+		want       string
+		wantStdout string
+		wantErr    bool
+	}{
+		{
+			name:       "string_default",
+			source:     `{{ promptString "email" "john@home.org" }}`,
+			stdin:      "\n",
+			want:       "john@home.org",
+			wantStdout: `email (default "john@home.org")? `,
+		},
+		{
+			name:       "bool_reprompt",
+			source:     `{{ promptBool "personal" }}`,
+			stdin:      "maybe\n\nn\n",
+			want:       "false",
+			wantStdout: "personal? \"maybe\": answer yes or no\npersonal? answer yes or no\npersonal? ",
+		},
+		{
+			name:       "bool_default",
+			source:     `{{ promptBool "personal" true }}`,
+			stdin:      "\n",
+			want:       "true",
+			wantStdout: "personal (default true)? ",
+		},
+		{
+			name:       "int_reprompt",
+			source:     `{{ promptInt "screens" 1 }}`,
+			stdin:      "two\n2\n",
+			want:       "2",
+			wantStdout: "screens (default 1)? \"two\": answer an integer\nscreens (default 1)? ",
+		},
+		{
+			name:       "choice",
+			source:     `{{ promptChoice "shell" (list "bash" "fish" "zsh") "bash" }}`,
+			stdin:      "sh\nzsh\n",
+			want:       "zsh",
+			wantStdout: "shell (bash/fish/zsh, default bash)? \"sh\": answer one of bash, fish, zsh\nshell (bash/fish/zsh, default bash)? ",
+		},
+		{
+			name:   "choice_default",
+			source: `{{ promptChoice "shell" (list "bash" "zsh") "zsh" }}`,
+			stdin:  "\n",
+			want:   "zsh",
+		},
+		{
+			name:   "multichoice",
+			source: `{{ promptMultichoice "tools" (list "go" "node" "rust") | join "+" }}`,
+			stdin:  "go, rust\n",
+			want:   "go+rust",
+		},
+		{
+			name:   "multichoice_default",
+			source: `{{ promptMultichoice "tools" (list "go" "node" "rust") (list "go") | join "+" }}`,
+			stdin:  "\n",
+			want:   "go",
+		},
+		{
+			name:    "multichoice_invalid_at_eof",
+			source:  `{{ promptMultichoice "tools" (list "go" "node") }}`,
+			stdin:   "python",
+			wantErr: true,
+		},
+		{
+			name:    "choice_bad_default",
+			source:  `{{ promptChoice "shell" (list "bash" "zsh") "fish" }}`,
+			stdin:   "\n",
+			wantErr: true,
+		},
+		{
+			name:    "too_many_defaults",
+			source:  `{{ promptString "email" "a" "b" }}`,
+			stdin:   "\n",
+			wantErr: true,
+		},
+		{
+			name:    "eof",
+			source:  `{{ promptString "email" }}`,
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			funcs := sprig.HermeticTxtFuncMap()
+			for key, value := range promptFuncs(bufio.NewReader(strings.NewReader(tc.stdin)), stdout) {
+				funcs[key] = value
+			}
+			got, err := chezmoi.TextTemplateEngine.Execute(tc.name, []byte(tc.source), funcs, nil)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("chezmoi.TextTemplateEngine.Execute(%q, %q, _, nil) == %q, <nil>, want _, !<nil>", tc.name, tc.source, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("chezmoi.TextTemplateEngine.Execute(%q, %q, _, nil) == _, %v, want _, <nil>", tc.name, tc.source, err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("chezmoi.TextTemplateEngine.Execute(%q, %q, _, nil) == %q, <nil>, want %q, <nil>", tc.name, tc.source, got, tc.want)
+			}
+			if tc.wantStdout != "" && stdout.String() != tc.wantStdout {
+				t.Errorf("stdout == %q, want %q", stdout.String(), tc.wantStdout)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+var managedCmd = &cobra.Command{
+	Use:   "managed",
+	Args:  cobra.NoArgs,
+	Short: "List the managed targets in the destination directory",
+	RunE:  makeRunE(config.runManagedCmd),
+}
+
+func init() {
+	rootCmd.AddCommand(managedCmd)
+}
+
+func (c *Config) runManagedCmd(fs vfs.FS, args []string) error {
+	ts, err := c.getTargetState(fs)
+	if err != nil {
+		return err
+	}
+	targetNames, err := ts.Managed()
+	if err != nil {
+		return err
+	}
+	for _, targetName := range targetNames {
+		fmt.Println(filepath.Join(c.DestDir, targetName))
+	}
+	return nil
+}
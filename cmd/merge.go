@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+type mergeConfig struct {
+	Command string
+	Args    []string
+}
+
+// A mergeCommandData is the template data for the args of a merge command.
+type mergeCommandData struct {
+	Name        string // Name is the path of the target relative to the destination directory.
+	Destination string // Destination is a file containing the current contents of the target.
+	Source      string // Source is a file containing the source of the target, into which the resolution is merged.
+	Target      string // Target is a file containing the contents of the target state.
+}
+
+// defaultMergeArgs are the args passed to the merge command when none are
+// configured.
+var defaultMergeArgs = []string{"{{ .Destination }}", "{{ .Source }}", "{{ .Target }}"}
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge [targets...]",
+	Short: "Merge changes to targets back into the source state with a three-way merge tool",
+	RunE:  makeLockedRunE(config.runMergeCmd),
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+
+	config.Merge.Command = "vimdiff"
+}
+
+func (c *Config) runMergeCmd(fs vfs.FS, args []string) error {
+	ts, err := c.getTargetState(fs)
+	if err != nil {
+		return err
+	}
+	var entries []chezmoi.Entry
+	if len(args) == 0 {
+		if entries, err = c.getChangedEntries(fs, ts); err != nil {
+			return err
+		}
+	} else if entries, err = c.getEntries(ts, args); err != nil {
+		return err
+	}
+
+	argTemplates := c.Merge.Args
+	if len(argTemplates) == 0 {
+		argTemplates = defaultMergeArgs
+	}
+	var tmpls []*template.Template
+	for i, argTemplate := range argTemplates {
+		tmpl, err := template.New(fmt.Sprintf("merge.args[%d]", i)).Option("missingkey=error").Parse(argTemplate)
+		if err != nil {
+			return err
+		}
+		tmpls = append(tmpls, tmpl)
+	}
+
+	// Lay out the three versions of each target in a private temporary
+	// directory, so that the merge tool cannot change anything else.
+	tempDir, err := ioutil.TempDir("", "chezmoi-merge")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	mutator := c.getDefaultMutator(fs)
+	for _, entry := range entries {
+		file, ok := entry.(*chezmoi.File)
+		if !ok {
+			return fmt.Errorf("%s: not a file", entry.TargetName())
+		}
+		if file.Encrypted {
+			return fmt.Errorf("%s: cannot merge encrypted files", entry.TargetName())
+		}
+		if err := c.mergeFile(fs, ts, file, tempDir, tmpls, mutator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getChangedEntries returns the file entries in ts whose targets have been
+// changed since chezmoi last wrote them and that apply would change.
+func (c *Config) getChangedEntries(fs vfs.FS, ts *chezmoi.TargetState) ([]chezmoi.Entry, error) {
+	plan, err := ts.Plan(fs)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := ts.ChangedTargets(fs, plan)
+	if err != nil {
+		return nil, err
+	}
+	var entries []chezmoi.Entry
+	for _, changedTarget := range changed {
+		entry, err := ts.Get(filepath.Join(ts.DestDir, changedTarget.TargetName))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := entry.(*chezmoi.File); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// mergeFile runs the merge command for file and writes the merged source back
+// to the source directory with mutator.
+func (c *Config) mergeFile(fs vfs.FS, ts *chezmoi.TargetState, file *chezmoi.File, tempDir string, tmpls []*template.Template, mutator chezmoi.Mutator) error {
+	name := file.TargetName()
+	sourcePath := filepath.Join(ts.SourceDir, file.SourceName())
+	sourceInfo, err := fs.Stat(sourcePath)
+	if err != nil {
+		return err
+	}
+	sourceData, err := fs.ReadFile(sourcePath)
+	if err != nil {
+		return err
+	}
+	targetData, err := file.Contents()
+	if err != nil {
+		return err
+	}
+	destData, err := fs.ReadFile(filepath.Join(ts.DestDir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	cmdData := mergeCommandData{
+		Name: name,
+	}
+	if cmdData.Destination, err = writeDiffTempFile(tempDir, "destination", name, destData); err != nil {
+		return err
+	}
+	if cmdData.Source, err = writeDiffTempFile(tempDir, "source", name, sourceData); err != nil {
+		return err
+	}
+	if cmdData.Target, err = writeDiffTempFile(tempDir, "target", name, targetData); err != nil {
+		return err
+	}
+	var args []string
+	for _, tmpl := range tmpls {
+		b := &bytes.Buffer{}
+		if err := tmpl.Execute(b, cmdData); err != nil {
+			return err
+		}
+		args = append(args, b.String())
+	}
+	if err := c.run("", c.Merge.Command, args...); err != nil {
+		return fmt.Errorf("%s: %s: %v", name, c.Merge.Command, err)
+	}
+
+	mergedData, err := ioutil.ReadFile(cmdData.Source)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(mergedData, sourceData) {
+		return nil
+	}
+	return mutator.WriteFile(sourcePath, mergedData, sourceInfo.Mode().Perm(), sourceData)
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestRunMergeCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake merge command requires a shell")
+	}
+	dir, err := ioutil.TempDir("", "chezmoi-test-merge")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(dir)
+	merge := filepath.Join(dir, "merge")
+	// The fake merge command resolves the merge by combining the
+	// destination, source, and target.
+	script := `#!/bin/sh
+echo "$(cat "$1"),$(cat "$2"),$(cat "$3")" > "$2"
+`
+	if err := ioutil.WriteFile(merge, []byte(script), 0755); err != nil {
+		t.Fatalf("ioutil.WriteFile(%q, _, 0755) == %v, want <nil>", merge, err)
+	}
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc": "local",
+			".chezmoi": map[string]interface{}{
+				"dot_bashrc.tmpl": `{{ "target" }}`,
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	c := &Config{
+		configFile: "/home/user/.config/chezmoi/chezmoi.toml",
+		SourceDir:  "/home/user/.chezmoi",
+		DestDir:    "/home/user",
+		Umask:      022,
+		Merge: mergeConfig{
+			Command: merge,
+		},
+	}
+	if err := c.runMergeCmd(fs, []string{"/home/user/.bashrc"}); err != nil {
+		t.Fatalf("c.runMergeCmd(_, _) == %v, want <nil>", err)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.chezmoi/dot_bashrc.tmpl",
+			vfst.TestContentsString("local,{{ \"target\" }},target\n"),
+		),
+		vfst.TestPath("/home/user/.bashrc",
+			vfst.TestContentsString("local"),
+		),
+	)
+}
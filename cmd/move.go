@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+var moveCmd = &cobra.Command{
+	Use:     "move old-target new-target",
+	Aliases: []string{"mv"},
+	Args:    cobra.ExactArgs(2),
+	Short:   "Move a target in the source state and the destination directory",
+	RunE:    makeLockedRunE(config.runMoveCmd),
+}
+
+type moveCmdConfig struct {
+	noRemove bool
+}
+
+func init() {
+	rootCmd.AddCommand(moveCmd)
+
+	persistentFlags := moveCmd.PersistentFlags()
+	persistentFlags.BoolVar(&config.move.noRemove, "no-remove", false, "do not add the old target to .chezmoiremove")
+}
+
+func (c *Config) runMoveCmd(fs vfs.FS, args []string) error {
+	ts, err := c.getTargetState(fs)
+	if err != nil {
+		return err
+	}
+	oldTarget, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	newTarget, err := filepath.Abs(args[1])
+	if err != nil {
+		return err
+	}
+	moveOptions := chezmoi.MoveOptions{
+		RecordRemove: !c.move.noRemove,
+	}
+	return ts.Move(fs, oldTarget, newTarget, moveOptions, c.getDefaultMutator(fs))
+}
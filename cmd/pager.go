@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// defaultPager returns the default pager, which is $PAGER if it is set and
+// less otherwise.
+func defaultPager() string {
+	if pager := os.Getenv("PAGER"); pager != "" {
+		return pager
+	}
+	return "less"
+}
+
+// useColor returns whether output to f should be colored. color is one of
+// auto, on, or off. In auto mode, output is colored if f is a terminal and the
+// NO_COLOR environment variable is not set.
+func useColor(color string, f *os.File) (bool, error) {
+	switch strings.ToLower(color) {
+	case "", "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		return terminal.IsTerminal(int(f.Fd())), nil
+	case "on", "true", "always":
+		return true, nil
+	case "off", "false", "never":
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s: invalid color", color)
+	}
+}
+
+// withPager calls f with a writer that writes to pager, if pager is not empty
+// and stdout is a terminal, or to stdout otherwise. pager is split into a
+// command and its args on whitespace.
+func withPager(pager string, f func(io.Writer) error) error {
+	argv := strings.Fields(pager)
+	if len(argv) == 0 || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		return f(os.Stdout)
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Like git, make less pass colors through and exit immediately if the
+	// output fits on one screen.
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		if execErr, ok := err.(*exec.Error); ok && execErr.Err == exec.ErrNotFound {
+			return f(os.Stdout)
+		}
+		return err
+	}
+	err = f(w)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if waitErr := cmd.Wait(); err == nil {
+		err = waitErr
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUseColor(t *testing.T) {
+	f, err := ioutil.TempFile("", "chezmoi-test-color")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	defer os.Setenv("NO_COLOR", os.Getenv("NO_COLOR"))
+	for _, tc := range []struct {
+		color   string
+		noColor string
+		want    bool
+		wantErr bool
+	}{
+		{color: "auto", want: false},
+		{color: "on", want: true},
+		{color: "on", noColor: "1", want: true},
+		{color: "true", want: true},
+		{color: "off", want: false},
+		{color: "invalid", wantErr: true},
+	} {
+		if err := os.Setenv("NO_COLOR", tc.noColor); err != nil {
+			t.Fatalf("os.Setenv(%q, %q) == %v, want <nil>", "NO_COLOR", tc.noColor, err)
+		}
+		got, err := useColor(tc.color, f)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("useColor(%q, _) == _, <nil>, want _, !<nil>", tc.color)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("useColor(%q, _) == %v, %v, want %v, <nil>", tc.color, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+)
+
+// redactedText replaces secrets in output.
+const redactedText = "<redacted>"
+
+// minRedactLength is the minimum length of a secret that is redacted. Shorter
+// values, for example the types of password manager fields, would redact too
+// much unrelated output.
+const minRedactLength = 4
+
+// A redactor records the values returned by secret template functions and
+// replaces them in text. It is a chezmoi.SecretTracker, so the values derived
+// from secrets by other template functions are also recorded. It is safe for
+// concurrent use.
+type redactor struct {
+	mu       sync.Mutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+// A redactingWriter is an io.Writer that redacts secrets from everything
+// written to it. Secrets are only redacted if they are written in a single
+// call to Write.
+type redactingWriter struct {
+	w io.Writer
+	r *redactor
+}
+
+// secretRedactor records every secret returned by a template function in a
+// single command.
+var secretRedactor = newRedactor()
+
+// newRedactor returns a new redactor with no secrets.
+func newRedactor() *redactor {
+	return &redactor{
+		secrets: make(map[string]bool),
+	}
+}
+
+// addSecretTemplateFunc adds the template function value, which returns a
+// secret, with name key. Any strings in the values that it returns are
+// redacted from diffs, verbose output, and errors. Errors are prefixed with the
+// call that returned them, for example `pass "email/work"`, so that they
+// identify the secret, and the template engines prefix them with the name of
+// the template.
+func (c *Config) addSecretTemplateFunc(key string, value interface{}) {
+	f := reflect.ValueOf(value)
+	c.addTemplateFunc(key, reflect.MakeFunc(f.Type(), func(args []reflect.Value) []reflect.Value {
+		defer func() {
+			if r := recover(); r != nil {
+				err, ok := r.(error)
+				if _, isRuntimeErr := r.(runtime.Error); !ok || isRuntimeErr {
+					panic(r)
+				}
+				chezmoi.ReturnTemplateFuncError(fmt.Errorf("%s: %v", secretTemplateFuncCall(key, args), err))
+			}
+		}()
+		var results []reflect.Value
+		if f.Type().IsVariadic() {
+			results = f.CallSlice(args)
+		} else {
+			results = f.Call(args)
+		}
+		for _, result := range results {
+			secretRedactor.add(result.Interface())
+		}
+		return results
+	}).Interface())
+}
+
+// secretTemplateFuncCall returns the call of the template function key with
+// args, as it would be written in a template.
+func secretTemplateFuncCall(key string, args []reflect.Value) string {
+	call := []string{key}
+	for _, arg := range args {
+		if arg.Kind() == reflect.Slice && arg.Type().Elem().Kind() == reflect.String {
+			// The variadic arguments of functions called with CallSlice.
+			for i := 0; i < arg.Len(); i++ {
+				call = append(call, strconv.Quote(arg.Index(i).String()))
+			}
+			continue
+		}
+		if arg.Kind() == reflect.String {
+			call = append(call, strconv.Quote(arg.String()))
+		} else {
+			call = append(call, fmt.Sprint(arg.Interface()))
+		}
+	}
+	return strings.Join(call, " ")
+}
+
+// AddSecret implements chezmoi.SecretTracker.AddSecret.
+func (r *redactor) AddSecret(value interface{}) {
+	r.add(value)
+}
+
+// ContainsSecret implements chezmoi.SecretTracker.ContainsSecret.
+func (r *redactor) ContainsSecret(value interface{}) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.secrets) == 0 {
+		return false
+	}
+	return r.containsLocked(value)
+}
+
+// add records the strings in value as secrets.
+func (r *redactor) add(value interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addLocked(value)
+}
+
+// addLocked records the strings in value as secrets. r.mu must be held.
+func (r *redactor) addLocked(value interface{}) {
+	switch value := value.(type) {
+	case string:
+		if len(value) >= minRedactLength && !r.secrets[value] {
+			r.secrets[value] = true
+			r.replacer = nil
+		}
+	case []byte:
+		r.addLocked(string(value))
+	case []string:
+		for _, s := range value {
+			r.addLocked(s)
+		}
+	case []interface{}:
+		for _, v := range value {
+			r.addLocked(v)
+		}
+	case []map[string]interface{}:
+		for _, v := range value {
+			r.addLocked(v)
+		}
+	case map[string]string:
+		for _, v := range value {
+			r.addLocked(v)
+		}
+	case map[string]interface{}:
+		for _, v := range value {
+			r.addLocked(v)
+		}
+	}
+}
+
+// containsLocked returns true if any of the strings in value contains a
+// secret. r.mu must be held.
+func (r *redactor) containsLocked(value interface{}) bool {
+	switch value := value.(type) {
+	case string:
+		for secret := range r.secrets {
+			if strings.Contains(value, secret) {
+				return true
+			}
+		}
+	case []byte:
+		return r.containsLocked(string(value))
+	case []string:
+		for _, s := range value {
+			if r.containsLocked(s) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range value {
+			if r.containsLocked(v) {
+				return true
+			}
+		}
+	case []map[string]interface{}:
+		for _, v := range value {
+			if r.containsLocked(v) {
+				return true
+			}
+		}
+	case map[string]string:
+		for _, v := range value {
+			if r.containsLocked(v) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, v := range value {
+			if r.containsLocked(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redact returns s with all secrets replaced by redactedText.
+func (r *redactor) redact(s string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.secrets) == 0 {
+		return s
+	}
+	if r.replacer == nil {
+		secrets := make([]string, 0, len(r.secrets))
+		for secret := range r.secrets {
+			secrets = append(secrets, secret)
+		}
+		// Replace longer secrets first, so that secrets that contain other
+		// secrets are completely redacted.
+		sort.Slice(secrets, func(i, j int) bool {
+			if len(secrets[i]) != len(secrets[j]) {
+				return len(secrets[i]) > len(secrets[j])
+			}
+			return secrets[i] < secrets[j]
+		})
+		oldnew := make([]string, 0, 2*len(secrets))
+		for _, secret := range secrets {
+			oldnew = append(oldnew, secret, redactedText)
+		}
+		r.replacer = strings.NewReplacer(oldnew...)
+	}
+	return r.replacer.Replace(s)
+}
+
+// writer returns an io.Writer that redacts secrets before writing to w.
+func (r *redactor) writer(w io.Writer) io.Writer {
+	return &redactingWriter{
+		w: w,
+		r: r,
+	}
+}
+
+// Write implements io.Writer.Write.
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.r.redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestRedactor(t *testing.T) {
+	r := newRedactor()
+	r.add(map[string]interface{}{
+		"login": map[string]interface{}{
+			"username": "user@example.com",
+			"password": "hunter2",
+		},
+		"fields": []interface{}{
+			map[string]interface{}{
+				"type":  float64(1),
+				"value": "token-token",
+			},
+		},
+		"type": "abc",
+	})
+	r.add("token")
+	for _, tc := range []struct {
+		s    string
+		want string
+	}{
+		{
+			s:    "password = hunter2",
+			want: "password = <redacted>",
+		},
+		{
+			s:    "user@example.com:token-token:token",
+			want: "<redacted>:<redacted>:<redacted>",
+		},
+		{
+			s:    "type = abc",
+			want: "type = abc",
+		},
+	} {
+		if got := r.redact(tc.s); got != tc.want {
+			t.Errorf("r.redact(%q) == %q, want %q", tc.s, got, tc.want)
+		}
+	}
+
+	b := &bytes.Buffer{}
+	if _, err := fmt.Fprintln(r.writer(b), "+password = hunter2"); err != nil {
+		t.Fatalf("fmt.Fprintln(_, _) == _, %v, want _, <nil>", err)
+	}
+	if got, want := b.String(), "+password = <redacted>\n"; got != want {
+		t.Errorf("b.String() == %q, want %q", got, want)
+	}
+}
+
+func TestAddSecretTemplateFunc(t *testing.T) {
+	secretRedactor = newRedactor()
+	defer func() {
+		secretRedactor = newRedactor()
+	}()
+	c := &Config{}
+	c.addSecretTemplateFunc("secret", func(args ...string) string {
+		return "secret-" + args[0]
+	})
+	tmpl, err := template.New("test").Funcs(c.templateFuncs).Parse(`{{ secret "password" }}`)
+	if err != nil {
+		t.Fatalf("template.New(...).Parse(_) == _, %v, want _, <nil>", err)
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, nil); err != nil {
+		t.Fatalf("tmpl.Execute(_, _) == %v, want <nil>", err)
+	}
+	if got, want := b.String(), "secret-password"; got != want {
+		t.Errorf("tmpl.Execute(_, _) wrote %q, want %q", got, want)
+	}
+	if got, want := secretRedactor.redact(b.String()), "<redacted>"; got != want {
+		t.Errorf("secretRedactor.redact(%q) == %q, want %q", b.String(), got, want)
+	}
+}
+
+func TestSecretTemplateFuncEngines(t *testing.T) {
+	secretRedactor = newRedactor()
+	defer func() {
+		secretRedactor = newRedactor()
+	}()
+	c := &Config{}
+	c.addSecretTemplateFunc("secret", func(args ...string) string {
+		return "secret-" + args[0]
+	})
+	c.addSecretTemplateFunc("secretJSON", func(args ...string) interface{} {
+		return map[string]interface{}{
+			"login": map[string]interface{}{
+				"password": "json-" + args[0],
+			},
+		}
+	})
+	// The same logical template in each engine uses the values returned by
+	// secret template functions, and values derived from them by other
+	// template functions, which are all redacted whichever engine executes
+	// the template.
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			"text.tmpl":          `{{ secret "password" }} {{ (secretJSON "token").login.password }} {{ secret "encoded" | b64enc }}`,
+			"starlark.star.tmpl": `print(secret("password"), secretJSON("token")["login"]["password"], b64enc(secret("encoded")))`,
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	for _, targetName := range []string{"text", "starlark"} {
+		t.Run(targetName, func(t *testing.T) {
+			secretRedactor = newRedactor()
+			ts := chezmoi.NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, c.templateFuncs)
+			ts.Secrets = secretRedactor
+			if err := ts.Populate(fs); err != nil {
+				t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+			}
+			contents, err := ts.Entries[targetName].(*chezmoi.File).Contents()
+			if err != nil {
+				t.Fatalf("Contents() == _, %v, want _, <nil>", err)
+			}
+			got := strings.TrimSuffix(string(contents), "\n")
+			if want := "secret-password json-token c2VjcmV0LWVuY29kZWQ="; got != want {
+				t.Errorf("Contents() == %q, _, want %q, _", got, want)
+			}
+			if got, want := secretRedactor.redact(got), "<redacted> <redacted> <redacted>"; got != want {
+				t.Errorf("secretRedactor.redact(_) == %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSecretTemplateFuncErrors(t *testing.T) {
+	c := &Config{}
+	c.addSecretTemplateFunc("secret", func(args ...string) string {
+		chezmoi.ReturnTemplateFuncError(errors.New("timed out after 1s"))
+		return ""
+	})
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			"text.tmpl":          `{{ secret "db" "password" }}`,
+			"starlark.star.tmpl": `print(secret("db", "password"))`,
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	for _, targetName := range []string{"text", "starlark"} {
+		t.Run(targetName, func(t *testing.T) {
+			ts := chezmoi.NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, c.templateFuncs)
+			if err := ts.Populate(fs); err != nil {
+				t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+			}
+			_, err := ts.Entries[targetName].(*chezmoi.File).Contents()
+			if err == nil {
+				t.Fatalf("Contents() == _, <nil>, want _, !<nil>")
+			}
+			// The error identifies the template and the secret.
+			for _, want := range []string{
+				ts.Entries[targetName].SourceName(),
+				`secret "db" "password": timed out after 1s`,
+			} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Contents() == _, %v, want an error containing %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -13,7 +13,7 @@ var removeCmd = &cobra.Command{
 	Aliases: []string{"rm"},
 	Args:    cobra.MinimumNArgs(1),
 	Short:   "Remove a target from the source state and the destination directory",
-	RunE:    makeRunE(config.runRemoveCmd),
+	RunE:    makeLockedRunE(config.runRemoveCmd),
 }
 
 func init() {
@@ -34,7 +34,7 @@ func (c *Config) runRemoveCmd(fs vfs.FS, args []string) error {
 		if err := mutator.RemoveAll(filepath.Join(c.DestDir, entry.TargetName())); err != nil && !os.IsNotExist(err) {
 			return err
 		}
-		if err := mutator.RemoveAll(filepath.Join(c.SourceDir, entry.SourceName())); err != nil && !os.IsNotExist(err) {
+		if err := mutator.RemoveAll(filepath.Join(ts.SourceDir, entry.SourceName())); err != nil && !os.IsNotExist(err) {
 			return err
 		}
 	}
@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
-	"github.com/Masterminds/sprig"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	vfs "github.com/twpayne/go-vfs"
@@ -14,10 +17,12 @@ import (
 var (
 	config = Config{
 		Umask: permValue(getUmask()),
+		DataCommand: dataCommandConfig{
+			Format: "json",
+		},
 		SourceVCS: sourceVCSConfig{
 			Command: "git",
 		},
-		templateFuncs: sprig.HermeticTxtFuncMap(),
 	}
 	version = "dev"
 	commit  = "unknown"
@@ -45,6 +50,12 @@ func init() {
 		printErrorAndExit(err)
 	}
 
+	config.cacheDir = filepath.Join(bds.CacheHome, "chezmoi")
+	config.stateDir = filepath.Join(getStateHome(homeDir), "chezmoi")
+	config.Backup.Dir = filepath.Join(bds.DataHome, "chezmoi-backups")
+	config.Backup.Keep = 10
+	config.MaxMemorySize = 64 << 20
+
 	persistentFlags := rootCmd.PersistentFlags()
 
 	persistentFlags.StringVarP(&config.configFile, "config", "c", getDefaultConfigFile(bds), "config file")
@@ -52,6 +63,9 @@ func init() {
 	persistentFlags.BoolVarP(&config.DryRun, "dry-run", "n", false, "dry run")
 	viper.BindPFlag("dry-run", persistentFlags.Lookup("dry-run"))
 
+	persistentFlags.BoolVarP(&config.KeepGoing, "keep-going", "k", false, "keep going as far as possible after an error")
+	viper.BindPFlag("keep-going", persistentFlags.Lookup("keep-going"))
+
 	persistentFlags.StringVarP(&config.SourceDir, "source", "S", getDefaultSourceDir(bds), "source directory")
 	viper.BindPFlag("source", persistentFlags.Lookup("source"))
 
@@ -66,20 +80,35 @@ func init() {
 
 	cobra.OnInitialize(func() {
 		viper.SetConfigFile(config.configFile)
-		if err := viper.ReadInConfig(); os.IsNotExist(err) {
-			return
-		} else if err != nil {
+		if err := viper.ReadInConfig(); err != nil && !os.IsNotExist(err) {
 			printErrorAndExit(err)
 		}
+		setEnvOverrides(viper.GetViper(), os.Environ())
 		if err := viper.Unmarshal(&config); err != nil {
 			printErrorAndExit(err)
 		}
 	})
 }
 
-// Execute executes the root command.
+// Execute executes the root command. The first interrupt cancels the command,
+// which stops as soon as it safely can, and restores the default handling of
+// interrupts, so a second interrupt exits immediately.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		cancel()
+	}()
+	config.ctx = ctx
+	err := rootCmd.Execute()
+	if closeErr := config.closePersistentState(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		printErrorAndExit(err)
 	}
 }
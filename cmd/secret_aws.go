@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+)
+
+// awsConfig configures the AWS SDK. Credentials are found with the SDK's
+// standard credential chain, so they can come from the environment, the shared
+// config and credentials files, SSO, or an instance or container role.
+type awsConfig struct {
+	Profile   string
+	Region    string
+	endpoint  string
+	sdkConfig *aws.Config
+}
+
+func init() {
+	config.addSecretTemplateFunc("awsSecretsManager", config.awsSecretsManagerFunc)
+	config.addSecretTemplateFunc("awsSecretsManagerRaw", config.awsSecretsManagerRawFunc)
+	config.addSecretTemplateFunc("awsSsmParameter", config.awsSsmParameterFunc)
+}
+
+// awsSecretsManagerFunc returns the JSON-parsed string value of the AWS
+// Secrets Manager secret secretID.
+func (c *Config) awsSecretsManagerFunc(secretID string) interface{} {
+	secretString := c.awsSecretsManagerRawFunc(secretID)
+	var data interface{}
+	if err := json.Unmarshal([]byte(secretString), &data); err != nil {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("awsSecretsManager: %s: %v", secretID, err))
+	}
+	return data
+}
+
+// awsSecretsManagerRawFunc returns the string value of the AWS Secrets
+// Manager secret secretID.
+func (c *Config) awsSecretsManagerRawFunc(secretID string) string {
+	output, err := c.awsOutput("secretsmanager", secretID, func(sdkConfig aws.Config) (string, error) {
+		ctx, cancel := c.secretContext()
+		defer cancel()
+		response, err := secretsmanager.NewFromConfig(sdkConfig).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secretID),
+		})
+		if err != nil {
+			return "", err
+		}
+		if response.SecretString == nil {
+			return "", errors.New("secret has no string value")
+		}
+		return *response.SecretString, nil
+	})
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("awsSecretsManager: %s: %v", secretID, err))
+	}
+	return output
+}
+
+// awsSsmParameterFunc returns the decrypted value of the AWS Systems Manager
+// Parameter Store parameter name.
+func (c *Config) awsSsmParameterFunc(name string) string {
+	output, err := c.awsOutput("ssm", name, func(sdkConfig aws.Config) (string, error) {
+		ctx, cancel := c.secretContext()
+		defer cancel()
+		response, err := ssm.NewFromConfig(sdkConfig).GetParameter(ctx, &ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return "", err
+		}
+		if response.Parameter == nil {
+			return "", errors.New("parameter has no value")
+		}
+		return aws.ToString(response.Parameter.Value), nil
+	})
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("awsSsmParameter: %s: %v", name, err))
+	}
+	return output
+}
+
+// awsOutput returns the value of the resource name of service, which is
+// fetched with get. Values are cached.
+func (c *Config) awsOutput(service, name string, get func(aws.Config) (string, error)) (string, error) {
+	key := []string{"aws", c.AWS.Profile, c.AWS.Region, service, name}
+	output, err := c.cachedSecretOutput(key, func() ([]byte, error) {
+		if c.Verbose {
+			fmt.Printf("aws %s %s\n", service, name)
+		}
+		sdkConfig, err := c.getAWSConfig()
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(sdkConfig)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(value), nil
+	})
+	return string(output), err
+}
+
+// getAWSConfig returns the AWS SDK's config, which is loaded once. It is only
+// called while secretCacheMu is held.
+func (c *Config) getAWSConfig() (aws.Config, error) {
+	if c.AWS.sdkConfig != nil {
+		return *c.AWS.sdkConfig, nil
+	}
+	var options []func(*awsconfig.LoadOptions) error
+	if c.AWS.Profile != "" {
+		options = append(options, awsconfig.WithSharedConfigProfile(c.AWS.Profile))
+	}
+	if c.AWS.Region != "" {
+		options = append(options, awsconfig.WithRegion(c.AWS.Region))
+	}
+	ctx, cancel := c.secretContext()
+	defer cancel()
+	sdkConfig, err := awsconfig.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if c.AWS.endpoint != "" {
+		sdkConfig.BaseEndpoint = aws.String(c.AWS.endpoint)
+	}
+	c.AWS.sdkConfig = &sdkConfig
+	return sdkConfig, nil
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestAWSTemplateFuncs(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// Requests are signed with the credentials and region of the
+		// profile.
+		if got, want := r.Header.Get("Authorization"), "Credential=AKIDWORK/"; !strings.Contains(got, want) || !strings.Contains(got, "/eu-west-1/") {
+			t.Errorf("r.Header.Get(\"Authorization\") == %q, want credentials AKIDWORK in eu-west-1", got)
+		}
+		var input map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			t.Errorf("json.NewDecoder(_).Decode(_) == %v, want <nil>", err)
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch target := r.Header.Get("X-Amz-Target"); {
+		case target == "secretsmanager.GetSecretValue" && input["SecretId"] == "example":
+			w.Write([]byte(`{"Name":"example","SecretString":"{\"username\":\"user\",\"password\":\"secret\"}"}`))
+		case target == "AmazonSSM.GetParameter" && input["Name"] == "/example/token" && input["WithDecryption"] == true:
+			w.Write([]byte(`{"Parameter":{"Name":"/example/token","Type":"SecureString","Value":"token"}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	// Credentials are found with the standard credential chain, here from the
+	// shared config and credentials files.
+	dir, err := ioutil.TempDir("", "chezmoi-test-aws")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config")
+	credentialsFile := filepath.Join(dir, "credentials")
+	for path, contents := range map[string]string{
+		configFile:      "[profile work]\nregion = eu-west-1\n",
+		credentialsFile: "[work]\naws_access_key_id = AKIDWORK\naws_secret_access_key = secret\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, _, 0600) == %v, want <nil>", path, err)
+		}
+	}
+	for key, value := range map[string]string{
+		"AWS_CONFIG_FILE":             configFile,
+		"AWS_SHARED_CREDENTIALS_FILE": credentialsFile,
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_SECRET_ACCESS_KEY":       "",
+		"AWS_SESSION_TOKEN":           "",
+		"AWS_PROFILE":                 "",
+		"AWS_REGION":                  "",
+		"AWS_DEFAULT_REGION":          "",
+	} {
+		defer os.Setenv(key, os.Getenv(key))
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatalf("os.Setenv(%q, _) == %v, want <nil>", key, err)
+		}
+	}
+
+	c := &Config{
+		AWS: awsConfig{
+			Profile:  "work",
+			endpoint: server.URL,
+		},
+	}
+	secretCache = make(map[string][]byte)
+	defer func() {
+		secretCache = make(map[string][]byte)
+	}()
+
+	want := map[string]interface{}{
+		"username": "user",
+		"password": "secret",
+	}
+	if diff, equal := messagediff.PrettyDiff(want, c.awsSecretsManagerFunc("example")); !equal {
+		t.Errorf("c.awsSecretsManagerFunc(%q) diff:\n%s", "example", diff)
+	}
+	if got, want := c.awsSecretsManagerRawFunc("example"), `{"username":"user","password":"secret"}`; got != want {
+		t.Errorf("c.awsSecretsManagerRawFunc(%q) == %q, want %q", "example", got, want)
+	}
+	if got, want := c.awsSsmParameterFunc("/example/token"), "token"; got != want {
+		t.Errorf("c.awsSsmParameterFunc(%q) == %q, want %q", "/example/token", got, want)
+	}
+	// Values are cached.
+	if got, want := requests, 2; got != want {
+		t.Errorf("requests == %d, want %d", got, want)
+	}
+
+	func() {
+		defer func() {
+			r := recover()
+			if err, ok := r.(error); !ok || !strings.Contains(err.Error(), "ResourceNotFoundException") {
+				t.Errorf("c.awsSecretsManagerRawFunc(%q) panicked with %v, want a ResourceNotFoundException", "missing", r)
+			}
+		}()
+		c.awsSecretsManagerRawFunc("missing")
+	}()
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+)
+
+// azureConfig configures the Azure SDK. Credentials are found with
+// DefaultAzureCredential, so they can come from the environment, a workload or
+// managed identity, or the account signed in to the Azure CLI or Azure
+// Developer CLI.
+type azureConfig struct {
+	// DefaultVault is the Key Vault used when azureKeyVault is not given a
+	// vault name.
+	DefaultVault string
+	// newClient returns a client for the Key Vault vault, and is replaced by
+	// tests to use a fake Key Vault.
+	newClient  func(vault string) (*azsecrets.Client, error)
+	credential azcore.TokenCredential
+}
+
+func init() {
+	config.addSecretTemplateFunc("azureKeyVault", config.azureKeyVaultFunc)
+}
+
+// azureKeyVaultFunc returns the value of the secret secretName in the Key
+// Vault vaultName, or in the default vault if vaultName is not given.
+func (c *Config) azureKeyVaultFunc(secretName string, vaultName ...string) string {
+	vault := c.Azure.DefaultVault
+	switch len(vaultName) {
+	case 0:
+	case 1:
+		vault = vaultName[0]
+	default:
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("azureKeyVault: expected 1 or 2 arguments, got %d", len(vaultName)+1))
+	}
+	if vault == "" {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("azureKeyVault: %s: no vault name and azure.defaultVault not set", secretName))
+	}
+	output, err := c.cachedSecretOutput([]string{"azureKeyVault", vault, secretName}, func() ([]byte, error) {
+		if c.Verbose {
+			fmt.Printf("azureKeyVault %s %s\n", vault, secretName)
+		}
+		newClient := c.Azure.newClient
+		if newClient == nil {
+			newClient = c.newAzureKeyVaultClient
+		}
+		client, err := newClient(vault)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := c.secretContext()
+		defer cancel()
+		response, err := client.GetSecret(ctx, secretName, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		if response.Value == nil {
+			return nil, errors.New("secret has no value")
+		}
+		return []byte(*response.Value), nil
+	})
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("azureKeyVault: %s: %s: %v", vault, secretName, err))
+	}
+	return string(output)
+}
+
+// newAzureKeyVaultClient returns a client for the Key Vault vault that
+// authenticates with DefaultAzureCredential, which is created once. It is only
+// called while secretCacheMu is held.
+func (c *Config) newAzureKeyVaultClient(vault string) (*azsecrets.Client, error) {
+	if c.Azure.credential == nil {
+		credential, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, err
+		}
+		c.Azure.credential = credential
+	}
+	return azsecrets.NewClient("https://"+vault+".vault.azure.net/", c.Azure.credential, nil)
+}
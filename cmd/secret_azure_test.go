@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+// A fakeAzureCredential is an azcore.TokenCredential that always returns the
+// same token.
+type fakeAzureCredential struct{}
+
+func (fakeAzureCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{
+		Token:     "token",
+		ExpiresOn: time.Now().Add(time.Hour),
+	}, nil
+}
+
+func TestAzureKeyVaultTemplateFunc(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Key Vault challenges unauthenticated requests for the tenant and
+		// resource of the token.
+		if r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant", resource="https://vault.azure.net"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests++
+		if got, want := r.Header.Get("Authorization"), "Bearer token"; got != want {
+			t.Errorf("r.Header.Get(\"Authorization\") == %q, want %q", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/personal/secrets/token/":
+			w.Write([]byte(`{"id":"https://personal.vault.azure.net/secrets/token/1","value":"personal-token"}`))
+		case "/work/secrets/token/":
+			w.Write([]byte(`{"id":"https://work.vault.azure.net/secrets/token/1","value":"work-token"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"SecretNotFound","message":"not found"}}`))
+		}
+	}))
+	defer server.Close()
+
+	c := &Config{
+		Azure: azureConfig{
+			DefaultVault: "personal",
+			newClient: func(vault string) (*azsecrets.Client, error) {
+				return azsecrets.NewClient(server.URL+"/"+vault, fakeAzureCredential{}, &azsecrets.ClientOptions{
+					ClientOptions: azcore.ClientOptions{
+						Transport: server.Client(),
+					},
+					DisableChallengeResourceVerification: true,
+				})
+			},
+		},
+	}
+	secretCache = make(map[string][]byte)
+	defer func() {
+		secretCache = make(map[string][]byte)
+	}()
+
+	if got, want := c.azureKeyVaultFunc("token"), "personal-token"; got != want {
+		t.Errorf("c.azureKeyVaultFunc(%q) == %q, want %q", "token", got, want)
+	}
+	if got, want := c.azureKeyVaultFunc("token", "work"), "work-token"; got != want {
+		t.Errorf("c.azureKeyVaultFunc(%q, %q) == %q, want %q", "token", "work", got, want)
+	}
+	if got, want := c.azureKeyVaultFunc("token"), "personal-token"; got != want {
+		t.Errorf("c.azureKeyVaultFunc(%q) == %q, want %q", "token", got, want)
+	}
+	// Values are cached.
+	if got, want := requests, 2; got != want {
+		t.Errorf("requests == %d, want %d", got, want)
+	}
+
+	func() {
+		defer func() {
+			r := recover()
+			if err, ok := r.(error); !ok || !strings.Contains(err.Error(), "SecretNotFound") {
+				t.Errorf("c.azureKeyVaultFunc(%q) panicked with %v, want a SecretNotFound error", "missing", r)
+			}
+		}()
+		c.azureKeyVaultFunc("missing")
+	}()
+}
@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
 
 	"github.com/spf13/cobra"
 	"github.com/twpayne/chezmoi/lib/chezmoi"
@@ -17,15 +20,29 @@ var bitwardenCmd = &cobra.Command{
 	RunE:  makeRunE(config.runBitwardenCmd),
 }
 
+// bitwardenSessionEnv is the environment variable that holds the Bitwarden
+// CLI session token.
+const bitwardenSessionEnv = "BW_SESSION"
+
 type bitwardenCmdConfig struct {
 	Bw string
+	// Unlock is whether to unlock the vault with bw unlock, prompting for the
+	// master password, if it is locked and there is no session in the
+	// environment.
+	Unlock bool
+	// PersistSession is whether to keep the session token in the keyring so
+	// that later commands do not need to unlock the vault again.
+	PersistSession bool
+	unlockOnce     sync.Once
+	session        string
+	unlockErr      error
 }
 
-var bitwardenCache = make(map[string]interface{})
-
 func init() {
 	config.Bitwarden.Bw = "bw"
-	config.addTemplateFunc("bitwarden", config.bitwardenFunc)
+	config.Bitwarden.Unlock = true
+	config.addSecretTemplateFunc("bitwarden", config.bitwardenFunc)
+	config.addSecretTemplateFunc("bitwardenFields", config.bitwardenFieldsFunc)
 
 	secretCmd.AddCommand(bitwardenCmd)
 }
@@ -35,23 +52,158 @@ func (c *Config) runBitwardenCmd(fs vfs.FS, args []string) error {
 }
 
 func (c *Config) bitwardenFunc(args ...string) interface{} {
-	key := strings.Join(args, "\x00")
-	if data, ok := bitwardenCache[key]; ok {
-		return data
-	}
 	name := c.Bitwarden.Bw
 	args = append([]string{"get"}, args...)
-	if c.Verbose {
-		fmt.Printf("%s %s\n", name, strings.Join(args, " "))
-	}
-	output, err := exec.Command(name, args...).CombinedOutput()
+	output, err := c.cachedSecretOutput(append([]string{name}, args...), func() ([]byte, error) {
+		environ, err := c.bitwardenEnv(os.Environ())
+		if err != nil {
+			return nil, fmt.Errorf("bitwarden: %v", err)
+		}
+		if c.Verbose {
+			fmt.Printf("%s %s\n", name, strings.Join(args, " "))
+		}
+		output, err := c.runSecretCmd(func(ctx context.Context) *exec.Cmd {
+			cmd := exec.CommandContext(ctx, name, args...)
+			cmd.Env = environ
+			return cmd
+		}, (*exec.Cmd).CombinedOutput)
+		if err != nil {
+			return nil, fmt.Errorf("bitwarden: %s %s: %v\n%s", name, strings.Join(args, " "), err, output)
+		}
+		return output, nil
+	})
 	if err != nil {
-		chezmoi.ReturnTemplateFuncError(fmt.Errorf("bitwarden: %s %s: %v\n%s", name, strings.Join(args, " "), err, output))
+		chezmoi.ReturnTemplateFuncError(err)
 	}
 	var data interface{}
 	if err := json.Unmarshal(output, &data); err != nil {
 		chezmoi.ReturnTemplateFuncError(fmt.Errorf("bitwarden: %s %s: %v\n%s", name, strings.Join(args, " "), err, output))
 	}
-	bitwardenCache[key] = data
 	return data
 }
+
+// bitwardenEnv returns environ with a session token for bw. If environ
+// already contains a session, or unlocking is disabled, then it is returned
+// unchanged. Otherwise the vault is unlocked once, which may prompt for the
+// master password, and the session is reused for the rest of the command.
+func (c *Config) bitwardenEnv(environ []string) ([]string, error) {
+	if !c.Bitwarden.Unlock || bitwardenHasSession(environ) {
+		return environ, nil
+	}
+	c.Bitwarden.unlockOnce.Do(func() {
+		c.Bitwarden.session, c.Bitwarden.unlockErr = c.bitwardenUnlock(environ)
+	})
+	if c.Bitwarden.unlockErr != nil {
+		return nil, c.Bitwarden.unlockErr
+	}
+	if c.Bitwarden.session == "" {
+		return environ, nil
+	}
+	return append(environ, bitwardenSessionEnv+"="+c.Bitwarden.session), nil
+}
+
+// bitwardenUnlock returns a session token for an unlocked vault, reusing a
+// persisted session if it is still valid. It returns the empty string if the
+// vault is already unlocked without one.
+func (c *Config) bitwardenUnlock(environ []string) (string, error) {
+	if c.Bitwarden.PersistSession {
+		if session := loadSession("bitwarden"); session != "" {
+			status, err := c.bitwardenStatus(append(environ, bitwardenSessionEnv+"="+session))
+			if err == nil && status == "unlocked" {
+				return session, nil
+			}
+		}
+	}
+	status, err := c.bitwardenStatus(environ)
+	if err != nil {
+		return "", err
+	}
+	switch status {
+	case "unlocked":
+		return "", nil
+	case "unauthenticated":
+		return "", fmt.Errorf("not logged in, run %s login", c.Bitwarden.Bw)
+	}
+	cmd := exec.Command(c.Bitwarden.Bw, "unlock", "--raw")
+	cmd.Env = environ
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s unlock --raw: %v", c.Bitwarden.Bw, err)
+	}
+	session := strings.TrimSpace(string(output))
+	if c.Bitwarden.PersistSession {
+		if err := saveSession("bitwarden", session); err != nil {
+			return "", fmt.Errorf("keyring: %v", err)
+		}
+	}
+	return session, nil
+}
+
+// bitwardenStatus returns the status of the vault, for example "locked", from
+// bw status.
+func (c *Config) bitwardenStatus(environ []string) (string, error) {
+	cmd := exec.Command(c.Bitwarden.Bw, "status")
+	cmd.Env = environ
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s status: %v", c.Bitwarden.Bw, err)
+	}
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(output, &status); err != nil {
+		return "", fmt.Errorf("%s status: %v\n%s", c.Bitwarden.Bw, err, output)
+	}
+	return status.Status, nil
+}
+
+// bitwardenHasSession returns whether environ contains a Bitwarden CLI
+// session token.
+func bitwardenHasSession(environ []string) bool {
+	for _, s := range environ {
+		if strings.HasPrefix(s, bitwardenSessionEnv+"=") && s != bitwardenSessionEnv+"=" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) bitwardenFieldsFunc(args ...string) interface{} {
+	fields, err := bitwardenParseFields(c.bitwardenFunc(args...))
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("bitwardenFields: %s: %v", strings.Join(args, " "), err))
+	}
+	return fields
+}
+
+// bitwardenParseFields returns the custom fields of the Bitwarden item data,
+// keyed by name.
+func bitwardenParseFields(data interface{}) (map[string]interface{}, error) {
+	item, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not an item")
+	}
+	result := make(map[string]interface{})
+	rawFields, ok := item["fields"]
+	if !ok || rawFields == nil {
+		return result, nil
+	}
+	fields, ok := rawFields.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid fields")
+	}
+	for _, rawField := range fields {
+		field, ok := rawField.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid field")
+		}
+		name, ok := field["name"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid field name")
+		}
+		result[name] = field
+	}
+	return result, nil
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func Test_bitwardenParseFields(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		item    string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "fields",
+			item: `{"name":"example.com","fields":[{"name":"token","value":"secret","type":1},{"name":"hidden","value":"value","type":0}]}`,
+			want: map[string]interface{}{
+				"token": map[string]interface{}{
+					"name":  "token",
+					"value": "secret",
+					"type":  float64(1),
+				},
+				"hidden": map[string]interface{}{
+					"name":  "hidden",
+					"value": "value",
+					"type":  float64(0),
+				},
+			},
+		},
+		{
+			name: "no_fields",
+			item: `{"name":"example.com"}`,
+			want: map[string]interface{}{},
+		},
+		{
+			name:    "not_an_item",
+			item:    `[]`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid_field",
+			item:    `{"fields":[{"value":"secret"}]}`,
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var data interface{}
+			if err := json.Unmarshal([]byte(tc.item), &data); err != nil {
+				t.Fatalf("json.Unmarshal(_, _) == %v, want <nil>", err)
+			}
+			got, err := bitwardenParseFields(data)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("bitwardenParseFields(_) == _, <nil>, want _, !<nil>")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("bitwardenParseFields(_) == _, %v, want _, <nil>", err)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.want, got); !equal {
+				t.Errorf("bitwardenParseFields(_) diff:\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_bitwardenHasSession(t *testing.T) {
+	for _, tc := range []struct {
+		environ []string
+		want    bool
+	}{
+		{
+			environ: []string{"HOME=/home/user"},
+			want:    false,
+		},
+		{
+			environ: []string{"HOME=/home/user", "BW_SESSION=token"},
+			want:    true,
+		},
+		{
+			environ: []string{"BW_SESSION="},
+			want:    false,
+		},
+	} {
+		if got := bitwardenHasSession(tc.environ); got != tc.want {
+			t.Errorf("bitwardenHasSession(%v) == %v, want %v", tc.environ, got, tc.want)
+		}
+	}
+}
+
+func TestBitwardenUnlock(t *testing.T) {
+	bw, cleanup := newFakeSecretCmd(t, "bw", map[string]string{
+		"status": `if [ "$BW_SESSION" = "token" ]; then
+	echo '{"status":"unlocked"}'
+else
+	echo '{"status":"locked"}'
+fi`,
+		"unlock --raw": `echo unlock >> "$(dirname "$0")/unlocks"
+echo token`,
+		"get item example.com": `if [ "$BW_SESSION" != "token" ]; then
+	echo "vault is locked" >&2
+	exit 1
+fi
+echo '{"name":"example.com"}'`,
+	})
+	defer cleanup()
+	unlocks := filepath.Join(filepath.Dir(bw), "unlocks")
+	defer os.Setenv("BW_SESSION", os.Getenv("BW_SESSION"))
+	if err := os.Unsetenv("BW_SESSION"); err != nil {
+		t.Fatalf("os.Unsetenv(%q) == %v, want <nil>", "BW_SESSION", err)
+	}
+	c := &Config{
+		Bitwarden: bitwardenCmdConfig{
+			Bw:     bw,
+			Unlock: true,
+		},
+	}
+	secretCache = make(map[string][]byte)
+	defer func() {
+		secretCache = make(map[string][]byte)
+	}()
+
+	// The vault is unlocked once, and the session reused.
+	for i := 0; i < 2; i++ {
+		secretCache = make(map[string][]byte)
+		if got, want := c.bitwardenFunc("item", "example.com").(map[string]interface{})["name"], "example.com"; got != want {
+			t.Errorf("c.bitwardenFunc(%q, %q).name == %v, want %v", "item", "example.com", got, want)
+		}
+	}
+	data, err := ioutil.ReadFile(unlocks)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile(%q) == _, %v, want _, <nil>", unlocks, err)
+	}
+	if got, want := string(data), "unlock\n"; got != want {
+		t.Errorf("ioutil.ReadFile(%q) == %q, _, want %q, _", unlocks, got, want)
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type secretCacheConfig struct {
+	// TTL is how long the outputs of secret manager commands are cached on
+	// disk, encrypted with the configured encryption tool. If it is zero
+	// then outputs are only cached in memory for a single command.
+	TTL time.Duration
+}
+
+// secretCache caches the outputs of secret manager commands for a single
+// command, keyed by the NUL-joined command and arguments.
+var secretCache = make(map[string][]byte)
+
+// secretCacheMu guards secretCache. It is held while secret manager
+// commands run, so templates that are executed concurrently run each command
+// only once, and never prompt for more than one password or unlock a password
+// manager more than once at a time.
+var secretCacheMu sync.Mutex
+
+// cachedSecretOutput returns the output of the secret manager command
+// identified by key, calling run to get it if it is not cached.
+func (c *Config) cachedSecretOutput(key []string, run func() ([]byte, error)) ([]byte, error) {
+	cacheKey := strings.Join(key, "\x00")
+	secretCacheMu.Lock()
+	defer secretCacheMu.Unlock()
+	if output, ok := secretCache[cacheKey]; ok {
+		return output, nil
+	}
+	output, err := c.readSecretCache(cacheKey)
+	if err == nil && output == nil {
+		output, err = run()
+		if err == nil {
+			err = c.writeSecretCache(cacheKey, output)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	secretCache[cacheKey] = output
+	return output, nil
+}
+
+// secretCachePath returns the path of the on-disk cache of the output for
+// cacheKey. The key is hashed so that secret manager arguments, which may
+// themselves be sensitive, do not appear in file names.
+func (c *Config) secretCachePath(cacheKey string) string {
+	sum := sha256.Sum256([]byte(cacheKey))
+	return filepath.Join(c.cacheDir, "secrets", hex.EncodeToString(sum[:]))
+}
+
+// readSecretCache returns the cached output for cacheKey, or nil if there is
+// no cached output, it is older than c.SecretCache.TTL, or it cannot be
+// decrypted, for example because the encryption tool's keys have changed.
+func (c *Config) readSecretCache(cacheKey string) ([]byte, error) {
+	if c.cacheDir == "" || c.SecretCache.TTL <= 0 {
+		return nil, nil
+	}
+	path := c.secretCachePath(cacheKey)
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case time.Since(info.ModTime()) >= c.SecretCache.TTL:
+		return nil, nil
+	}
+	ciphertext, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	encryption, err := c.getCachedEncryption()
+	if err != nil {
+		return nil, err
+	}
+	output, err := encryption.Decrypt(path, ciphertext)
+	if err != nil {
+		return nil, nil
+	}
+	return output, nil
+}
+
+// writeSecretCache encrypts output and caches it on disk as the output for
+// cacheKey. Nothing is written in dry run mode or if c.SecretCache.TTL is
+// zero.
+func (c *Config) writeSecretCache(cacheKey string, output []byte) error {
+	if c.cacheDir == "" || c.SecretCache.TTL <= 0 || c.DryRun {
+		return nil
+	}
+	encryption, err := c.getCachedEncryption()
+	if err != nil {
+		return err
+	}
+	path := c.secretCachePath(cacheKey)
+	ciphertext, err := encryption.Encrypt(path, output)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, ciphertext, 0600)
+}
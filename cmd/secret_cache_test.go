@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCachedSecretOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chezmoi-test-secret-cache")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(dir)
+	secretCache = make(map[string][]byte)
+	defer func() {
+		secretCache = make(map[string][]byte)
+	}()
+
+	runs := 0
+	run := func() ([]byte, error) {
+		runs++
+		return []byte("secret"), nil
+	}
+	get := func(c *Config, wantRuns int) {
+		output, err := c.cachedSecretOutput([]string{"secret", "get"}, run)
+		if err != nil {
+			t.Fatalf("c.cachedSecretOutput(_, _) == _, %v, want _, <nil>", err)
+		}
+		if got, want := string(output), "secret"; got != want {
+			t.Errorf("c.cachedSecretOutput(_, _) == %q, _, want %q, _", got, want)
+		}
+		if runs != wantRuns {
+			t.Errorf("runs == %d, want %d", runs, wantRuns)
+		}
+	}
+
+	c := &Config{
+		cacheDir:   dir,
+		encryption: reverseEncryption{},
+	}
+	get(c, 1)
+	get(c, 1)
+
+	// Without a TTL, outputs are not cached across commands.
+	secretCache = make(map[string][]byte)
+	get(c, 2)
+	path := c.secretCachePath("secret\x00get")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) == _, %v, want _, <not exist>", path, err)
+	}
+
+	// With a TTL, outputs are cached encrypted on disk.
+	c.SecretCache.TTL = time.Hour
+	secretCache = make(map[string][]byte)
+	get(c, 3)
+	ciphertext, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile(%q) == _, %v, want _, <nil>", path, err)
+	}
+	if got, want := string(ciphertext), "terces"; got != want {
+		t.Errorf("ioutil.ReadFile(%q) == %q, _, want %q, _", path, got, want)
+	}
+	secretCache = make(map[string][]byte)
+	get(c, 3)
+
+	// Expired outputs are ignored.
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatalf("os.Chtimes(%q, _, _) == %v, want <nil>", path, err)
+	}
+	secretCache = make(map[string][]byte)
+	get(c, 4)
+}
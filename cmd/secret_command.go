@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+)
+
+type secretCommandConfig struct {
+	// Timeout is how long each secret manager command may run before it is
+	// killed. If it is zero then commands may run for ever.
+	Timeout time.Duration
+	// MaxAttempts is the maximum number of times that a failing secret
+	// manager command is run, including the first.
+	MaxAttempts int
+}
+
+// A secretCommandTimeoutError is returned when a secret manager command is
+// killed because it ran for longer than the configured timeout.
+type secretCommandTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *secretCommandTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.timeout)
+}
+
+// exitCodeTempFail is the exit code of commands that failed temporarily and
+// may succeed if run again, EX_TEMPFAIL from sysexits.h.
+const exitCodeTempFail = 75
+
+// transientSecretCmdErrors are substrings of the standard error of secret
+// manager commands that failed because of a transient problem, like a network
+// outage or rate limiting, in lower case.
+var transientSecretCmdErrors = []string{
+	"connection refused",
+	"connection reset",
+	"network is unreachable",
+	"service unavailable",
+	"temporarily unavailable",
+	"timed out",
+	"too many requests",
+	"try again",
+}
+
+// runSecretCmd runs the secret manager command returned by newCmd with run,
+// for example (*exec.Cmd).Output, and returns its output. Each attempt is
+// limited to c.SecretCommand.Timeout, and attempts that fail transiently, as
+// classified by isRetryableSecretCmdError, are retried up to
+// c.SecretCommand.MaxAttempts times. newCmd is called once for each attempt,
+// as commands cannot be reused.
+func (c *Config) runSecretCmd(newCmd func(ctx context.Context) *exec.Cmd, run func(*exec.Cmd) ([]byte, error)) ([]byte, error) {
+	var cmdLine string
+	policy := chezmoi.RetryPolicy{
+		MaxAttempts: c.SecretCommand.MaxAttempts,
+		Backoff:     chezmoi.DefaultRetryPolicy.Backoff,
+		Retryable:   isRetryableSecretCmdError,
+		OnRetry: func(_ string, attempt int, err error) {
+			fmt.Fprintf(os.Stderr, "chezmoi: %s: attempt %d failed, retrying: %s\n", cmdLine, attempt, secretRedactor.redact(err.Error()))
+		},
+	}
+	var output []byte
+	err := policy.DoContext(c.getContext(), "", func() error {
+		ctx := c.getContext()
+		if c.SecretCommand.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.SecretCommand.Timeout)
+			defer cancel()
+		}
+		cmd := newCmd(ctx)
+		cmdLine = strings.Join(cmd.Args, " ")
+		// Do not wait for ever for the output of any processes started by
+		// the command after it is killed.
+		cmd.WaitDelay = time.Second
+		var err error
+		output, err = run(cmd)
+		switch exitErr, ok := err.(*exec.ExitError); {
+		case err != nil && ctx.Err() == context.DeadlineExceeded:
+			err = &secretCommandTimeoutError{
+				timeout: c.SecretCommand.Timeout,
+			}
+		case ok && exitErr.Stderr == nil:
+			// (*exec.Cmd).CombinedOutput returns the standard error in the
+			// output, so record it for isRetryableSecretCmdError.
+			exitErr.Stderr = output
+		}
+		return err
+	})
+	return output, err
+}
+
+// secretContext returns the context for a request to a secret manager's API,
+// which is cancelled after c.SecretCommand.Timeout, like a secret manager
+// command.
+func (c *Config) secretContext() (context.Context, context.CancelFunc) {
+	if c.SecretCommand.Timeout > 0 {
+		return context.WithTimeout(c.getContext(), c.SecretCommand.Timeout)
+	}
+	return context.WithCancel(c.getContext())
+}
+
+// isRetryableSecretCmdError returns true if err, returned by runSecretCmd,
+// is transient. Timeouts, commands that exit with EX_TEMPFAIL, and commands
+// whose standard error reports a transient problem are transient, as are
+// transient errors starting the command. All other failures, like failed
+// authentication or a missing secret, are not retried, as they would only fail
+// again.
+func isRetryableSecretCmdError(err error) bool {
+	switch e := err.(type) {
+	case *secretCommandTimeoutError:
+		return true
+	case *exec.ExitError:
+		if e.ExitCode() == exitCodeTempFail {
+			return true
+		}
+		stderr := strings.ToLower(string(e.Stderr))
+		for _, s := range transientSecretCmdErrors {
+			if strings.Contains(stderr, s) {
+				return true
+			}
+		}
+		return false
+	default:
+		return chezmoi.IsRetryableError(err)
+	}
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeSecretCmd writes a fake secret command called name and returns its
+// path and a function that removes it. cases maps the command's arguments,
+// joined with spaces, to the shell commands that it runs for them. The command
+// fails for any other arguments. The test is skipped on Windows.
+func newFakeSecretCmd(t *testing.T, name string, cases map[string]string) (string, func()) {
+	if runtime.GOOS == "windows" {
+		t.Skipf("fake %s command requires a shell", name)
+	}
+	dir, err := ioutil.TempDir("", "chezmoi-test-"+name)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+	argss := make([]string, 0, len(cases))
+	for args := range cases {
+		argss = append(argss, args)
+	}
+	sort.Strings(argss)
+	sb := &strings.Builder{}
+	sb.WriteString("#!/bin/sh\ncase \"$*\" in\n")
+	for _, args := range argss {
+		sb.WriteString("\"" + args + "\")\n")
+		for _, line := range strings.Split(strings.TrimSuffix(cases[args], "\n"), "\n") {
+			sb.WriteString("\t" + line + "\n")
+		}
+		sb.WriteString("\t;;\n")
+	}
+	sb.WriteString("*)\n\techo \"unexpected arguments: $*\" >&2\n\texit 1\n\t;;\nesac\n")
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(sb.String()), 0755); err != nil {
+		cleanup()
+		t.Fatalf("ioutil.WriteFile(%q, _, 0755) == %v, want <nil>", path, err)
+	}
+	return path, cleanup
+}
+
+func TestRunSecretCmd(t *testing.T) {
+	// The command hangs, always fails with a permanent error, or fails with a
+	// transient error on its first attempt and succeeds on its second,
+	// depending on its argument.
+	command, cleanup := newFakeSecretCmd(t, "secret", map[string]string{
+		"hang": "exec sleep 10",
+		"denied": `echo attempt >> "$(dirname "$0")/attempts"
+echo "access denied" >&2
+exit 1`,
+		"get": `echo attempt >> "$(dirname "$0")/attempts"
+if [ "$(wc -l < "$(dirname "$0")/attempts")" -lt 2 ]; then
+	echo "service temporarily unavailable" >&2
+	exit 1
+fi
+echo secret`,
+		"tempfail": `echo attempt >> "$(dirname "$0")/attempts"
+if [ "$(wc -l < "$(dirname "$0")/attempts")" -lt 2 ]; then
+	exit 75
+fi
+echo secret`,
+	})
+	defer cleanup()
+	attempts := filepath.Join(filepath.Dir(command), "attempts")
+	newCmd := func(args ...string) func(context.Context) *exec.Cmd {
+		return func(ctx context.Context) *exec.Cmd {
+			return exec.CommandContext(ctx, command, args...)
+		}
+	}
+
+	c := &Config{
+		SecretCommand: secretCommandConfig{
+			Timeout: 100 * time.Millisecond,
+		},
+	}
+	start := time.Now()
+	_, err := c.runSecretCmd(newCmd("hang"), (*exec.Cmd).Output)
+	if _, ok := err.(*secretCommandTimeoutError); !ok {
+		t.Errorf("c.runSecretCmd(_, _) == _, %v, want _, *secretCommandTimeoutError", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 5*time.Second {
+		t.Errorf("c.runSecretCmd(_, _) took %s, want <5s", elapsed)
+	}
+
+	if _, err := c.runSecretCmd(newCmd("get"), (*exec.Cmd).Output); err == nil {
+		t.Errorf("c.runSecretCmd(_, _) == _, <nil>, want _, !<nil>")
+	}
+
+	c.SecretCommand.MaxAttempts = 2
+	for _, arg := range []string{"get", "tempfail"} {
+		for _, run := range []func(*exec.Cmd) ([]byte, error){
+			(*exec.Cmd).Output,
+			(*exec.Cmd).CombinedOutput,
+		} {
+			if err := os.RemoveAll(attempts); err != nil {
+				t.Fatalf("os.RemoveAll(%q) == %v, want <nil>", attempts, err)
+			}
+			output, err := c.runSecretCmd(newCmd(arg), run)
+			if err != nil {
+				t.Fatalf("c.runSecretCmd(_, _) == _, %v, want _, <nil>", err)
+			}
+			if got, want := string(output), "secret\n"; got != want {
+				t.Errorf("c.runSecretCmd(_, _) == %q, _, want %q, _", got, want)
+			}
+		}
+	}
+
+	if err := os.RemoveAll(attempts); err != nil {
+		t.Fatalf("os.RemoveAll(%q) == %v, want <nil>", attempts, err)
+	}
+	if _, err := c.runSecretCmd(newCmd("denied"), (*exec.Cmd).Output); err == nil {
+		t.Errorf("c.runSecretCmd(_, _) == _, <nil>, want _, !<nil>")
+	}
+	data, err := ioutil.ReadFile(attempts)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile(%q) == _, %v, want _, <nil>", attempts, err)
+	}
+	if got, want := strings.Count(string(data), "\n"), 1; got != want {
+		t.Errorf("got %d attempts, want %d", got, want)
+	}
+}
+
+func TestRunSecretCmdContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake secret command requires a shell")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &Config{
+		ctx: ctx,
+		SecretCommand: secretCommandConfig{
+			MaxAttempts: 3,
+		},
+	}
+	attempts := 0
+	_, err := c.runSecretCmd(func(ctx context.Context) *exec.Cmd {
+		attempts++
+		return exec.CommandContext(ctx, "sleep", "10")
+	}, (*exec.Cmd).Output)
+	if err == nil {
+		t.Errorf("c.runSecretCmd(_, _) == _, <nil>, want _, !<nil>")
+	}
+	if got, want := attempts, 1; got != want {
+		t.Errorf("got %d attempts, want %d", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+)
+
+// gcpConfig configures the Google Cloud client libraries. Credentials are
+// application default credentials, so they can come from a service account key
+// in $GOOGLE_APPLICATION_CREDENTIALS, the credentials set with
+// gcloud auth application-default login, or, on Google Cloud machines, the
+// attached service account.
+type gcpConfig struct {
+	// Project is the project containing secrets. If it is empty then the
+	// project of the application default credentials is used.
+	Project string
+	// clientOptions, if not nil, are used instead of application default
+	// credentials, and are set by tests to use a fake Secret Manager.
+	clientOptions []option.ClientOption
+}
+
+func init() {
+	config.addSecretTemplateFunc("gcpSecretManager", config.gcpSecretManagerFunc)
+}
+
+// gcpSecretManagerFunc returns the data of version of the secret secret, or
+// of its latest version if version is not given.
+func (c *Config) gcpSecretManagerFunc(secret string, version ...string) string {
+	secretVersion := "latest"
+	switch len(version) {
+	case 0:
+	case 1:
+		secretVersion = version[0]
+	default:
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("gcpSecretManager: expected 1 or 2 arguments, got %d", len(version)+1))
+	}
+	output, err := c.cachedSecretOutput([]string{"gcpSecretManager", c.GCP.Project, secret, secretVersion}, func() ([]byte, error) {
+		if c.Verbose {
+			fmt.Printf("gcpSecretManager %s %s\n", secret, secretVersion)
+		}
+		ctx, cancel := c.secretContext()
+		defer cancel()
+		client, project, err := c.newGCPSecretManagerClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer client.Close()
+		response, err := client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{
+			Name: "projects/" + project + "/secrets/" + secret + "/versions/" + secretVersion,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return response.GetPayload().GetData(), nil
+	})
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("gcpSecretManager: %s: %v", secret, err))
+	}
+	return string(output)
+}
+
+// newGCPSecretManagerClient returns a new Secret Manager client and the project
+// containing secrets.
+func (c *Config) newGCPSecretManagerClient(ctx context.Context) (*secretmanager.Client, string, error) {
+	project := c.GCP.Project
+	options := c.GCP.clientOptions
+	if options == nil {
+		credentials, err := google.FindDefaultCredentials(ctx, secretmanager.DefaultAuthScopes()...)
+		if err != nil {
+			return nil, "", err
+		}
+		if project == "" {
+			project = credentials.ProjectID
+		}
+		options = []option.ClientOption{option.WithCredentials(credentials)}
+	}
+	if project == "" {
+		return nil, "", errors.New("no project and gcp.project not set")
+	}
+	client, err := secretmanager.NewRESTClient(ctx, options...)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, project, nil
+}
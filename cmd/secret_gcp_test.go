@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+)
+
+func TestGCPSecretManagerTemplateFunc(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/projects/my-project/secrets/token/versions/latest:access":
+			// "token", encoded in base64.
+			w.Write([]byte(`{"name":"projects/my-project/secrets/token/versions/2","payload":{"data":"dG9rZW4="}}`))
+		case "/v1/projects/my-project/secrets/token/versions/1:access":
+			// "old-token", encoded in base64.
+			w.Write([]byte(`{"name":"projects/my-project/secrets/token/versions/1","payload":{"data":"b2xkLXRva2Vu"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"Secret not found","status":"NOT_FOUND"}}`))
+		}
+	}))
+	defer server.Close()
+
+	c := &Config{
+		GCP: gcpConfig{
+			Project: "my-project",
+			clientOptions: []option.ClientOption{
+				option.WithEndpoint(server.URL),
+				option.WithoutAuthentication(),
+			},
+		},
+	}
+	secretCache = make(map[string][]byte)
+	defer func() {
+		secretCache = make(map[string][]byte)
+	}()
+
+	if got, want := c.gcpSecretManagerFunc("token"), "token"; got != want {
+		t.Errorf("c.gcpSecretManagerFunc(%q) == %q, want %q", "token", got, want)
+	}
+	if got, want := c.gcpSecretManagerFunc("token", "1"), "old-token"; got != want {
+		t.Errorf("c.gcpSecretManagerFunc(%q, %q) == %q, want %q", "token", "1", got, want)
+	}
+	if got, want := c.gcpSecretManagerFunc("token"), "token"; got != want {
+		t.Errorf("c.gcpSecretManagerFunc(%q) == %q, want %q", "token", got, want)
+	}
+	// Values are cached.
+	if got, want := requests, 2; got != want {
+		t.Errorf("requests == %d, want %d", got, want)
+	}
+
+	func() {
+		defer func() {
+			r := recover()
+			if err, ok := r.(error); !ok || !strings.Contains(err.Error(), "Secret not found") {
+				t.Errorf("c.gcpSecretManagerFunc(%q) panicked with %v, want a not found error", "missing", r)
+			}
+		}()
+		c.gcpSecretManagerFunc("missing")
+	}()
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os/exec"
@@ -21,11 +22,9 @@ type genericSecretCmdConfig struct {
 	Command string
 }
 
-var genericSecretCache = make(map[string][]byte)
-
 func init() {
-	config.addTemplateFunc("secret", config.secretFunc)
-	config.addTemplateFunc("secretJSON", config.secretJSONFunc)
+	config.addSecretTemplateFunc("secret", config.secretFunc)
+	config.addSecretTemplateFunc("secretJSON", config.secretJSONFunc)
 
 	secretCmd.AddCommand(genericSecretCmd)
 }
@@ -35,41 +34,42 @@ func (c *Config) runGenericSecretCmd(fs vfs.FS, args []string) error {
 }
 
 func (c *Config) secretFunc(args ...string) interface{} {
-	// FIXME factor out common functionality with secretJSONFunc
-	key := strings.Join(args, "\x00")
-	if output, ok := genericSecretCache[key]; ok {
-		return output
-	}
-	name := c.GenericSecret.Command
-	if c.Verbose {
-		fmt.Printf("%s %s\n", name, strings.Join(args, " "))
-	}
-	output, err := exec.Command(name, args...).CombinedOutput()
+	output, err := c.secretOutput("secret", args)
 	if err != nil {
-		chezmoi.ReturnTemplateFuncError(fmt.Errorf("secret: %s %s: %v\n%s", name, strings.Join(args, " "), err, output))
+		chezmoi.ReturnTemplateFuncError(err)
 	}
-	genericSecretCache[key] = output
 	return strings.TrimSpace(string(output))
 }
 
 func (c *Config) secretJSONFunc(args ...string) interface{} {
-	// FIXME factor out common functionality with secretFunc
-	key := strings.Join(args, "\x00")
-	if output, ok := genericSecretCache[key]; ok {
-		return output
-	}
-	name := c.GenericSecret.Command
-	if c.Verbose {
-		fmt.Printf("%s %s\n", name, strings.Join(args, " "))
-	}
-	output, err := exec.Command(name, args...).CombinedOutput()
+	output, err := c.secretOutput("secretJSON", args)
 	if err != nil {
-		chezmoi.ReturnTemplateFuncError(fmt.Errorf("secretJSON: %s %s: %v\n%s", name, strings.Join(args, " "), err, output))
+		chezmoi.ReturnTemplateFuncError(err)
 	}
 	var data interface{}
 	if err := json.Unmarshal(output, &data); err != nil {
-		chezmoi.ReturnTemplateFuncError(fmt.Errorf("secretJSON: %s %s: %v\n%s", name, strings.Join(args, " "), err, output))
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("secretJSON: %s %s: %v\n%s", c.GenericSecret.Command, strings.Join(args, " "), err, output))
 	}
-	genericSecretCache[key] = output
 	return data
 }
+
+// secretOutput returns the output of the generic secret command with args.
+// Outputs are cached, and shared by secret and secretJSON.
+func (c *Config) secretOutput(funcName string, args []string) ([]byte, error) {
+	name := c.GenericSecret.Command
+	if name == "" {
+		return nil, fmt.Errorf("%s: genericSecret.command not set", funcName)
+	}
+	return c.cachedSecretOutput(append([]string{name}, args...), func() ([]byte, error) {
+		if c.Verbose {
+			fmt.Printf("%s %s\n", name, strings.Join(args, " "))
+		}
+		output, err := c.runSecretCmd(func(ctx context.Context) *exec.Cmd {
+			return exec.CommandContext(ctx, name, args...)
+		}, (*exec.Cmd).CombinedOutput)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s %s: %v\n%s", funcName, name, strings.Join(args, " "), err, output)
+		}
+		return output, nil
+	})
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestGenericSecretTemplateFuncs(t *testing.T) {
+	command, cleanup := newFakeSecretCmd(t, "secret", map[string]string{
+		"get password": `echo "  secret  "`,
+		"get json":     `echo '{"username":"user"}'`,
+	})
+	defer cleanup()
+	c := &Config{
+		GenericSecret: genericSecretCmdConfig{
+			Command: command,
+		},
+	}
+	secretCache = make(map[string][]byte)
+	defer func() {
+		secretCache = make(map[string][]byte)
+	}()
+
+	for _, tc := range []struct {
+		name string
+		f    func() interface{}
+		want interface{}
+	}{
+		{
+			name: "secret",
+			f:    func() interface{} { return c.secretFunc("get", "password") },
+			want: "secret",
+		},
+		{
+			name: "secretJSON",
+			f:    func() interface{} { return c.secretJSONFunc("get", "json") },
+			want: map[string]interface{}{
+				"username": "user",
+			},
+		},
+		{
+			name: "secret_json",
+			f:    func() interface{} { return c.secretFunc("get", "json") },
+			want: `{"username":"user"}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Each function is called twice so that cached outputs are also
+			// tested.
+			for i := 0; i < 2; i++ {
+				if diff, equal := messagediff.PrettyDiff(tc.want, tc.f()); !equal {
+					t.Errorf("call %d diff:\n%s", i, diff)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+var gopassCmd = &cobra.Command{
+	Use:   "gopass [args...]",
+	Short: "Execute the gopass CLI",
+	RunE:  makeRunE(config.runSecretGopassCmd),
+}
+
+type gopassCmdConfig struct {
+	Gopass string
+}
+
+func init() {
+	secretCmd.AddCommand(gopassCmd)
+
+	config.Gopass.Gopass = "gopass"
+	config.addSecretTemplateFunc("gopass", config.gopassFunc)
+	config.addSecretTemplateFunc("gopassFields", config.gopassFieldsFunc)
+	config.addTemplateFunc("gopassList", config.gopassListFunc)
+}
+
+func (c *Config) runSecretGopassCmd(fs vfs.FS, args []string) error {
+	return c.exec(append([]string{c.Gopass.Gopass}, args...))
+}
+
+// gopassFunc returns the password of the entry id. Unlike pass, gopass
+// strips any fields itself.
+func (c *Config) gopassFunc(id string) string {
+	output, err := c.gopassOutput("show", "--password", id)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	return strings.TrimRight(string(output), "\n")
+}
+
+// gopassFieldsFunc returns the fields of the entry id. The entry is read
+// without gopass's own parsing, which reorders and reformats fields, and
+// parsed like a pass entry.
+func (c *Config) gopassFieldsFunc(id string) map[string]string {
+	output, err := c.gopassOutput("show", "--noparsing", id)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	fields, err := passParseFields(output)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("gopassFields: %s: %v", id, err))
+	}
+	return fields
+}
+
+// gopassListFunc returns the names of the entries in all mounts, or only the
+// entries below prefix if it is given.
+func (c *Config) gopassListFunc(prefix ...string) []string {
+	if len(prefix) > 1 {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("gopassList: expected 0 or 1 arguments, got %d", len(prefix)))
+	}
+	output, err := c.gopassOutput(append([]string{"list", "--flat"}, prefix...)...)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	return gopassParseList(output)
+}
+
+// gopassOutput returns the output of gopass with args. Outputs are cached.
+func (c *Config) gopassOutput(args ...string) ([]byte, error) {
+	name := c.Gopass.Gopass
+	return c.cachedSecretOutput(append([]string{name}, args...), func() ([]byte, error) {
+		if c.Verbose {
+			fmt.Printf("%s %s\n", name, strings.Join(args, " "))
+		}
+		output, err := c.runSecretCmd(func(ctx context.Context) *exec.Cmd {
+			return exec.CommandContext(ctx, name, args...)
+		}, (*exec.Cmd).Output)
+		if err != nil {
+			return nil, fmt.Errorf("gopass: %s %s: %v", name, strings.Join(args, " "), err)
+		}
+		return output, nil
+	})
+}
+
+// gopassParseList returns the entry names in the output of gopass list
+// --flat.
+func gopassParseList(output []byte) []string {
+	var names []string
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		if name := strings.TrimSpace(string(line)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
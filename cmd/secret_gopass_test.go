@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestGopassTemplateFuncs(t *testing.T) {
+	gopass, cleanup := newFakeSecretCmd(t, "gopass", map[string]string{
+		"show --password work/example.com":  "echo secret",
+		"show --noparsing work/example.com": `printf 'secret\nusername: user\nnotes:\n  line 1\n  line 2\n'`,
+		"list --flat":                       `printf 'personal/example.org\nwork/example.com\n'`,
+		"list --flat work":                  `printf 'work/example.com\n'`,
+	})
+	defer cleanup()
+	c := &Config{
+		Gopass: gopassCmdConfig{
+			Gopass: gopass,
+		},
+	}
+	secretCache = make(map[string][]byte)
+	defer func() {
+		secretCache = make(map[string][]byte)
+	}()
+
+	for _, tc := range []struct {
+		name string
+		f    func() interface{}
+		want interface{}
+	}{
+		{
+			name: "gopass",
+			f:    func() interface{} { return c.gopassFunc("work/example.com") },
+			want: "secret",
+		},
+		{
+			name: "gopassFields",
+			f:    func() interface{} { return c.gopassFieldsFunc("work/example.com") },
+			want: map[string]string{
+				"username": "user",
+				"notes":    "  line 1\n  line 2",
+			},
+		},
+		{
+			name: "gopassList",
+			f:    func() interface{} { return c.gopassListFunc() },
+			want: []string{"personal/example.org", "work/example.com"},
+		},
+		{
+			name: "gopassList_prefix",
+			f:    func() interface{} { return c.gopassListFunc("work") },
+			want: []string{"work/example.com"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff, equal := messagediff.PrettyDiff(tc.want, tc.f()); !equal {
+				t.Errorf("diff:\n%s", diff)
+			}
+		})
+	}
+}
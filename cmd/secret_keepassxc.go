@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+var keepassxcCmd = &cobra.Command{
+	Use:   "keepassxc [args...]",
+	Short: "Execute the KeePassXC CLI (keepassxc-cli)",
+	RunE:  makeRunE(config.runKeepassxcCmd),
+}
+
+// keepassxcAttributeRegexp matches the first line of an attribute in the
+// output of keepassxc-cli show, for example "UserName: user".
+var keepassxcAttributeRegexp = regexp.MustCompile(`\A([A-Za-z][A-Za-z0-9 ]*): ?(.*)\z`)
+
+type keepassxcCmdConfig struct {
+	Command  string
+	Database string
+	Args     []string
+	// password is the database password, read once and then reused for
+	// every lookup in the same command.
+	password []byte
+}
+
+func init() {
+	config.Keepassxc.Command = "keepassxc-cli"
+	config.addSecretTemplateFunc("keepassxc", config.keepassxcFunc)
+	config.addSecretTemplateFunc("keepassxcAttribute", config.keepassxcAttributeFunc)
+
+	secretCmd.AddCommand(keepassxcCmd)
+}
+
+func (c *Config) runKeepassxcCmd(fs vfs.FS, args []string) error {
+	return c.exec(append([]string{c.Keepassxc.Command}, args...))
+}
+
+// keepassxcFunc returns the attributes of entry, keyed by name, including
+// protected attributes like Password.
+func (c *Config) keepassxcFunc(entry string) map[string]string {
+	output, err := c.keepassxcOutput(entry, "show", "--show-protected")
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	attributes, err := keepassxcParseOutput(output)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("keepassxc: %s: %v", entry, err))
+	}
+	return attributes
+}
+
+// keepassxcAttributeFunc returns the value of attribute of entry.
+func (c *Config) keepassxcAttributeFunc(entry, attribute string) string {
+	output, err := c.keepassxcOutput(entry, "show", "--attributes", attribute)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	return strings.TrimRight(string(output), "\n")
+}
+
+// keepassxcOutput returns the output of keepassxc-cli with args for entry in
+// the database, unlocking the database with the password, which is prompted
+// for on first use. Outputs are cached.
+func (c *Config) keepassxcOutput(entry string, args ...string) ([]byte, error) {
+	if c.Keepassxc.Database == "" {
+		return nil, fmt.Errorf("keepassxc: keepassxc.database not set")
+	}
+	args = append(args, "--quiet")
+	args = append(args, c.Keepassxc.Args...)
+	args = append(args, c.Keepassxc.Database, entry)
+	name := c.Keepassxc.Command
+	return c.cachedSecretOutput(append([]string{name}, args...), func() ([]byte, error) {
+		if c.Keepassxc.password == nil {
+			password, err := readPassword(fmt.Sprintf("Enter password to unlock %s: ", c.Keepassxc.Database))
+			if err != nil {
+				return nil, fmt.Errorf("keepassxc: %v", err)
+			}
+			c.Keepassxc.password = password
+		}
+		if c.Verbose {
+			fmt.Printf("%s %s\n", name, strings.Join(args, " "))
+		}
+		stderr := &bytes.Buffer{}
+		output, err := c.runSecretCmd(func(ctx context.Context) *exec.Cmd {
+			cmd := exec.CommandContext(ctx, name, args...)
+			cmd.Stdin = bytes.NewReader(append(append([]byte{}, c.Keepassxc.password...), '\n'))
+			stderr.Reset()
+			cmd.Stderr = stderr
+			return cmd
+		}, (*exec.Cmd).Output)
+		if err != nil {
+			return nil, fmt.Errorf("keepassxc: %s %s: %v\n%s", name, strings.Join(args, " "), err, stderr.Bytes())
+		}
+		return output, nil
+	})
+}
+
+// keepassxcParseOutput parses the attributes in the output of keepassxc-cli
+// show. Lines that are not attributes, for example the later lines of
+// multi-line notes, are appended to the previous attribute.
+func keepassxcParseOutput(output []byte) (map[string]string, error) {
+	result := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(output))
+	key := ""
+	for s.Scan() {
+		if m := keepassxcAttributeRegexp.FindStringSubmatch(s.Text()); m != nil {
+			key = m[1]
+			result[key] = m[2]
+		} else if key != "" {
+			result[key] += "\n" + s.Text()
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_keepassxcParseOutput(t *testing.T) {
+	output := "Title: example.com\nUserName: user\nPassword: secret\nURL: https://example.com\nNotes: line 1\nline 2\n"
+	got, err := keepassxcParseOutput([]byte(output))
+	if err != nil {
+		t.Fatalf("keepassxcParseOutput(_) == _, %v, want _, <nil>", err)
+	}
+	want := map[string]string{
+		"Title":    "example.com",
+		"UserName": "user",
+		"Password": "secret",
+		"URL":      "https://example.com",
+		"Notes":    "line 1\nline 2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("keepassxcParseOutput(_) == %v, want %v", got, want)
+	}
+}
+
+func TestKeepassxcTemplateFuncs(t *testing.T) {
+	// The command reads the database password from its standard input.
+	checkPassword := `read password
+if [ "$password" != "hunter2" ]; then
+	echo "Error while reading the database: Invalid credentials" >&2
+	exit 1
+fi
+`
+	command, cleanup := newFakeSecretCmd(t, "keepassxc-cli", map[string]string{
+		"show --show-protected --quiet /home/user/secrets.kdbx example.com":       checkPassword + `printf 'Title: example.com\nUserName: user\nPassword: secret\n'`,
+		"show --attributes host-name --quiet /home/user/secrets.kdbx example.com": checkPassword + "echo example.com",
+	})
+	defer cleanup()
+	c := &Config{
+		Keepassxc: keepassxcCmdConfig{
+			Command:  command,
+			Database: "/home/user/secrets.kdbx",
+			password: []byte("hunter2"),
+		},
+	}
+	secretCache = make(map[string][]byte)
+	defer func() {
+		secretCache = make(map[string][]byte)
+	}()
+
+	if got, want := c.keepassxcFunc("example.com")["Password"], "secret"; got != want {
+		t.Errorf("c.keepassxcFunc(%q).Password == %q, want %q", "example.com", got, want)
+	}
+	if got, want := c.keepassxcAttributeFunc("example.com", "host-name"), "example.com"; got != want {
+		t.Errorf("c.keepassxcAttributeFunc(%q, %q) == %q, want %q", "example.com", "host-name", got, want)
+	}
+	c.Keepassxc.password = []byte("wrong")
+	secretCache = make(map[string][]byte)
+	if _, err := c.keepassxcOutput("example.com", "show", "--show-protected"); err == nil {
+		t.Errorf("c.keepassxcOutput(%q, %q, %q) == _, <nil>, want _, !<nil>", "example.com", "show", "--show-protected")
+	}
+}
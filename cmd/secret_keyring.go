@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/spf13/cobra"
 	"github.com/twpayne/chezmoi/lib/chezmoi"
@@ -27,6 +28,9 @@ type keyringKey struct {
 
 var keyringCache = make(map[keyringKey]string)
 
+// keyringCacheMu guards keyringCache.
+var keyringCacheMu sync.Mutex
+
 func init() {
 	secretCmd.AddCommand(keyringCmd)
 
@@ -38,7 +42,7 @@ func init() {
 	persistentFlags.StringVar(&config.keyring.user, "user", "", "user")
 	keyringCmd.MarkPersistentFlagRequired("user")
 
-	config.addTemplateFunc("keyring", config.keyringFunc)
+	config.addSecretTemplateFunc("keyring", config.keyringFunc)
 }
 
 func (*Config) keyringFunc(service, user string) string {
@@ -46,6 +50,8 @@ func (*Config) keyringFunc(service, user string) string {
 		service: service,
 		user:    user,
 	}
+	keyringCacheMu.Lock()
+	defer keyringCacheMu.Unlock()
 	if password, ok := keyringCache[key]; ok {
 		return password
 	}
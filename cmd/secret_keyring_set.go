@@ -1,8 +1,10 @@
 package cmd
 
 import (
-	"fmt"
+	"bufio"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 	vfs "github.com/twpayne/go-vfs"
@@ -24,15 +26,34 @@ func init() {
 	persistentFlags.StringVar(&config.keyring.password, "password", "", "password")
 }
 
+// runKeyringSetCmd sets a password in the keyring. If --password is not given
+// then the password is prompted for or, if stdin is not a terminal, read from
+// the first line of stdin.
 func (c *Config) runKeyringSetCmd(fs vfs.FS, args []string) error {
 	passwordString := c.keyring.password
 	if passwordString == "" {
-		fmt.Print("Password: ")
-		password, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-		if err != nil {
-			return err
+		if terminal.IsTerminal(int(os.Stdin.Fd())) {
+			password, err := readPassword("Password: ")
+			if err != nil {
+				return err
+			}
+			passwordString = string(password)
+		} else {
+			var err error
+			passwordString, err = readFirstLine(os.Stdin)
+			if err != nil {
+				return err
+			}
 		}
-		passwordString = string(password)
 	}
 	return keyring.Set(c.keyring.service, c.keyring.user, passwordString)
 }
+
+// readFirstLine returns the first line of r without its line ending.
+func readFirstLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
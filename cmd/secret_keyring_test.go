@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadFirstLine(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want string
+	}{
+		{s: "", want: ""},
+		{s: "secret", want: "secret"},
+		{s: "secret\n", want: "secret"},
+		{s: "secret\r\n", want: "secret"},
+		{s: "secret\nignored\n", want: "secret"},
+	} {
+		if got, err := readFirstLine(strings.NewReader(tc.s)); err != nil || got != tc.want {
+			t.Errorf("readFirstLine(%q) == %q, %v, want %q, <nil>", tc.s, got, err, tc.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os/exec"
@@ -38,11 +39,10 @@ type lastpassCmdConfig struct {
 	versionCheckOnce sync.Once
 }
 
-var lastPassCache = make(map[string]interface{})
-
 func init() {
 	config.Lastpass.Lpass = "lpass"
-	config.addTemplateFunc("lastpass", config.lastpassFunc)
+	config.addSecretTemplateFunc("lastpass", config.lastpassFunc)
+	config.addSecretTemplateFunc("lastpassRaw", config.lastpassRawFunc)
 
 	secretCmd.AddCommand(lastpassCmd)
 }
@@ -56,7 +56,9 @@ func (c *Config) lastpassOutput(args ...string) ([]byte, error) {
 	if c.Verbose {
 		fmt.Printf("%s %s\n", name, strings.Join(args, " "))
 	}
-	output, err := exec.Command(name, args...).CombinedOutput()
+	output, err := c.runSecretCmd(func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, name, args...)
+	}, (*exec.Cmd).CombinedOutput)
 	if err != nil {
 		return nil, fmt.Errorf("lastpass: %s %s: %v\n%s", name, strings.Join(args, " "), err, output)
 	}
@@ -64,31 +66,52 @@ func (c *Config) lastpassOutput(args ...string) ([]byte, error) {
 }
 
 func (c *Config) lastpassFunc(id string) interface{} {
-	c.Lastpass.versionCheckOnce.Do(func() {
-		if err := c.lastpassVersionCheck(); err != nil {
-			chezmoi.ReturnTemplateFuncError(err)
-		}
-	})
-	if data, ok := lastPassCache[id]; ok {
-		return data
-	}
-	output, err := c.lastpassOutput("show", "--json", id)
+	data, err := c.lastpassData(id)
 	if err != nil {
 		chezmoi.ReturnTemplateFuncError(err)
 	}
-	var data []map[string]interface{}
-	if err := json.Unmarshal(output, &data); err != nil {
-		chezmoi.ReturnTemplateFuncError(fmt.Errorf("lastpass: parse error: %v\n%q", err, output))
-	}
 	for _, d := range data {
 		if note, ok := d["note"].(string); ok {
 			d["note"] = lastpassParseNote(note)
 		}
 	}
-	lastPassCache[id] = data
 	return data
 }
 
+// lastpassRawFunc returns the data for id, like lastpassFunc, but without
+// parsing notes, so notes that are not structured are available as text.
+func (c *Config) lastpassRawFunc(id string) interface{} {
+	data, err := c.lastpassData(id)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	return data
+}
+
+// lastpassData returns the data from lpass show --json for id. The output is
+// cached, but the data is not, so callers may modify it.
+func (c *Config) lastpassData(id string) ([]map[string]interface{}, error) {
+	args := []string{"show", "--json", id}
+	output, err := c.cachedSecretOutput(append([]string{c.Lastpass.Lpass}, args...), func() ([]byte, error) {
+		var err error
+		c.Lastpass.versionCheckOnce.Do(func() {
+			err = c.lastpassVersionCheck()
+		})
+		if err != nil {
+			return nil, err
+		}
+		return c.lastpassOutput(args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var data []map[string]interface{}
+	if err := json.Unmarshal(output, &data); err != nil {
+		return nil, fmt.Errorf("lastpass: parse error: %v\n%q", err, output)
+	}
+	return data, nil
+}
+
 func (c *Config) lastpassVersionCheck() error {
 	output, err := c.lastpassOutput(lastpassVersionArgs...)
 	if err != nil {
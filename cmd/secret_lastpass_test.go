@@ -45,3 +45,37 @@ func Test_lastpassParseNote(t *testing.T) {
 		}
 	}
 }
+
+func TestLastpassTemplateFuncs(t *testing.T) {
+	lpass, cleanup := newFakeSecretCmd(t, "lpass", map[string]string{
+		"--version":               `echo "LastPass CLI v1.3.3"`,
+		"show --json example.com": `printf '%s\n' '[{"name":"example.com","username":"user","password":"secret","note":"Hostname:example.com\nfree text"}]'`,
+	})
+	defer cleanup()
+	c := &Config{
+		Lastpass: lastpassCmdConfig{
+			Lpass: lpass,
+		},
+	}
+	secretCache = make(map[string][]byte)
+	defer func() {
+		secretCache = make(map[string][]byte)
+	}()
+
+	entry := c.lastpassFunc("example.com").([]map[string]interface{})[0]
+	for key, want := range map[string]interface{}{
+		"username": "user",
+		"password": "secret",
+		"note": map[string]string{
+			"hostname": "example.com\nfree text\n",
+		},
+	} {
+		if diff, equal := messagediff.PrettyDiff(want, entry[key]); !equal {
+			t.Errorf("c.lastpassFunc(%q)[0].%s diff:\n%s", "example.com", key, diff)
+		}
+	}
+	rawEntry := c.lastpassRawFunc("example.com").([]map[string]interface{})[0]
+	if got, want := rawEntry["note"], "Hostname:example.com\nfree text"; got != want {
+		t.Errorf("c.lastpassRawFunc(%q)[0].note == %q, want %q", "example.com", got, want)
+	}
+}
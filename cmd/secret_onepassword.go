@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
 
+	"github.com/coreos/go-semver/semver"
 	"github.com/spf13/cobra"
 	"github.com/twpayne/chezmoi/lib/chezmoi"
 	vfs "github.com/twpayne/go-vfs"
@@ -17,15 +21,33 @@ var onepasswordCmd = &cobra.Command{
 	RunE:  makeRunE(config.runOnepasswordCmd),
 }
 
+// chezmoi uses op item get --format json, which was added in version 2.0.0
+// of the 1Password CLI.
+var onepasswordMinVersion = semver.Version{Major: 2, Minor: 0, Patch: 0}
+
+// onepasswordSessionEnvPrefix is the prefix of the environment variables that
+// hold 1Password CLI session tokens.
+const onepasswordSessionEnvPrefix = "OP_SESSION_"
+
 type onepasswordCmdConfig struct {
 	Op string
+	// Prompt is whether to sign in with op signin, prompting for a password,
+	// if there is no session in the environment.
+	Prompt bool
+	// PersistSession is whether to keep the session token in the keyring so
+	// that later commands do not need to sign in again.
+	PersistSession bool
+	signinOnce     sync.Once
+	sessionToken   string
+	signinErr      error
 }
 
-var onepasswordCache = make(map[string]interface{})
-
 func init() {
 	config.Onepassword.Op = "op"
-	config.addTemplateFunc("onepassword", config.onepasswordFunc)
+	config.Onepassword.Prompt = true
+	config.addSecretTemplateFunc("onepassword", config.onepasswordFunc)
+	config.addSecretTemplateFunc("onepasswordItemFields", config.onepasswordItemFieldsFunc)
+	config.addSecretTemplateFunc("onepasswordRead", config.onepasswordReadFunc)
 
 	secretCmd.AddCommand(onepasswordCmd)
 }
@@ -34,23 +56,181 @@ func (c *Config) runOnepasswordCmd(fs vfs.FS, args []string) error {
 	return c.exec(append([]string{c.Onepassword.Op}, args...))
 }
 
-func (c *Config) onepasswordFunc(item string) interface{} {
-	if data, ok := onepasswordCache[item]; ok {
-		return data
+// onepasswordFunc returns the item with the given id, and optionally vault and
+// account, from op item get.
+func (c *Config) onepasswordFunc(args ...string) interface{} {
+	data, err := c.onepasswordItem("onepassword", args)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
 	}
-	name := c.Onepassword.Op
-	args := []string{"get", "item", item}
-	if c.Verbose {
-		fmt.Printf("%s %s\n", name, strings.Join(args, " "))
+	return data
+}
+
+// onepasswordItemFieldsFunc returns the fields of the item with the given id,
+// and optionally vault and account, keyed by label.
+func (c *Config) onepasswordItemFieldsFunc(args ...string) interface{} {
+	data, err := c.onepasswordItem("onepasswordItemFields", args)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
 	}
-	output, err := exec.Command(name, args...).CombinedOutput()
+	fields, err := onepasswordItemFields(data)
 	if err != nil {
-		chezmoi.ReturnTemplateFuncError(fmt.Errorf("onepassword: %s %s: %v\n%s", name, strings.Join(args, " "), err, output))
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("onepasswordItemFields: %s: %v", strings.Join(args, " "), err))
+	}
+	return fields
+}
+
+// onepasswordReadFunc returns the secret referenced by url, for example
+// op://vault/item/field, and optionally account, from op read.
+func (c *Config) onepasswordReadFunc(url string, args ...string) string {
+	if len(args) > 1 {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("onepasswordRead: expected 1 or 2 arguments, got %d", len(args)+1))
+	}
+	opArgs := []string{"read", "--no-newline", url}
+	if len(args) == 1 {
+		opArgs = append(opArgs, "--account", args[0])
+	}
+	output, err := c.onepasswordOutput("onepasswordRead", opArgs)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	return string(output)
+}
+
+// onepasswordItem returns the parsed output of op item get for args, which
+// are an item id and optionally a vault and an account.
+func (c *Config) onepasswordItem(funcName string, args []string) (interface{}, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, fmt.Errorf("%s: expected 1, 2, or 3 arguments, got %d", funcName, len(args))
+	}
+	opArgs := []string{"item", "get", args[0], "--format", "json"}
+	if len(args) > 1 && args[1] != "" {
+		opArgs = append(opArgs, "--vault", args[1])
+	}
+	if len(args) > 2 && args[2] != "" {
+		opArgs = append(opArgs, "--account", args[2])
+	}
+	output, err := c.onepasswordOutput(funcName, opArgs)
+	if err != nil {
+		return nil, err
 	}
 	var data interface{}
 	if err := json.Unmarshal(output, &data); err != nil {
-		chezmoi.ReturnTemplateFuncError(fmt.Errorf("onepassword: %s %s: %v\n%s", name, strings.Join(args, " "), err, output))
+		return nil, fmt.Errorf("%s: %s %s: %v\n%s", funcName, c.Onepassword.Op, strings.Join(opArgs, " "), err, output)
 	}
-	onepasswordCache[item] = data
-	return data
+	return data, nil
+}
+
+// onepasswordOutput returns the output of op with args, signing in first if
+// needed. Outputs are cached.
+func (c *Config) onepasswordOutput(funcName string, args []string) ([]byte, error) {
+	name := c.Onepassword.Op
+	return c.cachedSecretOutput(append([]string{name}, args...), func() ([]byte, error) {
+		sessionArgs, err := c.onepasswordSessionArgs(os.Environ())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", funcName, err)
+		}
+		args := append(args, sessionArgs...)
+		if c.Verbose {
+			fmt.Printf("%s %s\n", name, strings.Join(args, " "))
+		}
+		output, err := c.runSecretCmd(func(ctx context.Context) *exec.Cmd {
+			return exec.CommandContext(ctx, name, args...)
+		}, (*exec.Cmd).Output)
+		if err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				output = exitErr.Stderr
+			}
+			return nil, fmt.Errorf("%s: %s %s: %v\n%s", funcName, name, strings.Join(args, " "), err, output)
+		}
+		return output, nil
+	})
+}
+
+// onepasswordSessionArgs returns the arguments that pass a session token to
+// op. If environ already contains a session, or signing in is disabled, then
+// none are needed. Otherwise op signin is run once to get a token, which may
+// prompt for a password.
+func (c *Config) onepasswordSessionArgs(environ []string) ([]string, error) {
+	if !c.Onepassword.Prompt || onepasswordHasSession(environ) {
+		return nil, nil
+	}
+	c.Onepassword.signinOnce.Do(func() {
+		c.Onepassword.sessionToken, c.Onepassword.signinErr = c.onepasswordSignin()
+	})
+	if c.Onepassword.signinErr != nil {
+		return nil, c.Onepassword.signinErr
+	}
+	// op signin prints nothing if the desktop app manages the session.
+	if c.Onepassword.sessionToken == "" {
+		return nil, nil
+	}
+	return []string{"--session", c.Onepassword.sessionToken}, nil
+}
+
+// onepasswordSignin returns a session token from op signin, reusing a
+// persisted session if it is still valid.
+func (c *Config) onepasswordSignin() (string, error) {
+	if c.Onepassword.PersistSession {
+		if session := loadSession("onepassword"); session != "" {
+			if err := exec.Command(c.Onepassword.Op, "whoami", "--session", session).Run(); err == nil {
+				return session, nil
+			}
+		}
+	}
+	cmd := exec.Command(c.Onepassword.Op, "signin", "--raw")
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s signin --raw: %v", c.Onepassword.Op, err)
+	}
+	session := strings.TrimSpace(string(output))
+	if c.Onepassword.PersistSession && session != "" {
+		if err := saveSession("onepassword", session); err != nil {
+			return "", fmt.Errorf("keyring: %v", err)
+		}
+	}
+	return session, nil
+}
+
+// onepasswordHasSession returns whether environ contains a 1Password CLI
+// session token.
+func onepasswordHasSession(environ []string) bool {
+	for _, s := range environ {
+		if strings.HasPrefix(s, onepasswordSessionEnvPrefix) && !strings.HasSuffix(s, "=") {
+			return true
+		}
+	}
+	return false
+}
+
+// onepasswordItemFields returns the fields of the 1Password item data, keyed
+// by label.
+func onepasswordItemFields(data interface{}) (map[string]interface{}, error) {
+	item, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not an item")
+	}
+	result := make(map[string]interface{})
+	rawFields, ok := item["fields"]
+	if !ok || rawFields == nil {
+		return result, nil
+	}
+	fields, ok := rawFields.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid fields")
+	}
+	for _, rawField := range fields {
+		field, ok := rawField.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid field")
+		}
+		label, ok := field["label"].(string)
+		if !ok || label == "" {
+			continue
+		}
+		result[label] = field
+	}
+	return result, nil
 }
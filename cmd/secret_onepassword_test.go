@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func Test_onepasswordHasSession(t *testing.T) {
+	for _, tc := range []struct {
+		environ []string
+		want    bool
+	}{
+		{
+			environ: []string{"HOME=/home/user"},
+			want:    false,
+		},
+		{
+			environ: []string{"HOME=/home/user", "OP_SESSION_my=token"},
+			want:    true,
+		},
+		{
+			environ: []string{"OP_SESSION_my="},
+			want:    false,
+		},
+	} {
+		if got := onepasswordHasSession(tc.environ); got != tc.want {
+			t.Errorf("onepasswordHasSession(%v) == %v, want %v", tc.environ, got, tc.want)
+		}
+	}
+}
+
+func Test_onepasswordItemFields(t *testing.T) {
+	var data interface{}
+	item := `{"id":"uuid","title":"example.com","fields":[{"id":"username","label":"username","value":"user","type":"STRING"},{"id":"password","label":"password","value":"secret","type":"CONCEALED"},{"id":"notesPlain","type":"STRING"}]}`
+	if err := json.Unmarshal([]byte(item), &data); err != nil {
+		t.Fatalf("json.Unmarshal(_, _) == %v, want <nil>", err)
+	}
+	got, err := onepasswordItemFields(data)
+	if err != nil {
+		t.Fatalf("onepasswordItemFields(_) == _, %v, want _, <nil>", err)
+	}
+	want := map[string]interface{}{
+		"username": map[string]interface{}{
+			"id":    "username",
+			"label": "username",
+			"value": "user",
+			"type":  "STRING",
+		},
+		"password": map[string]interface{}{
+			"id":    "password",
+			"label": "password",
+			"value": "secret",
+			"type":  "CONCEALED",
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(want, got); !equal {
+		t.Errorf("onepasswordItemFields(_) diff:\n%s", diff)
+	}
+	if _, err := onepasswordItemFields([]interface{}{}); err == nil {
+		t.Errorf("onepasswordItemFields(_) == _, <nil>, want _, !<nil>")
+	}
+}
+
+func TestOnepasswordTemplateFuncs(t *testing.T) {
+	op, cleanup := newFakeSecretCmd(t, "op", map[string]string{
+		"item get uuid --format json":                `echo '{"id":"uuid"}'`,
+		"item get uuid --format json --vault vault":  `echo '{"id":"uuid","fields":[{"label":"password","value":"secret"}]}'`,
+		"read --no-newline op://vault/item/password": `printf '%s' secret`,
+	})
+	defer cleanup()
+	c := &Config{
+		Onepassword: onepasswordCmdConfig{
+			Op: op,
+		},
+	}
+	secretCache = make(map[string][]byte)
+	defer func() {
+		secretCache = make(map[string][]byte)
+	}()
+
+	if got, want := c.onepasswordFunc("uuid").(map[string]interface{})["id"], "uuid"; got != want {
+		t.Errorf("c.onepasswordFunc(%q).id == %v, want %v", "uuid", got, want)
+	}
+	if got, want := c.onepasswordItemFieldsFunc("uuid", "vault").(map[string]interface{})["password"].(map[string]interface{})["value"], "secret"; got != want {
+		t.Errorf("c.onepasswordItemFieldsFunc(%q, %q).password.value == %v, want %v", "uuid", "vault", got, want)
+	}
+	if got, want := c.onepasswordReadFunc("op://vault/item/password"), "secret"; got != want {
+		t.Errorf("c.onepasswordReadFunc(%q) == %q, want %q", "op://vault/item/password", got, want)
+	}
+}
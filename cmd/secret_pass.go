@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
+	"regexp"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -17,17 +20,22 @@ var passCmd = &cobra.Command{
 	RunE:  makeRunE(config.runSecretPassCmd),
 }
 
+// passFieldRegexp matches the first line of a field in a pass entry, for
+// example "username: user". The colon must be followed by whitespace or the
+// end of the line so that lines containing URLs are not fields.
+var passFieldRegexp = regexp.MustCompile(`\A([^\s:][^:]*):(?:\s+(.*))?\z`)
+
 type passCmdConfig struct {
 	Pass string
 }
 
-var passCache = make(map[string]string)
-
 func init() {
 	secretCmd.AddCommand(passCmd)
 
 	config.Pass.Pass = "pass"
-	config.addTemplateFunc("pass", config.passFunc)
+	config.addSecretTemplateFunc("pass", config.passFunc)
+	config.addSecretTemplateFunc("passFields", config.passFieldsFunc)
+	config.addSecretTemplateFunc("passOTP", config.passOTPFunc)
 }
 
 func (c *Config) runSecretPassCmd(fs vfs.FS, args []string) error {
@@ -35,24 +43,88 @@ func (c *Config) runSecretPassCmd(fs vfs.FS, args []string) error {
 }
 
 func (c *Config) passFunc(id string) string {
-	if s, ok := passCache[id]; ok {
-		return s
+	output, err := c.passOutput(id)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
+	}
+	if index := bytes.IndexByte(output, '\n'); index != -1 {
+		return string(output[:index])
+	}
+	return string(output)
+}
+
+func (c *Config) passFieldsFunc(id string) map[string]string {
+	output, err := c.passOutput(id)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(err)
 	}
+	fields, err := passParseFields(output)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("passFields: %s: %v", id, err))
+	}
+	return fields
+}
+
+// passOTPFunc returns the current one-time password for id from pass otp,
+// which requires the pass-otp extension. One-time passwords are never cached.
+func (c *Config) passOTPFunc(id string) string {
 	name := c.Pass.Pass
-	args := []string{"show", id}
+	args := []string{"otp", id}
 	if c.Verbose {
 		fmt.Printf("%s %s\n", name, strings.Join(args, " "))
 	}
-	output, err := exec.Command(name, args...).Output()
+	output, err := c.runSecretCmd(func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, name, args...)
+	}, (*exec.Cmd).Output)
 	if err != nil {
-		chezmoi.ReturnTemplateFuncError(fmt.Errorf("pass: %s %s: %v", name, strings.Join(args, " "), err))
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("passOTP: %s %s: %v", name, strings.Join(args, " "), err))
 	}
-	var password string
-	if index := bytes.IndexByte(output, '\n'); index != -1 {
-		password = string(output[:index])
-	} else {
-		password = string(output)
+	return strings.TrimSpace(string(output))
+}
+
+// passOutput returns the output of pass show id. Outputs are cached.
+func (c *Config) passOutput(id string) ([]byte, error) {
+	name := c.Pass.Pass
+	args := []string{"show", id}
+	return c.cachedSecretOutput(append([]string{name}, args...), func() ([]byte, error) {
+		if c.Verbose {
+			fmt.Printf("%s %s\n", name, strings.Join(args, " "))
+		}
+		output, err := c.runSecretCmd(func(ctx context.Context) *exec.Cmd {
+			return exec.CommandContext(ctx, name, args...)
+		}, (*exec.Cmd).Output)
+		if err != nil {
+			return nil, fmt.Errorf("pass: %s %s: %v", name, strings.Join(args, " "), err)
+		}
+		return output, nil
+	})
+}
+
+// passParseFields parses the fields in the lines after the password in the
+// pass entry output. Each field starts with a line like "key: value", and
+// following lines that are not fields are appended to its value, so values
+// can span multiple lines. Trailing newlines are removed from values.
+func passParseFields(output []byte) (map[string]string, error) {
+	result := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(output))
+	s.Scan() // Skip the password.
+	key := ""
+	for s.Scan() {
+		if m := passFieldRegexp.FindStringSubmatch(s.Text()); m != nil {
+			key = strings.TrimSpace(m[1])
+			result[key] = m[2]
+		} else if key != "" {
+			if result[key] != "" {
+				result[key] += "\n"
+			}
+			result[key] += s.Text()
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for key, value := range result {
+		result[key] = strings.TrimRight(value, "\n")
 	}
-	passCache[id] = password
-	return passCache[id]
+	return result, nil
 }
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func Test_passParseFields(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		output string
+		want   map[string]string
+	}{
+		{
+			name:   "password_only",
+			output: "secret\n",
+			want:   map[string]string{},
+		},
+		{
+			name:   "fields",
+			output: "secret\nusername: user\nurl: https://example.com/login\n",
+			want: map[string]string{
+				"username": "user",
+				"url":      "https://example.com/login",
+			},
+		},
+		{
+			name:   "multi_line",
+			output: "secret\nnotes:\n  first line\n  second line\n\nuser name: user\n",
+			want: map[string]string{
+				"notes":     "  first line\n  second line",
+				"user name": "user",
+			},
+		},
+		{
+			name:   "ignore_leading_lines",
+			output: "secret\nhttps://example.com\nusername: user",
+			want: map[string]string{
+				"username": "user",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := passParseFields([]byte(tc.output))
+			if err != nil {
+				t.Fatalf("passParseFields(_) == _, %v, want _, <nil>", err)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.want, got); !equal {
+				t.Errorf("passParseFields(_) diff:\n%s", diff)
+			}
+		})
+	}
+}
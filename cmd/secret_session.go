@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	keyring "github.com/zalando/go-keyring"
+)
+
+// sessionKeyringService is the keyring service under which password manager
+// session tokens are persisted.
+const sessionKeyringService = "chezmoi"
+
+// loadSession returns the session token for the password manager name that
+// was persisted in the keyring, or the empty string if there is none.
+func loadSession(name string) string {
+	session, err := keyring.Get(sessionKeyringService, name+"-session")
+	if err != nil {
+		return ""
+	}
+	return session
+}
+
+// saveSession persists the session token for the password manager name in
+// the keyring so that it can be reused by later commands.
+func saveSession(name, session string) error {
+	return keyring.Set(sessionKeyringService, name+"-session", session)
+}
@@ -1,8 +1,10 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 
@@ -19,13 +21,15 @@ var vaultCmd = &cobra.Command{
 
 type vaultCmdConfig struct {
 	Vault string
+	// Address and Token, if set, override the VAULT_ADDR and VAULT_TOKEN
+	// environment variables.
+	Address string
+	Token   string
 }
 
-var vaultCache = make(map[string]interface{})
-
 func init() {
 	config.Vault.Vault = "vault"
-	config.addTemplateFunc("vault", config.vaultFunc)
+	config.addSecretTemplateFunc("vault", config.vaultFunc)
 
 	secretCmd.AddCommand(vaultCmd)
 }
@@ -35,22 +39,40 @@ func (c *Config) runVaultCmd(fs vfs.FS, args []string) error {
 }
 
 func (c *Config) vaultFunc(key string) interface{} {
-	if data, ok := vaultCache[key]; ok {
-		return data
-	}
 	name := c.Vault.Vault
 	args := []string{"kv", "get", "-format=json", key}
-	if c.Verbose {
-		fmt.Printf("%s %s\n", name, strings.Join(args, " "))
-	}
-	output, err := exec.Command(name, args...).CombinedOutput()
+	output, err := c.cachedSecretOutput(append([]string{name, c.Vault.Address}, args...), func() ([]byte, error) {
+		if c.Verbose {
+			fmt.Printf("%s %s\n", name, strings.Join(args, " "))
+		}
+		output, err := c.runSecretCmd(func(ctx context.Context) *exec.Cmd {
+			cmd := exec.CommandContext(ctx, name, args...)
+			cmd.Env = c.vaultEnv(os.Environ())
+			return cmd
+		}, (*exec.Cmd).CombinedOutput)
+		if err != nil {
+			return nil, fmt.Errorf("vault: %s %s: %v\n%s", name, strings.Join(args, " "), err, output)
+		}
+		return output, nil
+	})
 	if err != nil {
-		chezmoi.ReturnTemplateFuncError(fmt.Errorf("vault: %s %s: %v\n%s", name, strings.Join(args, " "), err, output))
+		chezmoi.ReturnTemplateFuncError(err)
 	}
 	var data interface{}
 	if err := json.Unmarshal(output, &data); err != nil {
 		chezmoi.ReturnTemplateFuncError(fmt.Errorf("vault: %s %s: %v\n%s", name, strings.Join(args, " "), err, output))
 	}
-	vaultCache[key] = data
 	return data
 }
+
+// vaultEnv returns environ with the configured address and token, if any.
+func (c *Config) vaultEnv(environ []string) []string {
+	env := append([]string{}, environ...)
+	if c.Vault.Address != "" {
+		env = append(env, "VAULT_ADDR="+c.Vault.Address)
+	}
+	if c.Vault.Token != "" {
+		env = append(env, "VAULT_TOKEN="+c.Vault.Token)
+	}
+	return env
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestVaultTemplateFunc(t *testing.T) {
+	vault, cleanup := newFakeSecretCmd(t, "vault", map[string]string{
+		"kv get -format=json secret/example": `printf '{"data":{"data":{"address":"%s","token":"%s"}}}\n' "$VAULT_ADDR" "$VAULT_TOKEN"`,
+	})
+	defer cleanup()
+	c := &Config{
+		Vault: vaultCmdConfig{
+			Vault:   vault,
+			Address: "https://vault.example.com:8200",
+			Token:   "s.token",
+		},
+	}
+	secretCache = make(map[string][]byte)
+	defer func() {
+		secretCache = make(map[string][]byte)
+	}()
+
+	want := map[string]interface{}{
+		"data": map[string]interface{}{
+			"data": map[string]interface{}{
+				"address": "https://vault.example.com:8200",
+				"token":   "s.token",
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(want, c.vaultFunc("secret/example")); !equal {
+		t.Errorf("c.vaultFunc(%q) diff:\n%s", "secret/example", diff)
+	}
+}
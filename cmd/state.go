@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+type stateCmdConfig struct {
+	format string
+}
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Args:  cobra.NoArgs,
+	Short: "Manipulate the persistent state",
+}
+
+var stateDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Args:  cobra.NoArgs,
+	Short: "Write a dump of the persistent state to stdout",
+	RunE:  makeRunE(config.runStateDumpCmd),
+}
+
+var stateResetCmd = &cobra.Command{
+	Use:   "reset [buckets...]",
+	Short: "Reset the persistent state, or only the given buckets (" + strings.Join(chezmoi.PersistentStateBuckets(), ", ") + ")",
+	RunE:  makeLockedRunE(config.runStateResetCmd),
+}
+
+func init() {
+	rootCmd.AddCommand(stateCmd)
+	stateCmd.AddCommand(stateDumpCmd)
+	stateCmd.AddCommand(stateResetCmd)
+
+	persistentFlags := stateDumpCmd.PersistentFlags()
+	persistentFlags.StringVarP(&config.state.format, "format", "f", "json", "format (JSON, TOML, or YAML)")
+}
+
+func (c *Config) runStateDumpCmd(fs vfs.FS, args []string) error {
+	format, ok := formatMap[strings.ToLower(c.state.format)]
+	if !ok {
+		return fmt.Errorf("%s: unknown format", c.state.format)
+	}
+	persistentState, err := c.getPersistentState(fs)
+	if err != nil {
+		return err
+	}
+	dump, err := chezmoi.DumpPersistentState(persistentState)
+	if err != nil {
+		return err
+	}
+	return format(os.Stdout, dump)
+}
+
+func (c *Config) runStateResetCmd(fs vfs.FS, args []string) error {
+	persistentState, err := c.getPersistentState(fs)
+	if err != nil {
+		return err
+	}
+	return chezmoi.ResetPersistentState(persistentState, args...)
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestStateReset(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".chezmoi": map[string]interface{}{
+				"dot_bashrc": "# bashrc\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	c := &Config{
+		configFile: "/home/user/.config/chezmoi/chezmoi.toml",
+		cacheDir:   "/home/user/.cache/chezmoi",
+		stateDir:   "/home/user/.local/state/chezmoi",
+		SourceDir:  "/home/user/.chezmoi",
+		DestDir:    "/home/user",
+		Umask:      022,
+	}
+	if err := c.runApplyCmd(fs, nil); err != nil {
+		t.Fatalf("c.runApplyCmd(_, nil) == %v, want <nil>", err)
+	}
+	vfst.RunTests(t, fs, "apply",
+		vfst.TestPath("/home/user/.local/state/chezmoi/chezmoistate.boltdb",
+			vfst.TestModeIsRegular,
+		),
+		vfst.TestPath("/home/user/.config/chezmoi/chezmoistate.boltdb",
+			vfst.TestDoesNotExist,
+		),
+	)
+	persistentState, err := c.getPersistentState(fs)
+	if err != nil {
+		t.Fatalf("c.getPersistentState(_) == _, %v, want _, <nil>", err)
+	}
+	if value, _ := persistentState.Get([]byte("entryState"), []byte(".bashrc")); value == nil {
+		t.Fatalf("entry state of .bashrc == <nil>, want !<nil>")
+	}
+	if err := c.runStateResetCmd(fs, []string{"entryState"}); err != nil {
+		t.Fatalf("c.runStateResetCmd(_, _) == %v, want <nil>", err)
+	}
+	if value, _ := persistentState.Get([]byte("entryState"), []byte(".bashrc")); value != nil {
+		t.Errorf("entry state of .bashrc == %q, want <nil>", value)
+	}
+	if err := c.runStateResetCmd(fs, []string{"unknown"}); err == nil {
+		t.Errorf("c.runStateResetCmd(_, [\"unknown\"]) == <nil>, want !<nil>")
+	}
+	if err := c.closePersistentState(); err != nil {
+		t.Fatalf("c.closePersistentState() == %v, want <nil>", err)
+	}
+}
+
+func TestStateImportLegacy(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		// {"scriptOnce":{"count":"{}"}}, with the value encoded in base64.
+		"/home/user/.config/chezmoi/chezmoistate.json": `{"scriptOnce":{"count":"e30="}}`,
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	for _, dryRun := range []bool{true, false} {
+		c := &Config{
+			configFile: "/home/user/.config/chezmoi/chezmoi.toml",
+			stateDir:   "/home/user/.local/state/chezmoi",
+			DryRun:     dryRun,
+		}
+		persistentState, err := c.getPersistentState(fs)
+		if err != nil {
+			t.Fatalf("c.getPersistentState(_) == _, %v, want _, <nil>", err)
+		}
+		if value, _ := persistentState.Get([]byte("scriptOnce"), []byte("count")); string(value) != "{}" {
+			t.Errorf("persistentState.Get(%q, %q) == %q, want %q", "scriptOnce", "count", value, "{}")
+		}
+		if err := c.closePersistentState(); err != nil {
+			t.Fatalf("c.closePersistentState() == %v, want <nil>", err)
+		}
+		wantState := vfst.TestDoesNotExist
+		if !dryRun {
+			wantState = vfst.TestModeIsRegular
+		}
+		vfst.RunTests(t, fs, "",
+			vfst.TestPath("/home/user/.local/state/chezmoi/chezmoistate.boltdb",
+				wantState,
+			),
+		)
+	}
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Args:  cobra.NoArgs,
+	Short: "Show the status of targets that have changed or would be changed by apply",
+	RunE:  makeRunE(config.runStatusCmd),
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
+
+func (c *Config) runStatusCmd(fs vfs.FS, args []string) error {
+	ts, err := c.getTargetState(fs)
+	if err != nil {
+		return err
+	}
+	statuses, err := ts.Status(fs)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		fmt.Printf("%s %s\n", status.Code, status.TargetName)
+	}
+	return nil
+}
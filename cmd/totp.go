@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+)
+
+// A totpKey is a key for generating time-based one-time passwords, as
+// described in RFC 6238.
+type totpKey struct {
+	secret    []byte
+	period    int64
+	digits    int
+	algorithm func() hash.Hash
+}
+
+func init() {
+	config.addSecretTemplateFunc("totp", config.totpFunc)
+}
+
+// totpFunc returns the current time-based one-time password for key, which
+// is either a base32-encoded secret or an otpauth://totp/ URI.
+func (c *Config) totpFunc(key string) string {
+	k, err := parseTOTPKey(key)
+	if err != nil {
+		chezmoi.ReturnTemplateFuncError(fmt.Errorf("totp: %v", err))
+	}
+	return k.generate(time.Now())
+}
+
+// parseTOTPKey parses s, which is either a base32-encoded secret or an
+// otpauth://totp/ URI. Secrets without a URI use the defaults of a 30 second
+// period, six digits, and SHA1.
+func parseTOTPKey(s string) (*totpKey, error) {
+	k := &totpKey{
+		period:    30,
+		digits:    6,
+		algorithm: sha1.New,
+	}
+	secret := s
+	if strings.HasPrefix(s, "otpauth://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if u.Host != "totp" {
+			return nil, fmt.Errorf("%s: unsupported OTP type", u.Host)
+		}
+		query := u.Query()
+		secret = query.Get("secret")
+		if period := query.Get("period"); period != "" {
+			if k.period, err = strconv.ParseInt(period, 10, 64); err != nil || k.period <= 0 {
+				return nil, fmt.Errorf("%s: invalid period", period)
+			}
+		}
+		if digits := query.Get("digits"); digits != "" {
+			if k.digits, err = strconv.Atoi(digits); err != nil || k.digits < 6 || k.digits > 10 {
+				return nil, fmt.Errorf("%s: invalid digits", digits)
+			}
+		}
+		switch algorithm := query.Get("algorithm"); strings.ToUpper(algorithm) {
+		case "", "SHA1":
+		case "SHA256":
+			k.algorithm = sha256.New
+		case "SHA512":
+			k.algorithm = sha512.New
+		default:
+			return nil, fmt.Errorf("%s: unsupported algorithm", algorithm)
+		}
+	}
+	// Secrets are often written in lower case, in groups separated by
+	// spaces, and without padding.
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	var err error
+	k.secret, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %v", err)
+	}
+	if len(k.secret) == 0 {
+		return nil, fmt.Errorf("no secret")
+	}
+	return k, nil
+}
+
+// generate returns the one-time password for k at t.
+func (k *totpKey) generate(t time.Time) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/k.period))
+	mac := hmac.New(k.algorithm, k.secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	code := int64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+	modulus := int64(1)
+	for i := 0; i < k.digits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", k.digits, code%modulus)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func Test_parseTOTPKey(t *testing.T) {
+	for _, tc := range []struct {
+		key     string
+		wantErr bool
+	}{
+		{key: "JBSWY3DPEHPK3PXP"},
+		{key: "jbsw y3dp ehpk 3pxp"},
+		{key: "otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example"},
+		{key: "", wantErr: true},
+		{key: "not base32!", wantErr: true},
+		{key: "otpauth://hotp/Example?secret=JBSWY3DPEHPK3PXP&counter=0", wantErr: true},
+		{key: "otpauth://totp/Example?secret=JBSWY3DPEHPK3PXP&algorithm=MD5", wantErr: true},
+		{key: "otpauth://totp/Example?secret=JBSWY3DPEHPK3PXP&digits=4", wantErr: true},
+		{key: "otpauth://totp/Example?secret=JBSWY3DPEHPK3PXP&period=0", wantErr: true},
+	} {
+		_, err := parseTOTPKey(tc.key)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("parseTOTPKey(%q) == _, %v, want error %v", tc.key, err, tc.wantErr)
+		}
+	}
+}
+
+func TestTOTPKeyGenerate(t *testing.T) {
+	// Test vectors from RFC 6238 Appendix B.
+	sha1Secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	sha256Secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890123456789012"))
+	sha512Secret := base32.StdEncoding.EncodeToString([]byte("1234567890123456789012345678901234567890123456789012345678901234"))
+	for _, tc := range []struct {
+		key  string
+		t    int64
+		want string
+	}{
+		{key: "otpauth://totp/test?digits=8&secret=" + sha1Secret, t: 59, want: "94287082"},
+		{key: "otpauth://totp/test?digits=8&secret=" + sha1Secret, t: 1111111109, want: "07081804"},
+		{key: "otpauth://totp/test?digits=8&secret=" + sha1Secret, t: 1234567890, want: "89005924"},
+		{key: "otpauth://totp/test?digits=8&algorithm=SHA256&secret=" + sha256Secret, t: 59, want: "46119246"},
+		{key: "otpauth://totp/test?digits=8&algorithm=SHA256&secret=" + sha256Secret, t: 1111111109, want: "68084774"},
+		{key: "otpauth://totp/test?digits=8&algorithm=SHA512&secret=" + sha512Secret, t: 59, want: "90693936"},
+		{key: sha1Secret, t: 59, want: "287082"},
+	} {
+		k, err := parseTOTPKey(tc.key)
+		if err != nil {
+			t.Fatalf("parseTOTPKey(%q) == _, %v, want _, <nil>", tc.key, err)
+		}
+		if got := k.generate(time.Unix(tc.t, 0)); got != tc.want {
+			t.Errorf("parseTOTPKey(%q).generate(%d) == %q, want %q", tc.key, tc.t, got, tc.want)
+		}
+	}
+}
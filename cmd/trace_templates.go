@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+var traceTemplatesCmd = &cobra.Command{
+	Use:   "trace-templates [targets...]",
+	Short: "Write the data keys accessed and branches taken by templates to stdout",
+	RunE:  makeRunE(config.runTraceTemplatesCmd),
+}
+
+func init() {
+	rootCmd.AddCommand(traceTemplatesCmd)
+}
+
+func (c *Config) runTraceTemplatesCmd(fs vfs.FS, args []string) error {
+	c.tracer = &chezmoi.TemplateTracer{}
+	ts, err := c.getTargetState(fs)
+	if err != nil {
+		return err
+	}
+	// Execute templates one at a time so that their traces are reported in a
+	// deterministic order.
+	ts.Parallelism = 1
+	// Report the traces of templates that were executed even if others
+	// failed.
+	var evaluateErr error
+	if len(args) == 0 {
+		evaluateErr = ts.Evaluate()
+	} else {
+		entries, err := c.getEntries(ts, args)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := entry.Evaluate(ts.TargetIgnore.Match); err != nil {
+				evaluateErr = err
+				break
+			}
+		}
+	}
+	if err := c.tracer.WriteReport(os.Stdout); err != nil {
+		return err
+	}
+	return evaluateErr
+}
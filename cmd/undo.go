@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Args:  cobra.NoArgs,
+	Short: "Undo the changes made by the last apply",
+	RunE:  makeLockedRunE(config.runUndoCmd),
+}
+
+func init() {
+	rootCmd.AddCommand(undoCmd)
+}
+
+func (c *Config) runUndoCmd(fs vfs.FS, args []string) error {
+	backupRoot, _ := c.getBackupRoot()
+	backupDir, err := chezmoi.LastUndoDir(fs, backupRoot)
+	if err != nil {
+		return err
+	}
+	if backupDir == "" {
+		return errors.New("nothing to undo")
+	}
+	return chezmoi.Rollback(fs, backupDir, c.getDefaultMutator(fs))
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestUndo(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc": "# local edits\n",
+			".chezmoi": map[string]interface{}{
+				"dot_bashrc":       "# bashrc\n",
+				"dot_dir/file":     "file",
+				"symlink_dot_link": ".bashrc",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	c := &Config{
+		configFile: "/home/user/.config/chezmoi/chezmoi.toml",
+		cacheDir:   "/home/user/.cache/chezmoi",
+		SourceDir:  "/home/user/.chezmoi",
+		DestDir:    "/home/user",
+		Umask:      022,
+	}
+	if err := c.runApplyCmd(fs, nil); err != nil {
+		t.Fatalf("c.runApplyCmd(_, nil) == %v, want <nil>", err)
+	}
+	vfst.RunTests(t, fs, "apply",
+		vfst.TestPath("/home/user/.bashrc",
+			vfst.TestContentsString("# bashrc\n"),
+		),
+		vfst.TestPath("/home/user/.dir/file",
+			vfst.TestContentsString("file"),
+		),
+	)
+	if err := c.runUndoCmd(fs, nil); err != nil {
+		t.Fatalf("c.runUndoCmd(_, nil) == %v, want <nil>", err)
+	}
+	vfst.RunTests(t, fs, "undo",
+		vfst.TestPath("/home/user/.bashrc",
+			vfst.TestModeIsRegular,
+			vfst.TestContentsString("# local edits\n"),
+		),
+		vfst.TestPath("/home/user/.dir",
+			vfst.TestDoesNotExist,
+		),
+		vfst.TestPath("/home/user/.link",
+			vfst.TestDoesNotExist,
+		),
+	)
+	if err := c.runUndoCmd(fs, nil); err == nil {
+		t.Errorf("c.runUndoCmd(_, nil) == <nil>, want !<nil>")
+	}
+}
@@ -2,9 +2,7 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/spf13/cobra"
 	vfs "github.com/twpayne/go-vfs"
@@ -26,22 +24,12 @@ func (c *Config) runUnmanagedCmd(fs vfs.FS, args []string) error {
 	if err != nil {
 		return err
 	}
-	return vfs.Walk(fs, c.DestDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if path == c.DestDir {
-			return nil
-		}
-		entry, _ := ts.Get(path)
-		managed := entry != nil
-		ignored := ts.TargetIgnore.Match(strings.TrimPrefix(path, c.DestDir+"/"))
-		if !managed && !ignored {
-			fmt.Println(path)
-		}
-		if info.IsDir() && (!managed || ignored) {
-			return filepath.SkipDir
-		}
-		return nil
-	})
+	targetNames, err := ts.Unmanaged(fs)
+	if err != nil {
+		return err
+	}
+	for _, targetName := range targetNames {
+		fmt.Println(filepath.Join(c.DestDir, targetName))
+	}
+	return nil
 }
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/twpayne/chezmoi/lib/chezmoi"
 	vfs "github.com/twpayne/go-vfs"
 )
 
@@ -16,7 +17,7 @@ var updateCmd = &cobra.Command{
 	Use:   "update",
 	Args:  cobra.NoArgs,
 	Short: "Pull changes from the source VCS and apply any changes",
-	RunE:  makeRunE(config.runUpdateCmd),
+	RunE:  makeLockedRunE(config.runUpdateCmd),
 }
 
 func init() {
@@ -48,16 +49,55 @@ func (c *Config) runUpdateCmd(fs vfs.FS, args []string) error {
 		return fmt.Errorf("%s: pull not supported", c.SourceVCS.Command)
 	}
 
+	oldTS, err := c.getTargetState(fs)
+	if err != nil {
+		return err
+	}
+
 	if err := c.run(c.SourceDir, c.SourceVCS.Command, pullArgs...); err != nil {
 		return err
 	}
 
+	// In dry run mode nothing is pulled, so there are no changes to show.
+	if !c.DryRun {
+		newTS, err := c.getTargetState(fs)
+		if err != nil {
+			return err
+		}
+		d, err := chezmoi.DiffTargetStates(oldTS, newTS)
+		if err != nil {
+			return err
+		}
+		printTargetStateDiff(d)
+	}
+
 	if c.update.apply {
 		mutator := c.getDefaultMutator(fs)
-		if err := c.applyArgs(fs, nil, mutator); err != nil {
+		if err := c.applyArgs(fs, nil, mutator, true); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+func printTargetStateDiff(d *chezmoi.TargetStateDiff) {
+	switch d.Len() {
+	case 0:
+		fmt.Println("no targets changed")
+		return
+	case 1:
+		fmt.Println("1 target changed:")
+	default:
+		fmt.Printf("%d targets changed:\n", d.Len())
+	}
+	for _, change := range d.Added {
+		fmt.Printf("  added    %s\n", change.TargetName)
+	}
+	for _, change := range d.Removed {
+		fmt.Printf("  removed  %s\n", change.TargetName)
+	}
+	for _, change := range d.Modified {
+		fmt.Printf("  modified %s\n", change.TargetName)
+	}
+}
@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/twpayne/chezmoi/lib/chezmoi"
@@ -14,13 +17,39 @@ var verifyCmd = &cobra.Command{
 	RunE:  makeRunE(config.runVerifyCmd),
 }
 
+type verifyCmdConfig struct {
+	format string
+	sample float64
+	seed   int64
+	recent time.Duration
+}
+
 func init() {
 	rootCmd.AddCommand(verifyCmd)
+
+	persistentFlags := verifyCmd.PersistentFlags()
+	persistentFlags.StringVarP(&config.verify.format, "format", "f", "", "write a report of differences in this format (JSON, TOML, or YAML)")
+	persistentFlags.Float64Var(&config.verify.sample, "sample", 0, "only verify a random sample of this percentage of targets")
+	persistentFlags.Int64Var(&config.verify.seed, "seed", 0, "seed for the random sample")
+	persistentFlags.DurationVar(&config.verify.recent, "recent", 24*time.Hour, "always verify targets that chezmoi wrote within this duration when sampling")
 }
 
 func (c *Config) runVerifyCmd(fs vfs.FS, args []string) error {
+	c.readOnlyState = true
+	if c.verify.sample > 0 {
+		if len(args) != 0 {
+			return fmt.Errorf("--sample cannot be used with targets")
+		}
+		return c.runVerifySample(fs)
+	}
+	if c.verify.format != "" {
+		if len(args) != 0 {
+			return fmt.Errorf("--format cannot be used with targets")
+		}
+		return c.runVerifyReport(fs)
+	}
 	mutator := chezmoi.NewAnyMutator(chezmoi.NullMutator)
-	if err := c.applyArgs(fs, args, mutator); err != nil {
+	if err := c.applyArgs(fs, args, mutator, false); err != nil {
 		return err
 	}
 	if mutator.Mutated() {
@@ -28,3 +57,58 @@ func (c *Config) runVerifyCmd(fs vfs.FS, args []string) error {
 	}
 	return nil
 }
+
+func (c *Config) runVerifyReport(fs vfs.FS) error {
+	format, ok := formatMap[strings.ToLower(c.verify.format)]
+	if !ok {
+		return fmt.Errorf("%s: unknown format", c.verify.format)
+	}
+	ts, err := c.getTargetState(fs)
+	if err != nil {
+		return err
+	}
+	report, err := ts.Verify(fs)
+	if err != nil {
+		return err
+	}
+	if err := format(os.Stdout, report); err != nil {
+		return err
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func (c *Config) runVerifySample(fs vfs.FS) error {
+	result, err := c.verifySample(fs)
+	if err != nil {
+		return err
+	}
+	for _, targetName := range result.Differences {
+		fmt.Println(targetName)
+	}
+	fmt.Printf("verified a sample of %d of %d targets (%g%%, seed %d)\n", result.SampleSize, result.Total, result.Percent, result.Seed)
+	if len(result.Differences) != 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// verifySample verifies a sample of the targets. Targets whose last written
+// states, in the persistent state, were modified within c.verify.recent are
+// always verified.
+func (c *Config) verifySample(fs vfs.FS) (*chezmoi.SampledVerifyResult, error) {
+	ts, err := c.getTargetState(fs)
+	if err != nil {
+		return nil, err
+	}
+	options := chezmoi.VerifySampleOptions{
+		Percent: c.verify.sample,
+		Seed:    c.verify.seed,
+	}
+	if c.verify.recent > 0 {
+		options.Priority = ts.ModifiedSince(time.Now().Add(-c.verify.recent))
+	}
+	return ts.VerifySample(fs, options)
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestVerifySampleRecent(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc":  "# bashrc\n",
+			".inputrc": "# inputrc\n",
+			".vimrc":   "# edited\n",
+			".chezmoi": map[string]interface{}{
+				"dot_bashrc":  "# bashrc\n",
+				"dot_inputrc": "# inputrc\n",
+				"dot_vimrc":   "# vimrc\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	c := &Config{
+		configFile: "/home/user/.config/chezmoi/chezmoi.toml",
+		stateDir:   "/home/user/.local/state/chezmoi",
+		SourceDir:  "/home/user/.chezmoi",
+		DestDir:    "/home/user",
+		Umask:      022,
+		verify: verifyCmdConfig{
+			sample: 1,
+			seed:   1,
+		},
+	}
+	defer c.closePersistentState()
+	persistentState, err := c.getPersistentState(fs)
+	if err != nil {
+		t.Fatalf("c.getPersistentState(_) == _, %v, want _, <nil>", err)
+	}
+	// .vimrc was written an hour ago and .bashrc two days ago.
+	for targetName, modTime := range map[string]time.Time{
+		".bashrc": time.Now().Add(-48 * time.Hour),
+		".vimrc":  time.Now().Add(-time.Hour),
+	} {
+		value := []byte(fmt.Sprintf(`{"type":"file","modTime":%d}`, modTime.UnixNano()))
+		if err := persistentState.Set([]byte("entryState"), []byte(targetName), value); err != nil {
+			t.Fatalf("persistentState.Set(_, %q, _) == %v, want <nil>", targetName, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name            string
+		recent          time.Duration
+		wantPriority    int
+		wantDifferences []string
+	}{
+		{
+			name:         "disabled",
+			recent:       0,
+			wantPriority: 0,
+		},
+		{
+			name:            "day",
+			recent:          24 * time.Hour,
+			wantPriority:    1,
+			wantDifferences: []string{".vimrc"},
+		},
+		{
+			name:            "week",
+			recent:          7 * 24 * time.Hour,
+			wantPriority:    2,
+			wantDifferences: []string{".vimrc"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c.verify.recent = tc.recent
+			result, err := c.verifySample(fs)
+			if err != nil {
+				t.Fatalf("c.verifySample(_) == _, %v, want _, <nil>", err)
+			}
+			if result.Priority != tc.wantPriority {
+				t.Errorf("c.verifySample(_).Priority == %d, want %d", result.Priority, tc.wantPriority)
+			}
+			if tc.wantDifferences == nil {
+				return
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.wantDifferences, result.Differences); !equal {
+				t.Errorf("c.verifySample(_).Differences == %v, want %v, diff:\n%s", result.Differences, tc.wantDifferences, diff)
+			}
+		})
+	}
+}
+
+func TestVerifyThenApplyRunOnce(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi/run_once_count": "#!/bin/sh\necho run >> count\n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	newConfig := func() *Config {
+		return &Config{
+			configFile: "/home/user/.config/chezmoi/chezmoi.toml",
+			cacheDir:   "/home/user/.cache/chezmoi",
+			stateDir:   "/home/user/.local/state/chezmoi",
+			SourceDir:  "/home/user/.chezmoi",
+			DestDir:    "/home/user",
+			Umask:      022,
+			Diff: diffConfig{
+				Format:  "unified",
+				noPager: true,
+			},
+		}
+	}
+
+	c := newConfig()
+	if err := c.runVerifyCmd(fs, nil); err != nil {
+		t.Fatalf("c.runVerifyCmd(_, nil) == %v, want <nil>", err)
+	}
+	if err := c.runDiffCmd(fs, nil); err != nil {
+		t.Fatalf("c.runDiffCmd(_, nil) == %v, want <nil>", err)
+	}
+	if err := c.closePersistentState(); err != nil {
+		t.Fatalf("c.closePersistentState() == %v, want <nil>", err)
+	}
+	vfst.RunTests(t, fs, "verify",
+		vfst.TestPath("/home/user/count",
+			vfst.TestDoesNotExist,
+		),
+		vfst.TestPath("/home/user/.local/state/chezmoi/chezmoistate.boltdb",
+			vfst.TestDoesNotExist,
+		),
+	)
+
+	for i := 0; i < 2; i++ {
+		c := newConfig()
+		if err := c.runApplyCmd(fs, nil); err != nil {
+			t.Fatalf("c.runApplyCmd(_, nil) == %v, want <nil>", err)
+		}
+		if err := c.closePersistentState(); err != nil {
+			t.Fatalf("c.closePersistentState() == %v, want <nil>", err)
+		}
+	}
+	vfst.RunTests(t, fs, "apply",
+		vfst.TestPath("/home/user/count",
+			vfst.TestContentsString("run\n"),
+		),
+	)
+}
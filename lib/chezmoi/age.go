@@ -0,0 +1,84 @@
+package chezmoi
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// An Age is an Encryption that uses age. age accepts SSH ed25519 and RSA keys
+// as recipients and identities, so machines with an existing SSH key can
+// decrypt files without a separate age key.
+type Age struct {
+	// Command is the age command. If it is empty then "age" is used.
+	Command string
+	// Args are extra arguments passed to every invocation of Command.
+	Args []string
+	// Identities are the paths of the identity files used to decrypt files,
+	// for example ~/.ssh/id_ed25519.
+	Identities []string
+	// Recipients are the recipients that files are encrypted for, for
+	// example age public keys or SSH public keys.
+	Recipients []string
+	// RecipientsFiles are the paths of files containing recipients, one per
+	// line, for example ~/.ssh/id_ed25519.pub or ~/.ssh/authorized_keys.
+	RecipientsFiles []string
+}
+
+// Decrypt implements Decryptor.Decrypt.
+func (a *Age) Decrypt(name string, ciphertext []byte) ([]byte, error) {
+	if len(a.Identities) == 0 {
+		return nil, fmt.Errorf("%s: no age identities configured", name)
+	}
+	args := []string{"--decrypt"}
+	for _, identity := range a.Identities {
+		args = append(args, "--identity", identity)
+	}
+	plaintext, err := a.run(ciphertext, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return plaintext, nil
+}
+
+// DecryptFile implements Encryption.DecryptFile.
+func (a *Age) DecryptFile(fs vfs.FS, path string) ([]byte, error) {
+	return decryptFile(a, fs, path)
+}
+
+// Encrypt implements Encryptor.Encrypt.
+func (a *Age) Encrypt(name string, plaintext []byte) ([]byte, error) {
+	if len(a.Recipients) == 0 && len(a.RecipientsFiles) == 0 {
+		return nil, fmt.Errorf("%s: no age recipients configured", name)
+	}
+	args := []string{"--encrypt", "--armor"}
+	for _, recipient := range a.Recipients {
+		args = append(args, "--recipient", recipient)
+	}
+	for _, recipientsFile := range a.RecipientsFiles {
+		args = append(args, "--recipients-file", recipientsFile)
+	}
+	ciphertext, err := a.run(plaintext, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return ciphertext, nil
+}
+
+// EncryptFile implements Encryption.EncryptFile.
+func (a *Age) EncryptFile(fs vfs.FS, path string) ([]byte, error) {
+	return encryptFile(a, fs, path)
+}
+
+// run runs a's command with args and stdin, and returns its output.
+func (a *Age) run(stdin []byte, args ...string) ([]byte, error) {
+	command := a.Command
+	if command == "" {
+		command = "age"
+	}
+	cmd := exec.Command(command, append(append([]string{}, a.Args...), args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	return commandOutput(cmd)
+}
@@ -0,0 +1,151 @@
+package chezmoi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+// newFakeAge writes a fake age command to dir that records its arguments in
+// dir/args and copies stdin to stdout.
+func newFakeAge(t *testing.T, dir string) string {
+	command := filepath.Join(dir, "age")
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\ncat\n"
+	if err := ioutil.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatalf("ioutil.WriteFile(%q, _, 0755) == %v, want <nil>", command, err)
+	}
+	return command
+}
+
+func TestAge(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake age command requires a shell")
+	}
+	dir, err := ioutil.TempDir("", "chezmoi-test-age")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(dir)
+	command := newFakeAge(t, dir)
+
+	for _, tc := range []struct {
+		name        string
+		age         *Age
+		decrypt     bool
+		wantArgs    []string
+		wantErrText string
+	}{
+		{
+			name: "encrypt_ssh_recipients_file",
+			age: &Age{
+				Command:         command,
+				RecipientsFiles: []string{"/home/user/.ssh/id_ed25519.pub"},
+			},
+			wantArgs: []string{"--encrypt", "--armor", "--recipients-file", "/home/user/.ssh/id_ed25519.pub"},
+		},
+		{
+			name: "encrypt_recipients",
+			age: &Age{
+				Command:    command,
+				Args:       []string{"--verbose"},
+				Recipients: []string{"ssh-ed25519", "age1"},
+			},
+			wantArgs: []string{"--verbose", "--encrypt", "--armor", "--recipient", "ssh-ed25519", "--recipient", "age1"},
+		},
+		{
+			name: "encrypt_no_recipients",
+			age: &Age{
+				Command: command,
+			},
+			wantErrText: "no age recipients configured",
+		},
+		{
+			name: "decrypt_ssh_identities",
+			age: &Age{
+				Command:    command,
+				Identities: []string{"/home/user/.ssh/id_ed25519", "/home/user/.ssh/id_rsa"},
+			},
+			decrypt:  true,
+			wantArgs: []string{"--decrypt", "--identity", "/home/user/.ssh/id_ed25519", "--identity", "/home/user/.ssh/id_rsa"},
+		},
+		{
+			name: "decrypt_no_identities",
+			age: &Age{
+				Command: command,
+			},
+			decrypt:     true,
+			wantErrText: "no age identities configured",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Remove(filepath.Join(dir, "args"))
+			input := []byte("contents\n")
+			var output []byte
+			var err error
+			if tc.decrypt {
+				output, err = tc.age.Decrypt("file", input)
+			} else {
+				output, err = tc.age.Encrypt("file", input)
+			}
+			if tc.wantErrText != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErrText) {
+					t.Fatalf("got %v, want error containing %q", err, tc.wantErrText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got %v, want <nil>", err)
+			}
+			if string(output) != string(input) {
+				t.Errorf("got %q, want %q", output, input)
+			}
+			args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+			if err != nil {
+				t.Fatalf("ioutil.ReadFile(_) == _, %v, want _, <nil>", err)
+			}
+			if gotArgs := strings.Fields(string(args)); !reflect.DeepEqual(gotArgs, tc.wantArgs) {
+				t.Errorf("got args %v, want %v", gotArgs, tc.wantArgs)
+			}
+		})
+	}
+}
+
+func TestAgeFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake age command requires a shell")
+	}
+	dir, err := ioutil.TempDir("", "chezmoi-test-age")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(dir)
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.netrc": "machine example.com\n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	var e Encryption = &Age{
+		Command:    newFakeAge(t, dir),
+		Identities: []string{"/home/user/.ssh/id_ed25519"},
+		Recipients: []string{"age1"},
+	}
+	want := []byte("machine example.com\n")
+	if got, err := e.EncryptFile(fs, "/home/user/.netrc"); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("e.EncryptFile(fs, _) == %q, %v, want %q, <nil>", got, err, want)
+	}
+	if got, err := e.DecryptFile(fs, "/home/user/.netrc"); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("e.DecryptFile(fs, _) == %q, %v, want %q, <nil>", got, err, want)
+	}
+	if _, err := e.DecryptFile(fs, "/home/user/.missing"); err == nil {
+		t.Errorf("e.DecryptFile(fs, _) == _, <nil>, want _, !<nil>")
+	}
+}
@@ -1,11 +1,16 @@
 package chezmoi
 
-import "os"
+import (
+	"context"
+	"os"
+	"sync"
+)
 
 // An AnyMutator wraps another Mutator and records if any of its mutating
-// methods are called.
+// methods are called. It is safe for concurrent use if its Mutator is.
 type AnyMutator struct {
 	m       Mutator
+	mu      sync.Mutex
 	mutated bool
 }
 
@@ -19,33 +24,47 @@ func NewAnyMutator(m Mutator) *AnyMutator {
 
 // Chmod implements Mutator.Chmod.
 func (m *AnyMutator) Chmod(name string, mode os.FileMode) error {
-	m.mutated = true
+	m.setMutated()
 	return m.m.Chmod(name, mode)
 }
 
+// CopyFile implements Mutator.CopyFile.
+func (m *AnyMutator) CopyFile(name, source string, size int64, perm os.FileMode, overwrite bool) error {
+	m.setMutated()
+	return m.m.CopyFile(name, source, size, perm, overwrite)
+}
+
 // Mkdir implements Mutator.Mkdir.
 func (m *AnyMutator) Mkdir(name string, perm os.FileMode) error {
-	m.mutated = true
+	m.setMutated()
 	return m.m.Mkdir(name, perm)
 }
 
 // Mutated returns true if any of its methods have been called.
 func (m *AnyMutator) Mutated() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.mutated
 }
 
 // RemoveAll implements Mutator.RemoveAll.
 func (m *AnyMutator) RemoveAll(name string) error {
-	m.mutated = true
+	m.setMutated()
 	return m.m.RemoveAll(name)
 }
 
 // Rename implements Mutator.Rename.
 func (m *AnyMutator) Rename(oldpath, newpath string) error {
-	m.mutated = true
+	m.setMutated()
 	return m.m.Rename(oldpath, newpath)
 }
 
+// RunScript implements Mutator.RunScript. Scripts are not part of the target
+// state, so running one does not count as a mutation.
+func (m *AnyMutator) RunScript(ctx context.Context, name, dir string, data []byte) (bool, error) {
+	return m.m.RunScript(ctx, name, dir, data)
+}
+
 // Stat implements Mutator.Stat.
 func (m *AnyMutator) Stat(path string) (os.FileInfo, error) {
 	return m.m.Stat(path)
@@ -53,12 +72,19 @@ func (m *AnyMutator) Stat(path string) (os.FileInfo, error) {
 
 // WriteFile implements Mutator.WriteFile.
 func (m *AnyMutator) WriteFile(name string, data []byte, perm os.FileMode, currData []byte) error {
-	m.mutated = true
+	m.setMutated()
 	return m.m.WriteFile(name, data, perm, currData)
 }
 
 // WriteSymlink implements Mutator.WriteSymlink.
 func (m *AnyMutator) WriteSymlink(oldname, newname string) error {
-	m.mutated = true
+	m.setMutated()
 	return m.m.WriteSymlink(oldname, newname)
 }
+
+// setMutated records that a mutating method has been called.
+func (m *AnyMutator) setMutated() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mutated = true
+}
@@ -0,0 +1,295 @@
+package chezmoi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// backupDirTimeFormat is the format of the names of backup directories, which
+// sort in the order in which they were created.
+const backupDirTimeFormat = "20060102T150405.000"
+
+// A BackupMutator wraps another Mutator and, before a target in destDir is
+// overwritten or removed, copies its previous version to a backup directory.
+// Each target is backed up at most once, so the backup directory holds the
+// targets as they were before the first change. Backups are written directly
+// to fs, bypassing the wrapped Mutator. The targets that are created and the
+// previous permissions of targets are recorded too, so that the changes can be
+// undone with Rollback once the undo log has been written with WriteUndoLog.
+// It is safe for concurrent use if its Mutator is, but backups are made one at
+// a time.
+type BackupMutator struct {
+	m         Mutator
+	fs        vfs.FS
+	destDir   string
+	backupDir string
+	mu        sync.Mutex
+	backedUp  map[string]bool
+	chmodded  map[string]bool
+	undoLog   UndoLog
+}
+
+// NewBackupMutator returns a new BackupMutator that backs up targets in destDir
+// to backupDir, which is created when the first target is backed up.
+func NewBackupMutator(m Mutator, fs vfs.FS, destDir, backupDir string) *BackupMutator {
+	return &BackupMutator{
+		m:         m,
+		fs:        fs,
+		destDir:   filepath.Clean(destDir),
+		backupDir: backupDir,
+		backedUp:  make(map[string]bool),
+		chmodded:  make(map[string]bool),
+		undoLog: UndoLog{
+			DestDir: filepath.Clean(destDir),
+		},
+	}
+}
+
+// BackupDirName returns the name of the backup directory in root for a backup
+// made at t.
+func BackupDirName(root string, t time.Time) string {
+	return filepath.Join(root, t.Format(backupDirTimeFormat))
+}
+
+// PruneBackups removes all but the newest keep backup directories in root with
+// mutator. If keep is zero then no backups are removed.
+func PruneBackups(fs vfs.FS, root string, keep int, mutator Mutator) error {
+	if keep <= 0 {
+		return nil
+	}
+	infos, err := fs.ReadDir(root)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	var names []string
+	for _, info := range infos {
+		if _, err := time.Parse(backupDirTimeFormat, info.Name()); err == nil && info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := mutator.RemoveAll(filepath.Join(root, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// BackupDir returns the backup directory.
+func (m *BackupMutator) BackupDir() string {
+	return m.backupDir
+}
+
+// Chmod implements Mutator.Chmod.
+func (m *BackupMutator) Chmod(name string, mode os.FileMode) error {
+	if err := m.backupPerm(name); err != nil {
+		return err
+	}
+	return m.m.Chmod(name, mode)
+}
+
+// CopyFile implements Mutator.CopyFile.
+func (m *BackupMutator) CopyFile(name, source string, size int64, perm os.FileMode, overwrite bool) error {
+	if err := m.backup(name); err != nil {
+		return err
+	}
+	return m.m.CopyFile(name, source, size, perm, overwrite)
+}
+
+// Mkdir implements Mutator.Mkdir.
+func (m *BackupMutator) Mkdir(name string, perm os.FileMode) error {
+	if err := m.backup(name); err != nil {
+		return err
+	}
+	return m.m.Mkdir(name, perm)
+}
+
+// RemoveAll implements Mutator.RemoveAll.
+func (m *BackupMutator) RemoveAll(name string) error {
+	if err := m.backup(name); err != nil {
+		return err
+	}
+	return m.m.RemoveAll(name)
+}
+
+// Rename implements Mutator.Rename.
+func (m *BackupMutator) Rename(oldpath, newpath string) error {
+	if err := m.backup(oldpath); err != nil {
+		return err
+	}
+	if err := m.backup(newpath); err != nil {
+		return err
+	}
+	return m.m.Rename(oldpath, newpath)
+}
+
+// RunScript implements Mutator.RunScript.
+func (m *BackupMutator) RunScript(ctx context.Context, name, dir string, data []byte) (bool, error) {
+	return m.m.RunScript(ctx, name, dir, data)
+}
+
+// Stat implements Mutator.Stat.
+func (m *BackupMutator) Stat(name string) (os.FileInfo, error) {
+	return m.m.Stat(name)
+}
+
+// WriteFile implements Mutator.WriteFile.
+func (m *BackupMutator) WriteFile(name string, data []byte, perm os.FileMode, currData []byte) error {
+	if err := m.backup(name); err != nil {
+		return err
+	}
+	return m.m.WriteFile(name, data, perm, currData)
+}
+
+// WriteSymlink implements Mutator.WriteSymlink.
+func (m *BackupMutator) WriteSymlink(oldname, newname string) error {
+	if err := m.backup(newname); err != nil {
+		return err
+	}
+	return m.m.WriteSymlink(oldname, newname)
+}
+
+// backup copies name to the backup directory, if it is in m.destDir and
+// neither it nor any of its parent directories has already been backed up,
+// and records its previous state in the undo log.
+func (m *BackupMutator) backup(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	targetName, ok := m.targetName(name)
+	if !ok {
+		return nil
+	}
+	m.backedUp[name] = true
+	info, err := m.fs.Lstat(name)
+	switch {
+	case isNotExist(err):
+		m.undoLog.Entries = append(m.undoLog.Entries, UndoEntry{
+			TargetName: targetName,
+		})
+		return nil
+	case err != nil:
+		return err
+	}
+	if err := m.copy(name, filepath.Join(m.backupDir, targetName)); err != nil {
+		return err
+	}
+	m.undoLog.Entries = append(m.undoLog.Entries, UndoEntry{
+		TargetName: targetName,
+		Existed:    true,
+		Perm:       info.Mode().Perm(),
+	})
+	return nil
+}
+
+// backupPerm records the permissions of name in the undo log, if it is in
+// m.destDir and neither it nor any of its parent directories has already been
+// backed up.
+func (m *BackupMutator) backupPerm(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	targetName, ok := m.targetName(name)
+	if !ok || m.chmodded[name] {
+		return nil
+	}
+	m.chmodded[name] = true
+	info, err := m.fs.Lstat(name)
+	if err != nil {
+		return err
+	}
+	m.undoLog.Entries = append(m.undoLog.Entries, UndoEntry{
+		TargetName: targetName,
+		Existed:    true,
+		PermOnly:   true,
+		Perm:       info.Mode().Perm(),
+	})
+	return nil
+}
+
+// copy copies src, recursively, to dst.
+func (m *BackupMutator) copy(src, dst string) error {
+	info, err := m.fs.Lstat(src)
+	if err != nil {
+		return err
+	}
+	// Backups may contain secrets, so only the user can read their
+	// directories.
+	if err := vfs.MkdirAll(m.fs, filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	switch {
+	case info.Mode().IsRegular():
+		// Files are streamed, as they may be too large to hold in memory.
+		return copyFile(m.fs, src, dst, info.Mode().Perm())
+	case info.IsDir():
+		if err := m.fs.Mkdir(dst, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+		infos, err := m.fs.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if err := m.copy(filepath.Join(src, info.Name()), filepath.Join(dst, info.Name())); err != nil {
+				return err
+			}
+		}
+		// Keep the directory's permissions, so that it can be restored,
+		// but make sure that the backup can still be removed.
+		return m.fs.Chmod(dst, info.Mode().Perm()|0700)
+	case info.Mode()&os.ModeType == os.ModeSymlink:
+		linkname, err := m.fs.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return m.fs.Symlink(linkname, dst)
+	default:
+		return nil
+	}
+}
+
+// copyFile copies the regular file src in fs to a new file dst with
+// permissions perm, streaming its contents and preserving any holes.
+func copyFile(fs vfs.FS, src, dst string, perm os.FileMode) error {
+	srcFile, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	dstFile, err := fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err := copyFileContents(dstFile, srcFile); err != nil {
+		dstFile.Close()
+		return err
+	}
+	return dstFile.Close()
+}
+
+// targetName returns the target name of name and true if name is in m.destDir
+// and neither it nor any of its parent directories has already been backed
+// up.
+func (m *BackupMutator) targetName(name string) (string, bool) {
+	targetName, err := filepath.Rel(m.destDir, name)
+	if err != nil || targetName == "." || strings.HasPrefix(targetName, "..") {
+		return "", false
+	}
+	for dir := filepath.Clean(name); dir != m.destDir && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if m.backedUp[dir] {
+			return "", false
+		}
+	}
+	return targetName, true
+}
@@ -0,0 +1,104 @@
+package chezmoi
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestBackupMutator(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc": &vfst.File{
+				Perm:     0600,
+				Contents: []byte("# local edits\n"),
+			},
+			".dir": map[string]interface{}{
+				"file": "file",
+			},
+			".link": &vfst.Symlink{Target: ".bashrc"},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	backupDir := BackupDirName("/home/user/.backups", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	if backupDir != "/home/user/.backups/20200102T030405.000" {
+		t.Errorf("BackupDirName(_, _) == %q, want %q", backupDir, "/home/user/.backups/20200102T030405.000")
+	}
+	m := NewBackupMutator(NewFSMutator(fs, "/home/user"), fs, "/home/user", backupDir)
+	for _, f := range []func() error{
+		func() error {
+			return m.WriteFile("/home/user/.bashrc", []byte("# first\n"), 0644, []byte("# local edits\n"))
+		},
+		func() error {
+			return m.WriteFile("/home/user/.bashrc", []byte("# second\n"), 0644, []byte("# first\n"))
+		},
+		func() error { return m.RemoveAll("/home/user/.dir/file") },
+		func() error { return m.RemoveAll("/home/user/.dir") },
+		func() error { return m.WriteSymlink(".profile", "/home/user/.link") },
+		func() error { return m.WriteFile("/home/user/.new", []byte("new"), 0644, nil) },
+	} {
+		if err := f(); err != nil {
+			t.Fatalf("got %v, want <nil>", err)
+		}
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.bashrc",
+			vfst.TestContentsString("# second\n"),
+		),
+		vfst.TestPath(backupDir,
+			vfst.TestIsDir,
+			vfst.TestModePerm(0700),
+		),
+		vfst.TestPath(backupDir+"/.bashrc",
+			vfst.TestModePerm(0600),
+			vfst.TestContentsString("# local edits\n"),
+		),
+		vfst.TestPath(backupDir+"/.dir/file",
+			vfst.TestContentsString("file"),
+		),
+		vfst.TestPath(backupDir+"/.link",
+			vfst.TestModeType(os.ModeSymlink),
+			vfst.TestSymlinkTarget(".bashrc"),
+		),
+		vfst.TestPath(backupDir+"/.new",
+			vfst.TestDoesNotExist,
+		),
+	)
+}
+
+func TestPruneBackups(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.backups": map[string]interface{}{
+			"20200101T000000.000": &vfst.Dir{Perm: 0700},
+			"20200102T000000.000": &vfst.Dir{Perm: 0700},
+			"20200103T000000.000": &vfst.Dir{Perm: 0700},
+			"notes":               "not a backup",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	if err := PruneBackups(fs, "/home/user/.backups", 2, NewFSMutator(fs, "/home/user")); err != nil {
+		t.Fatalf("PruneBackups(_, _, 2, _) == %v, want <nil>", err)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.backups/20200101T000000.000",
+			vfst.TestDoesNotExist,
+		),
+		vfst.TestPath("/home/user/.backups/20200102T000000.000",
+			vfst.TestIsDir,
+		),
+		vfst.TestPath("/home/user/.backups/20200103T000000.000",
+			vfst.TestIsDir,
+		),
+		vfst.TestPath("/home/user/.backups/notes",
+			vfst.TestModeIsRegular,
+		),
+	)
+}
@@ -0,0 +1,289 @@
+package chezmoi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	vfs "github.com/twpayne/go-vfs"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltPersistentStateTimeout is how long to wait for another process to
+// release its lock on a BoltPersistentState's database.
+const boltPersistentStateTimeout = 5 * time.Second
+
+// A BoltPersistentState is a PersistentState that is stored in a bbolt
+// database, with a bbolt bucket for each bucket. Each change is written in its
+// own transaction, which only writes the pages that changed, unless changes are
+// grouped with Batch. The database is opened when it is first accessed and
+// stays open, and locked, until Close is called. If s is read only then the
+// database is read once, if it exists, and changes are kept in memory and not
+// written. It is safe for concurrent use.
+type BoltPersistentState struct {
+	mu       sync.Mutex
+	fs       vfs.FS
+	path     string
+	readOnly bool
+	db       *bolt.DB
+	// tx is the transaction of the current Batch, if any.
+	tx *bolt.Tx
+	// memory holds the state if s is read only.
+	memory *MemoryPersistentState
+}
+
+// NewBoltPersistentState returns a new BoltPersistentState stored in path.
+func NewBoltPersistentState(fs vfs.FS, path string, readOnly bool) *BoltPersistentState {
+	return &BoltPersistentState{
+		fs:       fs,
+		path:     path,
+		readOnly: readOnly,
+	}
+}
+
+// Batch calls f, which changes s, and writes all of its changes in a single
+// transaction when f returns, instead of one transaction for each change.
+// Changes made concurrently by other goroutines while f runs are written in
+// the same transaction.
+func (s *BoltPersistentState) Batch(f func() error) error {
+	s.mu.Lock()
+	if err := s.open(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if s.memory != nil || s.tx != nil {
+		s.mu.Unlock()
+		return f()
+	}
+	tx, err := s.db.Begin(true)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.tx = tx
+	s.mu.Unlock()
+	err = f()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tx = nil
+	if commitErr := tx.Commit(); err == nil {
+		err = commitErr
+	}
+	return err
+}
+
+// Close closes s's database, if it is open.
+func (s *BoltPersistentState) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// Delete implements PersistentState.Delete.
+func (s *BoltPersistentState) Delete(bucket, key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.memory != nil {
+		return s.memory.Delete(bucket, key)
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil
+		}
+		return b.Delete(key)
+	})
+}
+
+// DeleteBucket implements PersistentState.DeleteBucket.
+func (s *BoltPersistentState) DeleteBucket(bucket []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.memory != nil {
+		return s.memory.DeleteBucket(bucket)
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(bucket); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+// ForEach implements PersistentState.ForEach. fn is called with copies of the
+// keys and values in bucket without s being locked, so fn may change s.
+func (s *BoltPersistentState) ForEach(bucket []byte, fn func(k, v []byte) error) error {
+	s.mu.Lock()
+	if err := s.open(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if s.memory != nil {
+		s.mu.Unlock()
+		return s.memory.ForEach(bucket, fn)
+	}
+	var keys, values [][]byte
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			keys = append(keys, copyBytes(k))
+			values = append(values, copyBytes(v))
+			return nil
+		})
+	})
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get implements PersistentState.Get.
+func (s *BoltPersistentState) Get(bucket, key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	if s.memory != nil {
+		return s.memory.Get(bucket, key)
+	}
+	var value []byte
+	if err := s.view(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bucket); b != nil {
+			value = copyBytes(b.Get(key))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Set implements PersistentState.Set.
+func (s *BoltPersistentState) Set(bucket, key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.memory != nil {
+		return s.memory.Set(bucket, key, value)
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		// bbolt uses key and value until the transaction ends, which, in a
+		// Batch, is after the caller might have changed them.
+		return b.Put(copyBytes(key), copyBytes(value))
+	})
+}
+
+// open opens s's database, if it is not already open. If s is read only then
+// the database is read into memory and closed.
+func (s *BoltPersistentState) open() error {
+	if s.db != nil || s.memory != nil {
+		return nil
+	}
+	if s.readOnly {
+		return s.openReadOnly()
+	}
+	if err := vfs.MkdirAll(s.fs, filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	db, err := s.openDB(false)
+	if err != nil {
+		return err
+	}
+	s.db = db
+	return nil
+}
+
+// openDB opens s's database with a timeout, so that chezmoi does not wait for
+// ever for another instance to release its lock.
+func (s *BoltPersistentState) openDB(readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{
+		OpenFile: s.fs.OpenFile,
+		ReadOnly: readOnly,
+		Timeout:  boltPersistentStateTimeout,
+	})
+	switch {
+	case err == bolt.ErrTimeout:
+		return nil, fmt.Errorf("%s: timeout obtaining persistent state lock, is another instance of chezmoi running?", s.path)
+	case err != nil:
+		return nil, fmt.Errorf("%s: %v", s.path, err)
+	default:
+		return db, nil
+	}
+}
+
+// openReadOnly reads s's database, if it exists, into memory.
+func (s *BoltPersistentState) openReadOnly() error {
+	memory := NewMemoryPersistentState()
+	switch _, err := s.fs.Stat(s.path); {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		db, err := s.openDB(true)
+		if err != nil {
+			return err
+		}
+		err = db.View(func(tx *bolt.Tx) error {
+			return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				return b.ForEach(func(k, v []byte) error {
+					return memory.Set(name, k, v)
+				})
+			})
+		})
+		if closeErr := db.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.memory = memory
+	return nil
+}
+
+// update calls fn in a read-write transaction, the current Batch's if there
+// is one.
+func (s *BoltPersistentState) update(fn func(*bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.Update(fn)
+}
+
+// view calls fn in a read-only transaction, or in the current Batch's
+// transaction if there is one.
+func (s *BoltPersistentState) view(fn func(*bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.View(fn)
+}
@@ -0,0 +1,50 @@
+package chezmoi
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBufferSize is the capacity of the largest buffer that is returned to
+// bufferPool, so that one large template does not keep a large buffer alive.
+const maxPooledBufferSize = 1 << 20
+
+// bufferPool holds the buffers that templates are executed into.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+// copyBufferPool holds the buffers that contents are copied through.
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 32*1024)
+		return &buf
+	},
+}
+
+// copyPooled copies r to w like io.Copy, but through a buffer from
+// copyBufferPool instead of a new one.
+func copyPooled(w io.Writer, r io.Reader) (int64, error) {
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+	// Hide any WriteTo method of r, like that of *os.File, which would copy
+	// through a new buffer.
+	return io.CopyBuffer(w, struct{ io.Reader }{r}, *buf)
+}
+
+// getBuffer returns an empty buffer from bufferPool.
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer returns buf to bufferPool, unless it is too large.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		bufferPool.Put(buf)
+	}
+}
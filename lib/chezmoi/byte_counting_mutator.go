@@ -0,0 +1,74 @@
+package chezmoi
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+)
+
+// A ByteCountingMutator wraps another Mutator and counts the number of bytes
+// written. It is safe for concurrent use if its Mutator is.
+type ByteCountingMutator struct {
+	m     Mutator
+	bytes uint64
+}
+
+// NewByteCountingMutator returns a new ByteCountingMutator.
+func NewByteCountingMutator(m Mutator) *ByteCountingMutator {
+	return &ByteCountingMutator{
+		m: m,
+	}
+}
+
+// Bytes returns the number of bytes written.
+func (m *ByteCountingMutator) Bytes() uint64 {
+	return atomic.LoadUint64(&m.bytes)
+}
+
+// Chmod implements Mutator.Chmod.
+func (m *ByteCountingMutator) Chmod(name string, mode os.FileMode) error {
+	return m.m.Chmod(name, mode)
+}
+
+// CopyFile implements Mutator.CopyFile.
+func (m *ByteCountingMutator) CopyFile(name, source string, size int64, perm os.FileMode, overwrite bool) error {
+	atomic.AddUint64(&m.bytes, uint64(size))
+	return m.m.CopyFile(name, source, size, perm, overwrite)
+}
+
+// Mkdir implements Mutator.Mkdir.
+func (m *ByteCountingMutator) Mkdir(name string, perm os.FileMode) error {
+	return m.m.Mkdir(name, perm)
+}
+
+// RemoveAll implements Mutator.RemoveAll.
+func (m *ByteCountingMutator) RemoveAll(name string) error {
+	return m.m.RemoveAll(name)
+}
+
+// Rename implements Mutator.Rename.
+func (m *ByteCountingMutator) Rename(oldpath, newpath string) error {
+	return m.m.Rename(oldpath, newpath)
+}
+
+// RunScript implements Mutator.RunScript.
+func (m *ByteCountingMutator) RunScript(ctx context.Context, name, dir string, data []byte) (bool, error) {
+	return m.m.RunScript(ctx, name, dir, data)
+}
+
+// Stat implements Mutator.Stat.
+func (m *ByteCountingMutator) Stat(path string) (os.FileInfo, error) {
+	return m.m.Stat(path)
+}
+
+// WriteFile implements Mutator.WriteFile.
+func (m *ByteCountingMutator) WriteFile(name string, data []byte, perm os.FileMode, currData []byte) error {
+	atomic.AddUint64(&m.bytes, uint64(len(data)))
+	return m.m.WriteFile(name, data, perm, currData)
+}
+
+// WriteSymlink implements Mutator.WriteSymlink.
+func (m *ByteCountingMutator) WriteSymlink(oldname, newname string) error {
+	atomic.AddUint64(&m.bytes, uint64(len(oldname)))
+	return m.m.WriteSymlink(oldname, newname)
+}
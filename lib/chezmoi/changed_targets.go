@@ -0,0 +1,63 @@
+package chezmoi
+
+import (
+	"fmt"
+	"path/filepath"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// A ChangedTargetError is the error for a target that executing a plan would
+// change, but that has been changed by something other than chezmoi since
+// chezmoi last wrote it, so applying it could lose those changes.
+type ChangedTargetError struct {
+	TargetName string
+	Last       *EntryState
+	Actual     *EntryState
+}
+
+// ChangedTargets returns a ChangedTargetError for every target that plan would
+// change and that has been changed since chezmoi last wrote it, in the order
+// of plan. Targets that chezmoi has never written, and targets that have been
+// removed, are never included.
+func (ts *TargetState) ChangedTargets(fs vfs.FS, plan *ApplyPlan) ([]*ChangedTargetError, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	var changed []*ChangedTargetError
+	checked := make(map[string]bool)
+	for i := range plan.Operations {
+		o := &plan.Operations[i]
+		if o.Type == OperationRunScript {
+			continue
+		}
+		targetName := ts.operationTargetName(o)
+		if checked[targetName] {
+			continue
+		}
+		checked[targetName] = true
+		lastState, err := ts.lastEntryState(targetName)
+		if err != nil {
+			return nil, err
+		}
+		if lastState == nil {
+			continue
+		}
+		actualState, err := actualEntryState(fs, filepath.Join(ts.DestDir, targetName), lastState, ts.ContentHashCache)
+		if err != nil {
+			return nil, err
+		}
+		if actualState == nil || actualState.Equal(lastState) {
+			continue
+		}
+		changed = append(changed, &ChangedTargetError{
+			TargetName: targetName,
+			Last:       lastState,
+			Actual:     actualState,
+		})
+	}
+	return changed, nil
+}
+
+func (e *ChangedTargetError) Error() string {
+	return fmt.Sprintf("%s: changed since chezmoi last wrote it", e.TargetName)
+}
@@ -0,0 +1,68 @@
+package chezmoi
+
+import (
+	"testing"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateChangedTargets(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			"dot_edited":  "original",
+			"dot_removed": "original",
+			"dot_same":    "original",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	persistentState := NewMemoryPersistentState()
+	newTargetState := func() *TargetState {
+		ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+		ts.PersistentState = persistentState
+		if err := ts.Populate(fs); err != nil {
+			t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+		}
+		return ts
+	}
+
+	ts := newTargetState()
+	if err := ts.Apply(fs, NewFSMutator(fs, "/home/user")); err != nil {
+		t.Fatalf("ts.Apply(_, _) == %v, want <nil>", err)
+	}
+	entries := []Entry{ts.Entries[".edited"], ts.Entries[".removed"], ts.Entries[".same"]}
+	if err := ts.SaveEntryStates(fs, entries); err != nil {
+		t.Fatalf("ts.SaveEntryStates(_, _) == %v, want <nil>", err)
+	}
+
+	// Edit one target and remove another behind chezmoi's back, then
+	// change every target in the source state.
+	for name, contents := range map[string]string{
+		"/home/user/.edited":              "local edits",
+		"/home/user/.chezmoi/dot_edited":  "updated",
+		"/home/user/.chezmoi/dot_removed": "updated",
+		"/home/user/.chezmoi/dot_same":    "updated",
+	} {
+		if err := fs.WriteFile(name, []byte(contents), 0644); err != nil {
+			t.Fatalf("fs.WriteFile(%q, _, _) == %v, want <nil>", name, err)
+		}
+	}
+	if err := fs.Remove("/home/user/.removed"); err != nil {
+		t.Fatalf("fs.Remove(_) == %v, want <nil>", err)
+	}
+
+	ts = newTargetState()
+	plan, err := ts.Plan(fs)
+	if err != nil {
+		t.Fatalf("ts.Plan(_) == _, %v, want _, <nil>", err)
+	}
+	changed, err := ts.ChangedTargets(fs, plan)
+	if err != nil {
+		t.Fatalf("ts.ChangedTargets(_, _) == _, %v, want _, <nil>", err)
+	}
+	if len(changed) != 1 || changed[0].TargetName != ".edited" || changed[0].Error() != ".edited: changed since chezmoi last wrote it" {
+		t.Errorf("ts.ChangedTargets(_, _) == %v, _, want [.edited], _", changed)
+	}
+}
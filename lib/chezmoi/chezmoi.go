@@ -3,18 +3,25 @@ package chezmoi
 import (
 	"archive/tar"
 	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"syscall"
 
 	vfs "github.com/twpayne/go-vfs"
 )
 
 const (
+	createPrefix     = "create_"
 	symlinkPrefix    = "symlink_"
 	privatePrefix    = "private_"
+	runPrefix        = "run_"
+	oncePrefix       = "once_"
+	onChangePrefix   = "onchange_"
 	emptyPrefix      = "empty_"
+	encryptedPrefix  = "encrypted_"
 	exactPrefix      = "exact_"
 	executablePrefix = "executable_"
 	dotPrefix        = "dot_"
@@ -27,14 +34,14 @@ type templateFuncError struct {
 	err error
 }
 
-// An Entry is either a Dir, a File, or a Symlink.
+// An Entry is either a Dir, a File, a Script, or a Symlink.
 type Entry interface {
 	Apply(fs vfs.FS, destDir string, ignore func(string) bool, umask os.FileMode, mutator Mutator) error
 	ConcreteValue(destDir string, ignore func(string) bool, sourceDir string, recursive bool) (interface{}, error)
 	Evaluate(ignore func(string) bool) error
 	SourceName() string
 	TargetName() string
-	archive(w *tar.Writer, ignore func(string) bool, headerTemplate *tar.Header, umask os.FileMode) error
+	archive(w *TarWriter, ignore func(string) bool, headerTemplate *tar.Header, umask os.FileMode) error
 }
 
 type parsedSourceFilePath struct {
@@ -42,6 +49,10 @@ type parsedSourceFilePath struct {
 	dirAttributes []DirAttributes
 }
 
+func (e templateFuncError) Error() string {
+	return e.err.Error()
+}
+
 // ReturnTemplateFuncError causes template execution to return an error.
 func ReturnTemplateFuncError(err error) {
 	panic(templateFuncError{
@@ -63,6 +74,34 @@ func isEmpty(b []byte) bool {
 	return len(bytes.TrimSpace(b)) == 0
 }
 
+// isNotExist returns true if err indicates that a target does not exist,
+// including because one of its parent directories is not a directory. A plan
+// that replaces a parent with a directory is computed before the parent is
+// replaced.
+func isNotExist(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR)
+}
+
+// osPath returns the path on the OS filesystem corresponding to name in fs, and
+// whether fs is backed by the OS filesystem.
+func osPath(fs vfs.FS, name string) (string, bool) {
+	switch fs := fs.(type) {
+	case interface {
+		Join(op, name string) (string, error)
+	}:
+		path, err := fs.Join("join", name)
+		if err != nil {
+			return "", false
+		}
+		return path, true
+	default:
+		if fs == vfs.OSFS {
+			return name, true
+		}
+		return "", false
+	}
+}
+
 // parseDirNameComponents parses multiple directory name components.
 func parseDirNameComponents(components []string) []DirAttributes {
 	das := []DirAttributes{}
@@ -84,6 +123,23 @@ func parseSourceFilePath(path string) parsedSourceFilePath {
 	}
 }
 
+// appendLeafEntries appends every entry in entries, whose sorted names are
+// sortedNames, that is not a directory to leaves, recursing into directories
+// that are not ignored, in order of entry name, and returns the result.
+func appendLeafEntries(leaves []Entry, entries map[string]Entry, sortedNames []string, ignore func(string) bool) []Entry {
+	for _, entryName := range sortedNames {
+		entry := entries[entryName]
+		dir, ok := entry.(*Dir)
+		switch {
+		case !ok:
+			leaves = append(leaves, entry)
+		case !ignore(dir.targetName):
+			leaves = appendLeafEntries(leaves, dir.Entries, dir.nameCache.sortedNames(dir.Entries), ignore)
+		}
+	}
+	return leaves
+}
+
 // sortedEntryNames returns a sorted slice of all entry names.
 func sortedEntryNames(entries map[string]Entry) []string {
 	entryNames := []string{}
@@ -94,6 +150,29 @@ func sortedEntryNames(entries map[string]Entry) []string {
 	return entryNames
 }
 
+// walkEntries calls f for every entry in entries, recursing into directories,
+// in order of entry name.
+func walkEntries(entries map[string]Entry, f func(Entry) error) error {
+	return walkSortedEntries(entries, sortedEntryNames(entries), f)
+}
+
+// walkSortedEntries is like walkEntries, but the sorted names of entries are
+// sortedNames.
+func walkSortedEntries(entries map[string]Entry, sortedNames []string, f func(Entry) error) error {
+	for _, entryName := range sortedNames {
+		entry := entries[entryName]
+		if err := f(entry); err != nil {
+			return err
+		}
+		if dir, ok := entry.(*Dir); ok {
+			if err := walkSortedEntries(dir.Entries, dir.nameCache.sortedNames(dir.Entries), f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func splitPathList(path string) []string {
 	if strings.HasPrefix(path, string(filepath.Separator)) {
 		path = strings.TrimPrefix(path, string(filepath.Separator))
@@ -20,7 +20,7 @@ func TestReturnTemplateError(t *testing.T) {
 	} {
 		t.Run(name, func(t *testing.T) {
 			ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", nil, funcs)
-			if got, err := ts.executeTemplateData(name, []byte(dataString)); err == nil {
+			if got, err := ts.executeTemplateData(nil, TextTemplateEngine, name, []byte(dataString)); err == nil {
 				t.Errorf("ts.executeTemplate(%q, %q) == %q, <nil>, want _, !<nil>", name, dataString, got)
 			}
 		})
@@ -0,0 +1,7 @@
+package chezmoi
+
+import "errors"
+
+// errCloneUnsupported is returned by cloneFile on platforms that cannot clone
+// files.
+var errCloneUnsupported = errors.New("file clone not supported")
@@ -0,0 +1,42 @@
+package chezmoi
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Arguments of the clonefileat system call, which makes a file share the data
+// of another on filesystems that support it, like APFS.
+const (
+	atFDCWD          = -2
+	cloneNoFollow    = 0x0001
+	cloneNoOwnerCopy = 0x0002
+	sysClonefileat   = 462
+)
+
+// cloneFile creates dst as a clone of src that shares its data, without
+// copying it. dst must not exist.
+func cloneFile(src, dst string) error {
+	srcPtr, err := syscall.BytePtrFromString(src)
+	if err != nil {
+		return err
+	}
+	dstPtr, err := syscall.BytePtrFromString(dst)
+	if err != nil {
+		return err
+	}
+	dirfd := atFDCWD
+	if _, _, errno := syscall.Syscall6(
+		sysClonefileat,
+		uintptr(dirfd),
+		uintptr(unsafe.Pointer(srcPtr)),
+		uintptr(dirfd),
+		uintptr(unsafe.Pointer(dstPtr)),
+		cloneNoFollow|cloneNoOwnerCopy,
+		0,
+	); errno != 0 {
+		return &os.LinkError{Op: "clonefileat", Old: src, New: dst, Err: errno}
+	}
+	return nil
+}
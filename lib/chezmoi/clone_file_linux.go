@@ -0,0 +1,39 @@
+package chezmoi
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl, which makes a file share the data of another
+// on filesystems that support it, like Btrfs and XFS. Its value is that of the
+// common encoding of ioctl numbers. On architectures with another encoding the
+// ioctl fails, so files are copied instead.
+const ficlone = 0x40049409
+
+// cloneFile creates dst as a clone of src that shares its data, without
+// copying it. dst must not exist.
+func cloneFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dstFile.Fd(), ficlone, srcFile.Fd()); errno != 0 {
+		err = &os.PathError{Op: "ioctl", Path: dst, Err: errno}
+	} else {
+		err = dstFile.Sync()
+	}
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+// +build !darwin,!linux
+
+package chezmoi
+
+func cloneFile(src, dst string) error {
+	return errCloneUnsupported
+}
@@ -0,0 +1,34 @@
+package chezmoi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCloneFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chezmoi-test-clone-file")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	if err := ioutil.WriteFile(src, []byte("# contents of src\n"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(...) == %v, want <nil>", err)
+	}
+	// Most filesystems, like ext4 and tmpfs, do not support clones, in which
+	// case nothing is left behind.
+	if err := cloneFile(src, dst); err != nil {
+		if _, statErr := os.Lstat(dst); !os.IsNotExist(statErr) {
+			t.Errorf("cloneFile(%q, %q) == %v, but %q exists", src, dst, err, dst)
+		}
+		t.Skipf("cloneFile(%q, %q) == %v", src, dst, err)
+	}
+	if got, err := ioutil.ReadFile(dst); err != nil || string(got) != "# contents of src\n" {
+		t.Errorf("ioutil.ReadFile(%q) == %q, %v, want %q, <nil>", dst, got, err, "# contents of src\n")
+	}
+	if err := cloneFile(src, dst); err == nil {
+		t.Errorf("cloneFile(%q, %q) == <nil>, want !<nil>", src, dst)
+	}
+}
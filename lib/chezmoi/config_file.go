@@ -0,0 +1,138 @@
+package chezmoi
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// configHashBucket records the SHA256s of the templates from which config
+// files were generated, keyed by config file path.
+var configHashBucket = []byte("configHash")
+
+// A configHash is the SHA256 of the template from which a config file was
+// generated.
+type configHash struct {
+	TemplateSHA256 string `json:"templateSHA256"`
+}
+
+// A ConfigFile holds the settings and template data read from a config file.
+type ConfigFile struct {
+	SourceDir string                 `json:"sourceDir" toml:"sourceDir" yaml:"sourceDir"`
+	DestDir   string                 `json:"destDir" toml:"destDir" yaml:"destDir"`
+	Umask     *os.FileMode           `json:"umask" toml:"umask" yaml:"umask"`
+	Data      map[string]interface{} `json:"data" toml:"data" yaml:"data"`
+}
+
+// FindConfigFile returns the path of the first config file named
+// chezmoi/chezmoi.json, chezmoi/chezmoi.toml, or chezmoi/chezmoi.yaml in
+// configDirs, or the empty string if there is none.
+func FindConfigFile(fs vfs.FS, configDirs []string) (string, error) {
+	extensions := make([]string, 0, len(formats))
+	for extension := range formats {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	for _, configDir := range configDirs {
+		for _, extension := range extensions {
+			path := filepath.Join(configDir, "chezmoi", "chezmoi."+extension)
+			if _, err := fs.Stat(path); err == nil {
+				return path, nil
+			} else if !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+	return "", nil
+}
+
+// ReadConfigFile reads the config file at path, whose format is determined by
+// its extension. A leading ~ in directories is replaced with homeDir.
+func ReadConfigFile(fs vfs.FS, path, homeDir string) (*ConfigFile, error) {
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	unmarshal, ok := formats[strings.TrimPrefix(filepath.Ext(path), ".")]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown format", path)
+	}
+	configFile := &ConfigFile{}
+	if err := unmarshal(data, configFile); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for key, value := range configFile.Data {
+		configFile.Data[key] = stringKeys(value)
+	}
+	configFile.SourceDir = expandTilde(configFile.SourceDir, homeDir)
+	configFile.DestDir = expandTilde(configFile.DestDir, homeDir)
+	return configFile, nil
+}
+
+// RecordConfigTemplate records in s that the config file at path was generated
+// from the template with contents template.
+func RecordConfigTemplate(s PersistentState, path string, template []byte) error {
+	value, err := json.Marshal(&configHash{
+		TemplateSHA256: hexSHA256(template),
+	})
+	if err != nil {
+		return err
+	}
+	return s.Set(configHashBucket, []byte(path), value)
+}
+
+// ConfigTemplateChanged returns whether the config file at path was generated
+// from a template other than the one with contents template, as recorded in s
+// by RecordConfigTemplate. If nothing is recorded for path then it returns
+// false.
+func ConfigTemplateChanged(s PersistentState, path string, template []byte) (bool, error) {
+	value, err := s.Get(configHashBucket, []byte(path))
+	if err != nil || value == nil {
+		return false, err
+	}
+	var hash configHash
+	if err := json.Unmarshal(value, &hash); err != nil {
+		return false, fmt.Errorf("%s: %v", path, err)
+	}
+	return hash.TemplateSHA256 != hexSHA256(template), nil
+}
+
+// NewTargetState returns a new TargetState using cf's settings and data.
+// Settings that are not set in cf are taken from defaults.
+func (cf *ConfigFile) NewTargetState(defaults *ConfigFile, templateFuncs template.FuncMap) *TargetState {
+	sourceDir, destDir, umask := defaults.SourceDir, defaults.DestDir, os.FileMode(022)
+	if defaults.Umask != nil {
+		umask = *defaults.Umask
+	}
+	if cf.SourceDir != "" {
+		sourceDir = cf.SourceDir
+	}
+	if cf.DestDir != "" {
+		destDir = cf.DestDir
+	}
+	if cf.Umask != nil {
+		umask = *cf.Umask
+	}
+	data := make(map[string]interface{})
+	MergeData(data, defaults.Data)
+	MergeData(data, cf.Data)
+	return NewTargetState(destDir, umask, sourceDir, data, templateFuncs)
+}
+
+// expandTilde replaces a leading ~ in path with homeDir.
+func expandTilde(path, homeDir string) string {
+	switch {
+	case path == "~":
+		return homeDir
+	case strings.HasPrefix(path, "~/"):
+		return filepath.Join(homeDir, path[2:])
+	default:
+		return path
+	}
+}
@@ -0,0 +1,190 @@
+package chezmoi
+
+import (
+	"os"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	umask := os.FileMode(077)
+	for _, tc := range []struct {
+		name    string
+		root    interface{}
+		want    *ConfigFile
+		wantErr bool
+	}{
+		{
+			name: "toml",
+			root: map[string]interface{}{
+				"/home/user/.config/chezmoi/chezmoi.toml": "sourceDir = \"~/dotfiles\"\numask = 63\n[data]\n  email = \"user@home.org\"\n[data.git]\n  editor = \"vi\"\n",
+			},
+			want: &ConfigFile{
+				SourceDir: "/home/user/dotfiles",
+				Umask:     &umask,
+				Data: map[string]interface{}{
+					"email": "user@home.org",
+					"git": map[string]interface{}{
+						"editor": "vi",
+					},
+				},
+			},
+		},
+		{
+			name: "yaml",
+			root: map[string]interface{}{
+				"/home/user/.config/chezmoi/chezmoi.yaml": "destDir: /srv/home\ndata:\n  email: user@home.org\n  git:\n    editor: vi\n",
+			},
+			want: &ConfigFile{
+				DestDir: "/srv/home",
+				Data: map[string]interface{}{
+					"email": "user@home.org",
+					"git": map[string]interface{}{
+						"editor": "vi",
+					},
+				},
+			},
+		},
+		{
+			name: "json",
+			root: map[string]interface{}{
+				"/home/user/.config/chezmoi/chezmoi.json": `{"sourceDir": "/srv/dotfiles", "data": {"email": "user@home.org"}}`,
+			},
+			want: &ConfigFile{
+				SourceDir: "/srv/dotfiles",
+				Data: map[string]interface{}{
+					"email": "user@home.org",
+				},
+			},
+		},
+		{
+			name: "invalid",
+			root: map[string]interface{}{
+				"/home/user/.config/chezmoi/chezmoi.json": "{",
+			},
+			wantErr: true,
+		},
+		{
+			name: "none",
+			root: map[string]interface{}{
+				"/home/user/.config/chezmoi/chezmoi.ini": "",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(tc.root)
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			path, err := FindConfigFile(fs, []string{"/home/user/.local/config", "/home/user/.config"})
+			if err != nil {
+				t.Fatalf("FindConfigFile(...) == _, %v, want _, <nil>", err)
+			}
+			if tc.want == nil && !tc.wantErr {
+				if path != "" {
+					t.Errorf("FindConfigFile(...) == %q, <nil>, want \"\", <nil>", path)
+				}
+				return
+			}
+			got, err := ReadConfigFile(fs, path, "/home/user")
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ReadConfigFile(_, %q, _) == %+v, <nil>, want _, !<nil>", path, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadConfigFile(_, %q, _) == _, %v, want _, <nil>", path, err)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.want, got); !equal {
+				t.Errorf("ReadConfigFile(_, %q, _) diff:\n%s", path, diff)
+			}
+		})
+	}
+}
+
+func TestConfigFileNewTargetState(t *testing.T) {
+	umask := os.FileMode(077)
+	defaults := &ConfigFile{
+		SourceDir: "/home/user/.local/share/chezmoi",
+		DestDir:   "/home/user",
+		Data: map[string]interface{}{
+			"git": map[string]interface{}{
+				"editor": "vi",
+			},
+		},
+	}
+	cf := &ConfigFile{
+		SourceDir: "/home/user/dotfiles",
+		Umask:     &umask,
+		Data: map[string]interface{}{
+			"git": map[string]interface{}{
+				"email": "user@home.org",
+			},
+		},
+	}
+	ts := cf.NewTargetState(defaults, nil)
+	if ts.SourceDir != "/home/user/dotfiles" || ts.DestDir != "/home/user" || ts.Umask != 077 {
+		t.Errorf("cf.NewTargetState(...) == %+v, want SourceDir /home/user/dotfiles, DestDir /home/user, and Umask 077", ts)
+	}
+	wantData := map[string]interface{}{
+		"git": map[string]interface{}{
+			"editor": "vi",
+			"email":  "user@home.org",
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(wantData, ts.Data); !equal {
+		t.Errorf("cf.NewTargetState(...).Data diff:\n%s", diff)
+	}
+	if _, ok := defaults.Data["git"].(map[string]interface{})["email"]; ok {
+		t.Errorf("cf.NewTargetState(...) modified defaults.Data")
+	}
+}
+
+func TestConfigTemplateChanged(t *testing.T) {
+	s := NewMemoryPersistentState()
+	path := "/home/user/.config/chezmoi/chezmoi.toml"
+	template := []byte(`email = "{{ promptString "email" }}"`)
+	for _, tc := range []struct {
+		name     string
+		record   []byte
+		template []byte
+		want     bool
+	}{
+		{
+			name:     "not_recorded",
+			template: template,
+			want:     false,
+		},
+		{
+			name:     "unchanged",
+			record:   template,
+			template: template,
+			want:     false,
+		},
+		{
+			name:     "changed",
+			record:   template,
+			template: []byte(`email = "{{ promptString "work email" }}"`),
+			want:     true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.record != nil {
+				if err := RecordConfigTemplate(s, path, tc.record); err != nil {
+					t.Fatalf("RecordConfigTemplate(_, %q, _) == %v, want <nil>", path, err)
+				}
+			}
+			got, err := ConfigTemplateChanged(s, path, tc.template)
+			if err != nil {
+				t.Fatalf("ConfigTemplateChanged(_, %q, _) == _, %v, want _, <nil>", path, err)
+			}
+			if got != tc.want {
+				t.Errorf("ConfigTemplateChanged(_, %q, _) == %v, _, want %v, _", path, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package chezmoi
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// contentHashBucket caches the SHA256s of the contents of files, keyed by
+// path.
+var contentHashBucket = []byte("contentHash")
+
+// A contentHash is the SHA256 of the contents of a file, with the size and
+// modification time, in Unix nanoseconds, of the file when it was computed.
+type contentHash struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	SHA256  string `json:"sha256"`
+}
+
+// A ContentHashCache caches the SHA256s of the contents of files, keyed by
+// path, size, and modification time, so that files that have not changed are
+// not read again, even by later commands. Hashes are read from a
+// PersistentState and new hashes are held in memory until they are written
+// with Flush. It is safe for concurrent use.
+type ContentHashCache struct {
+	mu              sync.Mutex
+	persistentState PersistentState
+	hashes          map[string]*contentHash
+}
+
+// NewContentHashCache returns a new ContentHashCache that reads hashes from
+// persistentState.
+func NewContentHashCache(persistentState PersistentState) *ContentHashCache {
+	return &ContentHashCache{
+		persistentState: persistentState,
+		hashes:          make(map[string]*contentHash),
+	}
+}
+
+// Dirty returns true if c has new hashes that have not been written.
+func (c *ContentHashCache) Dirty() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.hashes) != 0
+}
+
+// FileSHA256 returns the hex-encoded SHA256 of the contents of the regular
+// file at path in fs, whose os.FileInfo is info. The file is only read if no
+// hash is cached for its size and modification time. If c is nil then the
+// file is always read. Files modified in the current second might be modified
+// again without changing their modification time, so their hashes are not
+// cached.
+func (c *ContentHashCache) FileSHA256(fs vfs.FS, path string, info os.FileInfo) (string, error) {
+	if c == nil {
+		return fileSHA256(fs, path)
+	}
+	hash, err := c.get(path)
+	if err != nil {
+		return "", err
+	}
+	if hash != nil && hash.Size == info.Size() && hash.ModTime == info.ModTime().UnixNano() {
+		return hash.SHA256, nil
+	}
+	sha256, err := fileSHA256(fs, path)
+	if err != nil {
+		return "", err
+	}
+	if info.ModTime().UnixNano() < time.Now().Truncate(time.Second).UnixNano() {
+		c.mu.Lock()
+		c.hashes[path] = &contentHash{
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixNano(),
+			SHA256:  sha256,
+		}
+		c.mu.Unlock()
+	}
+	return sha256, nil
+}
+
+// Flush writes the new hashes in c to persistentState, all at once if
+// persistentState supports it.
+func (c *ContentHashCache) Flush(persistentState PersistentState) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	paths := make([]string, 0, len(c.hashes))
+	for path := range c.hashes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if err := batchPersistentState(persistentState, func() error {
+		for _, path := range paths {
+			value, err := json.Marshal(c.hashes[path])
+			if err != nil {
+				return err
+			}
+			if err := persistentState.Set(contentHashBucket, []byte(path), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	c.hashes = make(map[string]*contentHash)
+	return nil
+}
+
+// get returns the hash of path, or nil if no hash is cached.
+func (c *ContentHashCache) get(path string) (*contentHash, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hash, ok := c.hashes[path]; ok {
+		return hash, nil
+	}
+	if c.persistentState == nil {
+		return nil, nil
+	}
+	value, err := c.persistentState.Get(contentHashBucket, []byte(path))
+	if err != nil || value == nil {
+		return nil, err
+	}
+	var hash contentHash
+	if err := json.Unmarshal(value, &hash); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &hash, nil
+}
+
+// fileSHA256 returns the hex-encoded SHA256 of the contents of the file at
+// path in fs, streaming them.
+func fileSHA256(fs vfs.FS, path string) (string, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return readerHexSHA256(file)
+}
@@ -0,0 +1,96 @@
+package chezmoi
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestContentHashCache(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.bashrc": "# old\n",
+		"/home/user/.hgrc":   "# hgrc\n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	modTime := time.Now().Add(-time.Hour)
+	if err := fs.Chtimes("/home/user/.bashrc", modTime, modTime); err != nil {
+		t.Fatalf("fs.Chtimes(...) == %v, want <nil>", err)
+	}
+	fileSHA256 := func(c *ContentHashCache, path string) string {
+		info, err := fs.Lstat(path)
+		if err != nil {
+			t.Fatalf("fs.Lstat(%q) == _, %v, want _, <nil>", path, err)
+		}
+		sha256, err := c.FileSHA256(fs, path, info)
+		if err != nil {
+			t.Fatalf("c.FileSHA256(_, %q, _) == _, %v, want _, <nil>", path, err)
+		}
+		return sha256
+	}
+
+	persistentState := NewMemoryPersistentState()
+	c1 := NewContentHashCache(persistentState)
+	if got, want := fileSHA256(c1, "/home/user/.bashrc"), hexSHA256([]byte("# old\n")); got != want {
+		t.Errorf("c1.FileSHA256(_, %q, _) == %q, want %q", "/home/user/.bashrc", got, want)
+	}
+	// Files modified in the current second are not cached.
+	if got, want := fileSHA256(c1, "/home/user/.hgrc"), hexSHA256([]byte("# hgrc\n")); got != want {
+		t.Errorf("c1.FileSHA256(_, %q, _) == %q, want %q", "/home/user/.hgrc", got, want)
+	}
+	if !c1.Dirty() {
+		t.Errorf("c1.Dirty() == false, want true")
+	}
+	if err := c1.Flush(persistentState); err != nil {
+		t.Fatalf("c1.Flush(_) == %v, want <nil>", err)
+	}
+	if c1.Dirty() {
+		t.Errorf("c1.Dirty() == true, want false")
+	}
+	if value, _ := persistentState.Get(contentHashBucket, []byte("/home/user/.hgrc")); value != nil {
+		t.Errorf("persistentState.Get(%q, %q) == %q, want <nil>", contentHashBucket, "/home/user/.hgrc", value)
+	}
+
+	// Change the contents without changing the size or modification time, so
+	// that a later cache returns the cached hash without reading the file.
+	if err := fs.WriteFile("/home/user/.bashrc", []byte("# new\n"), 0666); err != nil {
+		t.Fatalf("fs.WriteFile(...) == %v, want <nil>", err)
+	}
+	if err := fs.Chtimes("/home/user/.bashrc", modTime, modTime); err != nil {
+		t.Fatalf("fs.Chtimes(...) == %v, want <nil>", err)
+	}
+	c2 := NewContentHashCache(persistentState)
+	if got, want := fileSHA256(c2, "/home/user/.bashrc"), hexSHA256([]byte("# old\n")); got != want {
+		t.Errorf("c2.FileSHA256(_, %q, _) == %q, want %q", "/home/user/.bashrc", got, want)
+	}
+
+	// Changing the modification time makes the file be read again.
+	modTime = modTime.Add(time.Minute)
+	if err := fs.Chtimes("/home/user/.bashrc", modTime, modTime); err != nil {
+		t.Fatalf("fs.Chtimes(...) == %v, want <nil>", err)
+	}
+	if got, want := fileSHA256(c2, "/home/user/.bashrc"), hexSHA256([]byte("# new\n")); got != want {
+		t.Errorf("c2.FileSHA256(_, %q, _) == %q, want %q", "/home/user/.bashrc", got, want)
+	}
+}
+
+func BenchmarkFileSHA256(b *testing.B) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.bashrc": strings.Repeat("# contents\n", 1024),
+	})
+	defer cleanup()
+	if err != nil {
+		b.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fileSHA256(fs, "/home/user/.bashrc"); err != nil {
+			b.Fatalf("fileSHA256(_, %q) == _, %v, want _, <nil>", "/home/user/.bashrc", err)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package chezmoi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	vfs "github.com/twpayne/go-vfs"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// dataFileName is the name, without extension, of files in the source
+// directory that contain template data.
+const dataFileName = ".chezmoidata"
+
+// builtinDataSource is the DataProvenance source of data that chezmoi
+// provides itself.
+const builtinDataSource = "built-in"
+
+// A DataProvenance maps the dot-separated path of each value in template data
+// to the source that set it, for example a config file or a .chezmoidata file.
+type DataProvenance map[string]string
+
+// formats maps file extensions to functions that decode them.
+var formats = map[string]func([]byte, interface{}) error{
+	"json": json.Unmarshal,
+	"toml": toml.Unmarshal,
+	"yaml": yaml.Unmarshal,
+}
+
+// DataFromCommand runs cmd and returns its output decoded as format, which
+// must be one of "json", "toml", or "yaml".
+func DataFromCommand(cmd *exec.Cmd, format string) (map[string]interface{}, error) {
+	output, err := commandOutput(cmd)
+	if err != nil {
+		return nil, err
+	}
+	data, err := decodeData(format, output)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", strings.Join(cmd.Args, " "), err)
+	}
+	return data, nil
+}
+
+// DefaultData returns facts about the machine and the current user: its
+// arch, os, fullHostname, hostname, username, group, and homeDir. group is
+// omitted if it cannot be looked up.
+func DefaultData() (map[string]interface{}, error) {
+	data := map[string]interface{}{
+		"arch": runtime.GOARCH,
+		"os":   runtime.GOOS,
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	data["fullHostname"] = hostname
+	data["hostname"] = strings.SplitN(hostname, ".", 2)[0]
+
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	data["username"] = currentUser.Username
+	data["homeDir"] = currentUser.HomeDir
+	if group, err := user.LookupGroupId(currentUser.Gid); err == nil {
+		data["group"] = group.Name
+	}
+
+	return data, nil
+}
+
+// MergeData recursively merges src into dst. Maps that are in both are merged,
+// and other values in src replace those in dst. Maps in src are copied, so
+// later merges into dst never modify src.
+func MergeData(dst, src map[string]interface{}) {
+	for key, srcValue := range src {
+		srcMap, ok := srcValue.(map[string]interface{})
+		if !ok {
+			dst[key] = srcValue
+			continue
+		}
+		dstMap, ok := dst[key].(map[string]interface{})
+		if !ok {
+			dstMap = make(map[string]interface{})
+			dst[key] = dstMap
+		}
+		MergeData(dstMap, srcMap)
+	}
+}
+
+// MergeData merges src into dst, as MergeData does, and records that the
+// values in src came from source.
+func (p DataProvenance) MergeData(dst, src map[string]interface{}, source string) {
+	MergeData(dst, src)
+	p.add("", src, source)
+}
+
+// add records that the values in data, whose paths start with prefix, came
+// from source.
+func (p DataProvenance) add(prefix string, data map[string]interface{}, source string) {
+	for key, value := range data {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if len(value) != 0 {
+				p.add(prefix+key+".", value, source)
+				continue
+			}
+		case map[string]string:
+			if len(value) != 0 {
+				for k := range value {
+					p.set(prefix+key+"."+k, source)
+				}
+				continue
+			}
+		}
+		p.set(prefix+key, source)
+	}
+}
+
+// merge records the sources in other, which take precedence over those in p.
+func (p DataProvenance) merge(other DataProvenance) {
+	for path, source := range other {
+		p.set(path, source)
+	}
+}
+
+// set records that the value at path came from source. The value replaces any
+// values that contain it or are contained by it.
+func (p DataProvenance) set(path, source string) {
+	for existingPath := range p {
+		if strings.HasPrefix(existingPath, path+".") || strings.HasPrefix(path, existingPath+".") {
+			delete(p, existingPath)
+		}
+	}
+	p[path] = source
+}
+
+// addDefaultData adds DefaultData, osRelease, and sourceDir to the chezmoi map
+// in ts.Data. Values already in the map take precedence over DefaultData and
+// osRelease, but sourceDir is always ts.SourceDir.
+func (ts *TargetState) addDefaultData(fs vfs.FS) error {
+	defaultData, err := DefaultData()
+	if err != nil {
+		return err
+	}
+	chezmoiData := make(map[string]interface{})
+	MergeData(chezmoiData, defaultData)
+	existingData, _ := ts.Data["chezmoi"].(map[string]interface{})
+	if _, ok := existingData["osRelease"]; !ok {
+		osRelease, err := OSRelease(fs)
+		if err == nil {
+			chezmoiData["osRelease"] = osRelease
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	MergeData(chezmoiData, existingData)
+	chezmoiData["sourceDir"] = ts.SourceDir
+	data := make(map[string]interface{})
+	MergeData(data, ts.Data)
+	data["chezmoi"] = chezmoiData
+	ts.Data = data
+	if ts.DataProvenance != nil {
+		provenance := make(DataProvenance)
+		provenance.add("chezmoi.", defaultData, builtinDataSource)
+		if osRelease, ok := chezmoiData["osRelease"]; ok && existingData["osRelease"] == nil {
+			provenance.add("chezmoi.", map[string]interface{}{
+				"osRelease": osRelease,
+			}, builtinDataSource)
+		}
+		provenance.merge(ts.DataProvenance)
+		provenance.set("chezmoi.sourceDir", builtinDataSource)
+		ts.DataProvenance = provenance
+	}
+	return nil
+}
+
+// readData merges the data in the .chezmoidata files in ts.SourceDir into
+// ts.Data. Files are read in name order, and values in ts.Data take precedence
+// over values in the files.
+func (ts *TargetState) readData(fs vfs.FS) error {
+	infos, err := fs.ReadDir(ts.SourceDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var paths []string
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), dataFileName+".") && info.Mode().IsRegular() {
+			paths = append(paths, filepath.Join(ts.SourceDir, info.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	sort.Strings(paths)
+	data := make(map[string]interface{})
+	provenance := make(DataProvenance)
+	for _, path := range paths {
+		contents, err := fs.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fileData, err := decodeData(strings.TrimPrefix(filepath.Ext(path), "."), contents)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		provenance.MergeData(data, fileData, filepath.Base(path))
+	}
+	MergeData(data, ts.Data)
+	ts.Data = data
+	if ts.DataProvenance != nil {
+		provenance.merge(ts.DataProvenance)
+		ts.DataProvenance = provenance
+	}
+	return nil
+}
+
+// commandOutput runs cmd and returns its standard output. If cmd fails then
+// the error includes its standard error.
+func commandOutput(cmd *exec.Cmd) ([]byte, error) {
+	name := strings.Join(cmd.Args, " ")
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) != 0 {
+			return nil, fmt.Errorf("%s: %v: %s", name, err, bytes.TrimSpace(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return output, nil
+}
+
+// decodeData decodes data in format.
+func decodeData(format string, data []byte) (map[string]interface{}, error) {
+	unmarshal, ok := formats[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown format", format)
+	}
+	var result map[string]interface{}
+	if err := unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	for key, value := range result {
+		result[key] = stringKeys(value)
+	}
+	return result, nil
+}
+
+// stringKeys returns value with all maps converted to maps with string keys,
+// as YAML decodes maps with interface{} keys.
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			result[fmt.Sprint(k)] = stringKeys(v)
+		}
+		return result
+	case []interface{}:
+		for i, v := range value {
+			value[i] = stringKeys(v)
+		}
+		return value
+	default:
+		return value
+	}
+}
@@ -0,0 +1,285 @@
+package chezmoi
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateReadData(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		root    interface{}
+		data    map[string]interface{}
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "merge",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi": map[string]interface{}{
+					".chezmoidata.json": `{"email": "json@example.com", "git": {"editor": "vi"}}`,
+					".chezmoidata.toml": "[git]\n  name = \"TOML\"\n",
+					".chezmoidata.yaml": "git:\n  signingkey: ABCD\nhosts:\n- name: work\n  ip: 192.168.0.1\n",
+				},
+			},
+			data: map[string]interface{}{
+				"email": "config@example.com",
+			},
+			want: map[string]interface{}{
+				"email": "config@example.com",
+				"git": map[string]interface{}{
+					"editor":     "vi",
+					"name":       "TOML",
+					"signingkey": "ABCD",
+				},
+				"hosts": []interface{}{
+					map[string]interface{}{
+						"name": "work",
+						"ip":   "192.168.0.1",
+					},
+				},
+			},
+		},
+		{
+			name: "no_data_files",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/dot_bashrc": "# contents of .bashrc\n",
+			},
+			data: map[string]interface{}{
+				"email": "config@example.com",
+			},
+			want: map[string]interface{}{
+				"email": "config@example.com",
+			},
+		},
+		{
+			name: "unknown_format",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/.chezmoidata.ini": "email = ini@example.com\n",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/.chezmoidata.json": "{",
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(tc.root)
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", tc.data, nil)
+			err = ts.Populate(fs)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ts.Populate(%+v) == <nil>, want !<nil>", fs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+			}
+			if diff, equal := messagediff.PrettyDiff(withDefaultData(t, tc.want, ts.SourceDir), ts.Data); !equal {
+				t.Errorf("ts.Data diff:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTargetStateDefaultData(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi/.chezmoiroot": "home\n",
+		"/home/user/.chezmoi/home":         &vfst.Dir{Perm: 0755},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	data := map[string]interface{}{
+		"chezmoi": map[string]interface{}{
+			"hostname":  "example",
+			"osRelease": map[string]interface{}{"id": "debian"},
+			"sourceDir": "/tmp",
+		},
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", data, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	chezmoiData := ts.Data["chezmoi"].(map[string]interface{})
+	for key, want := range map[string]interface{}{
+		"arch":      runtime.GOARCH,
+		"hostname":  "example",
+		"os":        runtime.GOOS,
+		"sourceDir": "/home/user/.chezmoi/home",
+	} {
+		if got := chezmoiData[key]; got != want {
+			t.Errorf("ts.Data[\"chezmoi\"][%q] == %v, want %v", key, got, want)
+		}
+	}
+	if _, ok := chezmoiData["osRelease"]; !ok {
+		t.Errorf("ts.Data[\"chezmoi\"][\"osRelease\"] missing")
+	}
+	for _, key := range []string{"fullHostname", "homeDir", "username"} {
+		if _, ok := chezmoiData[key].(string); !ok {
+			t.Errorf("ts.Data[\"chezmoi\"][%q] == %v, want a string", key, chezmoiData[key])
+		}
+	}
+	if got := data["chezmoi"].(map[string]interface{})["sourceDir"]; got != "/tmp" {
+		t.Errorf("data[\"chezmoi\"][\"sourceDir\"] == %v, want /tmp", got)
+	}
+}
+
+func TestTargetStateDataProvenance(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			".chezmoidata.json": `{"email": "user@home.org", "git": {"name": "User", "editor": {"name": "vi"}}}`,
+			".chezmoidata.toml": "[git]\neditor = \"vim\"\n",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	data := make(map[string]interface{})
+	provenance := make(DataProvenance)
+	provenance.MergeData(data, map[string]interface{}{
+		"chezmoi": map[string]interface{}{
+			"hostname": "example",
+		},
+	}, "cmd")
+	provenance.MergeData(data, map[string]interface{}{
+		"email": "user@company.com",
+	}, "config")
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", data, nil)
+	ts.DataProvenance = provenance
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	for path, want := range map[string]string{
+		"chezmoi.arch":      "built-in",
+		"chezmoi.hostname":  "cmd",
+		"chezmoi.sourceDir": "built-in",
+		"email":             "config",
+		"git.editor":        ".chezmoidata.toml",
+		"git.name":          ".chezmoidata.json",
+	} {
+		if got := ts.DataProvenance[path]; got != want {
+			t.Errorf("ts.DataProvenance[%q] == %q, want %q", path, got, want)
+		}
+	}
+	if got, ok := ts.DataProvenance["git.editor.name"]; ok {
+		t.Errorf("ts.DataProvenance[\"git.editor.name\"] == %q, want no value", got)
+	}
+}
+
+func TestDataFromCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		format  string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:   "json",
+			args:   []string{"echo", `{"inventory": {"rack": "a1"}}`},
+			format: "json",
+			want: map[string]interface{}{
+				"inventory": map[string]interface{}{
+					"rack": "a1",
+				},
+			},
+		},
+		{
+			name:   "yaml",
+			args:   []string{"echo", "inventory:\n  rack: a1"},
+			format: "YAML",
+			want: map[string]interface{}{
+				"inventory": map[string]interface{}{
+					"rack": "a1",
+				},
+			},
+		},
+		{
+			name:    "unknown_format",
+			args:    []string{"echo", "rack=a1"},
+			format:  "ini",
+			wantErr: true,
+		},
+		{
+			name:    "failure",
+			args:    []string{"false"},
+			format:  "json",
+			wantErr: true,
+		},
+		{
+			name:    "invalid_output",
+			args:    []string{"echo", "{"},
+			format:  "json",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DataFromCommand(exec.Command(tc.args[0], tc.args[1:]...), tc.format)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("DataFromCommand(%v, %q) == %v, <nil>, want _, !<nil>", tc.args, tc.format, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DataFromCommand(%v, %q) == _, %v, want _, <nil>", tc.args, tc.format, err)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.want, got); !equal {
+				t.Errorf("DataFromCommand(%v, %q) diff:\n%s", tc.args, tc.format, diff)
+			}
+		})
+	}
+}
+
+// withDefaultData returns data with the default data that Populate adds for
+// sourceDir.
+func withDefaultData(t *testing.T, data map[string]interface{}, sourceDir string) map[string]interface{} {
+	defaultData, err := DefaultData()
+	if err != nil {
+		t.Fatalf("DefaultData() == _, %v, want _, <nil>", err)
+	}
+	defaultData["sourceDir"] = sourceDir
+	result := map[string]interface{}{
+		"chezmoi": defaultData,
+	}
+	MergeData(result, data)
+	return result
+}
+
+func TestTargetStateOSRelease(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/etc/os-release":                  "ID=ubuntu\nID_LIKE=debian\nVERSION_ID=\"18.04\"\n",
+		"/home/user/.chezmoi/dot_foo.tmpl": "{{ .chezmoi.osRelease.id }} {{ .chezmoi.osRelease.idLike }} {{ .chezmoi.osRelease.versionID }}",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	got, err := ts.Entries[".foo"].(*File).Contents()
+	if err != nil {
+		t.Fatalf("file.Contents() == _, %v, want _, <nil>", err)
+	}
+	if want := "ubuntu debian 18.04"; string(got) != want {
+		t.Errorf("file.Contents() == %q, _, want %q, _", got, want)
+	}
+}
@@ -0,0 +1,21 @@
+package chezmoi
+
+// A Decryptor decrypts the contents of encrypted source files.
+type Decryptor interface {
+	Decrypt(name string, ciphertext []byte) ([]byte, error)
+}
+
+type nullDecryptor struct{}
+
+// NullDecryptor is a Decryptor that returns its ciphertext unchanged.
+var NullDecryptor nullDecryptor
+
+// Decrypt implements Decryptor.Decrypt.
+func (nullDecryptor) Decrypt(name string, ciphertext []byte) ([]byte, error) {
+	return ciphertext, nil
+}
+
+// An Encryptor encrypts the contents of source files.
+type Encryptor interface {
+	Encrypt(name string, plaintext []byte) ([]byte, error)
+}
@@ -0,0 +1,485 @@
+package chezmoi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/pmezard/go-difflib/difflib"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// A DiffFormat is a format of diffs.
+type DiffFormat string
+
+// Diff formats.
+const (
+	// DiffFormatUnified is a plain unified diff, with operations that do not
+	// change the contents of files written as shell commands.
+	DiffFormatUnified DiffFormat = "unified"
+	// DiffFormatGit is a git patch that can be applied with git apply.
+	// Operations that git cannot represent, like creating empty directories
+	// and running scripts, are omitted.
+	DiffFormatGit DiffFormat = "git"
+)
+
+// DiffOptions are options to WriteDiff.
+type DiffOptions struct {
+	Format  DiffFormat
+	DestDir string // DestDir is the directory that paths in git patches are relative to.
+	Color   bool   // Color enables ANSI colors, including highlighting of changed words within lines.
+	// Redact, if not nil, is called on each line of file contents before it is
+	// colored, so that secrets are not split by escape sequences.
+	Redact func(string) string
+	// MaxMemorySize, if positive, is the size of the largest removed file
+	// that is read to diff it. Larger files, like files that are copied
+	// because they are too large to hold in memory, are diffed as binary
+	// files.
+	MaxMemorySize int64
+}
+
+// ANSI escape sequences used in colored diffs.
+const (
+	ansiBold    = "\x1b[1m"
+	ansiCyan    = "\x1b[36m"
+	ansiGreen   = "\x1b[32m"
+	ansiRed     = "\x1b[31m"
+	ansiReverse = "\x1b[7m"
+	ansiReset   = "\x1b[0m"
+)
+
+// minHighlightRatio is the minimum similarity of a removed and an added line
+// for the changed words within them to be highlighted. Less similar lines are
+// considered completely changed.
+const minHighlightRatio = 0.5
+
+// A diffWriter writes diffs of operations.
+type diffWriter struct {
+	w       io.Writer
+	fs      vfs.FS
+	options DiffOptions
+}
+
+// Diff writes diffs of the changes that applying ts would make to fs to w and
+// returns a Summary of them. Nothing in fs is changed.
+func (ts *TargetState) Diff(fs vfs.FS, w io.Writer, diffOptions DiffOptions) (*Summary, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	plan, err := ts.planAll(context.Background(), fs)
+	if err != nil {
+		return nil, err
+	}
+	operations := plan.Operations
+	if diffOptions.DestDir == "" {
+		diffOptions.DestDir = ts.DestDir
+	}
+	if diffOptions.MaxMemorySize == 0 {
+		diffOptions.MaxMemorySize = ts.MaxMemorySize
+	}
+	if err := WriteDiff(w, fs, operations, diffOptions); err != nil {
+		return nil, err
+	}
+	return NewSummary(operations), nil
+}
+
+// WriteDiff writes diffs of operations, which have not yet been executed on
+// fs, to w. Created and removed files are diffed against /dev/null.
+func WriteDiff(w io.Writer, fs vfs.FS, operations []Operation, diffOptions DiffOptions) error {
+	dw := &diffWriter{
+		w:       w,
+		fs:      fs,
+		options: diffOptions,
+	}
+	for _, o := range operations {
+		var err error
+		if diffOptions.Format == DiffFormatGit {
+			err = dw.writeGitOperation(o)
+		} else {
+			err = dw.writeUnifiedOperation(o)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffLines splits s into lines for diffing. The last line does not end with
+// a newline if s does not. Empty contents have no lines.
+func diffLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// formatRange formats the range of lines from start to stop in a hunk header.
+func formatRange(start, stop int) string {
+	switch length := stop - start; length {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, length)
+	}
+}
+
+// gitMode returns the mode of a file with permissions perm as written in git
+// patches.
+func gitMode(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return "120000"
+	case mode.Perm()&0111 != 0:
+		return "100755"
+	default:
+		return "100644"
+	}
+}
+
+// gitPath returns the path of name in a git patch, prefixed with prefix.
+func (dw *diffWriter) gitPath(prefix, name string) string {
+	if relPath, err := filepath.Rel(dw.options.DestDir, name); err == nil {
+		name = relPath
+	}
+	return prefix + filepath.ToSlash(name)
+}
+
+// printf writes to dw.
+func (dw *diffWriter) printf(format string, args ...interface{}) error {
+	_, err := fmt.Fprintf(dw.w, format, args...)
+	return err
+}
+
+// tooLarge returns true if the file whose os.FileInfo is info is too large to
+// read to diff it.
+func (dw *diffWriter) tooLarge(info os.FileInfo) bool {
+	return dw.options.MaxMemorySize > 0 && info.Size() > dw.options.MaxMemorySize
+}
+
+// writeBinaryFiles writes that the binary files fromFile and toFile differ.
+func (dw *diffWriter) writeBinaryFiles(fromFile, toFile string) error {
+	return dw.writeLine(ansiBold, "Binary files "+fromFile+" and "+toFile+" differ")
+}
+
+// writeGitBinaryFile writes a git patch of changing the file name from oldMode
+// to newMode and its contents to unknown binary contents.
+func (dw *diffWriter) writeGitBinaryFile(name string, oldMode, newMode os.FileMode) error {
+	fromFile, toFile, err := dw.writeGitHeader(name, oldMode, newMode)
+	if err != nil {
+		return err
+	}
+	return dw.writeBinaryFiles(fromFile, toFile)
+}
+
+// writeGitFile writes a git patch of changing the file name from oldMode and
+// oldData to newMode and newData. A zero mode means that the file does not
+// exist.
+func (dw *diffWriter) writeGitFile(name string, oldMode os.FileMode, oldData []byte, newMode os.FileMode, newData []byte) error {
+	fromFile, toFile, err := dw.writeGitHeader(name, oldMode, newMode)
+	if err != nil {
+		return err
+	}
+	if string(oldData) == string(newData) {
+		return nil
+	}
+	return dw.writeUnifiedFile(fromFile, toFile, oldData, newData)
+}
+
+// writeGitHeader writes the header of a git patch of changing the file name
+// from oldMode to newMode and returns the names of the old and new files to use
+// in the diff of its contents.
+func (dw *diffWriter) writeGitHeader(name string, oldMode, newMode os.FileMode) (string, string, error) {
+	aPath, bPath := dw.gitPath("a/", name), dw.gitPath("b/", name)
+	if err := dw.writeLine(ansiBold, "diff --git "+aPath+" "+bPath); err != nil {
+		return "", "", err
+	}
+	fromFile, toFile := aPath, bPath
+	switch {
+	case oldMode == 0:
+		fromFile = "/dev/null"
+		if err := dw.writeLine(ansiBold, "new file mode "+gitMode(newMode)); err != nil {
+			return "", "", err
+		}
+	case newMode == 0:
+		toFile = "/dev/null"
+		if err := dw.writeLine(ansiBold, "deleted file mode "+gitMode(oldMode)); err != nil {
+			return "", "", err
+		}
+	case gitMode(oldMode) != gitMode(newMode):
+		if err := dw.writeLine(ansiBold, "old mode "+gitMode(oldMode)); err != nil {
+			return "", "", err
+		}
+		if err := dw.writeLine(ansiBold, "new mode "+gitMode(newMode)); err != nil {
+			return "", "", err
+		}
+	}
+	return fromFile, toFile, nil
+}
+
+// writeGitOperation writes o as a git patch.
+func (dw *diffWriter) writeGitOperation(o Operation) error {
+	switch o.Type {
+	case OperationChmod:
+		info, err := dw.fs.Lstat(o.Name)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		// The contents do not change, so they are not needed.
+		return dw.writeGitFile(o.Name, info.Mode(), nil, o.Mode, nil)
+	case OperationCreate:
+		if o.Source != "" {
+			return dw.writeGitBinaryFile(o.Name, 0, o.Mode)
+		}
+		return dw.writeGitFile(o.Name, 0, nil, o.Mode, o.Data)
+	case OperationOverwrite:
+		oldMode := o.Mode
+		if info, err := dw.fs.Lstat(o.Name); err == nil {
+			oldMode = info.Mode()
+		}
+		if o.Source != "" {
+			return dw.writeGitBinaryFile(o.Name, oldMode, o.Mode)
+		}
+		return dw.writeGitFile(o.Name, oldMode, o.CurrData, o.Mode, o.Data)
+	case OperationRemove:
+		return vfs.Walk(dw.fs, o.Name, func(path string, info os.FileInfo, err error) error {
+			switch {
+			case os.IsNotExist(err):
+				return nil
+			case err != nil:
+				return err
+			case info.Mode().IsRegular() && dw.tooLarge(info):
+				return dw.writeGitBinaryFile(path, info.Mode(), 0)
+			case info.Mode().IsRegular():
+				data, err := dw.fs.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				return dw.writeGitFile(path, info.Mode(), data, 0, nil)
+			case info.Mode()&os.ModeSymlink != 0:
+				linkname, err := dw.fs.Readlink(path)
+				if err != nil {
+					return err
+				}
+				return dw.writeGitFile(path, info.Mode(), []byte(linkname), 0, nil)
+			default:
+				return nil
+			}
+		})
+	case OperationSymlink:
+		var oldMode os.FileMode
+		var oldData []byte
+		if info, err := dw.fs.Lstat(o.Name); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			linkname, err := dw.fs.Readlink(o.Name)
+			if err != nil {
+				return err
+			}
+			oldMode, oldData = info.Mode(), []byte(linkname)
+		}
+		return dw.writeGitFile(o.Name, oldMode, oldData, os.ModeSymlink, []byte(o.OldName))
+	default:
+		return nil
+	}
+}
+
+// writeHunks writes the hunks of the unified diff from a to b, with three
+// lines of context.
+func (dw *diffWriter) writeHunks(a, b []string) error {
+	for _, group := range difflib.NewMatcher(a, b).GetGroupedOpCodes(3) {
+		first, last := group[0], group[len(group)-1]
+		if err := dw.writeLine(ansiCyan, "@@ -"+formatRange(first.I1, last.I2)+" +"+formatRange(first.J1, last.J2)+" @@"); err != nil {
+			return err
+		}
+		for _, c := range group {
+			switch c.Tag {
+			case 'd':
+				if err := dw.writeLines('-', a[c.I1:c.I2], nil); err != nil {
+					return err
+				}
+			case 'e':
+				if err := dw.writeLines(' ', a[c.I1:c.I2], nil); err != nil {
+					return err
+				}
+			case 'i':
+				if err := dw.writeLines('+', b[c.J1:c.J2], nil); err != nil {
+					return err
+				}
+			case 'r':
+				if err := dw.writeLines('-', a[c.I1:c.I2], b[c.J1:c.J2]); err != nil {
+					return err
+				}
+				if err := dw.writeLines('+', b[c.J1:c.J2], a[c.I1:c.I2]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeLine writes line, colored with code if colors are enabled and code is
+// not empty.
+func (dw *diffWriter) writeLine(code, line string) error {
+	if dw.options.Color && code != "" {
+		return dw.printf("%s%s%s\n", code, line, ansiReset)
+	}
+	return dw.printf("%s\n", line)
+}
+
+// writeLines writes lines, each prefixed with prefix. A line without a
+// trailing newline is marked as such. If colors are enabled, the words in each
+// line that differ from the corresponding line in others are highlighted.
+func (dw *diffWriter) writeLines(prefix byte, lines, others []string) error {
+	var code string
+	switch prefix {
+	case '-':
+		code = ansiRed
+	case '+':
+		code = ansiGreen
+	}
+	for i, line := range lines {
+		noNewline := !strings.HasSuffix(line, "\n")
+		line = strings.TrimSuffix(line, "\n")
+		if dw.options.Redact != nil {
+			line = dw.options.Redact(line)
+		}
+		if dw.options.Color && i < len(others) {
+			other := strings.TrimSuffix(others[i], "\n")
+			if dw.options.Redact != nil {
+				other = dw.options.Redact(other)
+			}
+			line = highlightWords(line, other, code)
+		}
+		if err := dw.writeLine(code, string(prefix)+line); err != nil {
+			return err
+		}
+		if noNewline {
+			if err := dw.printf("\\ No newline at end of file\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// highlightWords returns line with the words that do not appear in other
+// highlighted, restoring code after each highlighted word. Nothing is
+// highlighted if line and other are too different.
+func highlightWords(line, other, code string) string {
+	words, otherWords := splitWords(line), splitWords(other)
+	m := difflib.NewMatcher(words, otherWords)
+	if m.Ratio() < minHighlightRatio {
+		return line
+	}
+	sb := &strings.Builder{}
+	for _, c := range m.GetOpCodes() {
+		word := strings.Join(words[c.I1:c.I2], "")
+		if c.Tag == 'e' || word == "" {
+			sb.WriteString(word)
+			continue
+		}
+		sb.WriteString(ansiReverse + word + ansiReset + code)
+	}
+	return sb.String()
+}
+
+// splitWords splits s into words, runs of whitespace, and single punctuation
+// characters.
+func splitWords(s string) []string {
+	var words []string
+	start := 0
+	isWord := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+	}
+	runes := []rune(s)
+	for i := 1; i <= len(runes); i++ {
+		if i < len(runes) {
+			prev, r := runes[i-1], runes[i]
+			if isWord(prev) && isWord(r) || unicode.IsSpace(prev) && unicode.IsSpace(r) {
+				continue
+			}
+		}
+		words = append(words, string(runes[start:i]))
+		start = i
+	}
+	return words
+}
+
+// writeUnifiedFile writes the unified diff from the contents a of fromFile to
+// the contents b of toFile.
+func (dw *diffWriter) writeUnifiedFile(fromFile, toFile string, a, b []byte) error {
+	if isBinary(a) || isBinary(b) {
+		return dw.writeBinaryFiles(fromFile, toFile)
+	}
+	if err := dw.writeLine(ansiBold, "--- "+fromFile); err != nil {
+		return err
+	}
+	if err := dw.writeLine(ansiBold, "+++ "+toFile); err != nil {
+		return err
+	}
+	return dw.writeHunks(diffLines(string(a)), diffLines(string(b)))
+}
+
+// writeUnifiedOperation writes o as a unified diff. Operations that do not
+// change the contents of files are written as the equivalent shell commands.
+func (dw *diffWriter) writeUnifiedOperation(o Operation) error {
+	switch o.Type {
+	case OperationChmod:
+		return dw.printf("chmod %o %s\n", o.Mode, o.Name)
+	case OperationCreate:
+		if o.Source != "" {
+			return dw.writeBinaryFiles("/dev/null", o.Name)
+		}
+		return dw.writeUnifiedFile("/dev/null", o.Name, nil, o.Data)
+	case OperationMkdir:
+		return dw.printf("mkdir -m %o %s\n", o.Mode, o.Name)
+	case OperationOverwrite:
+		if info, err := dw.fs.Lstat(o.Name); err == nil && info.Mode().Perm() != o.Mode {
+			if err := dw.printf("chmod %o %s\n", o.Mode, o.Name); err != nil {
+				return err
+			}
+		}
+		if o.Source != "" {
+			return dw.writeBinaryFiles(o.Name, o.Name)
+		}
+		return dw.writeUnifiedFile(o.Name, o.Name, o.CurrData, o.Data)
+	case OperationRemove:
+		info, err := dw.fs.Lstat(o.Name)
+		switch {
+		case os.IsNotExist(err):
+			return nil
+		case err != nil:
+			return err
+		case info.Mode().IsRegular() && dw.tooLarge(info):
+			return dw.writeBinaryFiles(o.Name, "/dev/null")
+		case info.Mode().IsRegular():
+			data, err := dw.fs.ReadFile(o.Name)
+			if err != nil {
+				return err
+			}
+			return dw.writeUnifiedFile(o.Name, "/dev/null", data, nil)
+		default:
+			return dw.printf("rm -rf %s\n", o.Name)
+		}
+	case OperationRename:
+		return dw.printf("mv %s %s\n", o.OldName, o.Name)
+	case OperationRunScript:
+		return dw.printf("( cd %s && %s )\n", o.Dir, o.Name)
+	case OperationSymlink:
+		return dw.printf("ln -sf %s %s\n", o.OldName, o.Name)
+	default:
+		return nil
+	}
+}
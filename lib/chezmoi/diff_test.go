@@ -0,0 +1,282 @@
+package chezmoi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateDiff(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc": &vfst.File{
+				Perm:     0600,
+				Contents: []byte("# first line\n# old line\n# last line\n"),
+			},
+			".old": "# old\n",
+			".chezmoi": map[string]interface{}{
+				".chezmoiremove": ".old\n",
+				"dot_bashrc":     "# first line\n# new line\n# last line\n",
+				"dot_dir": map[string]interface{}{
+					"file":        "# contents of file\n",
+					"empty_empty": "",
+				},
+				"run_hello": "#!/bin/sh\necho hello\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	b := &bytes.Buffer{}
+	summary, err := ts.Diff(fs, b, DiffOptions{})
+	if err != nil {
+		t.Fatalf("ts.Diff(%+v, _, _) == _, %v, want _, <nil>", fs, err)
+	}
+	want := "" +
+		"--- /home/user/.old\n" +
+		"+++ /dev/null\n" +
+		"@@ -1 +0,0 @@\n" +
+		"-# old\n" +
+		"chmod 644 /home/user/.bashrc\n" +
+		"--- /home/user/.bashrc\n" +
+		"+++ /home/user/.bashrc\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		" # first line\n" +
+		"-# old line\n" +
+		"+# new line\n" +
+		" # last line\n" +
+		"mkdir -m 755 /home/user/.dir\n" +
+		"--- /dev/null\n" +
+		"+++ /home/user/.dir/empty\n" +
+		"--- /dev/null\n" +
+		"+++ /home/user/.dir/file\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+# contents of file\n" +
+		"( cd /home/user && hello )\n"
+	if got := b.String(); got != want {
+		t.Errorf("ts.Diff(%+v, _, _) wrote\n%s\nwant\n%s", fs, got, want)
+	}
+	wantSummary := &Summary{
+		FilesAdded:    2,
+		FilesModified: 1,
+		FilesRemoved:  1,
+		DirsCreated:   1,
+		ScriptsRun:    1,
+		BytesWritten:  55,
+	}
+	if diff, equal := messagediff.PrettyDiff(wantSummary, summary); !equal {
+		t.Errorf("ts.Diff(%+v, _, _) summary diff:\n%s", fs, diff)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.old",
+			vfst.TestContentsString("# old\n"),
+		),
+		vfst.TestPath("/home/user/.dir",
+			vfst.TestDoesNotExist,
+		),
+	)
+}
+
+func TestTargetStateDiffGit(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc": &vfst.File{
+				Perm:     0755,
+				Contents: []byte("# first line\n# old line\n# last line\n"),
+			},
+			".old": "# old\n",
+			".profile": &vfst.File{
+				Perm:     0755,
+				Contents: []byte("# contents of .profile\n"),
+			},
+			".chezmoi": map[string]interface{}{
+				".chezmoiremove":   ".old\n",
+				"dot_bashrc":       "# first line\n# new line\n# last line",
+				"dot_profile":      "# contents of .profile\n",
+				"symlink_dot_link": ".bashrc",
+				"dot_dir": map[string]interface{}{
+					"executable_file": "# contents of file\n",
+				},
+				"run_hello": "#!/bin/sh\necho hello\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	b := &bytes.Buffer{}
+	if _, err := ts.Diff(fs, b, DiffOptions{Format: DiffFormatGit}); err != nil {
+		t.Fatalf("ts.Diff(%+v, _, _) == _, %v, want _, <nil>", fs, err)
+	}
+	want := "" +
+		"diff --git a/.old b/.old\n" +
+		"deleted file mode 100644\n" +
+		"--- a/.old\n" +
+		"+++ /dev/null\n" +
+		"@@ -1 +0,0 @@\n" +
+		"-# old\n" +
+		"diff --git a/.bashrc b/.bashrc\n" +
+		"old mode 100755\n" +
+		"new mode 100644\n" +
+		"--- a/.bashrc\n" +
+		"+++ b/.bashrc\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		" # first line\n" +
+		"-# old line\n" +
+		"-# last line\n" +
+		"+# new line\n" +
+		"+# last line\n" +
+		"\\ No newline at end of file\n" +
+		"diff --git a/.dir/file b/.dir/file\n" +
+		"new file mode 100755\n" +
+		"--- /dev/null\n" +
+		"+++ b/.dir/file\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+# contents of file\n" +
+		"diff --git a/.link b/.link\n" +
+		"new file mode 120000\n" +
+		"--- /dev/null\n" +
+		"+++ b/.link\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+.bashrc\n" +
+		"\\ No newline at end of file\n" +
+		"diff --git a/.profile b/.profile\n" +
+		"old mode 100755\n" +
+		"new mode 100644\n"
+	if got := b.String(); got != want {
+		t.Errorf("ts.Diff(%+v, _, _) wrote\n%s\nwant\n%s", fs, got, want)
+	}
+}
+
+func TestWriteDiffColor(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".config": "name = old\npassword = hunter-1\n",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	operations := []Operation{
+		{
+			Type:     OperationOverwrite,
+			Name:     "/home/user/.config",
+			Mode:     0644,
+			Data:     []byte("name = new\npassword = hunter-2\nsomething completely different\n"),
+			CurrData: []byte("name = old\npassword = hunter-1\n"),
+		},
+	}
+	diffOptions := DiffOptions{
+		Color: true,
+		Redact: strings.NewReplacer(
+			"hunter-1", "<redacted>",
+			"hunter-2", "<redacted>",
+		).Replace,
+	}
+	b := &bytes.Buffer{}
+	if err := WriteDiff(b, fs, operations, diffOptions); err != nil {
+		t.Fatalf("WriteDiff(...) == %v, want <nil>", err)
+	}
+	want := "" +
+		"\x1b[1m--- /home/user/.config\x1b[0m\n" +
+		"\x1b[1m+++ /home/user/.config\x1b[0m\n" +
+		"\x1b[36m@@ -1,2 +1,3 @@\x1b[0m\n" +
+		"\x1b[31m-name = \x1b[7mold\x1b[0m\x1b[31m\x1b[0m\n" +
+		"\x1b[31m-password = <redacted>\x1b[0m\n" +
+		"\x1b[32m+name = \x1b[7mnew\x1b[0m\x1b[32m\x1b[0m\n" +
+		"\x1b[32m+password = <redacted>\x1b[0m\n" +
+		"\x1b[32m+something completely different\x1b[0m\n"
+	if got := b.String(); got != want {
+		t.Errorf("WriteDiff(...) wrote\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestWriteDiffLarge(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".large": "# old contents of .large\n",
+			".old":   "# contents of .old, which is large\n",
+			".chezmoi": map[string]interface{}{
+				"dot_large": "# contents of .large\n",
+				"dot_new":   "# contents of .new\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	operations := []Operation{
+		{
+			Type: OperationRemove,
+			Name: "/home/user/.old",
+		},
+		{
+			Type:   OperationOverwrite,
+			Name:   "/home/user/.large",
+			Mode:   0644,
+			Source: "/home/user/.chezmoi/dot_large",
+			Size:   int64(len("# contents of .large\n")),
+		},
+		{
+			Type:   OperationCreate,
+			Name:   "/home/user/.new",
+			Mode:   0755,
+			Source: "/home/user/.chezmoi/dot_new",
+			Size:   int64(len("# contents of .new\n")),
+		},
+	}
+	for _, tc := range []struct {
+		format DiffFormat
+		want   string
+	}{
+		{
+			format: DiffFormatUnified,
+			want: "" +
+				"Binary files /home/user/.old and /dev/null differ\n" +
+				"Binary files /home/user/.large and /home/user/.large differ\n" +
+				"Binary files /dev/null and /home/user/.new differ\n",
+		},
+		{
+			format: DiffFormatGit,
+			want: "" +
+				"diff --git a/.old b/.old\n" +
+				"deleted file mode 100644\n" +
+				"Binary files a/.old and /dev/null differ\n" +
+				"diff --git a/.large b/.large\n" +
+				"Binary files a/.large and b/.large differ\n" +
+				"diff --git a/.new b/.new\n" +
+				"new file mode 100755\n" +
+				"Binary files /dev/null and b/.new differ\n",
+		},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			b := &bytes.Buffer{}
+			if err := WriteDiff(b, fs, operations, DiffOptions{
+				Format:        tc.format,
+				DestDir:       "/home/user",
+				MaxMemorySize: 8,
+			}); err != nil {
+				t.Fatalf("WriteDiff(...) == %v, want <nil>", err)
+			}
+			if got := b.String(); got != tc.want {
+				t.Errorf("WriteDiff(...) wrote\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
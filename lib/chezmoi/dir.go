@@ -23,6 +23,7 @@ type Dir struct {
 	Exact      bool
 	Perm       os.FileMode
 	Entries    map[string]Entry
+	nameCache  entryNameCache
 }
 
 type dirConcreteValue struct {
@@ -92,6 +93,7 @@ func (d *Dir) Apply(fs vfs.FS, destDir string, ignore func(string) bool, umask o
 	}
 	targetPath := filepath.Join(destDir, d.targetName)
 	info, err := fs.Lstat(targetPath)
+	created := false
 	switch {
 	case err == nil && info.IsDir():
 		if info.Mode().Perm() != d.Perm&^umask {
@@ -104,26 +106,30 @@ func (d *Dir) Apply(fs vfs.FS, destDir string, ignore func(string) bool, umask o
 			return err
 		}
 		fallthrough
-	case os.IsNotExist(err):
+	case isNotExist(err):
 		if err := mutator.Mkdir(targetPath, d.Perm&^umask); err != nil {
 			return err
 		}
+		created = true
 	default:
 		return err
 	}
-	for _, entryName := range sortedEntryNames(d.Entries) {
+	for _, entryName := range d.nameCache.applyOrderNames(d.Entries) {
 		if err := d.Entries[entryName].Apply(fs, destDir, ignore, umask, mutator); err != nil {
 			return err
 		}
 	}
-	if d.Exact {
+	// A newly created directory contains only its entries.
+	if d.Exact && !created {
 		infos, err := fs.ReadDir(targetPath)
 		if err != nil {
 			return err
 		}
 		for _, info := range infos {
 			name := info.Name()
-			if _, ok := d.Entries[name]; !ok {
+			// Scripts do not create targets, so a target with the same
+			// name as a script is unmanaged.
+			if entry, ok := d.Entries[name]; !ok || isScript(entry) {
 				if ignore(filepath.Join(d.targetName, name)) {
 					continue
 				}
@@ -143,7 +149,7 @@ func (d *Dir) ConcreteValue(destDir string, ignore func(string) bool, sourceDir
 	}
 	var entryConcreteValues []interface{}
 	if recursive {
-		for _, entryName := range sortedEntryNames(d.Entries) {
+		for _, entryName := range d.nameCache.sortedNames(d.Entries) {
 			entryConcreteValue, err := d.Entries[entryName].ConcreteValue(destDir, ignore, sourceDir, recursive)
 			if err != nil {
 				return nil, err
@@ -163,17 +169,19 @@ func (d *Dir) ConcreteValue(destDir string, ignore func(string) bool, sourceDir
 	}, nil
 }
 
-// Evaluate evaluates all entries in d.
+// Evaluate evaluates all entries in d. Evaluation continues after errors, and
+// all errors are returned as a MultiError.
 func (d *Dir) Evaluate(ignore func(string) bool) error {
 	if ignore(d.targetName) {
 		return nil
 	}
-	for _, entryName := range sortedEntryNames(d.Entries) {
+	var errs MultiError
+	for _, entryName := range d.nameCache.sortedNames(d.Entries) {
 		if err := d.Entries[entryName].Evaluate(ignore); err != nil {
-			return err
+			errs = appendError(errs, err)
 		}
 	}
-	return nil
+	return errs.errorOrNil()
 }
 
 // Private returns true if d is private.
@@ -192,7 +200,7 @@ func (d *Dir) TargetName() string {
 }
 
 // archive writes d to w.
-func (d *Dir) archive(w *tar.Writer, ignore func(string) bool, headerTemplate *tar.Header, umask os.FileMode) error {
+func (d *Dir) archive(w *TarWriter, ignore func(string) bool, headerTemplate *tar.Header, umask os.FileMode) error {
 	if ignore(d.targetName) {
 		return nil
 	}
@@ -203,7 +211,7 @@ func (d *Dir) archive(w *tar.Writer, ignore func(string) bool, headerTemplate *t
 	if err := w.WriteHeader(&header); err != nil {
 		return err
 	}
-	for _, entryName := range sortedEntryNames(d.Entries) {
+	for _, entryName := range d.nameCache.sortedNames(d.Entries) {
 		if err := d.Entries[entryName].archive(w, ignore, headerTemplate, umask); err != nil {
 			return err
 		}
@@ -0,0 +1,93 @@
+package chezmoi
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// encryptionFileName is the name of the file in a source directory that
+// names the encryption tool, from TargetState.Encryptions, used for the
+// encrypted files in that directory and its subdirectories.
+const encryptionFileName = ".chezmoiencryption"
+
+// An Encryption is an encryption tool. It encrypts and decrypts both contents
+// and files, so that new tools can be added without changing TargetState.
+type Encryption interface {
+	Decryptor
+	Encryptor
+	DecryptFile(fs vfs.FS, path string) ([]byte, error)
+	EncryptFile(fs vfs.FS, path string) ([]byte, error)
+}
+
+var (
+	_ Encryption = &Age{}
+	_ Encryption = &GPG{}
+)
+
+// decryptFile reads the file at path in fs and decrypts it with d.
+func decryptFile(d Decryptor, fs vfs.FS, path string) ([]byte, error) {
+	ciphertext, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return d.Decrypt(path, ciphertext)
+}
+
+// encryptFile reads the file at path in fs and encrypts it with e.
+func encryptFile(e Encryptor, fs vfs.FS, path string) ([]byte, error) {
+	plaintext, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return e.Encrypt(path, plaintext)
+}
+
+// addEncryptionDir records the encryption tool named in the
+// .chezmoiencryption file at path for the target directory dirName.
+func (ts *TargetState) addEncryptionDir(fs vfs.FS, path, dirName string) error {
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSpace(string(data))
+	if _, ok := ts.Encryptions[name]; !ok {
+		return fmt.Errorf("%s: %s: unknown encryption tool", path, name)
+	}
+	if ts.encryptionDirs == nil {
+		ts.encryptionDirs = make(map[string]string)
+	}
+	ts.encryptionDirs[dirName] = name
+	return nil
+}
+
+// decryptor returns the Decryptor for the target targetName.
+func (ts *TargetState) decryptor(targetName string) Decryptor {
+	if encryption := ts.dirEncryption(targetName); encryption != nil {
+		return encryption
+	}
+	return ts.Decryptor
+}
+
+// dirEncryption returns the Encryption named by the .chezmoiencryption file
+// in the nearest directory containing targetName, or nil if there is none.
+func (ts *TargetState) dirEncryption(targetName string) Encryption {
+	for dirName := filepath.Dir(targetName); ; dirName = filepath.Dir(dirName) {
+		if name, ok := ts.encryptionDirs[dirName]; ok {
+			return ts.Encryptions[name]
+		}
+		if dirName == "." || dirName == string(filepath.Separator) {
+			return nil
+		}
+	}
+}
+
+// encryptor returns the Encryptor for the target targetName.
+func (ts *TargetState) encryptor(targetName string) Encryptor {
+	if encryption := ts.dirEncryption(targetName); encryption != nil {
+		return encryption
+	}
+	return ts.Encryptor
+}
@@ -0,0 +1,91 @@
+package chezmoi
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	vfs "github.com/twpayne/go-vfs"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+// A prefixEncryption is an Encryption that adds a prefix to its input.
+type prefixEncryption string
+
+func (e prefixEncryption) Decrypt(name string, ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, []byte(e)) {
+		return nil, fmt.Errorf("%s: missing prefix %q", name, string(e))
+	}
+	return ciphertext[len(e):], nil
+}
+
+func (e prefixEncryption) DecryptFile(fs vfs.FS, path string) ([]byte, error) {
+	return decryptFile(e, fs, path)
+}
+
+func (e prefixEncryption) Encrypt(name string, plaintext []byte) ([]byte, error) {
+	return append([]byte(e), plaintext...), nil
+}
+
+func (e prefixEncryption) EncryptFile(fs vfs.FS, path string) ([]byte, error) {
+	return encryptFile(e, fs, path)
+}
+
+func TestTargetStateEncryptionDirs(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".chezmoi": map[string]interface{}{
+				"encrypted_dot_netrc":               reverseBytes([]byte("machine example.com\n")),
+				"dot_legacy/.chezmoiencryption":     "legacy\n",
+				"dot_legacy/encrypted_token":        "legacy:token\n",
+				"dot_legacy/dir/encrypted_password": "legacy:password\n",
+			},
+			".legacy/key": "key\n",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", nil, nil)
+	ts.Decryptor = reverseCrypter{}
+	ts.Encryptor = reverseCrypter{}
+	ts.Encryptions = map[string]Encryption{
+		"legacy": prefixEncryption("legacy:"),
+	}
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+	}
+	for targetName, want := range map[string]string{
+		".netrc":               "machine example.com\n",
+		".legacy/token":        "token\n",
+		".legacy/dir/password": "password\n",
+	} {
+		entry, err := ts.findEntry(targetName)
+		if err != nil {
+			t.Fatalf("ts.findEntry(%q) == _, %v, want _, <nil>", targetName, err)
+		}
+		if got, err := entry.(*File).Contents(); err != nil || string(got) != want {
+			t.Errorf("ts.findEntry(%q).Contents() == %q, %v, want %q, <nil>", targetName, got, err, want)
+		}
+	}
+
+	// Files added to a directory with a .chezmoiencryption file are encrypted
+	// with the encryption tool that it names.
+	mutator := NewFSMutator(fs, ts.DestDir)
+	if err := ts.Add(fs, AddOptions{Encrypt: true}, "/home/user/.legacy/key", nil, mutator); err != nil {
+		t.Fatalf("ts.Add(_, _, %q, nil, _) == %v, want <nil>", "/home/user/.legacy/key", err)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.chezmoi/dot_legacy/encrypted_key",
+			vfst.TestModeIsRegular,
+			vfst.TestContentsString("legacy:key\n"),
+		),
+	)
+
+	// Unknown encryption tools are an error.
+	ts = NewTargetState("/home/user", 0, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err == nil {
+		t.Errorf("ts.Populate(_) == <nil>, want !<nil>")
+	}
+}
@@ -0,0 +1,73 @@
+package chezmoi
+
+import "sync"
+
+// An entryNameCache caches the orderings of the names of a map of entries, so that
+// they are not allocated and sorted again every time that the map is
+// traversed. The cached orderings are checked against the map every time that
+// they are used, so the map may be changed at any time. It is safe for
+// concurrent use.
+type entryNameCache struct {
+	mu         sync.Mutex
+	sorted     []string
+	applyOrder []string
+}
+
+// applyOrderNames returns the names of entries in the order in which they are
+// applied, see applyOrder. The caller must not modify the returned slice.
+func (c *entryNameCache) applyOrderNames(entries map[string]Entry) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sorted := c.sortedNamesLocked(entries)
+	if c.applyOrder == nil || !isApplyOrder(c.applyOrder, entries) {
+		c.applyOrder = applyOrder(sorted, entries)
+	}
+	return c.applyOrder
+}
+
+// sortedNames returns the sorted names of entries. The caller must not modify
+// the returned slice.
+func (c *entryNameCache) sortedNames(entries map[string]Entry) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sortedNamesLocked(entries)
+}
+
+// sortedNamesLocked implements sortedNames. c.mu must be held.
+func (c *entryNameCache) sortedNamesLocked(entries map[string]Entry) []string {
+	if c.sorted == nil || !sameEntryNames(c.sorted, entries) {
+		c.sorted = sortedEntryNames(entries)
+		c.applyOrder = nil
+	}
+	return c.sorted
+}
+
+// isApplyOrder returns true if entryNames, which are the names of entries, are
+// in the order in which entries are applied: first the entries that are not
+// scripts, then the scripts, both in name order.
+func isApplyOrder(entryNames []string, entries map[string]Entry) bool {
+	for i := 1; i < len(entryNames); i++ {
+		prevScript, script := isScript(entries[entryNames[i-1]]), isScript(entries[entryNames[i]])
+		switch {
+		case prevScript && !script:
+			return false
+		case prevScript == script && entryNames[i-1] > entryNames[i]:
+			return false
+		}
+	}
+	return true
+}
+
+// sameEntryNames returns true if entryNames are exactly the names of entries,
+// in any order, without allocating.
+func sameEntryNames(entryNames []string, entries map[string]Entry) bool {
+	if len(entryNames) != len(entries) {
+		return false
+	}
+	for _, entryName := range entryNames {
+		if _, ok := entries[entryName]; !ok {
+			return false
+		}
+	}
+	return true
+}
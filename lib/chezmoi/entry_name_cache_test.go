@@ -0,0 +1,38 @@
+package chezmoi
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestEntryNameCache(t *testing.T) {
+	var c entryNameCache
+	entries := map[string]Entry{
+		"b":      &File{targetName: "b"},
+		"a":      &File{targetName: "a"},
+		"script": &Script{targetName: "script"},
+	}
+	check := func(wantSorted, wantApplyOrder []string) {
+		if diff, equal := messagediff.PrettyDiff(wantSorted, c.sortedNames(entries)); !equal {
+			t.Errorf("c.sortedNames(_) == %v, want %v, diff:\n%s", c.sortedNames(entries), wantSorted, diff)
+		}
+		if diff, equal := messagediff.PrettyDiff(wantApplyOrder, c.applyOrderNames(entries)); !equal {
+			t.Errorf("c.applyOrderNames(_) == %v, want %v, diff:\n%s", c.applyOrderNames(entries), wantApplyOrder, diff)
+		}
+	}
+
+	check([]string{"a", "b", "script"}, []string{"a", "b", "script"})
+
+	// Replacing an entry with one with a different name, so that the number
+	// of entries does not change, invalidates the cache.
+	delete(entries, "b")
+	entries["c"] = &Script{targetName: "c"}
+	check([]string{"a", "c", "script"}, []string{"a", "c", "script"})
+
+	// Changing the type of an entry changes the apply order.
+	entries["a"] = &Script{targetName: "a"}
+	check([]string{"a", "c", "script"}, []string{"a", "c", "script"})
+	entries["c"] = &File{targetName: "c"}
+	check([]string{"a", "c", "script"}, []string{"c", "a", "script"})
+}
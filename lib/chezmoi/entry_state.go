@@ -0,0 +1,281 @@
+package chezmoi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// The state of each target, as last written by chezmoi, is recorded in
+// entryStateBucket, keyed by target name.
+var entryStateBucket = []byte("entryState")
+
+// An EntryState is the state of a target. Files are identified by the SHA256
+// of their contents, so that no contents are stored. The last written states
+// of files also record their size and modification time, in nanoseconds since
+// the Unix epoch, so that targets that have not changed since can be
+// recognized without reading their contents.
+type EntryState struct {
+	Type     string      `json:"type" yaml:"type"`
+	Mode     os.FileMode `json:"mode,omitempty" yaml:"mode,omitempty"`
+	SHA256   string      `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	Linkname string      `json:"linkname,omitempty" yaml:"linkname,omitempty"`
+	Size     int64       `json:"size,omitempty" yaml:"size,omitempty"`
+	ModTime  int64       `json:"modTime,omitempty" yaml:"modTime,omitempty"`
+}
+
+// Equal returns true if s and other are both nil or describe the same state.
+// Sizes and modification times are not compared.
+func (s *EntryState) Equal(other *EntryState) bool {
+	if s == nil || other == nil {
+		return s == nil && other == nil
+	}
+	return s.Type == other.Type &&
+		s.Mode == other.Mode &&
+		s.SHA256 == other.SHA256 &&
+		s.Linkname == other.Linkname
+}
+
+// matchesInfo returns true if s is the state of a file with the size,
+// modification time, and permissions in info, in which case the file's
+// contents are assumed to be unchanged since s was recorded.
+func (s *EntryState) matchesInfo(info os.FileInfo) bool {
+	return s != nil &&
+		s.Type == "file" &&
+		s.ModTime != 0 &&
+		info.Mode().IsRegular() &&
+		s.Mode == info.Mode().Perm() &&
+		s.Size == info.Size() &&
+		s.ModTime == info.ModTime().UnixNano()
+}
+
+// ModifiedSince returns a function, for VerifySampleOptions.Priority, that
+// returns true if the last written state of an entry in ts.PersistentState
+// records that it was modified at or after t. Entries whose state cannot be
+// read are treated as modified, so that they are verified.
+func (ts *TargetState) ModifiedSince(t time.Time) func(Entry) bool {
+	since := t.UnixNano()
+	return func(entry Entry) bool {
+		state, err := lastEntryState(ts.PersistentState, entry.TargetName())
+		if err != nil {
+			return true
+		}
+		return state != nil && state.ModTime >= since
+	}
+}
+
+// SaveEntryStates records the target states of entries, and of every entry in
+// them, in ts.PersistentState as their last written states. It should be
+// called after entries have been applied successfully. Only changed states are
+// written, and targets that were not left in their target state, for example
+// because they were skipped during an interactive apply, are not recorded.
+// Files modified in the current second might be modified again without
+// changing their modification time, so their sizes and modification times are
+// not recorded until a later call.
+func (ts *TargetState) SaveEntryStates(fs vfs.FS, entries []Entry) error {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	if ts.PersistentState == nil {
+		return nil
+	}
+	racyModTime := time.Now().Truncate(time.Second).UnixNano()
+	entriesMap := make(map[string]Entry)
+	for _, entry := range entries {
+		entriesMap[entry.TargetName()] = entry
+	}
+	return batchPersistentState(ts.PersistentState, func() error {
+		return ts.saveEntryStates(fs, entriesMap, racyModTime)
+	})
+}
+
+// saveEntryStates records the target states of entriesMap in
+// ts.PersistentState for SaveEntryStates.
+func (ts *TargetState) saveEntryStates(fs vfs.FS, entriesMap map[string]Entry, racyModTime int64) error {
+	return walkEntries(entriesMap, func(entry Entry) error {
+		if isScript(entry) || ts.TargetIgnore.Match(entry.TargetName()) {
+			return nil
+		}
+		state, err := ts.targetEntryState(fs, entry)
+		if err != nil {
+			return err
+		}
+		lastState, err := ts.lastEntryState(entry.TargetName())
+		if err != nil {
+			return err
+		}
+		actualState, err := actualEntryState(fs, filepath.Join(ts.DestDir, entry.TargetName()), lastState, ts.ContentHashCache)
+		if err != nil {
+			return err
+		}
+		if !state.Equal(actualState) {
+			return nil
+		}
+		if state != nil && actualState.ModTime < racyModTime {
+			state.Size = actualState.Size
+			state.ModTime = actualState.ModTime
+		}
+		if state == nil && lastState == nil || state != nil && lastState != nil && *state == *lastState {
+			return nil
+		}
+		key := []byte(entry.TargetName())
+		if state == nil {
+			return ts.PersistentState.Delete(entryStateBucket, key)
+		}
+		value, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return ts.PersistentState.Set(entryStateBucket, key, value)
+	})
+}
+
+// actualEntryState returns the state of path in fs, or nil if it does not
+// exist. If path is a file whose size, modification time, and permissions match
+// lastState then lastState is returned without reading the file's contents.
+// Otherwise the hash of a file's contents is taken from cache, if possible.
+func actualEntryState(fs vfs.FS, path string, lastState *EntryState, cache *ContentHashCache) (*EntryState, error) {
+	info, err := fs.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	if lastState.matchesInfo(info) {
+		state := *lastState
+		return &state, nil
+	}
+	state := &EntryState{
+		Type: fileTypeName(info.Mode()),
+	}
+	switch state.Type {
+	case "file":
+		sha256, err := cache.FileSHA256(fs, path, info)
+		if err != nil {
+			return nil, err
+		}
+		state.Mode = info.Mode().Perm()
+		state.SHA256 = sha256
+		state.Size = info.Size()
+		state.ModTime = info.ModTime().UnixNano()
+	case "dir":
+		state.Mode = info.Mode().Perm()
+	case "symlink":
+		if state.Linkname, err = fs.Readlink(path); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// lastEntryState returns the last written state of targetName, or nil if no
+// state is recorded.
+func (ts *TargetState) lastEntryState(targetName string) (*EntryState, error) {
+	return lastEntryState(ts.PersistentState, targetName)
+}
+
+// lastEntryState returns the last written state of targetName recorded in
+// persistentState, or nil if no state is recorded or persistentState is nil.
+func lastEntryState(persistentState PersistentState, targetName string) (*EntryState, error) {
+	if persistentState == nil {
+		return nil, nil
+	}
+	value, err := persistentState.Get(entryStateBucket, []byte(targetName))
+	if err != nil || value == nil {
+		return nil, err
+	}
+	var state EntryState
+	if err := json.Unmarshal(value, &state); err != nil {
+		return nil, fmt.Errorf("%s: %v", targetName, err)
+	}
+	return &state, nil
+}
+
+// hexSHA256 returns the hex-encoded SHA256 of data.
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readerHexSHA256 returns the hex-encoded SHA256 of the contents of r.
+func readerHexSHA256(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := copyPooled(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// targetEntryState returns the state that applying entry would leave its
+// target in, or nil if the target would not exist. Existing create-only files
+// keep their contents, so these are hashed from fs. Large contents are hashed
+// from their source files.
+func (ts *TargetState) targetEntryState(fs vfs.FS, entry Entry) (*EntryState, error) {
+	switch entry := entry.(type) {
+	case *Dir:
+		return &EntryState{
+			Type: "dir",
+			Mode: entry.Perm &^ ts.Umask,
+		}, nil
+	case *File:
+		if entry.Create {
+			targetPath := filepath.Join(ts.DestDir, entry.targetName)
+			if info, err := fs.Lstat(targetPath); err == nil && info.Mode().IsRegular() {
+				sha256, err := entry.contentHashCache.FileSHA256(fs, targetPath, info)
+				if err != nil {
+					return nil, err
+				}
+				return &EntryState{
+					Type:   "file",
+					Mode:   entry.Perm &^ ts.Umask,
+					SHA256: sha256,
+				}, nil
+			}
+		}
+		large, sourceInfo, err := entry.large()
+		if err != nil {
+			return nil, err
+		}
+		var empty bool
+		var sha256 string
+		if large {
+			if empty, err = entry.empty(); err == nil {
+				sha256, err = entry.largeSHA256(sourceInfo)
+			}
+		} else {
+			var contents []byte
+			if contents, err = entry.Contents(); err == nil {
+				empty, sha256 = isEmpty(contents), hexSHA256(contents)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if empty && !entry.Empty {
+			return nil, nil
+		}
+		return &EntryState{
+			Type:   "file",
+			Mode:   entry.Perm &^ ts.Umask,
+			SHA256: sha256,
+		}, nil
+	case *Symlink:
+		linkname, err := entry.Linkname()
+		if err != nil {
+			return nil, err
+		}
+		return &EntryState{
+			Type:     "symlink",
+			Linkname: linkname,
+		}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,87 @@
+package chezmoi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateEntryStateCache(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			"dot_bashrc": "# bashrc\n",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	persistentState := NewMemoryPersistentState()
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	ts.PersistentState = persistentState
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	mutator := NewFSMutator(fs, "/home/user")
+	if err := ts.Apply(fs, mutator); err != nil {
+		t.Fatalf("ts.Apply(_, _) == %v, want <nil>", err)
+	}
+	entries := []Entry{ts.Entries[".bashrc"]}
+
+	// The target was only just written, so its size and modification time
+	// are not recorded.
+	if err := ts.SaveEntryStates(fs, entries); err != nil {
+		t.Fatalf("ts.SaveEntryStates(_, _) == %v, want <nil>", err)
+	}
+	if state, _ := ts.lastEntryState(".bashrc"); state == nil || state.ModTime != 0 {
+		t.Fatalf("ts.lastEntryState(%q) == %+v, want a state without a modification time", ".bashrc", state)
+	}
+
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := fs.Chtimes("/home/user/.bashrc", modTime, modTime); err != nil {
+		t.Fatalf("fs.Chtimes(_, _, _) == %v, want <nil>", err)
+	}
+	if err := ts.SaveEntryStates(fs, entries); err != nil {
+		t.Fatalf("ts.SaveEntryStates(_, _) == %v, want <nil>", err)
+	}
+	if state, _ := ts.lastEntryState(".bashrc"); state == nil || state.ModTime != modTime.UnixNano() || state.Size != int64(len("# bashrc\n")) {
+		t.Fatalf("ts.lastEntryState(%q) == %+v, want a state with a size and modification time", ".bashrc", state)
+	}
+
+	// Change the target's contents without changing its size or
+	// modification time. The cached state hides the change from apply and
+	// verify, which do not read the target's contents.
+	if err := fs.WriteFile("/home/user/.bashrc", []byte("# edited\n"), 0644); err != nil {
+		t.Fatalf("fs.WriteFile(_, _, _) == %v, want <nil>", err)
+	}
+	if err := fs.Chtimes("/home/user/.bashrc", modTime, modTime); err != nil {
+		t.Fatalf("fs.Chtimes(_, _, _) == %v, want <nil>", err)
+	}
+	dryRunMutator := NewDryRunMutator()
+	if err := ts.Apply(fs, dryRunMutator); err != nil {
+		t.Fatalf("ts.Apply(_, _) == %v, want <nil>", err)
+	}
+	if len(dryRunMutator.Operations) != 0 {
+		t.Errorf("ts.Apply(_, _) operations == %+v, want none", dryRunMutator.Operations)
+	}
+	report, err := ts.Verify(fs)
+	if err != nil {
+		t.Fatalf("ts.Verify(_) == _, %v, want _, <nil>", err)
+	}
+	if !report.OK() {
+		t.Errorf("ts.Verify(_) == %+v, want OK", report)
+	}
+
+	// Any change to the modification time causes the contents to be read.
+	if err := fs.Chtimes("/home/user/.bashrc", modTime, modTime.Add(time.Second)); err != nil {
+		t.Fatalf("fs.Chtimes(_, _, _) == %v, want <nil>", err)
+	}
+	dryRunMutator = NewDryRunMutator()
+	if err := ts.Apply(fs, dryRunMutator); err != nil {
+		t.Fatalf("ts.Apply(_, _) == %v, want <nil>", err)
+	}
+	if len(dryRunMutator.Operations) != 1 || dryRunMutator.Operations[0].Type != OperationOverwrite {
+		t.Errorf("ts.Apply(_, _) operations == %+v, want one overwrite", dryRunMutator.Operations)
+	}
+}
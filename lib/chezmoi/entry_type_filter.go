@@ -0,0 +1,156 @@
+package chezmoi
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An EntryTypeSet is a set of entry types.
+type EntryTypeSet int
+
+// Entry types.
+const (
+	EntryTypeDirs EntryTypeSet = 1 << iota
+	EntryTypeFiles
+	EntryTypeRemove // Targets removed by .chezmoiremove or exact directories.
+	EntryTypeScripts
+	EntryTypeSymlinks
+	EntryTypeEncrypted // Encrypted files, which are also files.
+
+	EntryTypesAll  = EntryTypeDirs | EntryTypeFiles | EntryTypeRemove | EntryTypeScripts | EntryTypeSymlinks | EntryTypeEncrypted
+	EntryTypesNone = EntryTypeSet(0)
+)
+
+var entryTypeNames = map[string]EntryTypeSet{
+	"all":       EntryTypesAll,
+	"dirs":      EntryTypeDirs,
+	"encrypted": EntryTypeEncrypted,
+	"files":     EntryTypeFiles,
+	"none":      EntryTypesNone,
+	"remove":    EntryTypeRemove,
+	"scripts":   EntryTypeScripts,
+	"symlinks":  EntryTypeSymlinks,
+}
+
+// An EntryTypeFilter selects the entries whose types are in Include and not in
+// Exclude.
+type EntryTypeFilter struct {
+	Include EntryTypeSet
+	Exclude EntryTypeSet
+}
+
+// ParseEntryTypeSet parses a comma-separated list of entry type names, for
+// example "files,symlinks".
+func ParseEntryTypeSet(s string) (EntryTypeSet, error) {
+	types := EntryTypesNone
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		t, ok := entryTypeNames[name]
+		if !ok {
+			return EntryTypesNone, fmt.Errorf("%s: unknown entry type", name)
+		}
+		types |= t
+	}
+	return types, nil
+}
+
+// String returns s as a comma-separated list of entry type names.
+func (s EntryTypeSet) String() string {
+	switch s {
+	case EntryTypesAll:
+		return "all"
+	case EntryTypesNone:
+		return "none"
+	}
+	var names []string
+	for name, t := range entryTypeNames {
+		if t != EntryTypesAll && t != EntryTypesNone && s&t != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// NewEntryTypeFilter returns a new EntryTypeFilter.
+func NewEntryTypeFilter(include, exclude EntryTypeSet) *EntryTypeFilter {
+	return &EntryTypeFilter{
+		Include: include,
+		Exclude: exclude,
+	}
+}
+
+// IncludeEntry returns true if f includes entry.
+func (f *EntryTypeFilter) IncludeEntry(entry Entry) bool {
+	return f.includeTypes(entryTypes(entry))
+}
+
+// includeTypes returns true if f includes an entry with types.
+func (f *EntryTypeFilter) includeTypes(types EntryTypeSet) bool {
+	return types&f.Include != 0 && types&f.Exclude == 0
+}
+
+// entryTypes returns the types of entry.
+func entryTypes(entry Entry) EntryTypeSet {
+	switch entry := entry.(type) {
+	case *Dir:
+		return EntryTypeDirs
+	case *File:
+		if entry.Encrypted {
+			return EntryTypeFiles | EntryTypeEncrypted
+		}
+		return EntryTypeFiles
+	case *Script:
+		return EntryTypeScripts
+	case *Symlink:
+		return EntryTypeSymlinks
+	default:
+		return EntryTypesNone
+	}
+}
+
+// filterOperationsByType returns the operations in operations that change
+// targets included by ts.EntryTypeFilter. Operations inside a directory whose
+// creation is filtered out are also filtered out.
+func (ts *TargetState) filterOperationsByType(operations []Operation) []Operation {
+	if ts.EntryTypeFilter == nil {
+		return operations
+	}
+	var result []Operation
+	var excludedDirs []string
+	for _, o := range operations {
+		if isInDirs(o.Name, excludedDirs) {
+			continue
+		}
+		if !ts.EntryTypeFilter.includeTypes(ts.operationEntryTypes(&o)) {
+			if o.Type == OperationMkdir {
+				excludedDirs = append(excludedDirs, o.Name)
+			}
+			continue
+		}
+		result = append(result, o)
+	}
+	return result
+}
+
+// operationEntryTypes returns the types of the entry changed by o. Operations
+// on targets that are not entries in ts are removals.
+func (ts *TargetState) operationEntryTypes(o *Operation) EntryTypeSet {
+	if o.Type == OperationRunScript {
+		return EntryTypeScripts
+	}
+	targetName, err := filepath.Rel(ts.DestDir, o.Name)
+	if err != nil {
+		return EntryTypeRemove
+	}
+	entry, err := ts.findEntry(targetName)
+	if err != nil || entry == nil {
+		return EntryTypeRemove
+	}
+	return entryTypes(entry)
+}
@@ -0,0 +1,102 @@
+package chezmoi
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestParseEntryTypeSet(t *testing.T) {
+	for _, tc := range []struct {
+		s       string
+		want    EntryTypeSet
+		wantErr bool
+	}{
+		{s: "", want: EntryTypesNone},
+		{s: "all", want: EntryTypesAll},
+		{s: "none", want: EntryTypesNone},
+		{s: "files", want: EntryTypeFiles},
+		{s: "Files, symlinks", want: EntryTypeFiles | EntryTypeSymlinks},
+		{s: "dirs,scripts,remove,encrypted", want: EntryTypeDirs | EntryTypeScripts | EntryTypeRemove | EntryTypeEncrypted},
+		{s: "templates", wantErr: true},
+	} {
+		got, err := ParseEntryTypeSet(tc.s)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseEntryTypeSet(%q) == %v, <nil>, want _, !<nil>", tc.s, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("ParseEntryTypeSet(%q) == %v, %v, want %v, <nil>", tc.s, got, err, tc.want)
+		}
+		if got2, err := ParseEntryTypeSet(got.String()); err != nil || got2 != got {
+			t.Errorf("ParseEntryTypeSet(%q) == %v, %v, want %v, <nil>", got.String(), got2, err, got)
+		}
+	}
+}
+
+func TestTargetStatePlanEntryTypeFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		filter    *EntryTypeFilter
+		wantNames []string
+	}{
+		{
+			name:      "all",
+			filter:    nil,
+			wantNames: []string{"/home/user/.old", "/home/user/.dir", "/home/user/.dir/file", "/home/user/.file", "/home/user/.link", "script"},
+		},
+		{
+			name:      "exclude_scripts",
+			filter:    NewEntryTypeFilter(EntryTypesAll, EntryTypeScripts),
+			wantNames: []string{"/home/user/.old", "/home/user/.dir", "/home/user/.dir/file", "/home/user/.file", "/home/user/.link"},
+		},
+		{
+			name:      "exclude_dirs",
+			filter:    NewEntryTypeFilter(EntryTypesAll, EntryTypeDirs),
+			wantNames: []string{"/home/user/.old", "/home/user/.file", "/home/user/.link", "script"},
+		},
+		{
+			name:      "include_symlinks_and_remove",
+			filter:    NewEntryTypeFilter(EntryTypeSymlinks|EntryTypeRemove, EntryTypesNone),
+			wantNames: []string{"/home/user/.old", "/home/user/.link"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user": map[string]interface{}{
+					".old": "old",
+					".chezmoi": map[string]interface{}{
+						".chezmoiremove":   ".old\n",
+						"dot_dir/file":     "file",
+						"dot_file":         "file",
+						"symlink_dot_link": ".file",
+						"run_script":       "#!/bin/sh\n",
+					},
+				},
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+			ts.EntryTypeFilter = tc.filter
+			if err := ts.Populate(fs); err != nil {
+				t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+			}
+			plan, err := ts.Plan(fs)
+			if err != nil {
+				t.Fatalf("ts.Plan(%+v) == _, %v, want _, <nil>", fs, err)
+			}
+			var gotNames []string
+			for _, o := range plan.Operations {
+				gotNames = append(gotNames, o.Name)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.wantNames, gotNames); !equal {
+				t.Errorf("ts.Plan(_) changes %v, want %v, diff:\n%s", gotNames, tc.wantNames, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package chezmoi
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// An ExtractOptions contains options for ExtractTargets.
+type ExtractOptions struct {
+	StripComponents int
+	Umask           os.FileMode // Umask is applied to the modes in the archive.
+}
+
+// ExtractTargets ensures that the targets in the tar archive r that match
+// targets are present in destDir. Each element of targets is a target name or
+// a glob pattern, and matching a directory selects everything beneath it.
+// Modes are restored from the archive. Parent directories that are not in the
+// archive are created with mode 0777 &^ extractOptions.Umask. It is an error
+// if any element of targets does not match anything in the archive, or if any
+// member of the archive is outside destDir.
+func ExtractTargets(r *tar.Reader, targets []string, fs vfs.FS, destDir string, extractOptions ExtractOptions, mutator Mutator) error {
+	for _, target := range targets {
+		if _, err := filepath.Match(target, ""); err != nil {
+			return fmt.Errorf("%s: %v", target, err)
+		}
+	}
+	matched := make(map[string]bool)
+	dirPerms := make(map[string]os.FileMode)
+	selected := make(map[string]Entry)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink:
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeLink:
+			return fmt.Errorf("%s: hard links are not supported", header.Name)
+		default:
+			return fmt.Errorf("%s: unsupported typeflag '%c'", header.Name, header.Typeflag)
+		}
+		targetName := strings.TrimPrefix(filepath.Clean(header.Name), "."+string(os.PathSeparator))
+		if targetName == "." {
+			continue
+		}
+		if filepath.IsAbs(targetName) || strings.HasPrefix(targetName, string(os.PathSeparator)) || targetName == ".." || strings.HasPrefix(targetName, ".."+string(os.PathSeparator)) {
+			return fmt.Errorf("%s: outside target directory", header.Name)
+		}
+		if extractOptions.StripComponents > 0 {
+			components := splitPathList(targetName)
+			if len(components) <= extractOptions.StripComponents {
+				continue
+			}
+			targetName = filepath.Join(components[extractOptions.StripComponents:]...)
+		}
+		perm := os.FileMode(header.Mode).Perm()
+		if header.Typeflag == tar.TypeDir {
+			dirPerms[targetName] = perm
+		}
+		if !matchTarget(targets, targetName, matched) {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			selected[targetName] = newDir("", targetName, false, perm)
+		case tar.TypeReg:
+			contents, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			// The archive records the exact contents of files, so files that
+			// are empty or contain only whitespace are extracted too.
+			selected[targetName] = &File{
+				targetName: targetName,
+				Empty:      true,
+				Perm:       perm,
+				contents:   contents,
+			}
+		case tar.TypeSymlink:
+			selected[targetName] = &Symlink{
+				targetName: targetName,
+				linkname:   header.Linkname,
+			}
+		}
+	}
+
+	var missing []string
+	for _, target := range targets {
+		if !matched[target] {
+			missing = append(missing, target)
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("%s: not found in archive", strings.Join(missing, ", "))
+	}
+
+	// Build a tree of the selected entries, adding any parent directories, so
+	// that parents are ensured before their children.
+	entries := make(map[string]Entry)
+	targetNames := make([]string, 0, len(selected))
+	for targetName := range selected {
+		targetNames = append(targetNames, targetName)
+	}
+	sort.Strings(targetNames)
+	for _, targetName := range targetNames {
+		parentEntries := entries
+		components := splitPathList(targetName)
+		for i, name := range components[:len(components)-1] {
+			dirName := filepath.Join(components[:i+1]...)
+			if _, ok := parentEntries[name]; !ok {
+				if dir, ok := selected[dirName].(*Dir); ok {
+					parentEntries[name] = dir
+				} else if perm, ok := dirPerms[dirName]; ok {
+					parentEntries[name] = newDir("", dirName, false, perm)
+				} else {
+					parentEntries[name] = newDir("", dirName, false, 0777)
+				}
+			}
+			dir, ok := parentEntries[name].(*Dir)
+			if !ok {
+				return fmt.Errorf("%s: not a directory", dirName)
+			}
+			parentEntries = dir.Entries
+		}
+		name := components[len(components)-1]
+		if _, ok := parentEntries[name]; !ok {
+			parentEntries[name] = selected[targetName]
+		}
+	}
+
+	ignore := func(string) bool { return false }
+	for _, entryName := range sortedEntryNames(entries) {
+		if err := entries[entryName].Apply(fs, destDir, ignore, extractOptions.Umask, mutator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchTarget returns true if targetName or any of its parent directories
+// matches any of targets, recording the targets that match in matched.
+func matchTarget(targets []string, targetName string, matched map[string]bool) bool {
+	result := false
+	for _, target := range targets {
+		for name := targetName; name != "." && name != string(os.PathSeparator); name = filepath.Dir(name) {
+			if ok, _ := filepath.Match(filepath.Clean(target), name); ok {
+				matched[target] = true
+				result = true
+				break
+			}
+		}
+	}
+	return result
+}
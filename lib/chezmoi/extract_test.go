@@ -0,0 +1,260 @@
+package chezmoi
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestExtractTargets(t *testing.T) {
+	srcFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			"dot_bashrc":                   "# contents of .bashrc\n",
+			"private_dot_netrc":            "# contents of .netrc\n",
+			"private_dot_ssh/config":       "# contents of .ssh/config\n",
+			"private_dot_ssh/known_hosts":  "# contents of .ssh/known_hosts\n",
+			"dir/executable_script":        "#!/bin/sh\n",
+			"dir/subdir/symlink_dot_vimrc": ".config/vimrc",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(srcFS); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", srcFS, err)
+	}
+	b := &bytes.Buffer{}
+	w := NewTarWriter(b)
+	if err := ts.Archive(w, 022); err != nil {
+		t.Fatalf("ts.Archive(_, 022) == %v, want <nil>", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("w.Close() == %v, want <nil>", err)
+	}
+	archive := b.Bytes()
+
+	for _, tc := range []struct {
+		name    string
+		targets []string
+		wantErr bool
+		tests   []vfst.Test
+	}{
+		{
+			name:    "file",
+			targets: []string{".bashrc"},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.bashrc",
+					vfst.TestModeIsRegular,
+					vfst.TestModePerm(0644),
+					vfst.TestContentsString("# contents of .bashrc\n"),
+				),
+				vfst.TestPath("/home/user/.netrc",
+					vfst.TestDoesNotExist,
+				),
+				vfst.TestPath("/home/user/dir",
+					vfst.TestDoesNotExist,
+				),
+			},
+		},
+		{
+			name:    "nested",
+			targets: []string{"dir/subdir/.vimrc", ".ssh/config"},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/dir",
+					vfst.TestIsDir,
+					vfst.TestModePerm(0755),
+				),
+				vfst.TestPath("/home/user/dir/script",
+					vfst.TestDoesNotExist,
+				),
+				vfst.TestPath("/home/user/dir/subdir/.vimrc",
+					vfst.TestModeType(os.ModeSymlink),
+					vfst.TestSymlinkTarget(".config/vimrc"),
+				),
+				vfst.TestPath("/home/user/.ssh",
+					vfst.TestIsDir,
+					vfst.TestModePerm(0700),
+				),
+				vfst.TestPath("/home/user/.ssh/config",
+					vfst.TestModeIsRegular,
+					vfst.TestModePerm(0644),
+					vfst.TestContentsString("# contents of .ssh/config\n"),
+				),
+				vfst.TestPath("/home/user/.ssh/known_hosts",
+					vfst.TestDoesNotExist,
+				),
+			},
+		},
+		{
+			name:    "dir",
+			targets: []string{"dir"},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/dir/script",
+					vfst.TestModeIsRegular,
+					vfst.TestModePerm(0755),
+					vfst.TestContentsString("#!/bin/sh\n"),
+				),
+				vfst.TestPath("/home/user/dir/subdir/.vimrc",
+					vfst.TestModeType(os.ModeSymlink),
+					vfst.TestSymlinkTarget(".config/vimrc"),
+				),
+			},
+		},
+		{
+			name:    "glob",
+			targets: []string{".*rc"},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.bashrc",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# contents of .bashrc\n"),
+				),
+				vfst.TestPath("/home/user/.netrc",
+					vfst.TestModeIsRegular,
+					vfst.TestModePerm(0600),
+					vfst.TestContentsString("# contents of .netrc\n"),
+				),
+				vfst.TestPath("/home/user/dir",
+					vfst.TestDoesNotExist,
+				),
+			},
+		},
+		{
+			name:    "missing",
+			targets: []string{".bashrc", ".missing"},
+			wantErr: true,
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.bashrc",
+					vfst.TestDoesNotExist,
+				),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user": &vfst.Dir{Perm: 0755},
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			r := tar.NewReader(bytes.NewReader(archive))
+			err = ExtractTargets(r, tc.targets, fs, "/home/user", ExtractOptions{}, NewFSMutator(fs, "/home/user"))
+			if tc.wantErr && err == nil {
+				t.Errorf("ExtractTargets(_, %v, ...) == <nil>, want !<nil>", tc.targets)
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("ExtractTargets(_, %v, ...) == %v, want <nil>", tc.targets, err)
+			}
+			vfst.RunTests(t, fs, "", tc.tests)
+		})
+	}
+}
+
+func TestExtractTargetsMembers(t *testing.T) {
+	type member struct {
+		name     string
+		contents string
+	}
+	for _, tc := range []struct {
+		name    string
+		members []member
+		targets []string
+		wantErr bool
+		tests   []vfst.Test
+	}{
+		{
+			name: "whitespace",
+			members: []member{
+				{name: ".empty"},
+				{name: ".newline", contents: "\n"},
+			},
+			targets: []string{"*"},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.empty",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString(""),
+				),
+				vfst.TestPath("/home/user/.newline",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("\n"),
+				),
+			},
+		},
+		{
+			name: "parent",
+			members: []member{
+				{name: ".bashrc", contents: "# contents of .bashrc\n"},
+				{name: "../.bashrc", contents: "# outside\n"},
+			},
+			targets: []string{"*"},
+			wantErr: true,
+			tests: []vfst.Test{
+				vfst.TestPath("/home/.bashrc",
+					vfst.TestDoesNotExist,
+				),
+			},
+		},
+		{
+			name: "nested_parent",
+			members: []member{
+				{name: "a/../../.bashrc", contents: "# outside\n"},
+			},
+			targets: []string{"*"},
+			wantErr: true,
+			tests: []vfst.Test{
+				vfst.TestPath("/home/.bashrc",
+					vfst.TestDoesNotExist,
+				),
+			},
+		},
+		{
+			name: "absolute",
+			members: []member{
+				{name: "/home/.bashrc", contents: "# outside\n"},
+			},
+			targets: []string{"*"},
+			wantErr: true,
+			tests: []vfst.Test{
+				vfst.TestPath("/home/.bashrc",
+					vfst.TestDoesNotExist,
+				),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &bytes.Buffer{}
+			w := tar.NewWriter(b)
+			for _, m := range tc.members {
+				if err := w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: m.name, Mode: 0644, Size: int64(len(m.contents))}); err != nil {
+					t.Fatalf("w.WriteHeader(_) == %v, want <nil>", err)
+				}
+				if _, err := w.Write([]byte(m.contents)); err != nil {
+					t.Fatalf("w.Write(_) == _, %v, want _, <nil>", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("w.Close() == %v, want <nil>", err)
+			}
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user": &vfst.Dir{Perm: 0755},
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			err = ExtractTargets(tar.NewReader(b), tc.targets, fs, "/home/user", ExtractOptions{}, NewFSMutator(fs, "/home/user"))
+			if tc.wantErr && err == nil {
+				t.Errorf("ExtractTargets(_, %v, ...) == <nil>, want !<nil>", tc.targets)
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("ExtractTargets(_, %v, ...) == %v, want <nil>", tc.targets, err)
+			}
+			vfst.RunTests(t, fs, "", tc.tests)
+		})
+	}
+}
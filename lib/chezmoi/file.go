@@ -2,40 +2,70 @@ package chezmoi
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"unicode"
 
 	vfs "github.com/twpayne/go-vfs"
 )
 
 // A FileAttributes holds attributes passed from a source file name.
 type FileAttributes struct {
-	Name     string
-	Mode     os.FileMode
-	Empty    bool
-	Template bool
+	Name      string
+	Mode      os.FileMode
+	Create    bool
+	Empty     bool
+	Encrypted bool
+	Script    bool
+	Once      bool
+	OnChange  bool
+	Template  bool
+	Engine    string
 }
 
-// A File represents the target state of a file.
+// A File represents the target state of a file. Its contents are evaluated at
+// most once, and it is safe for concurrent use.
 type File struct {
 	sourceName       string
 	targetName       string
+	Create           bool
 	Empty            bool
+	Encrypted        bool
 	Perm             os.FileMode
 	Template         bool
+	mu               sync.Mutex
 	contents         []byte
 	contentsErr      error
 	evaluateContents func() ([]byte, error)
+	// openContents, if not nil, opens the source file whose contents are
+	// f's contents, because they need no evaluation. They are read when
+	// they are needed and never held by f, so that large files do not stay
+	// in memory.
+	openContents     func() (*os.File, error)
+	persistentState  PersistentState
+	contentHashCache *ContentHashCache
+	// sourceFS and sourcePath locate the source file of streamed contents.
+	// Streamed contents larger than maxMemorySize, if it is positive, are
+	// never read into memory.
+	sourceFS      vfs.FS
+	sourcePath    string
+	maxMemorySize int64
 }
 
 type fileConcreteValue struct {
 	Type       string `json:"type" yaml:"type"`
 	SourcePath string `json:"sourcePath" yaml:"sourcePath"`
 	TargetPath string `json:"targetPath" yaml:"targetPath"`
+	Create     bool   `json:"create" yaml:"create"`
 	Empty      bool   `json:"empty" yaml:"empty"`
+	Encrypted  bool   `json:"encrypted" yaml:"encrypted"`
 	Perm       int    `json:"perm" yaml:"perm"`
 	Template   bool   `json:"template" yaml:"template"`
 	Contents   string `json:"contents" yaml:"contents"`
@@ -45,12 +75,39 @@ type fileConcreteValue struct {
 func ParseFileAttributes(sourceName string) FileAttributes {
 	name := sourceName
 	mode := os.FileMode(0666)
+	create := false
 	empty := false
+	encrypted := false
+	script := false
+	once := false
+	onChange := false
 	template := false
-	if strings.HasPrefix(name, symlinkPrefix) {
+	engine := ""
+	switch {
+	case strings.HasPrefix(name, runPrefix):
+		name = strings.TrimPrefix(name, runPrefix)
+		mode = 0700
+		script = true
+		switch {
+		case strings.HasPrefix(name, oncePrefix):
+			name = strings.TrimPrefix(name, oncePrefix)
+			once = true
+		case strings.HasPrefix(name, onChangePrefix):
+			name = strings.TrimPrefix(name, onChangePrefix)
+			onChange = true
+		}
+	case strings.HasPrefix(name, symlinkPrefix):
 		name = strings.TrimPrefix(name, symlinkPrefix)
 		mode |= os.ModeSymlink
-	} else {
+	default:
+		if strings.HasPrefix(name, createPrefix) {
+			name = strings.TrimPrefix(name, createPrefix)
+			create = true
+		}
+		if strings.HasPrefix(name, encryptedPrefix) {
+			name = strings.TrimPrefix(name, encryptedPrefix)
+			encrypted = true
+		}
 		private := false
 		if strings.HasPrefix(name, privatePrefix) {
 			name = strings.TrimPrefix(name, privatePrefix)
@@ -67,6 +124,11 @@ func ParseFileAttributes(sourceName string) FileAttributes {
 		if private {
 			mode &= 0700
 		}
+		// Encrypted files are always private so that decrypted secrets are
+		// never readable by other users.
+		if encrypted {
+			mode = 0600
+		}
 	}
 	if strings.HasPrefix(name, dotPrefix) {
 		name = "." + strings.TrimPrefix(name, dotPrefix)
@@ -74,22 +136,55 @@ func ParseFileAttributes(sourceName string) FileAttributes {
 	if strings.HasSuffix(name, templateSuffix) {
 		name = strings.TrimSuffix(name, templateSuffix)
 		template = true
+		// An extension registered with RegisterTemplateEngine before the
+		// .tmpl suffix selects an alternative template engine.
+		if ext := filepath.Ext(name); ext != "" {
+			if _, ok := registeredTemplateEngine(ext[1:]); ok {
+				name = strings.TrimSuffix(name, ext)
+				engine = ext[1:]
+			}
+		}
 	}
 	return FileAttributes{
-		Name:     name,
-		Mode:     mode,
-		Empty:    empty,
-		Template: template,
+		Name:      name,
+		Mode:      mode,
+		Create:    create,
+		Empty:     empty,
+		Encrypted: encrypted,
+		Script:    script,
+		Once:      once,
+		OnChange:  onChange,
+		Template:  template,
+		Engine:    engine,
 	}
 }
 
 // SourceName returns fa's source name.
 func (fa FileAttributes) SourceName() string {
 	sourceName := ""
-	switch fa.Mode & os.ModeType {
-	case 0:
+	switch {
+	case fa.Script:
+		sourceName = runPrefix
+		switch {
+		case fa.Once:
+			sourceName += oncePrefix
+		case fa.OnChange:
+			sourceName += onChangePrefix
+		}
+	case fa.Mode&os.ModeType == 0:
+		if fa.Create {
+			sourceName = createPrefix
+		}
+		if fa.Encrypted {
+			// Encrypted files are implicitly private and never executable.
+			sourceName += encryptedPrefix
+			if fa.Empty {
+				sourceName += emptyPrefix
+			}
+			break
+		}
 		if fa.Mode.Perm()&os.FileMode(077) == os.FileMode(0) {
-			sourceName = privatePrefix
+			sourceName += privatePrefix
 		}
 		if fa.Empty {
 			sourceName += emptyPrefix
@@ -97,7 +192,7 @@ func (fa FileAttributes) SourceName() string {
 		if fa.Mode.Perm()&os.FileMode(0111) != os.FileMode(0) {
 			sourceName += executablePrefix
 		}
-	case os.ModeSymlink:
+	case fa.Mode&os.ModeType == os.ModeSymlink:
 		sourceName = symlinkPrefix
 	default:
 		panic(fmt.Sprintf("%+v: unsupported type", fa))
@@ -108,6 +203,9 @@ func (fa FileAttributes) SourceName() string {
 		sourceName += fa.Name
 	}
 	if fa.Template {
+		if fa.Engine != "" {
+			sourceName += "." + fa.Engine
+		}
 		sourceName += templateSuffix
 	}
 	return sourceName
@@ -118,23 +216,45 @@ func (f *File) Apply(fs vfs.FS, destDir string, ignore func(string) bool, umask
 	if ignore(f.targetName) {
 		return nil
 	}
+	targetPath := filepath.Join(destDir, f.targetName)
+	if large, sourceInfo, err := f.large(); err != nil {
+		return err
+	} else if large {
+		return f.applyLarge(fs, targetPath, sourceInfo, umask, mutator)
+	}
 	contents, err := f.Contents()
 	if err != nil {
 		return err
 	}
-	targetPath := filepath.Join(destDir, f.targetName)
 	info, err := fs.Lstat(targetPath)
 	var currData []byte
 	switch {
 	case err == nil && info.Mode().IsRegular():
+		// Create-only files are never overwritten once they exist, but
+		// their permissions are still kept up to date.
+		if f.Create {
+			if info.Mode().Perm() != f.Perm&^umask {
+				return mutator.Chmod(targetPath, f.Perm&^umask)
+			}
+			return nil
+		}
 		if isEmpty(contents) && !f.Empty {
 			return mutator.RemoveAll(targetPath)
 		}
-		currData, err = fs.ReadFile(targetPath)
-		if err != nil {
+		if unchanged, err := f.unchanged(info, contents, umask); err != nil {
 			return err
+		} else if unchanged {
+			return nil
 		}
-		if !bytes.Equal(currData, contents) {
+		if equal, err := f.targetContentsEqual(fs, targetPath, info, contents); err != nil {
+			return err
+		} else if !equal {
+			// The mutator needs the current contents, for example to
+			// write a diff.
+			currData, err = fs.ReadFile(targetPath)
+			if err != nil {
+				return err
+			}
 			break
 		}
 		if info.Mode().Perm() != f.Perm&^umask {
@@ -147,7 +267,7 @@ func (f *File) Apply(fs vfs.FS, destDir string, ignore func(string) bool, umask
 		if err := mutator.RemoveAll(targetPath); err != nil {
 			return err
 		}
-	case os.IsNotExist(err):
+	case isNotExist(err):
 	default:
 		return err
 	}
@@ -157,6 +277,123 @@ func (f *File) Apply(fs vfs.FS, destDir string, ignore func(string) bool, umask
 	return mutator.WriteFile(targetPath, contents, f.Perm&^umask, currData)
 }
 
+// applyLarge is like Apply for large contents, whose source file's
+// os.FileInfo is sourceInfo. They are compared with the target by size and
+// hash, or by reading both files in chunks, and are copied from the source
+// file by mutator, so they are never held in memory.
+func (f *File) applyLarge(fs vfs.FS, targetPath string, sourceInfo os.FileInfo, umask os.FileMode, mutator Mutator) error {
+	empty, err := f.empty()
+	if err != nil {
+		return err
+	}
+	info, err := fs.Lstat(targetPath)
+	overwrite := false
+	switch {
+	case err == nil && info.Mode().IsRegular():
+		if f.Create {
+			if info.Mode().Perm() != f.Perm&^umask {
+				return mutator.Chmod(targetPath, f.Perm&^umask)
+			}
+			return nil
+		}
+		if empty && !f.Empty {
+			return mutator.RemoveAll(targetPath)
+		}
+		if equal, err := f.largeTargetEqual(fs, targetPath, info, sourceInfo); err != nil {
+			return err
+		} else if !equal {
+			overwrite = true
+			break
+		}
+		if info.Mode().Perm() != f.Perm&^umask {
+			return mutator.Chmod(targetPath, f.Perm&^umask)
+		}
+		return nil
+	case err == nil:
+		if err := mutator.RemoveAll(targetPath); err != nil {
+			return err
+		}
+	case isNotExist(err):
+	default:
+		return err
+	}
+	if empty && !f.Empty {
+		return nil
+	}
+	return mutator.CopyFile(targetPath, f.sourcePath, sourceInfo.Size(), f.Perm&^umask, overwrite)
+}
+
+// unchanged returns true if the target of f, whose Lstat is info, is known to
+// have contents and permissions from its last written state in f's persistent
+// state, and that state is f's target state, in which case there is no need to
+// read the target's contents.
+func (f *File) unchanged(info os.FileInfo, contents []byte, umask os.FileMode) (bool, error) {
+	lastState, err := lastEntryState(f.persistentState, f.targetName)
+	if err != nil {
+		return false, err
+	}
+	return lastState.matchesInfo(info) &&
+		lastState.Mode == f.Perm&^umask &&
+		lastState.SHA256 == hexSHA256(contents), nil
+}
+
+// targetContentsEqual returns true if the contents of the target of f at
+// targetPath, whose Lstat is info, are contents. Sizes are compared first. If
+// f's persistent state recorded the target's contents at its current size and
+// modification time, or f's content hash cache has a hash of the target, then
+// their hashes are compared, otherwise the target is compared in chunks, so it
+// is never held in memory.
+func (f *File) targetContentsEqual(fs vfs.FS, targetPath string, info os.FileInfo, contents []byte) (bool, error) {
+	if info.Size() != int64(len(contents)) {
+		return false, nil
+	}
+	lastState, err := lastEntryState(f.persistentState, f.targetName)
+	if err != nil {
+		return false, err
+	}
+	if lastState.matchesInfo(info) {
+		return lastState.SHA256 == hexSHA256(contents), nil
+	}
+	if f.contentHashCache != nil {
+		sha256, err := f.contentHashCache.FileSHA256(fs, targetPath, info)
+		if err != nil {
+			return false, err
+		}
+		return sha256 == hexSHA256(contents), nil
+	}
+	return equalFileContents(fs, targetPath, contents)
+}
+
+// largeTargetEqual returns true if the contents of the target of f at
+// targetPath, whose Lstat is info, are f's large contents, whose source file's
+// os.FileInfo is sourceInfo. Sizes are compared first, then hashes if the
+// target's hash is known from f's persistent state or content hash cache,
+// otherwise both files are compared in chunks.
+func (f *File) largeTargetEqual(fs vfs.FS, targetPath string, info, sourceInfo os.FileInfo) (bool, error) {
+	if info.Size() != sourceInfo.Size() {
+		return false, nil
+	}
+	lastState, err := lastEntryState(f.persistentState, f.targetName)
+	if err != nil {
+		return false, err
+	}
+	if !lastState.matchesInfo(info) && f.contentHashCache == nil {
+		return equalFiles(fs, targetPath, f.sourceFS, f.sourcePath)
+	}
+	sha256, err := f.largeSHA256(sourceInfo)
+	if err != nil {
+		return false, err
+	}
+	if lastState.matchesInfo(info) {
+		return lastState.SHA256 == sha256, nil
+	}
+	targetSHA256, err := f.contentHashCache.FileSHA256(fs, targetPath, info)
+	if err != nil {
+		return false, err
+	}
+	return targetSHA256 == sha256, nil
+}
+
 // ConcreteValue implements Entry.ConcreteValue.
 func (f *File) ConcreteValue(destDir string, ignore func(string) bool, sourceDir string, recursive bool) (interface{}, error) {
 	if ignore(f.targetName) {
@@ -170,7 +407,9 @@ func (f *File) ConcreteValue(destDir string, ignore func(string) bool, sourceDir
 		Type:       "file",
 		SourcePath: filepath.Join(sourceDir, f.SourceName()),
 		TargetPath: filepath.Join(destDir, f.TargetName()),
+		Create:     f.Create,
 		Empty:      f.Empty,
+		Encrypted:  f.Encrypted,
 		Perm:       int(f.Perm),
 		Template:   f.Template,
 		Contents:   string(contents),
@@ -179,16 +418,24 @@ func (f *File) ConcreteValue(destDir string, ignore func(string) bool, sourceDir
 
 // Contents returns f's contents.
 func (f *File) Contents() ([]byte, error) {
-	if f.evaluateContents != nil {
+	f.mu.Lock()
+	openContents := f.openContents
+	if openContents == nil && f.evaluateContents != nil {
 		f.contents, f.contentsErr = f.evaluateContents()
 		f.evaluateContents = nil
 	}
-	return f.contents, f.contentsErr
+	contents, contentsErr := f.contents, f.contentsErr
+	f.mu.Unlock()
+	if openContents != nil {
+		return readOpenContents(openContents)
+	}
+	return contents, contentsErr
 }
 
-// Evaluate evaluates f's contents.
+// Evaluate evaluates f's contents. Contents that need no evaluation are not
+// read.
 func (f *File) Evaluate(ignore func(string) bool) error {
-	if ignore(f.targetName) {
+	if ignore(f.targetName) || f.streamed() {
 		return nil
 	}
 	_, err := f.Contents()
@@ -200,6 +447,32 @@ func (f *File) Executable() bool {
 	return f.Perm&0111 != 0
 }
 
+// Reader returns a reader of f's contents and their size. Contents that need
+// no evaluation are streamed from the source file. The caller must close the
+// reader.
+func (f *File) Reader() (io.ReadCloser, int64, error) {
+	f.mu.Lock()
+	openContents := f.openContents
+	f.mu.Unlock()
+	if openContents != nil {
+		file, err := openContents()
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, 0, err
+		}
+		return file, info.Size(), nil
+	}
+	contents, err := f.Contents()
+	if err != nil {
+		return nil, 0, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(contents)), int64(len(contents)), nil
+}
+
 // Private returns true if f is private.
 func (f *File) Private() bool {
 	return f.Perm&077 == 0
@@ -215,26 +488,197 @@ func (f *File) TargetName() string {
 	return f.targetName
 }
 
-// archive writes f to w.
-func (f *File) archive(w *tar.Writer, ignore func(string) bool, headerTemplate *tar.Header, umask os.FileMode) error {
+// archive writes f to w, streaming its contents.
+func (f *File) archive(w *TarWriter, ignore func(string) bool, headerTemplate *tar.Header, umask os.FileMode) error {
 	if ignore(f.targetName) {
 		return nil
 	}
-	contents, err := f.Contents()
+	if !f.Empty {
+		if empty, err := f.empty(); err != nil {
+			return err
+		} else if empty {
+			return nil
+		}
+	}
+	r, size, err := f.Reader()
 	if err != nil {
 		return err
 	}
-	if len(contents) == 0 && !f.Empty {
-		return nil
-	}
+	defer r.Close()
 	header := *headerTemplate
 	header.Typeflag = tar.TypeReg
 	header.Name = f.targetName
-	header.Size = int64(len(contents))
+	header.Size = size
 	header.Mode = int64(f.Perm &^ umask)
+	if file, ok := r.(*os.File); ok {
+		if info, err := file.Stat(); err == nil && isSparse(info) {
+			if segments, err := fileDataSegments(file, size); err == nil {
+				return w.writeSparseFile(&header, file, segments)
+			}
+		}
+	}
 	if err := w.WriteHeader(&header); err != nil {
-		return nil
+		return err
 	}
-	_, err = w.Write(contents)
+	_, err = copyPooled(w, r)
 	return err
 }
+
+// empty returns true if f's contents are empty, reading no more of them than
+// is needed to decide.
+func (f *File) empty() (bool, error) {
+	r, _, err := f.Reader()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	return isEmptyReader(r)
+}
+
+// large returns true if f's contents are streamed from its source file and
+// are larger than f.maxMemorySize, in which case they are never read into
+// memory, and the os.FileInfo of the source file.
+func (f *File) large() (bool, os.FileInfo, error) {
+	if f.maxMemorySize <= 0 || !f.streamed() {
+		return false, nil, nil
+	}
+	info, err := f.sourceFS.Stat(f.sourcePath)
+	if err != nil {
+		return false, nil, err
+	}
+	return info.Size() > f.maxMemorySize, info, nil
+}
+
+// largeSHA256 returns the hex-encoded SHA256 of f's large contents, whose
+// source file's os.FileInfo is sourceInfo, from f's content hash cache if it
+// has one.
+func (f *File) largeSHA256(sourceInfo os.FileInfo) (string, error) {
+	return f.contentHashCache.FileSHA256(f.sourceFS, f.sourcePath, sourceInfo)
+}
+
+// load reads f's contents into memory if they would otherwise be streamed
+// from its source file, so that f no longer depends on its source file.
+func (f *File) load() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.openContents == nil {
+		return nil
+	}
+	f.contents, f.contentsErr = readOpenContents(f.openContents)
+	f.openContents = nil
+	f.sourceFS, f.sourcePath = nil, ""
+	return f.contentsErr
+}
+
+// streamed returns true if f's contents are streamed from its source file.
+func (f *File) streamed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.openContents != nil
+}
+
+// hexSHA256 returns the hex-encoded SHA256 sum of f's contents, streaming
+// them.
+func (f *File) hexSHA256() (string, error) {
+	r, _, err := f.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return readerHexSHA256(r)
+}
+
+// readOpenContents returns all of the contents of the file opened by
+// openContents.
+func readOpenContents(openContents func() (*os.File, error)) ([]byte, error) {
+	file, err := openContents()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// equalFileContents returns true if the contents of the file at path are
+// contents, reading the file in chunks and stopping at the first difference.
+func equalFileContents(fs vfs.FS, path string, contents []byte) (bool, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := file.Read(buf)
+		if n > len(contents) || !bytes.Equal(buf[:n], contents[:n]) {
+			return false, nil
+		}
+		contents = contents[n:]
+		switch {
+		case err == io.EOF:
+			return len(contents) == 0, nil
+		case err != nil:
+			return false, err
+		}
+	}
+}
+
+// equalFiles returns true if the file at path1 in fs1 and the file at path2 in
+// fs2 have the same contents.
+func equalFiles(fs1 vfs.FS, path1 string, fs2 vfs.FS, path2 string) (bool, error) {
+	file1, err := fs1.Open(path1)
+	if err != nil {
+		return false, err
+	}
+	defer file1.Close()
+	file2, err := fs2.Open(path2)
+	if err != nil {
+		return false, err
+	}
+	defer file2.Close()
+	return equalReaders(file1, file2)
+}
+
+// equalReaders returns true if r1 and r2 have the same contents, reading them
+// in chunks and stopping at the first difference.
+func equalReaders(r1, r2 io.Reader) (bool, error) {
+	buf1 := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf1)
+	buf2 := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf2)
+	for {
+		n1, err1 := io.ReadFull(r1, *buf1)
+		if err1 != nil && err1 != io.EOF && err1 != io.ErrUnexpectedEOF {
+			return false, err1
+		}
+		n2, err2 := io.ReadFull(r2, *buf2)
+		if err2 != nil && err2 != io.EOF && err2 != io.ErrUnexpectedEOF {
+			return false, err2
+		}
+		if n1 != n2 || !bytes.Equal((*buf1)[:n1], (*buf2)[:n2]) {
+			return false, nil
+		}
+		// Both readers returned the same short read, so both are at their
+		// ends.
+		if err1 != nil {
+			return true, nil
+		}
+	}
+}
+
+// isEmptyReader returns true if the contents of r are empty, like isEmpty,
+// stopping at the first rune that is not white space.
+func isEmptyReader(r io.Reader) (bool, error) {
+	br := bufio.NewReader(r)
+	for {
+		c, _, err := br.ReadRune()
+		switch {
+		case err == io.EOF:
+			return true, nil
+		case err != nil:
+			return false, err
+		case !unicode.IsSpace(c):
+			return false, nil
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package chezmoi
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
 )
 
 func TestFileAttributes(t *testing.T) {
@@ -84,6 +88,59 @@ func TestFileAttributes(t *testing.T) {
 				Template: true,
 			},
 		},
+		{
+			sourceName: "encrypted_foo",
+			fa: FileAttributes{
+				Name:      "foo",
+				Mode:      0600,
+				Encrypted: true,
+			},
+		},
+		{
+			sourceName: "encrypted_empty_dot_foo.tmpl",
+			fa: FileAttributes{
+				Name:      ".foo",
+				Mode:      0600,
+				Empty:     true,
+				Encrypted: true,
+				Template:  true,
+			},
+		},
+		{
+			sourceName: "create_private_dot_foo",
+			fa: FileAttributes{
+				Name:   ".foo",
+				Mode:   0600,
+				Create: true,
+			},
+		},
+		{
+			sourceName: "create_encrypted_foo.tmpl",
+			fa: FileAttributes{
+				Name:      "foo",
+				Mode:      0600,
+				Create:    true,
+				Encrypted: true,
+				Template:  true,
+			},
+		},
+		{
+			sourceName: "dot_foo.star.tmpl",
+			fa: FileAttributes{
+				Name:     ".foo",
+				Mode:     0666,
+				Template: true,
+				Engine:   "star",
+			},
+		},
+		{
+			sourceName: "foo.bar.tmpl",
+			fa: FileAttributes{
+				Name:     "foo.bar",
+				Mode:     0666,
+				Template: true,
+			},
+		},
 		{
 			sourceName: "symlink_foo",
 			fa: FileAttributes{
@@ -106,6 +163,41 @@ func TestFileAttributes(t *testing.T) {
 				Template: true,
 			},
 		},
+		{
+			sourceName: "run_foo",
+			fa: FileAttributes{
+				Name:   "foo",
+				Mode:   0700,
+				Script: true,
+			},
+		},
+		{
+			sourceName: "run_once_foo",
+			fa: FileAttributes{
+				Name:   "foo",
+				Mode:   0700,
+				Script: true,
+				Once:   true,
+			},
+		},
+		{
+			sourceName: "run_onchange_foo",
+			fa: FileAttributes{
+				Name:     "foo",
+				Mode:     0700,
+				Script:   true,
+				OnChange: true,
+			},
+		},
+		{
+			sourceName: "run_dot_foo.tmpl",
+			fa: FileAttributes{
+				Name:     ".foo",
+				Mode:     0700,
+				Script:   true,
+				Template: true,
+			},
+		},
 	} {
 		t.Run(tc.sourceName, func(t *testing.T) {
 			gotFA := ParseFileAttributes(tc.sourceName)
@@ -118,3 +210,264 @@ func TestFileAttributes(t *testing.T) {
 		})
 	}
 }
+
+func TestFileStreamsContents(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi/dot_bashrc":      "# bashrc\n",
+		"/home/user/.chezmoi/dot_hgrc.tmpl":   "# {{ .name }}\n",
+		"/home/user/.chezmoi/empty_dot_empty": " \n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", map[string]interface{}{"name": "user"}, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+	}
+	if err := ts.Evaluate(); err != nil {
+		t.Fatalf("ts.Evaluate() == %v, want <nil>", err)
+	}
+	for _, tc := range []struct {
+		targetName   string
+		wantStreamed bool
+		wantContents string
+		wantEmpty    bool
+	}{
+		{
+			targetName:   ".bashrc",
+			wantStreamed: true,
+			wantContents: "# bashrc\n",
+		},
+		{
+			targetName:   ".empty",
+			wantStreamed: true,
+			wantContents: " \n",
+			wantEmpty:    true,
+		},
+		{
+			targetName:   ".hgrc",
+			wantContents: "# user\n",
+		},
+	} {
+		t.Run(tc.targetName, func(t *testing.T) {
+			f := ts.Entries[tc.targetName].(*File)
+			if gotStreamed := f.contents == nil; gotStreamed != tc.wantStreamed {
+				t.Errorf("f.contents == nil is %v, want %v", gotStreamed, tc.wantStreamed)
+			}
+			r, size, err := f.Reader()
+			if err != nil {
+				t.Fatalf("f.Reader() == _, _, %v, want _, _, <nil>", err)
+			}
+			defer r.Close()
+			gotContents, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ioutil.ReadAll(_) == _, %v, want _, <nil>", err)
+			}
+			if string(gotContents) != tc.wantContents || size != int64(len(tc.wantContents)) {
+				t.Errorf("f.Reader() read %q with size %d, want %q with size %d", gotContents, size, tc.wantContents, len(tc.wantContents))
+			}
+			if gotEmpty, err := f.empty(); err != nil || gotEmpty != tc.wantEmpty {
+				t.Errorf("f.empty() == %v, %v, want %v, <nil>", gotEmpty, err, tc.wantEmpty)
+			}
+		})
+	}
+}
+
+func TestIsEmptyReader(t *testing.T) {
+	for _, s := range []string{"", " ", "\n\t\n", " \n", "a", " a ", "\xff"} {
+		want := isEmpty([]byte(s))
+		if got, err := isEmptyReader(strings.NewReader(s)); err != nil || got != want {
+			t.Errorf("isEmptyReader(%q) == %v, %v, want %v, <nil>", s, got, err, want)
+		}
+	}
+}
+
+func TestEqualFileContents(t *testing.T) {
+	large := strings.Repeat("0123456789abcdef", 4096)
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/empty": "",
+		"/home/user/large": large,
+		"/home/user/small": "small",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	for _, tc := range []struct {
+		path     string
+		contents string
+		want     bool
+	}{
+		{path: "/home/user/empty", contents: "", want: true},
+		{path: "/home/user/empty", contents: "small", want: false},
+		{path: "/home/user/large", contents: large, want: true},
+		{path: "/home/user/large", contents: large[:len(large)-1] + "!", want: false},
+		{path: "/home/user/large", contents: large[:len(large)-1], want: false},
+		{path: "/home/user/large", contents: large + "!", want: false},
+		{path: "/home/user/small", contents: "small", want: true},
+		{path: "/home/user/small", contents: "smal", want: false},
+	} {
+		if got, err := equalFileContents(fs, tc.path, []byte(tc.contents)); err != nil || got != tc.want {
+			t.Errorf("equalFileContents(_, %q, %d bytes) == %v, %v, want %v, <nil>", tc.path, len(tc.contents), got, err, tc.want)
+		}
+	}
+}
+
+func TestFileTargetContentsEqual(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.bashrc": &vfst.File{Perm: 0644, Contents: []byte("# old\n")},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	info, err := fs.Lstat("/home/user/.bashrc")
+	if err != nil {
+		t.Fatalf("fs.Lstat(_) == _, %v, want _, <nil>", err)
+	}
+	persistentState := NewMemoryPersistentState()
+	f := &File{
+		targetName:      ".bashrc",
+		Perm:            0644,
+		persistentState: persistentState,
+	}
+	for _, tc := range []struct {
+		name      string
+		lastState *EntryState
+		contents  string
+		want      bool
+	}{
+		{name: "read_equal", contents: "# old\n", want: true},
+		{name: "read_different", contents: "# new\n", want: false},
+		{name: "different_size", contents: "# newer\n", want: false},
+		{
+			// The recorded state's hash is trusted over the target's
+			// contents, so the target is not read.
+			name: "hash_different",
+			lastState: &EntryState{
+				Type:    "file",
+				Mode:    0644,
+				Size:    info.Size(),
+				ModTime: info.ModTime().UnixNano(),
+				SHA256:  hexSHA256([]byte("# new\n")),
+			},
+			contents: "# old\n",
+			want:     false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := persistentState.Delete(entryStateBucket, []byte(".bashrc")); err != nil {
+				t.Fatalf("persistentState.Delete(...) == %v, want <nil>", err)
+			}
+			if tc.lastState != nil {
+				value, err := json.Marshal(tc.lastState)
+				if err != nil {
+					t.Fatalf("json.Marshal(_) == _, %v, want _, <nil>", err)
+				}
+				if err := persistentState.Set(entryStateBucket, []byte(".bashrc"), value); err != nil {
+					t.Fatalf("persistentState.Set(...) == %v, want <nil>", err)
+				}
+			}
+			if got, err := f.targetContentsEqual(fs, "/home/user/.bashrc", info, []byte(tc.contents)); err != nil || got != tc.want {
+				t.Errorf("f.targetContentsEqual(_, _, _, %q) == %v, %v, want %v, <nil>", tc.contents, got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestFileApplyLarge(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".large": "# old contents of .large\n",
+			".same":  "# contents of .same\n",
+			".chezmoi": map[string]interface{}{
+				"dot_large": "# contents of .large\n",
+				"dot_new":   "# contents of .new\n",
+				"dot_same":  "# contents of .same\n",
+				"dot_small": "small\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	ts.MaxMemorySize = 8
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+	}
+	plan, err := ts.Plan(fs)
+	if err != nil {
+		t.Fatalf("ts.Plan(_) == _, %v, want _, <nil>", err)
+	}
+	// Large files are copied from their source files instead of being read.
+	wantOperations := []Operation{
+		{
+			Type:   OperationOverwrite,
+			Name:   "/home/user/.large",
+			Mode:   0644,
+			Source: "/home/user/.chezmoi/dot_large",
+			Size:   int64(len("# contents of .large\n")),
+		},
+		{
+			Type:   OperationCreate,
+			Name:   "/home/user/.new",
+			Mode:   0644,
+			Source: "/home/user/.chezmoi/dot_new",
+			Size:   int64(len("# contents of .new\n")),
+		},
+		{
+			Type: OperationCreate,
+			Name: "/home/user/.small",
+			Mode: 0644,
+			Data: []byte("small\n"),
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(wantOperations, plan.Operations); !equal {
+		t.Errorf("ts.Plan(_).Operations == %+v, want %+v, diff:\n%s", plan.Operations, wantOperations, diff)
+	}
+	if err := ts.Apply(fs, NewFSMutator(fs, "/home/user")); err != nil {
+		t.Fatalf("ts.Apply(_, _) == %v, want <nil>", err)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.large",
+			vfst.TestModePerm(0644),
+			vfst.TestContentsString("# contents of .large\n"),
+		),
+		vfst.TestPath("/home/user/.new",
+			vfst.TestModePerm(0644),
+			vfst.TestContentsString("# contents of .new\n"),
+		),
+	)
+	report, err := ts.Verify(fs)
+	if err != nil {
+		t.Fatalf("ts.Verify(_) == _, %v, want _, <nil>", err)
+	}
+	if len(report.Drifts) != 0 {
+		t.Errorf("ts.Verify(_).Drifts == %+v, want []", report.Drifts)
+	}
+}
+
+func TestEqualReaders(t *testing.T) {
+	large := strings.Repeat("0123456789abcdef", 4096)
+	for _, tc := range []struct {
+		s1   string
+		s2   string
+		want bool
+	}{
+		{s1: "", s2: "", want: true},
+		{s1: "", s2: "small", want: false},
+		{s1: "small", s2: "small", want: true},
+		{s1: "small", s2: "smal", want: false},
+		{s1: large, s2: large, want: true},
+		{s1: large, s2: large[:len(large)-1] + "!", want: false},
+		{s1: large, s2: large[:len(large)-1], want: false},
+		{s1: large, s2: large + "!", want: false},
+	} {
+		if got, err := equalReaders(strings.NewReader(tc.s1), strings.NewReader(tc.s2)); err != nil || got != tc.want {
+			t.Errorf("equalReaders(%d bytes, %d bytes) == %v, %v, want %v, <nil>", len(tc.s1), len(tc.s2), got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package chezmoi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// errFreeSpaceUnsupported is returned by freeSpace on platforms where the free
+// space cannot be determined.
+var errFreeSpaceUnsupported = errors.New("free space query not supported")
+
+// A FreeSpaceOptions contains options for CheckFreeSpace.
+type FreeSpaceOptions struct {
+	// Margin is the number of bytes that must remain free after applying.
+	Margin uint64
+	// FreeSpace returns the number of bytes available to the current user on
+	// the filesystem containing path. If nil, the platform's native query is
+	// used.
+	FreeSpace func(path string) (uint64, error)
+}
+
+// An InsufficientSpaceError is returned when there is not enough free space
+// to apply a target state.
+type InsufficientSpaceError struct {
+	Path      string
+	Required  uint64
+	Available uint64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("%s: insufficient space: %d bytes required, %d bytes available", e.Path, e.Required, e.Available)
+}
+
+// CheckFreeSpace returns an *InsufficientSpaceError if applying entries to
+// destDir in fs would write more bytes, plus freeSpaceOptions.Margin, than are
+// available on destDir's filesystem. No changes are made. The check is skipped
+// if fs is not backed by the OS filesystem or if the platform cannot report
+// free space.
+func CheckFreeSpace(fs vfs.FS, destDir string, entries []Entry, ignore func(string) bool, umask os.FileMode, freeSpaceOptions FreeSpaceOptions) error {
+	freeSpaceFunc := freeSpaceOptions.FreeSpace
+	if freeSpaceFunc == nil {
+		freeSpaceFunc = freeSpace
+	}
+	path, ok := osPath(fs, destDir)
+	if !ok {
+		return nil
+	}
+	mutator := NewByteCountingMutator(NullMutator)
+	for _, entry := range entries {
+		if err := entry.Apply(fs, destDir, ignore, umask, mutator); err != nil {
+			return err
+		}
+	}
+	if mutator.Bytes() == 0 {
+		return nil
+	}
+	required := mutator.Bytes() + freeSpaceOptions.Margin
+	// destDir may not exist yet, so query its nearest existing ancestor.
+	for {
+		if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+	available, err := freeSpaceFunc(path)
+	switch {
+	case err == errFreeSpaceUnsupported:
+		return nil
+	case err != nil:
+		return err
+	}
+	if required > available {
+		return &InsufficientSpaceError{
+			Path:      destDir,
+			Required:  required,
+			Available: available,
+		}
+	}
+	return nil
+}
+
+// CheckFreeSpace checks that there is enough free space to apply ts. See
+// CheckFreeSpace.
+func (ts *TargetState) CheckFreeSpace(fs vfs.FS, freeSpaceOptions FreeSpaceOptions) error {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	entries := make([]Entry, 0, len(ts.Entries))
+	for _, entryName := range ts.nameCache.sortedNames(ts.Entries) {
+		entries = append(entries, ts.Entries[entryName])
+	}
+	return CheckFreeSpace(fs, ts.DestDir, entries, ts.TargetIgnore.Match, ts.Umask, freeSpaceOptions)
+}
@@ -0,0 +1,7 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!windows
+
+package chezmoi
+
+func freeSpace(path string) (uint64, error) {
+	return 0, errFreeSpaceUnsupported
+}
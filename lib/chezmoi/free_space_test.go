@@ -0,0 +1,101 @@
+package chezmoi
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateCheckFreeSpace(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc": "# contents of .bashrc\n",
+			".chezmoi": map[string]interface{}{
+				"dot_bashrc":          "# contents of .bashrc\n",
+				"dot_inputrc":         "0123456789",
+				"dir/foo":             "0123456789",
+				"symlink_dot_bar":     "foo",
+				"empty_dot_hushlogin": "",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	// .bashrc is already up to date, so only .inputrc, dir/foo, and .bar
+	// are written.
+	wantRequired := uint64(10 + 10 + 3)
+	errFreeSpace := errors.New("free space")
+	for _, tc := range []struct {
+		name      string
+		margin    uint64
+		available uint64
+		err       error
+		wantErr   error
+	}{
+		{
+			name:      "enough",
+			available: wantRequired,
+		},
+		{
+			name:      "insufficient",
+			available: wantRequired - 1,
+			wantErr: &InsufficientSpaceError{
+				Path:      "/home/user",
+				Required:  wantRequired,
+				Available: wantRequired - 1,
+			},
+		},
+		{
+			name:      "margin",
+			margin:    100,
+			available: wantRequired + 99,
+			wantErr: &InsufficientSpaceError{
+				Path:      "/home/user",
+				Required:  wantRequired + 100,
+				Available: wantRequired + 99,
+			},
+		},
+		{
+			name: "unsupported",
+			err:  errFreeSpaceUnsupported,
+		},
+		{
+			name:    "error",
+			err:     errFreeSpace,
+			wantErr: errFreeSpace,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			freeSpaceOptions := FreeSpaceOptions{
+				Margin: tc.margin,
+				FreeSpace: func(string) (uint64, error) {
+					return tc.available, tc.err
+				},
+			}
+			err := ts.CheckFreeSpace(fs, freeSpaceOptions)
+			switch wantErr := tc.wantErr.(type) {
+			case *InsufficientSpaceError:
+				gotErr, ok := err.(*InsufficientSpaceError)
+				if !ok || *gotErr != *wantErr {
+					t.Errorf("ts.CheckFreeSpace(...) == %v, want %v", err, wantErr)
+				}
+			default:
+				if err != tc.wantErr {
+					t.Errorf("ts.CheckFreeSpace(...) == %v, want %v", err, tc.wantErr)
+				}
+			}
+		})
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.inputrc",
+			vfst.TestDoesNotExist,
+		),
+	)
+}
@@ -0,0 +1,13 @@
+// +build darwin dragonfly freebsd linux
+
+package chezmoi
+
+import "syscall"
+
+func freeSpace(path string) (uint64, error) {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &statfs); err != nil {
+		return 0, err
+	}
+	return uint64(statfs.Bavail) * uint64(statfs.Bsize), nil
+}
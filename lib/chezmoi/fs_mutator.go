@@ -1,8 +1,14 @@
 package chezmoi
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"syscall"
 
@@ -13,58 +19,100 @@ import (
 // An FSMutator makes changes to an vfs.FS.
 type FSMutator struct {
 	vfs.FS
-	devCache     map[string]uint // devCache maps directories to device numbers.
-	tempDirCache map[uint]string // tempDir maps device numbers to renameio temporary directories.
 }
 
 // NewFSMutator returns an mutator that acts on fs.
 func NewFSMutator(fs vfs.FS, destDir string) *FSMutator {
 	return &FSMutator{
-		FS:           fs,
-		devCache:     make(map[string]uint),
-		tempDirCache: make(map[uint]string),
+		FS: fs,
 	}
 }
 
-// WriteFile implements Mutator.WriteFile.
-func (a *FSMutator) WriteFile(name string, data []byte, perm os.FileMode, currData []byte) error {
-	// Special case: if writing to the real filesystem, use github.com/google/renameio
-	if a.FS == vfs.OSFS {
-		dir := filepath.Dir(name)
-		dev, ok := a.devCache[dir]
-		if !ok {
-			info, err := a.Stat(dir)
-			if err != nil {
-				return err
-			}
-			statT, ok := info.Sys().(*syscall.Stat_t)
-			if !ok {
-				return errors.New("os.FileInfo.Sys() cannot be converted to a *syscall.Stat_t")
-			}
-			dev = uint(statT.Dev)
-			a.devCache[dir] = dev
-		}
-		tempDir, ok := a.tempDirCache[dev]
-		if !ok {
-			tempDir = renameio.TempDir(dir)
-			a.tempDirCache[dev] = tempDir
+// CopyFile implements Mutator.CopyFile. If the filesystem supports it, like
+// Btrfs, XFS, and APFS do, then name is replaced with a clone of source that
+// shares its data, so nothing is copied. Otherwise, name is replaced like
+// WriteFile, with the contents of source copied through a small buffer. If
+// source is sparse then only its data is copied, so name has the same holes.
+// If overwrite is false then name must not already exist. An error is returned,
+// and name is left unchanged, if the copy is not size bytes long, for example
+// because source changed after size was determined.
+func (a *FSMutator) CopyFile(name, source string, size int64, perm os.FileMode, overwrite bool) error {
+	if !overwrite {
+		if _, err := a.FS.Lstat(name); err == nil {
+			return &os.PathError{Op: "create", Path: name, Err: os.ErrExist}
+		} else if !os.IsNotExist(err) {
+			return err
 		}
-		t, err := renameio.TempFile(tempDir, name)
+	}
+	if err := a.cloneFile(name, source, size, perm); err == nil {
+		return nil
+	}
+	return a.writeFile(name, perm, func(f *os.File) error {
+		sourceFile, err := a.FS.Open(source)
 		if err != nil {
 			return err
 		}
-		defer func() {
-			_ = t.Cleanup()
-		}()
-		if err := t.Chmod(perm); err != nil {
+		defer sourceFile.Close()
+		if err := copyFileContents(f, sourceFile); err != nil {
 			return err
 		}
-		if _, err := t.Write(data); err != nil {
+		info, err := f.Stat()
+		if err != nil {
 			return err
 		}
-		return t.CloseAtomicallyReplace()
+		return checkCopySize(source, info.Size(), size)
+	})
+}
+
+// RunScript implements Mutator.RunScript. The script is written to a temporary
+// file and executed with dir as its working directory. The script is killed if
+// ctx is done before it exits.
+func (a *FSMutator) RunScript(ctx context.Context, name, dir string, data []byte) (bool, error) {
+	osDir, ok := osPath(a.FS, dir)
+	if !ok {
+		return false, fmt.Errorf("%s: cannot run scripts on this filesystem", name)
 	}
-	return a.FS.WriteFile(name, data, perm)
+	f, err := ioutil.TempFile("", "chezmoi-*-"+filepath.Base(name))
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = os.RemoveAll(f.Name())
+	}()
+	if err := f.Chmod(0700); err != nil {
+		f.Close()
+		return false, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+	cmd := exec.CommandContext(ctx, f.Name())
+	cmd.Dir = osDir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return true, fmt.Errorf("%s: %v", name, err)
+	}
+	return true, nil
+}
+
+// WriteFile implements Mutator.WriteFile. The file is replaced atomically,
+// so that an interrupted write never leaves it truncated: data is written to a
+// temporary file in the same directory, which is synced to disk and given its
+// permissions before it is renamed over name. If the directory does not allow
+// the temporary file to be created, or the filesystem does not support renaming
+// it over name, then name is written in place instead. Errors writing the
+// temporary file, like running out of space, are returned with name unchanged.
+func (a *FSMutator) WriteFile(name string, data []byte, perm os.FileMode, currData []byte) error {
+	return a.writeFile(name, perm, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
 }
 
 // WriteSymlink implements Mutator.WriteSymlink.
@@ -78,3 +126,160 @@ func (a *FSMutator) WriteSymlink(oldname, newname string) error {
 	}
 	return a.FS.Symlink(oldname, newname)
 }
+
+// cloneFile atomically replaces name with a clone of source with permissions
+// perm, like WriteFile. It returns an error, and leaves name unchanged, if the
+// clone cannot be made, for example because the filesystem does not support
+// clones or because name and source are on different filesystems, or if the
+// clone is not size bytes long.
+func (a *FSMutator) cloneFile(name, source string, size int64, perm os.FileMode) error {
+	tempName, err := tempFileName(name)
+	if err != nil {
+		return err
+	}
+	osSource, ok := osPath(a.FS, source)
+	if !ok {
+		return errCloneUnsupported
+	}
+	osTempName, ok := osPath(a.FS, tempName)
+	if !ok {
+		return errCloneUnsupported
+	}
+	if err := cloneFile(osSource, osTempName); err != nil {
+		return err
+	}
+	if info, err := a.FS.Stat(tempName); err != nil {
+		_ = a.FS.Remove(tempName)
+		return err
+	} else if err := checkCopySize(source, info.Size(), size); err != nil {
+		_ = a.FS.Remove(tempName)
+		return err
+	}
+	if err := a.FS.Chmod(tempName, perm); err != nil {
+		_ = a.FS.Remove(tempName)
+		return err
+	}
+	if err := a.FS.Rename(tempName, name); err != nil {
+		_ = a.FS.Remove(tempName)
+		return err
+	}
+	a.syncDir(filepath.Dir(name))
+	return nil
+}
+
+// syncDir syncs dir to disk so that renames in it are durable. Errors are
+// ignored as not all platforms support syncing directories.
+func (a *FSMutator) syncDir(dir string) {
+	f, err := a.FS.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = f.Sync()
+	_ = f.Close()
+}
+
+// writeFile replaces name with a file with permissions perm whose contents
+// are written by write, as described in WriteFile. write may be called more
+// than once, each time with a new empty file. name is only written in place if
+// the temporary file cannot be created because the directory does not allow
+// it, or cannot be renamed because the filesystem does not support it. Any
+// other error is returned with name unchanged.
+func (a *FSMutator) writeFile(name string, perm os.FileMode, write func(*os.File) error) error {
+	f, tempName, err := a.createTempFile(name)
+	if os.IsPermission(err) || errors.Is(err, syscall.ENOTSUP) {
+		return a.writeFileInPlace(name, perm, write)
+	} else if err != nil {
+		return err
+	}
+	if err := writeTempFile(f, perm, write); err != nil {
+		_ = a.FS.Remove(tempName)
+		return err
+	}
+	if err := a.FS.Rename(tempName, name); err != nil {
+		_ = a.FS.Remove(tempName)
+		if errors.Is(err, syscall.EXDEV) || errors.Is(err, syscall.ENOTSUP) {
+			return a.writeFileInPlace(name, perm, write)
+		}
+		return err
+	}
+	a.syncDir(filepath.Dir(name))
+	return nil
+}
+
+// createTempFile creates a new empty temporary file in the same directory as
+// name, and returns it and its name.
+func (a *FSMutator) createTempFile(name string) (*os.File, string, error) {
+	for i := 0; ; i++ {
+		tempName, err := tempFileName(name)
+		if err != nil {
+			return nil, "", err
+		}
+		f, err := a.FS.OpenFile(tempName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return f, tempName, nil
+		}
+		if !os.IsExist(err) || i >= 10 {
+			return nil, "", err
+		}
+	}
+}
+
+// writeFileInPlace writes name with write, truncating it if it already
+// exists, and syncs it to disk.
+func (a *FSMutator) writeFileInPlace(name string, perm os.FileMode, write func(*os.File) error) error {
+	f, err := a.FS.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return a.FS.Chmod(name, perm)
+}
+
+// writeTempFile writes the new temporary file f with write, gives it
+// permissions perm, syncs it to disk, and closes it.
+func writeTempFile(f *os.File, perm os.FileMode, write func(*os.File) error) error {
+	err := func() error {
+		if err := write(f); err != nil {
+			return err
+		}
+		if err := f.Chmod(perm); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// checkCopySize returns an error if a copy of source is copied bytes long
+// instead of size bytes long.
+func checkCopySize(source string, copied, size int64) error {
+	if copied != size {
+		return fmt.Errorf("%s: copied %d bytes, want %d", source, copied, size)
+	}
+	return nil
+}
+
+// tempFileName returns a random name for a temporary file in the same directory
+// as name. The name is read from crypto/rand, so concurrent processes never
+// choose the same sequence of names.
+func tempFileName(name string) (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	dir, base := filepath.Split(name)
+	return filepath.Join(dir, "."+base+".chezmoi-"+hex.EncodeToString(random)), nil
+}
@@ -0,0 +1,308 @@
+package chezmoi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	vfs "github.com/twpayne/go-vfs"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+// A noRenameFS is a vfs.FS whose Rename method always fails, like some
+// network filesystems.
+type noRenameFS struct {
+	vfs.FS
+}
+
+func (noRenameFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.ENOTSUP}
+}
+
+// A noCreateFS is a vfs.FS that does not allow new files to be created, like a
+// directory that is not writable that contains writable files.
+type noCreateFS struct {
+	vfs.FS
+}
+
+func (fs noCreateFS) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	if flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EACCES}
+	}
+	return fs.FS.OpenFile(name, flag, perm)
+}
+
+// A renameErrorFS is a vfs.FS whose Rename method always fails with err.
+type renameErrorFS struct {
+	vfs.FS
+	err error
+}
+
+func (fs renameErrorFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.err}
+}
+
+func TestFSMutatorWriteFile(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		wrapFS func(vfs.FS) vfs.FS
+	}{
+		{
+			name: "rename",
+			wrapFS: func(fs vfs.FS) vfs.FS {
+				return fs
+			},
+		},
+		{
+			name: "in_place",
+			wrapFS: func(fs vfs.FS) vfs.FS {
+				return noRenameFS{FS: fs}
+			},
+		},
+		{
+			name: "no_create",
+			wrapFS: func(fs vfs.FS) vfs.FS {
+				return noCreateFS{FS: fs}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user": map[string]interface{}{
+					".bashrc": &vfst.File{
+						Perm:     0600,
+						Contents: []byte("# old contents of .bashrc, which are longer\n"),
+					},
+				},
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			mutator := NewFSMutator(tc.wrapFS(fs), "/home/user")
+			if err := mutator.WriteFile("/home/user/.bashrc", []byte("# contents of .bashrc\n"), 0644, nil); err != nil {
+				t.Fatalf("mutator.WriteFile(...) == %v, want <nil>", err)
+			}
+			if err := mutator.WriteFile("/home/user/.profile", []byte("# contents of .profile\n"), 0755, nil); err != nil {
+				t.Fatalf("mutator.WriteFile(...) == %v, want <nil>", err)
+			}
+			vfst.RunTests(t, fs, "",
+				vfst.TestPath("/home/user/.bashrc",
+					vfst.TestModeIsRegular,
+					vfst.TestModePerm(0644),
+					vfst.TestContentsString("# contents of .bashrc\n"),
+				),
+				vfst.TestPath("/home/user/.profile",
+					vfst.TestModeIsRegular,
+					vfst.TestModePerm(0755),
+					vfst.TestContentsString("# contents of .profile\n"),
+				),
+			)
+			// No temporary files are left behind.
+			infos, err := fs.ReadDir("/home/user")
+			if err != nil {
+				t.Fatalf("fs.ReadDir(%q) == _, %v, want _, <nil>", "/home/user", err)
+			}
+			if got, want := len(infos), 2; got != want {
+				t.Errorf("len(fs.ReadDir(%q)) == %d, want %d", "/home/user", got, want)
+			}
+		})
+	}
+}
+
+func TestFSMutatorWriteFileError(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		wrapFS  func(vfs.FS) vfs.FS
+		write   func(*os.File) error
+		wantErr error
+	}{
+		{
+			name: "no_space",
+			wrapFS: func(fs vfs.FS) vfs.FS {
+				return fs
+			},
+			write: func(f *os.File) error {
+				if _, err := f.Write([]byte("# partial")); err != nil {
+					return err
+				}
+				return syscall.ENOSPC
+			},
+			wantErr: syscall.ENOSPC,
+		},
+		{
+			name: "rename_error",
+			wrapFS: func(fs vfs.FS) vfs.FS {
+				return renameErrorFS{FS: fs, err: syscall.EIO}
+			},
+			write: func(f *os.File) error {
+				_, err := f.Write([]byte("# contents of .bashrc\n"))
+				return err
+			},
+			wantErr: syscall.EIO,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user": map[string]interface{}{
+					".bashrc": "# old contents of .bashrc\n",
+				},
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			mutator := NewFSMutator(tc.wrapFS(fs), "/home/user")
+			if err := mutator.writeFile("/home/user/.bashrc", 0644, tc.write); !errors.Is(err, tc.wantErr) {
+				t.Errorf("mutator.writeFile(...) == %v, want %v", err, tc.wantErr)
+			}
+			// The original file is unchanged and no temporary files are left
+			// behind.
+			vfst.RunTests(t, fs, "",
+				vfst.TestPath("/home/user/.bashrc",
+					vfst.TestContentsString("# old contents of .bashrc\n"),
+				),
+			)
+			infos, err := fs.ReadDir("/home/user")
+			if err != nil {
+				t.Fatalf("fs.ReadDir(%q) == _, %v, want _, <nil>", "/home/user", err)
+			}
+			if got, want := len(infos), 1; got != want {
+				t.Errorf("len(fs.ReadDir(%q)) == %d, want %d", "/home/user", got, want)
+			}
+		})
+	}
+}
+
+func TestFSMutatorCopyFile(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		wrapFS func(vfs.FS) vfs.FS
+	}{
+		{
+			name: "rename",
+			wrapFS: func(fs vfs.FS) vfs.FS {
+				return fs
+			},
+		},
+		{
+			name: "in_place",
+			wrapFS: func(fs vfs.FS) vfs.FS {
+				return noRenameFS{FS: fs}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user": map[string]interface{}{
+					".large": &vfst.File{
+						Perm:     0600,
+						Contents: []byte("# old contents of .large, which are longer\n"),
+					},
+					".chezmoi": map[string]interface{}{
+						"dot_large": "# contents of .large\n",
+					},
+				},
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			// Files are cloned if the filesystem supports it, and copied
+			// otherwise.
+			mutator := NewFSMutator(tc.wrapFS(fs), "/home/user")
+			size := int64(len("# contents of .large\n"))
+			if err := mutator.CopyFile("/home/user/.large", "/home/user/.chezmoi/dot_large", size, 0644, true); err != nil {
+				t.Fatalf("mutator.CopyFile(...) == %v, want <nil>", err)
+			}
+			if err := mutator.CopyFile("/home/user/.new", "/home/user/.chezmoi/dot_large", size, 0755, false); err != nil {
+				t.Fatalf("mutator.CopyFile(...) == %v, want <nil>", err)
+			}
+			vfst.RunTests(t, fs, "",
+				vfst.TestPath("/home/user/.large",
+					vfst.TestModeIsRegular,
+					vfst.TestModePerm(0644),
+					vfst.TestContentsString("# contents of .large\n"),
+				),
+				vfst.TestPath("/home/user/.new",
+					vfst.TestModeIsRegular,
+					vfst.TestModePerm(0755),
+					vfst.TestContentsString("# contents of .large\n"),
+				),
+			)
+			// No temporary files are left behind.
+			infos, err := fs.ReadDir("/home/user")
+			if err != nil {
+				t.Fatalf("fs.ReadDir(%q) == _, %v, want _, <nil>", "/home/user", err)
+			}
+			if got, want := len(infos), 3; got != want {
+				t.Errorf("len(fs.ReadDir(%q)) == %d, want %d", "/home/user", got, want)
+			}
+		})
+	}
+}
+
+func TestFSMutatorCopyFileErrors(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".large": "# old contents of .large\n",
+			".chezmoi": map[string]interface{}{
+				"dot_large": "# contents of .large\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	mutator := NewFSMutator(fs, "/home/user")
+	size := int64(len("# contents of .large\n"))
+	// Existing files are only replaced if overwrite is set.
+	if err := mutator.CopyFile("/home/user/.large", "/home/user/.chezmoi/dot_large", size, 0644, false); !os.IsExist(err) {
+		t.Errorf("mutator.CopyFile(%q, _, _, _, false) == %v, want an exist error", "/home/user/.large", err)
+	}
+	// Copies whose size differs from the expected size, for example because
+	// the source changed, are rejected.
+	if err := mutator.CopyFile("/home/user/.large", "/home/user/.chezmoi/dot_large", size+1, 0644, true); err == nil {
+		t.Errorf("mutator.CopyFile(%q, _, %d, _, true) == <nil>, want !<nil>", "/home/user/.large", size+1)
+	}
+	if err := mutator.CopyFile("/home/user/.new", "/home/user/.chezmoi/dot_large", size-1, 0644, false); err == nil {
+		t.Errorf("mutator.CopyFile(%q, _, %d, _, false) == <nil>, want !<nil>", "/home/user/.new", size-1)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.large",
+			vfst.TestContentsString("# old contents of .large\n"),
+		),
+		vfst.TestPath("/home/user/.new",
+			vfst.TestDoesNotExist,
+		),
+	)
+	// No temporary files are left behind.
+	infos, err := fs.ReadDir("/home/user")
+	if err != nil {
+		t.Fatalf("fs.ReadDir(%q) == _, %v, want _, <nil>", "/home/user", err)
+	}
+	if got, want := len(infos), 2; got != want {
+		t.Errorf("len(fs.ReadDir(%q)) == %d, want %d", "/home/user", got, want)
+	}
+}
+
+func TestTempFileName(t *testing.T) {
+	names := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		name, err := tempFileName("/home/user/.bashrc")
+		if err != nil {
+			t.Fatalf("tempFileName(%q) == _, %v, want _, <nil>", "/home/user/.bashrc", err)
+		}
+		if dir, base := filepath.Split(name); dir != "/home/user/" || !strings.HasPrefix(base, "..bashrc.chezmoi-") {
+			t.Errorf("tempFileName(%q) == %q, want a hidden file next to .bashrc", "/home/user/.bashrc", name)
+		}
+		if names[name] {
+			t.Errorf("tempFileName(%q) == %q, which was already returned", "/home/user/.bashrc", name)
+		}
+		names[name] = true
+	}
+}
@@ -0,0 +1,121 @@
+package chezmoi
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// A GPG is an Encryption that uses gpg.
+type GPG struct {
+	// Command is the gpg command. If it is empty then "gpg" is used.
+	Command string
+	// Args are extra arguments passed to every invocation of Command, for
+	// example "--homedir".
+	Args []string
+	// Recipient is the key that files are encrypted for. If it is empty
+	// then files are encrypted for the default key.
+	Recipient string
+	// Symmetric encrypts files with a passphrase instead of a key.
+	Symmetric bool
+	// Passphrase, if not nil, returns the passphrase that is passed to gpg,
+	// instead of gpg prompting for it. It is called at most once, and the
+	// passphrase is cached in memory for the lifetime of the GPG. It is not
+	// supported on Windows.
+	Passphrase   func() ([]byte, error)
+	passphrase   []byte
+	passphraseMu sync.Mutex
+}
+
+// Decrypt implements Decryptor.Decrypt.
+func (g *GPG) Decrypt(name string, ciphertext []byte) ([]byte, error) {
+	plaintext, err := g.run(ciphertext, "--decrypt", "--quiet")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return plaintext, nil
+}
+
+// DecryptFile implements Encryption.DecryptFile.
+func (g *GPG) DecryptFile(fs vfs.FS, path string) ([]byte, error) {
+	return decryptFile(g, fs, path)
+}
+
+// Encrypt implements Encryptor.Encrypt.
+func (g *GPG) Encrypt(name string, plaintext []byte) ([]byte, error) {
+	var args []string
+	switch {
+	case g.Symmetric:
+		args = []string{"--armor", "--symmetric", "--quiet"}
+	case g.Recipient == "":
+		args = []string{"--armor", "--encrypt", "--quiet", "--default-recipient-self"}
+	default:
+		args = []string{"--armor", "--encrypt", "--quiet", "--recipient", g.Recipient}
+	}
+	ciphertext, err := g.run(plaintext, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return ciphertext, nil
+}
+
+// EncryptFile implements Encryption.EncryptFile.
+func (g *GPG) EncryptFile(fs vfs.FS, path string) ([]byte, error) {
+	return encryptFile(g, fs, path)
+}
+
+// getPassphrase returns the passphrase, calling g.Passphrase if it is not yet
+// cached. Concurrent callers wait for the first, so the user is prompted at
+// most once.
+func (g *GPG) getPassphrase() ([]byte, error) {
+	g.passphraseMu.Lock()
+	defer g.passphraseMu.Unlock()
+	if g.passphrase == nil {
+		passphrase, err := g.Passphrase()
+		if err != nil {
+			return nil, err
+		}
+		g.passphrase = passphrase
+	}
+	return g.passphrase, nil
+}
+
+// run runs g's command with args and stdin, and returns its output. If
+// g.Passphrase is set then the passphrase is passed to gpg on file descriptor
+// 3.
+func (g *GPG) run(stdin []byte, args ...string) ([]byte, error) {
+	command := g.Command
+	if command == "" {
+		command = "gpg"
+	}
+	argv := append([]string{}, g.Args...)
+	var passphraseReader *os.File
+	if g.Passphrase != nil {
+		passphrase, err := g.getPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		_, err = w.Write(passphrase)
+		w.Close()
+		if err != nil {
+			return nil, err
+		}
+		passphraseReader = r
+		argv = append(argv, "--batch", "--pinentry-mode", "loopback", "--passphrase-fd", "3")
+	}
+	cmd := exec.Command(command, append(argv, args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	if passphraseReader != nil {
+		cmd.ExtraFiles = []*os.File{passphraseReader}
+	}
+	return commandOutput(cmd)
+}
@@ -0,0 +1,90 @@
+package chezmoi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestGPG(t *testing.T) {
+	command, err := exec.LookPath("gpg")
+	if err != nil {
+		t.Skip("gpg not found in $PATH")
+	}
+	homeDir, err := ioutil.TempDir("", "chezmoi-test-gpg")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(homeDir)
+	defer exec.Command("gpgconf", "--homedir", homeDir, "--kill", "gpg-agent").Run()
+	args := []string{"--homedir", homeDir, "--batch"}
+	recipient := "chezmoi-test@example.com"
+	if output, err := exec.Command(command, append(args, "--passphrase", "", "--quick-generate-key", recipient)...).CombinedOutput(); err != nil {
+		t.Fatalf("gpg --quick-generate-key: %v: %s", err, output)
+	}
+
+	g := &GPG{
+		Command:   command,
+		Args:      args,
+		Recipient: recipient,
+	}
+	plaintext := []byte("machine example.com\n")
+	ciphertext, err := g.Encrypt(".netrc", plaintext)
+	if err != nil {
+		t.Fatalf("g.Encrypt(_, %q) == _, %v, want _, <nil>", plaintext, err)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Errorf("g.Encrypt(_, %q) == %q, want encrypted contents", plaintext, ciphertext)
+	}
+	if got, err := g.Decrypt(".netrc", ciphertext); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("g.Decrypt(_, _) == %q, %v, want %q, <nil>", got, err, plaintext)
+	}
+	if _, err := g.Decrypt(".netrc", plaintext); err == nil {
+		t.Errorf("g.Decrypt(_, %q) == _, <nil>, want _, !<nil>", plaintext)
+	}
+}
+
+func TestGPGSymmetric(t *testing.T) {
+	command, err := exec.LookPath("gpg")
+	if err != nil {
+		t.Skip("gpg not found in $PATH")
+	}
+	homeDir, err := ioutil.TempDir("", "chezmoi-test-gpg")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(homeDir)
+	defer exec.Command("gpgconf", "--homedir", homeDir, "--kill", "gpg-agent").Run()
+
+	prompts := 0
+	newGPG := func(passphrase string) *GPG {
+		return &GPG{
+			Command:   command,
+			Args:      []string{"--homedir", homeDir},
+			Symmetric: true,
+			Passphrase: func() ([]byte, error) {
+				prompts++
+				return []byte(passphrase), nil
+			},
+		}
+	}
+	g := newGPG("correct horse battery staple")
+	plaintext := []byte("token\n")
+	ciphertext, err := g.Encrypt("token", plaintext)
+	if err != nil {
+		t.Fatalf("g.Encrypt(_, %q) == _, %v, want _, <nil>", plaintext, err)
+	}
+	for i := 0; i < 2; i++ {
+		if got, err := g.Decrypt("token", ciphertext); err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("g.Decrypt(_, _) == %q, %v, want %q, <nil>", got, err, plaintext)
+		}
+	}
+	if prompts != 1 {
+		t.Errorf("prompts == %d, want 1", prompts)
+	}
+	if _, err := newGPG("wrong").Decrypt("token", ciphertext); err == nil {
+		t.Errorf("Decrypt with the wrong passphrase == _, <nil>, want _, !<nil>")
+	}
+}
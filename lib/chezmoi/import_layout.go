@@ -0,0 +1,278 @@
+package chezmoi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// A ConflictPolicy determines what happens when an imported target is already
+// provided by another stow package or is already in the source state as a
+// different type.
+type ConflictPolicy int
+
+// ConflictPolicies.
+const (
+	ConflictError     ConflictPolicy = iota // Return an error.
+	ConflictSkip                            // Keep the existing target.
+	ConflictOverwrite                       // Replace the existing target.
+)
+
+// An ImportReport records the targets imported from another dotfile layout.
+// Each target is in only one list. Targets that conflicted are in Conflicted,
+// however the conflict was resolved.
+type ImportReport struct {
+	Imported   []string `json:"imported" yaml:"imported"`
+	Skipped    []string `json:"skipped" yaml:"skipped"`
+	Conflicted []string `json:"conflicted" yaml:"conflicted"`
+}
+
+// An ImportStowOptions contains options for TargetState.ImportStow.
+type ImportStowOptions struct {
+	Packages       []string // Packages to import, or all packages if empty.
+	Dotfiles       bool     // Dotfiles converts dot- prefixes to ., like stow --dotfiles.
+	ConflictPolicy ConflictPolicy
+}
+
+// stowIgnorePatterns are the patterns that stow ignores by default.
+var stowIgnorePatterns = []string{
+	"RCS", "*,v", "CVS", ".#*", ".cvsignore", ".svn", "_darcs", ".hg", ".git",
+	".gitignore", ".gitmodules", ".stow-local-ignore", "*~", "#*#", "README*",
+	"LICENSE*", "COPYING",
+}
+
+// ImportPaths adds the targets at targetPaths, for example the paths tracked by
+// a bare git repo in the destination directory, to ts. Targets that do not
+// exist or are ignored are skipped. Targets that are already in the source
+// state as a different type are conflicts and are skipped.
+func (ts *TargetState) ImportPaths(fs vfs.FS, targetPaths []string, addOptions AddOptions, mutator Mutator) (*ImportReport, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	report := &ImportReport{}
+	for _, targetPath := range targetPaths {
+		if !filepath.IsAbs(targetPath) {
+			targetPath = filepath.Join(ts.DestDir, targetPath)
+		}
+		targetName, err := filepath.Rel(ts.DestDir, targetPath)
+		if err != nil {
+			return report, err
+		}
+		if ts.TargetIgnore.Match(targetName) {
+			report.Skipped = append(report.Skipped, targetName)
+			continue
+		}
+		info, err := fs.Lstat(targetPath)
+		switch {
+		case os.IsNotExist(err):
+			report.Skipped = append(report.Skipped, targetName)
+			continue
+		case err != nil:
+			return report, err
+		}
+		entry, err := ts.get(targetPath)
+		if err != nil && !os.IsNotExist(err) {
+			return report, err
+		}
+		if entry != nil && !sameEntryType(entry, info) {
+			report.Conflicted = append(report.Conflicted, targetName)
+			continue
+		}
+		if err := ts.add(fs, addOptions, targetPath, info, mutator); err != nil {
+			return report, err
+		}
+		report.Imported = append(report.Imported, targetName)
+	}
+	return report, nil
+}
+
+// ImportStow imports the packages in the stow directory stowDir into ts.
+// Each package is a directory tree whose contents are stowed relative to
+// ts.DestDir. Directories shared between packages are merged, and targets
+// provided by more than one package, or that are already in the source state as
+// a different type, are resolved with importStowOptions.ConflictPolicy.
+func (ts *TargetState) ImportStow(fs vfs.FS, stowDir string, importStowOptions ImportStowOptions, mutator Mutator) (*ImportReport, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	packages := importStowOptions.Packages
+	if len(packages) == 0 {
+		infos, err := fs.ReadDir(stowDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if info.IsDir() && !stowIgnore(info.Name()) {
+				packages = append(packages, info.Name())
+			}
+		}
+	}
+	sort.Strings(packages)
+
+	report := &ImportReport{}
+	importedBy := make(map[string]string)
+	for _, pkg := range packages {
+		pkgDir := filepath.Join(stowDir, pkg)
+		if err := vfs.Walk(fs, pkgDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(pkgDir, path)
+			if err != nil {
+				return err
+			}
+			if relPath == "." {
+				return nil
+			}
+			if stowIgnore(info.Name()) {
+				report.Skipped = append(report.Skipped, filepath.Join(pkg, relPath))
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			targetName := relPath
+			if importStowOptions.Dotfiles {
+				targetName = stowDotfilesName(relPath)
+			}
+			if ts.TargetIgnore.Match(targetName) {
+				report.Skipped = append(report.Skipped, targetName)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			parentDirSourceName, entries, err := ts.ensureDirs(filepath.Dir(targetName), false, mutator)
+			if err != nil {
+				return err
+			}
+			name := filepath.Base(targetName)
+			existingEntry := entries[name]
+			if _, ok := existingEntry.(*Dir); ok && info.IsDir() {
+				return nil
+			}
+			otherPkg, importedByOtherPkg := importedBy[targetName]
+			if importedByOtherPkg || existingEntry != nil && !sameEntryType(existingEntry, info) {
+				switch importStowOptions.ConflictPolicy {
+				case ConflictSkip:
+					report.conflict(targetName, false)
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				case ConflictOverwrite:
+					if err := mutator.RemoveAll(filepath.Join(ts.SourceDir, existingEntry.SourceName())); err != nil {
+						return err
+					}
+					delete(entries, name)
+					for importedTargetName := range importedBy {
+						if importedTargetName == targetName || strings.HasPrefix(importedTargetName, targetName+string(filepath.Separator)) {
+							delete(importedBy, importedTargetName)
+						}
+					}
+					report.conflict(targetName, true)
+				default:
+					if importedByOtherPkg {
+						return fmt.Errorf("%s: provided by both %s and %s", targetName, otherPkg, pkg)
+					}
+					return fmt.Errorf("%s: already in source state as a different type", targetName)
+				}
+			}
+			if info.IsDir() {
+				return ts.addDir(targetName, entries, parentDirSourceName, false, info.Mode().Perm(), false, mutator)
+			}
+			switch {
+			case info.Mode().IsRegular():
+				contents, err := fs.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				if err := ts.addFile(targetName, entries, parentDirSourceName, info, false, false, contents, mutator); err != nil {
+					return err
+				}
+			case info.Mode()&os.ModeType == os.ModeSymlink:
+				linkname, err := fs.Readlink(path)
+				if err != nil {
+					return err
+				}
+				if err := ts.addSymlink(targetName, entries, parentDirSourceName, linkname, mutator); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("%s: not a regular file, directory, or symlink", path)
+			}
+			importedBy[targetName] = pkg
+			if !report.isConflicted(targetName) {
+				report.Imported = append(report.Imported, targetName)
+			}
+			return nil
+		}); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// conflict records that targetName conflicted. Each target is reported in only
+// one list, so targetName is removed from r.Imported, as are the targets beneath
+// it if it was replaced.
+func (r *ImportReport) conflict(targetName string, replaced bool) {
+	imported := r.Imported[:0]
+	for _, importedTargetName := range r.Imported {
+		if importedTargetName != targetName && !(replaced && strings.HasPrefix(importedTargetName, targetName+string(filepath.Separator))) {
+			imported = append(imported, importedTargetName)
+		}
+	}
+	r.Imported = imported
+	if !r.isConflicted(targetName) {
+		r.Conflicted = append(r.Conflicted, targetName)
+	}
+}
+
+// isConflicted returns true if targetName has been reported as conflicted.
+func (r *ImportReport) isConflicted(targetName string) bool {
+	for _, conflictedTargetName := range r.Conflicted {
+		if conflictedTargetName == targetName {
+			return true
+		}
+	}
+	return false
+}
+
+// sameEntryType returns true if entry has the same type as info.
+func sameEntryType(entry Entry, info os.FileInfo) bool {
+	switch entry.(type) {
+	case *Dir:
+		return info.IsDir()
+	case *File:
+		return info.Mode().IsRegular()
+	case *Symlink:
+		return info.Mode()&os.ModeType == os.ModeSymlink
+	default:
+		return false
+	}
+}
+
+// stowDotfilesName converts the dot- prefixes of each component of relPath to
+// a leading dot.
+func stowDotfilesName(relPath string) string {
+	components := splitPathList(relPath)
+	for i, component := range components {
+		if strings.HasPrefix(component, "dot-") {
+			components[i] = "." + strings.TrimPrefix(component, "dot-")
+		}
+	}
+	return filepath.Join(components...)
+}
+
+// stowIgnore returns true if stow ignores name by default.
+func stowIgnore(name string) bool {
+	for _, pattern := range stowIgnorePatterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
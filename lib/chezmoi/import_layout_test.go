@@ -0,0 +1,262 @@
+package chezmoi
+
+import (
+	"os"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateImportStow(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		importStowOptions ImportStowOptions
+		wantErr           bool
+		wantReport        *ImportReport
+		tests             []vfst.Test
+	}{
+		{
+			name: "conflict_error",
+			importStowOptions: ImportStowOptions{
+				Dotfiles: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "conflict_skip",
+			importStowOptions: ImportStowOptions{
+				Dotfiles:       true,
+				ConflictPolicy: ConflictSkip,
+			},
+			wantReport: &ImportReport{
+				Imported:   []string{".bash_profile", ".inputrc", ".vim/colors/theme.vim", ".vimrc"},
+				Skipped:    []string{"bash/README.md"},
+				Conflicted: []string{".bashrc"},
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.bashrc",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# bash .bashrc\n"),
+				),
+			},
+		},
+		{
+			name: "conflict_overwrite",
+			importStowOptions: ImportStowOptions{
+				Dotfiles:       true,
+				ConflictPolicy: ConflictOverwrite,
+			},
+			wantReport: &ImportReport{
+				Imported:   []string{".bash_profile", ".inputrc", ".vim/colors/theme.vim", ".vimrc"},
+				Skipped:    []string{"bash/README.md"},
+				Conflicted: []string{".bashrc"},
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.bashrc",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# override .bashrc\n"),
+				),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user/.chezmoi": &vfst.Dir{Perm: 0700},
+				"/home/user/dotfiles": map[string]interface{}{
+					"bash": map[string]interface{}{
+						"README.md":        "# bash package\n",
+						"dot-bashrc":       "# bash .bashrc\n",
+						"dot-bash_profile": "# bash .bash_profile\n",
+						"dot-inputrc":      &vfst.Symlink{Target: ".config/inputrc"},
+					},
+					"override": map[string]interface{}{
+						"dot-bashrc": "# override .bashrc\n",
+					},
+					"vim": map[string]interface{}{
+						"dot-vimrc":                "# vim .vimrc\n",
+						"dot-vim/colors/theme.vim": "# vim theme\n",
+					},
+				},
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+			report, err := ts.ImportStow(fs, "/home/user/dotfiles", tc.importStowOptions, NewFSMutator(fs, "/home/user"))
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ts.ImportStow(...) == _, <nil>, want _, !<nil>")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ts.ImportStow(...) == _, %v, want _, <nil>", err)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.wantReport, report); !equal {
+				t.Errorf("ts.ImportStow(...) report diff:\n%s", diff)
+			}
+
+			// Apply a freshly populated target state and check that the
+			// result matches the stowed layout.
+			ts2 := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+			if err := ts2.Populate(fs); err != nil {
+				t.Fatalf("ts2.Populate(%+v) == %v, want <nil>", fs, err)
+			}
+			if err := ts2.Apply(fs, NewFSMutator(fs, "/home/user")); err != nil {
+				t.Fatalf("ts2.Apply(...) == %v, want <nil>", err)
+			}
+			vfst.RunTests(t, fs, "",
+				vfst.TestPath("/home/user/.bash_profile",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# bash .bash_profile\n"),
+				),
+				vfst.TestPath("/home/user/.inputrc",
+					vfst.TestModeType(os.ModeSymlink),
+					vfst.TestSymlinkTarget(".config/inputrc"),
+				),
+				vfst.TestPath("/home/user/.vim/colors/theme.vim",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# vim theme\n"),
+				),
+				vfst.TestPath("/home/user/.vimrc",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# vim .vimrc\n"),
+				),
+				vfst.TestPath("/home/user/README.md",
+					vfst.TestDoesNotExist,
+				),
+				tc.tests,
+			)
+		})
+	}
+}
+
+func TestTargetStateImportStowDirConflict(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		conflictPolicy ConflictPolicy
+		wantErr        bool
+		wantReport     *ImportReport
+		tests          []vfst.Test
+	}{
+		{
+			name:           "conflict_error",
+			conflictPolicy: ConflictError,
+			wantErr:        true,
+		},
+		{
+			name:           "conflict_skip",
+			conflictPolicy: ConflictSkip,
+			wantReport: &ImportReport{
+				Imported:   []string{},
+				Conflicted: []string{".config"},
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.chezmoi/dot_config",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# file .config\n"),
+				),
+			},
+		},
+		{
+			name:           "conflict_overwrite",
+			conflictPolicy: ConflictOverwrite,
+			wantReport: &ImportReport{
+				Imported:   []string{".config/git/config"},
+				Conflicted: []string{".config"},
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.chezmoi/dot_config/git/config",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# git config\n"),
+				),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user/.chezmoi": &vfst.Dir{Perm: 0700},
+				"/home/user/dotfiles": map[string]interface{}{
+					"a": map[string]interface{}{
+						"dot-config": "# file .config\n",
+					},
+					"b": map[string]interface{}{
+						"dot-config/git/config": "# git config\n",
+					},
+				},
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+			importStowOptions := ImportStowOptions{
+				Dotfiles:       true,
+				ConflictPolicy: tc.conflictPolicy,
+			}
+			report, err := ts.ImportStow(fs, "/home/user/dotfiles", importStowOptions, NewFSMutator(fs, "/home/user"))
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ts.ImportStow(...) == _, <nil>, want _, !<nil>")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ts.ImportStow(...) == _, %v, want _, <nil>", err)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.wantReport, report); !equal {
+				t.Errorf("ts.ImportStow(...) report diff:\n%s", diff)
+			}
+			vfst.RunTests(t, fs, "", tc.tests)
+		})
+	}
+}
+
+func TestTargetStateImportPaths(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".chezmoi": map[string]interface{}{
+				"symlink_dot_vimrc": ".config/vimrc",
+			},
+			".bashrc":            "# contents of .bashrc\n",
+			".config/git/config": "# contents of .config/git/config\n",
+			".vimrc":             "# contents of .vimrc\n",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	report, err := ts.ImportPaths(fs, []string{".bashrc", ".config/git/config", ".missing", "/home/user/.vimrc"}, AddOptions{}, NewFSMutator(fs, "/home/user"))
+	if err != nil {
+		t.Fatalf("ts.ImportPaths(...) == _, %v, want _, <nil>", err)
+	}
+	wantReport := &ImportReport{
+		Imported:   []string{".bashrc", ".config/git/config"},
+		Skipped:    []string{".missing"},
+		Conflicted: []string{".vimrc"},
+	}
+	if diff, equal := messagediff.PrettyDiff(wantReport, report); !equal {
+		t.Errorf("ts.ImportPaths(...) report diff:\n%s", diff)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.chezmoi/dot_bashrc",
+			vfst.TestModeIsRegular,
+			vfst.TestContentsString("# contents of .bashrc\n"),
+		),
+		vfst.TestPath("/home/user/.chezmoi/dot_config/git/config",
+			vfst.TestModeIsRegular,
+			vfst.TestContentsString("# contents of .config/git/config\n"),
+		),
+		vfst.TestPath("/home/user/.chezmoi/symlink_dot_vimrc",
+			vfst.TestModeIsRegular,
+			vfst.TestContentsString(".config/vimrc"),
+		),
+	)
+}
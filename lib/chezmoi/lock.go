@@ -0,0 +1,81 @@
+package chezmoi
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// errLockHeld is returned by lockFile when another process holds the lock.
+var errLockHeld = errors.New("lock held")
+
+// A LockedError is returned by AcquireLock when another process holds the lock.
+type LockedError struct {
+	Path string
+	PID  int // PID is the process that holds the lock, or zero if unknown.
+}
+
+// A Lock is an exclusive advisory lock on a file, held until it is released
+// or the process exits.
+type Lock struct {
+	f *os.File
+}
+
+// AcquireLock acquires an exclusive advisory lock on path in fs, creating path
+// and its parent directories if needed, and records the current process's PID
+// in it. If another process holds the lock then it returns a *LockedError
+// without waiting. On platforms without advisory locks, AcquireLock always
+// succeeds.
+func AcquireLock(fs vfs.FS, path string) (*Lock, error) {
+	if err := vfs.MkdirAll(fs, filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := fs.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		var pid int
+		if err == errLockHeld {
+			if data, err := ioutil.ReadAll(f); err == nil {
+				pid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+			}
+		}
+		f.Close()
+		if err == errLockHeld {
+			return nil, &LockedError{Path: path, PID: pid}
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release releases l.
+func (l *Lock) Release() error {
+	if err := unlockFile(l.f); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+func (e *LockedError) Error() string {
+	if e.PID != 0 {
+		return fmt.Sprintf("%s: another instance of chezmoi (pid %d) is running", e.Path, e.PID)
+	}
+	return fmt.Sprintf("%s: another instance of chezmoi is running", e.Path)
+}
@@ -0,0 +1,13 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!windows
+
+package chezmoi
+
+import "os"
+
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
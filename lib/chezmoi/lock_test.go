@@ -0,0 +1,48 @@
+package chezmoi
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestAcquireLock(t *testing.T) {
+	switch runtime.GOOS {
+	case "darwin", "dragonfly", "freebsd", "linux", "windows":
+	default:
+		t.Skip("advisory locks not supported")
+	}
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": &vfst.Dir{Perm: 0755},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	path := "/home/user/.local/state/chezmoi/chezmoi.lock"
+	lock, err := AcquireLock(fs, path)
+	if err != nil {
+		t.Fatalf("AcquireLock(_, %q) == _, %v, want _, <nil>", path, err)
+	}
+	_, err = AcquireLock(fs, path)
+	lockedErr, ok := err.(*LockedError)
+	if !ok {
+		t.Fatalf("AcquireLock(_, %q) == _, %v, want _, a *LockedError", path, err)
+	}
+	// Windows does not allow the PID to be read while the lock is held.
+	if runtime.GOOS != "windows" && lockedErr.PID != os.Getpid() {
+		t.Errorf("AcquireLock(_, %q) == _, %+v, want _, a *LockedError with PID %d", path, lockedErr, os.Getpid())
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("lock.Release() == %v, want <nil>", err)
+	}
+	lock, err = AcquireLock(fs, path)
+	if err != nil {
+		t.Fatalf("AcquireLock(_, %q) == _, %v, want _, <nil>", path, err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("lock.Release() == %v, want <nil>", err)
+	}
+}
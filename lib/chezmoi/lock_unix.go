@@ -0,0 +1,22 @@
+// +build darwin dragonfly freebsd linux
+
+package chezmoi
+
+import (
+	"os"
+	"syscall"
+)
+
+func lockFile(f *os.File) error {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK {
+			return errLockHeld
+		}
+		return err
+	}
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -1,20 +1,25 @@
 package chezmoi
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/pmezard/go-difflib/difflib"
 )
 
 // A LoggingMutator wraps an Mutator and logs all of the actions it executes
-// and any errors.
+// and any errors. It is safe for concurrent use if its Mutator is, and the log
+// of each action is written in a single write.
 type LoggingMutator struct {
-	m Mutator
-	w io.Writer
+	m  Mutator
+	mu sync.Mutex
+	w  io.Writer
 }
 
 // NewLoggingMutator returns a new LoggingMutator.
@@ -30,9 +35,22 @@ func (m *LoggingMutator) Chmod(name string, mode os.FileMode) error {
 	action := fmt.Sprintf("chmod %o %s", mode, name)
 	err := m.m.Chmod(name, mode)
 	if err == nil {
-		_, _ = fmt.Fprintln(m.w, action)
+		m.logf("%s\n", action)
 	} else {
-		_, _ = fmt.Fprintf(m.w, "%s: %v\n", action, err)
+		m.logf("%s: %v\n", action, err)
+	}
+	return err
+}
+
+// CopyFile implements Mutator.CopyFile. The contents are not diffed, as they
+// are too large to hold in memory.
+func (m *LoggingMutator) CopyFile(name, source string, size int64, perm os.FileMode, overwrite bool) error {
+	action := fmt.Sprintf("install -m %o %s %s", perm, source, name)
+	err := m.m.CopyFile(name, source, size, perm, overwrite)
+	if err == nil {
+		m.logf("%s\n", action)
+	} else {
+		m.logf("%s: %v\n", action, err)
 	}
 	return err
 }
@@ -42,9 +60,9 @@ func (m *LoggingMutator) Mkdir(name string, perm os.FileMode) error {
 	action := fmt.Sprintf("mkdir -m %o %s", perm, name)
 	err := m.m.Mkdir(name, perm)
 	if err == nil {
-		_, _ = fmt.Fprintln(m.w, action)
+		m.logf("%s\n", action)
 	} else {
-		_, _ = fmt.Fprintf(m.w, "%s: %v\n", action, err)
+		m.logf("%s: %v\n", action, err)
 	}
 	return err
 }
@@ -54,9 +72,9 @@ func (m *LoggingMutator) RemoveAll(name string) error {
 	action := fmt.Sprintf("rm -rf %s", name)
 	err := m.m.RemoveAll(name)
 	if err == nil {
-		_, _ = fmt.Fprintln(m.w, action)
+		m.logf("%s\n", action)
 	} else {
-		_, _ = fmt.Fprintf(m.w, "%s: %v\n", action, err)
+		m.logf("%s: %v\n", action, err)
 	}
 	return err
 }
@@ -66,13 +84,25 @@ func (m *LoggingMutator) Rename(oldpath, newpath string) error {
 	action := fmt.Sprintf("mv %s %s", oldpath, newpath)
 	err := m.m.Rename(oldpath, newpath)
 	if err == nil {
-		_, _ = fmt.Fprintln(m.w, action)
+		m.logf("%s\n", action)
 	} else {
-		_, _ = fmt.Fprintf(m.w, "%s: %v\n", action, err)
+		m.logf("%s: %v\n", action, err)
 	}
 	return err
 }
 
+// RunScript implements Mutator.RunScript.
+func (m *LoggingMutator) RunScript(ctx context.Context, name, dir string, data []byte) (bool, error) {
+	action := fmt.Sprintf("( cd %s && %s )", dir, name)
+	ran, err := m.m.RunScript(ctx, name, dir, data)
+	if err == nil {
+		m.logf("%s\n", action)
+	} else {
+		m.logf("%s: %v\n", action, err)
+	}
+	return ran, err
+}
+
 // Stat implements Mutator.Stat.
 func (m *LoggingMutator) Stat(name string) (os.FileInfo, error) {
 	return m.m.Stat(name)
@@ -83,7 +113,8 @@ func (m *LoggingMutator) WriteFile(name string, data []byte, perm os.FileMode, c
 	action := fmt.Sprintf("install -m %o /dev/null %s", perm, name)
 	err := m.m.WriteFile(name, data, perm, currData)
 	if err == nil {
-		_, _ = fmt.Fprintln(m.w, action)
+		b := &bytes.Buffer{}
+		b.WriteString(action + "\n")
 		if !isBinary(currData) && !isBinary(data) {
 			unifiedDiff := difflib.UnifiedDiff{
 				A:        difflib.SplitLines(string(currData)),
@@ -93,12 +124,13 @@ func (m *LoggingMutator) WriteFile(name string, data []byte, perm os.FileMode, c
 				Context:  3,
 				Eol:      "\n",
 			}
-			if err := difflib.WriteUnifiedDiff(m.w, unifiedDiff); err != nil {
+			if err := difflib.WriteUnifiedDiff(b, unifiedDiff); err != nil {
 				return err
 			}
 		}
+		m.write(b.Bytes())
 	} else {
-		_, _ = fmt.Fprintf(m.w, "%s: %v\n", action, err)
+		m.logf("%s: %v\n", action, err)
 	}
 	return err
 }
@@ -108,9 +140,9 @@ func (m *LoggingMutator) WriteSymlink(oldname, newname string) error {
 	action := fmt.Sprintf("ln -sf %s %s", oldname, newname)
 	err := m.m.WriteSymlink(oldname, newname)
 	if err == nil {
-		_, _ = fmt.Fprintln(m.w, action)
+		m.logf("%s\n", action)
 	} else {
-		_, _ = fmt.Fprintf(m.w, "%s: %v\n", action, err)
+		m.logf("%s: %v\n", action, err)
 	}
 	return err
 }
@@ -118,3 +150,15 @@ func (m *LoggingMutator) WriteSymlink(oldname, newname string) error {
 func isBinary(data []byte) bool {
 	return len(data) != 0 && !strings.HasPrefix(http.DetectContentType(data), "text/")
 }
+
+// logf writes a formatted message to m's log.
+func (m *LoggingMutator) logf(format string, args ...interface{}) {
+	m.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+// write writes data to m's log.
+func (m *LoggingMutator) write(data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _ = m.w.Write(data)
+}
@@ -0,0 +1,66 @@
+package chezmoi
+
+import (
+	"path/filepath"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// Managed returns the names of all the targets managed by ts, in the order in
+// which a walk of the destination directory would visit them. Ignored targets
+// and scripts, which do not create targets, are not included.
+func (ts *TargetState) Managed() ([]string, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	var targetNames []string
+	if err := walkSortedEntries(ts.Entries, ts.nameCache.sortedNames(ts.Entries), func(entry Entry) error {
+		if !isScript(entry) && !ts.TargetIgnore.Match(entry.TargetName()) {
+			targetNames = append(targetNames, entry.TargetName())
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return targetNames, nil
+}
+
+// Unmanaged returns the names of the targets in fs that are neither managed by
+// ts nor ignored, in the order in which a walk of the destination directory
+// would visit them. The contents of unmanaged directories are not included.
+func (ts *TargetState) Unmanaged(fs vfs.FS) ([]string, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	var targetNames []string
+	if err := ts.unmanaged(fs, "", ts.Entries, &targetNames); err != nil {
+		return nil, err
+	}
+	return targetNames, nil
+}
+
+// unmanaged appends the names of the unmanaged targets in the target directory
+// dirName, whose entries are entries, to targetNames.
+func (ts *TargetState) unmanaged(fs vfs.FS, dirName string, entries map[string]Entry, targetNames *[]string) error {
+	infos, err := fs.ReadDir(filepath.Join(ts.DestDir, dirName))
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		targetName := filepath.Join(dirName, info.Name())
+		if ts.TargetIgnore.Match(targetName) {
+			continue
+		}
+		// Scripts do not create targets, so a target with the same name as a
+		// script is unmanaged.
+		entry, ok := entries[info.Name()]
+		if !ok || isScript(entry) {
+			*targetNames = append(*targetNames, targetName)
+			continue
+		}
+		if dir, ok := entry.(*Dir); ok && info.IsDir() {
+			if err := ts.unmanaged(fs, targetName, dir.Entries, targetNames); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package chezmoi
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateManagedAndUnmanaged(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc":  "# contents of .bashrc\n",
+			".history": "# unmanaged\n",
+			".ignored": "# ignored\n",
+			"hello":    "# same name as a script\n",
+			".dir": map[string]interface{}{
+				"file":  "# contents of file\n",
+				"other": "# unmanaged\n",
+			},
+			".cache": map[string]interface{}{
+				"file": "# in an unmanaged directory\n",
+			},
+			".chezmoi": map[string]interface{}{
+				".chezmoiignore":   ".ignored\n.chezmoi\n",
+				"dot_bashrc":       "# contents of .bashrc\n",
+				"dot_profile":      "# contents of .profile\n",
+				"symlink_dot_link": ".bashrc",
+				"dot_dir": map[string]interface{}{
+					"file": "# contents of file\n",
+				},
+				"run_hello": "#!/bin/sh\necho hello\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+
+	gotManaged, err := ts.Managed()
+	if err != nil {
+		t.Fatalf("ts.Managed() == _, %v, want _, <nil>", err)
+	}
+	wantManaged := []string{".bashrc", ".dir", ".dir/file", ".link", ".profile"}
+	if diff, equal := messagediff.PrettyDiff(wantManaged, gotManaged); !equal {
+		t.Errorf("ts.Managed() diff:\n%s", diff)
+	}
+
+	gotUnmanaged, err := ts.Unmanaged(fs)
+	if err != nil {
+		t.Fatalf("ts.Unmanaged(%+v) == _, %v, want _, <nil>", fs, err)
+	}
+	wantUnmanaged := []string{".cache", ".dir/other", ".history", "hello"}
+	if diff, equal := messagediff.PrettyDiff(wantUnmanaged, gotUnmanaged); !equal {
+		t.Errorf("ts.Unmanaged(%+v) diff:\n%s", fs, diff)
+	}
+}
+
+func BenchmarkTargetStateManaged(b *testing.B) {
+	ts := newBenchmarkTargetState(100, 50)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ts.Managed(); err != nil {
+			b.Fatalf("ts.Managed() == _, %v, want _, <nil>", err)
+		}
+	}
+}
+
+// newBenchmarkTargetState returns a new TargetState with dirs directories in
+// its root, each of which contains files files.
+func newBenchmarkTargetState(dirs, files int) *TargetState {
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	for i := 0; i < dirs; i++ {
+		dirName := fmt.Sprintf("dir%d", i)
+		dir := newDir(dirName, dirName, false, 0777)
+		for j := 0; j < files; j++ {
+			fileName := fmt.Sprintf("file%d", j)
+			dir.Entries[fileName] = &File{
+				sourceName: filepath.Join(dirName, fileName),
+				targetName: filepath.Join(dirName, fileName),
+				Perm:       0666,
+			}
+		}
+		ts.Entries[dirName] = dir
+	}
+	return ts
+}
@@ -0,0 +1,87 @@
+package chezmoi
+
+import (
+	"sort"
+	"sync"
+)
+
+// A MemoryPersistentState is a PersistentState that is held in memory. It is
+// safe for concurrent use.
+type MemoryPersistentState struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemoryPersistentState returns a new, empty MemoryPersistentState.
+func NewMemoryPersistentState() *MemoryPersistentState {
+	return &MemoryPersistentState{
+		buckets: make(map[string]map[string][]byte),
+	}
+}
+
+// Delete implements PersistentState.Delete.
+func (s *MemoryPersistentState) Delete(bucket, key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.buckets[string(bucket)], string(key))
+	return nil
+}
+
+// DeleteBucket implements PersistentState.DeleteBucket.
+func (s *MemoryPersistentState) DeleteBucket(bucket []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.buckets, string(bucket))
+	return nil
+}
+
+// ForEach implements PersistentState.ForEach. fn is called with copies of the
+// keys and values in bucket without s being locked, so fn may change s.
+func (s *MemoryPersistentState) ForEach(bucket []byte, fn func(k, v []byte) error) error {
+	s.mu.RLock()
+	b := s.buckets[string(bucket)]
+	keys := make([]string, 0, len(b))
+	for key := range b {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = copyBytes(b[key])
+	}
+	s.mu.RUnlock()
+	for i, key := range keys {
+		if err := fn([]byte(key), values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get implements PersistentState.Get.
+func (s *MemoryPersistentState) Get(bucket, key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyBytes(s.buckets[string(bucket)][string(key)]), nil
+}
+
+// Set implements PersistentState.Set.
+func (s *MemoryPersistentState) Set(bucket, key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[string(bucket)]
+	if !ok {
+		b = make(map[string][]byte)
+		s.buckets[string(bucket)] = b
+	}
+	b[string(key)] = copyBytes(value)
+	return nil
+}
+
+// copyBytes returns a copy of b, or nil if b is nil.
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,186 @@
+package chezmoi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// A MoveOptions contains options for TargetState.Move.
+type MoveOptions struct {
+	// RecordRemove adds the old target to .chezmoiremove so that it is also
+	// removed on other machines.
+	RecordRemove bool
+}
+
+// Move moves the target oldTarget to newTarget. The source entry is renamed,
+// keeping its attributes, and, if oldTarget exists in the destination
+// directory, it is renamed to newTarget. Moving a directory moves everything
+// in it. It is an error if newTarget is already in the source state or exists
+// in the destination directory.
+func (ts *TargetState) Move(fs vfs.FS, oldTarget, newTarget string, moveOptions MoveOptions, mutator Mutator) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	oldName, err := ts.targetName(oldTarget)
+	if err != nil {
+		return err
+	}
+	newName, err := ts.targetName(newTarget)
+	if err != nil {
+		return err
+	}
+	if newName == oldName || strings.HasPrefix(newName, oldName+string(os.PathSeparator)) {
+		return fmt.Errorf("%s: cannot move to %s", oldName, newName)
+	}
+	entry, err := ts.findEntry(oldName)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("%s: not in source state", oldName)
+	}
+	if newEntry, err := ts.findEntry(newName); err == nil && newEntry != nil {
+		return fmt.Errorf("%s: already in source state", newName)
+	}
+	oldPath := filepath.Join(ts.DestDir, oldName)
+	newPath := filepath.Join(ts.DestDir, newName)
+	if _, err := fs.Lstat(newPath); err == nil {
+		return fmt.Errorf("%s: already exists", newPath)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// Move the source entry. Entries read their source files lazily, so
+	// evaluate entry and load the contents of files that would otherwise be
+	// streamed from their old source files first.
+	if err := entry.Evaluate(ts.TargetIgnore.Match); err != nil {
+		return err
+	}
+	if err := loadEntryContents(entry); err != nil {
+		return err
+	}
+	parentDirSourceName, newEntries, err := ts.ensureDirs(filepath.Dir(newName), false, mutator)
+	if err != nil {
+		return err
+	}
+	oldComponents := splitPathList(oldName)
+	oldEntries, err := ts.findEntries(oldComponents[:len(oldComponents)-1])
+	if err != nil {
+		return err
+	}
+	newBase := filepath.Base(newName)
+	var sourceName string
+	if _, ok := entry.(*Dir); ok {
+		da := ParseDirAttributes(filepath.Base(entry.SourceName()))
+		da.Name = newBase
+		sourceName = da.SourceName()
+	} else {
+		fa := ParseFileAttributes(filepath.Base(entry.SourceName()))
+		fa.Name = newBase
+		sourceName = fa.SourceName()
+	}
+	if parentDirSourceName != "" {
+		sourceName = filepath.Join(parentDirSourceName, sourceName)
+	}
+	if err := mutator.Rename(filepath.Join(ts.SourceDir, entry.SourceName()), filepath.Join(ts.SourceDir, sourceName)); err != nil {
+		return err
+	}
+	delete(oldEntries, filepath.Base(oldName))
+	setEntryNames(entry, sourceName, newName)
+	newEntries[newBase] = entry
+
+	// Move the target, preserving its metadata.
+	if _, err := fs.Lstat(oldPath); err == nil {
+		if err := vfs.MkdirAll(mutator, filepath.Dir(newPath), 0777&^ts.Umask); err != nil {
+			return err
+		}
+		if err := mutator.Rename(oldPath, newPath); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if moveOptions.RecordRemove {
+		return ts.recordRemove(fs, oldName, newName, mutator)
+	}
+	return nil
+}
+
+// recordRemove adds oldName to .chezmoiremove, and removes newName from it
+// so that the moved target is not removed.
+func (ts *TargetState) recordRemove(fs vfs.FS, oldName, newName string, mutator Mutator) error {
+	path := filepath.Join(ts.SourceDir, removeFileName)
+	currData, err := fs.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	data := &bytes.Buffer{}
+	found := false
+	s := bufio.NewScanner(bytes.NewReader(currData))
+	for s.Scan() {
+		switch strings.TrimSpace(s.Text()) {
+		case newName:
+			continue
+		case oldName:
+			found = true
+		}
+		fmt.Fprintln(data, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if !found {
+		fmt.Fprintln(data, oldName)
+	}
+	return mutator.WriteFile(path, data.Bytes(), 0666&^ts.Umask, currData)
+}
+
+// loadEntryContents loads the contents of every file in entry, recursing into
+// directories.
+func loadEntryContents(entry Entry) error {
+	return walkEntries(map[string]Entry{entry.TargetName(): entry}, func(entry Entry) error {
+		if f, ok := entry.(*File); ok {
+			return f.load()
+		}
+		return nil
+	})
+}
+
+// setEntryNames sets the source and target names of entry and, if entry is a
+// directory, of everything in it.
+func setEntryNames(entry Entry, sourceName, targetName string) {
+	switch entry := entry.(type) {
+	case *Dir:
+		entry.sourceName = sourceName
+		entry.targetName = targetName
+		for name, childEntry := range entry.Entries {
+			setEntryNames(childEntry, filepath.Join(sourceName, filepath.Base(childEntry.SourceName())), filepath.Join(targetName, name))
+		}
+	case *File:
+		entry.sourceName = sourceName
+		entry.targetName = targetName
+	case *Script:
+		entry.sourceName = sourceName
+		entry.targetName = targetName
+	case *Symlink:
+		entry.sourceName = sourceName
+		entry.targetName = targetName
+	}
+}
+
+// targetName returns the name of target relative to ts.DestDir.
+func (ts *TargetState) targetName(target string) (string, error) {
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(ts.DestDir, target)
+	}
+	if !filepath.HasPrefix(target, ts.DestDir) {
+		return "", fmt.Errorf("%s: outside target directory", target)
+	}
+	return filepath.Rel(ts.DestDir, target)
+}
@@ -0,0 +1,212 @@
+package chezmoi
+
+import (
+	"os"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateMove(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		root      interface{}
+		oldTarget string
+		newTarget string
+		wantErr   bool
+		tests     []vfst.Test
+	}{
+		{
+			name: "file",
+			root: map[string]interface{}{
+				"/home/user": map[string]interface{}{
+					".bashrc": &vfst.File{
+						Perm:     0600,
+						Contents: []byte("# contents of .bashrc\n"),
+					},
+					".chezmoi": map[string]interface{}{
+						"private_dot_bashrc": "# contents of .bashrc\n",
+					},
+				},
+			},
+			oldTarget: "/home/user/.bashrc",
+			newTarget: "/home/user/.config/bash/bashrc",
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.chezmoi/private_dot_bashrc",
+					vfst.TestDoesNotExist,
+				),
+				vfst.TestPath("/home/user/.chezmoi/dot_config/bash/private_bashrc",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# contents of .bashrc\n"),
+				),
+				vfst.TestPath("/home/user/.chezmoi/.chezmoiremove",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString(".bashrc\n"),
+				),
+				vfst.TestPath("/home/user/.bashrc",
+					vfst.TestDoesNotExist,
+				),
+				vfst.TestPath("/home/user/.config/bash/bashrc",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# contents of .bashrc\n"),
+				),
+			},
+		},
+		{
+			name: "dir",
+			root: map[string]interface{}{
+				"/home/user": map[string]interface{}{
+					".bashrc.d": map[string]interface{}{
+						"aliases": &vfst.File{
+							Perm:     0755,
+							Contents: []byte("# aliases\n"),
+						},
+						"prompt": &vfst.Symlink{Target: "aliases"},
+					},
+					".chezmoi": map[string]interface{}{
+						".chezmoiremove": ".config/bash\n.old\n",
+						"exact_dot_bashrc.d": map[string]interface{}{
+							"executable_aliases": "# aliases\n",
+							"symlink_prompt":     "aliases",
+						},
+						"dot_config": &vfst.Dir{Perm: 0755},
+					},
+				},
+			},
+			oldTarget: ".bashrc.d",
+			newTarget: ".config/bash",
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.chezmoi/exact_dot_bashrc.d",
+					vfst.TestDoesNotExist,
+				),
+				vfst.TestPath("/home/user/.chezmoi/dot_config/exact_bash/executable_aliases",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# aliases\n"),
+				),
+				vfst.TestPath("/home/user/.chezmoi/dot_config/exact_bash/symlink_prompt",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("aliases"),
+				),
+				vfst.TestPath("/home/user/.chezmoi/.chezmoiremove",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString(".old\n.bashrc.d\n"),
+				),
+				vfst.TestPath("/home/user/.bashrc.d",
+					vfst.TestDoesNotExist,
+				),
+				vfst.TestPath("/home/user/.config/bash/aliases",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# aliases\n"),
+				),
+				vfst.TestPath("/home/user/.config/bash/prompt",
+					vfst.TestModeType(os.ModeSymlink),
+					vfst.TestSymlinkTarget("aliases"),
+				),
+			},
+		},
+		{
+			name: "collision",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi": map[string]interface{}{
+					"dot_bashrc":  "# contents of .bashrc\n",
+					"dot_profile": "# contents of .profile\n",
+				},
+			},
+			oldTarget: ".bashrc",
+			newTarget: ".profile",
+			wantErr:   true,
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.chezmoi/dot_bashrc",
+					vfst.TestModeIsRegular,
+				),
+			},
+		},
+		{
+			name: "destination_collision",
+			root: map[string]interface{}{
+				"/home/user": map[string]interface{}{
+					".profile": "# unmanaged\n",
+					".chezmoi": map[string]interface{}{
+						"dot_bashrc": "# contents of .bashrc\n",
+					},
+				},
+			},
+			oldTarget: ".bashrc",
+			newTarget: ".profile",
+			wantErr:   true,
+		},
+		{
+			name: "into_itself",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/dot_dir/foo": "bar",
+			},
+			oldTarget: ".dir",
+			newTarget: ".dir/subdir",
+			wantErr:   true,
+		},
+		{
+			name: "not_managed",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi": &vfst.Dir{Perm: 0700},
+			},
+			oldTarget: ".bashrc",
+			newTarget: ".profile",
+			wantErr:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(tc.root)
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+			if err := ts.Populate(fs); err != nil {
+				t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+			}
+			err = ts.Move(fs, tc.oldTarget, tc.newTarget, MoveOptions{RecordRemove: true}, NewFSMutator(fs, "/home/user"))
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ts.Move(_, %q, %q, ...) == <nil>, want !<nil>", tc.oldTarget, tc.newTarget)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("ts.Move(_, %q, %q, ...) == %v, want <nil>", tc.oldTarget, tc.newTarget, err)
+				}
+				// The in-memory state must match a freshly populated
+				// state, and applying it must be a no-op.
+				ts2 := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+				if err := ts2.Populate(fs); err != nil {
+					t.Fatalf("ts2.Populate(%+v) == %v, want <nil>", fs, err)
+				}
+				d, err := DiffTargetStates(ts, ts2)
+				if err != nil {
+					t.Fatalf("DiffTargetStates(ts, ts2) == _, %v, want _, <nil>", err)
+				}
+				if !d.Empty() {
+					t.Errorf("DiffTargetStates(ts, ts2) == %+v, want empty", d)
+				}
+				wantConcreteValue, err := ts2.ConcreteValue(true)
+				if err != nil {
+					t.Fatalf("ts2.ConcreteValue(true) == _, %v, want _, <nil>", err)
+				}
+				gotConcreteValue, err := ts.ConcreteValue(true)
+				if err != nil {
+					t.Fatalf("ts.ConcreteValue(true) == _, %v, want _, <nil>", err)
+				}
+				if diff, equal := messagediff.PrettyDiff(wantConcreteValue, gotConcreteValue); !equal {
+					t.Errorf("ts.ConcreteValue(true) diff:\n%s", diff)
+				}
+				mutator := NewAnyMutator(NullMutator)
+				if err := ts.Apply(fs, mutator); err != nil {
+					t.Fatalf("ts.Apply(...) == %v, want <nil>", err)
+				}
+				if mutator.Mutated() {
+					t.Errorf("ts.Apply(...) mutated destination, want no changes")
+				}
+			}
+			vfst.RunTests(t, fs, "", tc.tests)
+		})
+	}
+}
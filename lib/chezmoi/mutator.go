@@ -1,13 +1,22 @@
 package chezmoi
 
-import "os"
+import (
+	"context"
+	"os"
+)
 
-// An Mutator makes changes.
+// An Mutator makes changes. CopyFile is like WriteFile, but the contents are
+// those of the file source, of size size, which are streamed instead of being
+// held in memory. RunScript reports whether the script was actually run, so
+// that Mutators that only pretend to make changes do not cause runs to be
+// recorded.
 type Mutator interface {
 	Chmod(name string, mode os.FileMode) error
+	CopyFile(name, source string, size int64, perm os.FileMode, overwrite bool) error
 	Mkdir(name string, perm os.FileMode) error
 	RemoveAll(name string) error
 	Rename(oldpath, newpath string) error
+	RunScript(ctx context.Context, name, dir string, data []byte) (bool, error)
 	Stat(name string) (os.FileInfo, error)
 	WriteFile(filename string, data []byte, perm os.FileMode, currData []byte) error
 	WriteSymlink(oldname, newname string) error
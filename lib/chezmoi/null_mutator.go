@@ -1,6 +1,9 @@
 package chezmoi
 
-import "os"
+import (
+	"context"
+	"os"
+)
 
 type nullMutator struct{}
 
@@ -12,6 +15,11 @@ func (nullMutator) Chmod(string, os.FileMode) error {
 	return nil
 }
 
+// CopyFile implements Mutator.CopyFile.
+func (nullMutator) CopyFile(string, string, int64, os.FileMode, bool) error {
+	return nil
+}
+
 // Mkdir implements Mutator.Mkdir.
 func (nullMutator) Mkdir(string, os.FileMode) error {
 	return nil
@@ -27,6 +35,11 @@ func (nullMutator) Rename(string, string) error {
 	return nil
 }
 
+// RunScript implements Mutator.RunScript. Scripts are never run.
+func (nullMutator) RunScript(context.Context, string, string, []byte) (bool, error) {
+	return false, nil
+}
+
 // Stat implements Mutator.Stat.
 func (nullMutator) Stat(path string) (os.FileInfo, error) {
 	return nil, &os.PathError{
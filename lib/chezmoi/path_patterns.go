@@ -0,0 +1,87 @@
+package chezmoi
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A PathPatterns is a list of glob patterns that match slash-separated target
+// names. In addition to the syntax of path.Match, a ** component matches zero
+// or more directories, so .config/nvim/** matches .config/nvim and everything
+// in it.
+type PathPatterns []string
+
+// NewPathPatterns returns a new PathPatterns. It returns an error if any
+// pattern is malformed.
+func NewPathPatterns(patterns ...string) (PathPatterns, error) {
+	for _, pattern := range patterns {
+		for _, component := range strings.Split(pattern, "/") {
+			if _, err := path.Match(component, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return PathPatterns(patterns), nil
+}
+
+// HasMeta returns true if s contains any of the special characters of
+// patterns.
+func HasMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// Match returns true if targetName, or any of its parent directories, matches
+// any pattern in p.
+func (p PathPatterns) Match(targetName string) bool {
+	for name := filepath.ToSlash(targetName); name != "." && name != "/" && name != ""; name = path.Dir(name) {
+		components := strings.Split(name, "/")
+		for _, pattern := range p {
+			if matchComponents(strings.Split(pattern, "/"), components) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchComponents returns true if the components of a name match the components
+// of a pattern.
+func matchComponents(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		return matchComponents(pattern[1:], name) || len(name) > 0 && matchComponents(pattern, name[1:])
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchComponents(pattern[1:], name[1:])
+}
+
+// MatchEntries returns the entries in ts whose target names match p, in order.
+// Entries inside a matching directory are not returned separately.
+func (ts *TargetState) MatchEntries(p PathPatterns) ([]Entry, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	var entries []Entry
+	var matchedDirs []string
+	if err := walkSortedEntries(ts.Entries, ts.nameCache.sortedNames(ts.Entries), func(entry Entry) error {
+		targetName := entry.TargetName()
+		if isInDirs(targetName, matchedDirs) || !p.Match(targetName) {
+			return nil
+		}
+		entries = append(entries, entry)
+		if _, ok := entry.(*Dir); ok {
+			matchedDirs = append(matchedDirs, targetName)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,109 @@
+package chezmoi
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestPathPatternsMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern    string
+		targetName string
+		want       bool
+	}{
+		{pattern: ".bashrc", targetName: ".bashrc", want: true},
+		{pattern: ".bashrc", targetName: ".zshrc", want: false},
+		{pattern: ".*rc", targetName: ".zshrc", want: true},
+		{pattern: ".config", targetName: ".config/nvim/init.vim", want: true},
+		{pattern: ".config/*", targetName: ".config/nvim/init.vim", want: true},
+		{pattern: ".config/*/init.vim", targetName: ".config/nvim/init.vim", want: true},
+		{pattern: ".config/*/init.vim", targetName: ".config/nvim", want: false},
+		{pattern: ".config/nvim/**", targetName: ".config/nvim", want: true},
+		{pattern: ".config/nvim/**", targetName: ".config/nvim/lua/plugins.lua", want: true},
+		{pattern: ".config/nvim/**", targetName: ".config", want: false},
+		{pattern: "**/*.lua", targetName: ".config/nvim/lua/plugins.lua", want: true},
+		{pattern: "**/*.lua", targetName: "init.lua", want: true},
+		{pattern: "**/*.lua", targetName: ".config/nvim/init.vim", want: false},
+		{pattern: ".config/**/plugins.lua", targetName: ".config/nvim/lua/plugins.lua", want: true},
+	} {
+		p, err := NewPathPatterns(tc.pattern)
+		if err != nil {
+			t.Fatalf("NewPathPatterns(%q) == _, %v, want _, <nil>", tc.pattern, err)
+		}
+		if got := p.Match(filepath.FromSlash(tc.targetName)); got != tc.want {
+			t.Errorf("NewPathPatterns(%q).Match(%q) == %v, want %v", tc.pattern, tc.targetName, got, tc.want)
+		}
+	}
+	if _, err := NewPathPatterns("[.bashrc"); err == nil {
+		t.Errorf("NewPathPatterns(%q) == _, <nil>, want _, !<nil>", "[.bashrc")
+	}
+}
+
+func TestTargetStatePlanMatchingEntries(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			"dot_bashrc": "# bashrc\n",
+			"dot_config": map[string]interface{}{
+				"git/config": "# git\n",
+				"nvim": map[string]interface{}{
+					"init.vim":        "\" init\n",
+					"lua/plugins.lua": "-- plugins\n",
+				},
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	patterns, err := NewPathPatterns(".config/nvim/**")
+	if err != nil {
+		t.Fatalf("NewPathPatterns(_) == _, %v, want _, <nil>", err)
+	}
+	entries, err := ts.MatchEntries(patterns)
+	if err != nil {
+		t.Fatalf("ts.MatchEntries(_) == _, %v, want _, <nil>", err)
+	}
+	if len(entries) != 1 || entries[0].TargetName() != filepath.Join(".config", "nvim") {
+		t.Fatalf("ts.MatchEntries(_) == %v, want [.config/nvim]", entries)
+	}
+	plan, err := ts.PlanEntries(fs, entries)
+	if err != nil {
+		t.Fatalf("ts.PlanEntries(%+v, _) == _, %v, want _, <nil>", fs, err)
+	}
+	var gotNames []string
+	for _, o := range plan.Operations {
+		gotNames = append(gotNames, o.Name)
+	}
+	wantNames := []string{
+		"/home/user/.config",
+		"/home/user/.config/nvim",
+		"/home/user/.config/nvim/init.vim",
+		"/home/user/.config/nvim/lua",
+		"/home/user/.config/nvim/lua/plugins.lua",
+	}
+	if diff, equal := messagediff.PrettyDiff(wantNames, gotNames); !equal {
+		t.Errorf("ts.PlanEntries(_, _) changes %v, want %v, diff:\n%s", gotNames, wantNames, diff)
+	}
+	if err := ts.ExecutePlan(plan, NewFSMutator(fs, "/home/user")); err != nil {
+		t.Fatalf("ts.ExecutePlan(_, _) == %v, want <nil>", err)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.config/nvim/lua/plugins.lua",
+			vfst.TestContentsString("-- plugins\n"),
+		),
+		vfst.TestPath("/home/user/.config/git",
+			vfst.TestDoesNotExist,
+		),
+		vfst.TestPath("/home/user/.bashrc",
+			vfst.TestDoesNotExist,
+		),
+	)
+}
@@ -1,6 +1,9 @@
 package chezmoi
 
-import "path/filepath"
+import (
+	"os"
+	"path/filepath"
+)
 
 // An PatternSet is a set of patterns.
 type PatternSet map[string]struct{}
@@ -10,20 +13,23 @@ func NewPatternSet() PatternSet {
 	return PatternSet(make(map[string]struct{}))
 }
 
-// Add adds pattern to ps.
+// Add adds pattern to ps. It returns an error if pattern is malformed.
 func (ps PatternSet) Add(pattern string) error {
 	if _, err := filepath.Match(pattern, ""); err != nil {
-		return nil
+		return err
 	}
 	ps[pattern] = struct{}{}
 	return nil
 }
 
-// Match returns if name matches any pattern in ps.
+// Match returns if name, or any of its parent directories, matches any
+// pattern in ps.
 func (ps PatternSet) Match(name string) bool {
-	for pattern := range ps {
-		if ok, _ := filepath.Match(pattern, name); ok {
-			return true
+	for ; name != "." && name != string(os.PathSeparator) && name != ""; name = filepath.Dir(name) {
+		for pattern := range ps {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return true
+			}
 		}
 	}
 	return false
@@ -0,0 +1,38 @@
+package chezmoi
+
+import "testing"
+
+func TestPatternSet(t *testing.T) {
+	ps := NewPatternSet()
+	for _, pattern := range []string{
+		"*.old",
+		".work",
+		"dir/*/cache",
+	} {
+		if err := ps.Add(pattern); err != nil {
+			t.Fatalf("ps.Add(%q) == %v, want <nil>", pattern, err)
+		}
+	}
+	if err := ps.Add("["); err == nil {
+		t.Errorf("ps.Add(%q) == <nil>, want !<nil>", "[")
+	}
+	for name, want := range map[string]bool{
+		".bashrc":               false,
+		".bashrc.old":           true,
+		".work":                 true,
+		".work/file":            true,
+		".workspace":            false,
+		"dir/app/cache":         true,
+		"dir/app/cache/file":    true,
+		"dir/app/config":        false,
+		"dir/app/config/cache":  false,
+		"dir/app/config/x.old":  false,
+		"dir.old/file":          true,
+		"other/.work":           false,
+		"other/.work/file.conf": false,
+	} {
+		if got := ps.Match(name); got != want {
+			t.Errorf("ps.Match(%q) == %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package chezmoi
+
+import (
+	"encoding/json"
+	"fmt"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// A PersistentState is a persistent store of key-value pairs grouped into
+// buckets. It records state between runs, for example which run_once_ scripts
+// have been run. Get returns nil if key is not in bucket, and ForEach calls fn
+// for each key in bucket in key order. DeleteBucket removes every key in
+// bucket.
+type PersistentState interface {
+	Delete(bucket, key []byte) error
+	DeleteBucket(bucket []byte) error
+	ForEach(bucket []byte, fn func(k, v []byte) error) error
+	Get(bucket, key []byte) ([]byte, error)
+	Set(bucket, key, value []byte) error
+}
+
+// persistentStateBuckets are all the buckets that chezmoi uses, in name order.
+var persistentStateBuckets = [][]byte{
+	configHashBucket,
+	contentHashBucket,
+	entryStateBucket,
+	scriptOnChangeBucket,
+	scriptOnceBucket,
+	templateOutputBucket,
+}
+
+// batchPersistentState calls f, which changes s. If s can write many changes
+// at once then they are written together when f returns.
+func batchPersistentState(s PersistentState, f func() error) error {
+	if batcher, ok := s.(interface {
+		Batch(func() error) error
+	}); ok {
+		return batcher.Batch(f)
+	}
+	return f()
+}
+
+// ImportJSONPersistentState copies the buckets in the JSON file at path, in
+// which older versions of chezmoi stored the persistent state, into s.
+func ImportJSONPersistentState(fs vfs.FS, path string, s PersistentState) error {
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var buckets map[string]map[string][]byte
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return batchPersistentState(s, func() error {
+		for bucket, values := range buckets {
+			for key, value := range values {
+				if err := s.Set([]byte(bucket), []byte(key), value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// PersistentStateBuckets returns the names of all the buckets that chezmoi
+// uses.
+func PersistentStateBuckets() []string {
+	names := make([]string, 0, len(persistentStateBuckets))
+	for _, bucket := range persistentStateBuckets {
+		names = append(names, string(bucket))
+	}
+	return names
+}
+
+// DumpPersistentState returns the contents of all the buckets in s, keyed by
+// bucket name and then key. Values that are JSON, which includes all values
+// that chezmoi writes, are decoded and others are returned as strings.
+func DumpPersistentState(s PersistentState) (map[string]map[string]interface{}, error) {
+	dump := make(map[string]map[string]interface{})
+	for _, bucket := range persistentStateBuckets {
+		values := make(map[string]interface{})
+		if err := s.ForEach(bucket, func(k, v []byte) error {
+			var value interface{}
+			if err := json.Unmarshal(v, &value); err != nil {
+				value = string(v)
+			}
+			values[string(k)] = value
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		dump[string(bucket)] = values
+	}
+	return dump, nil
+}
+
+// ResetPersistentState removes every key from the buckets named buckets in s,
+// or from all buckets if buckets is empty.
+func ResetPersistentState(s PersistentState, buckets ...string) error {
+	if len(buckets) == 0 {
+		buckets = PersistentStateBuckets()
+	}
+	for _, name := range buckets {
+		if !isPersistentStateBucket(name) {
+			return fmt.Errorf("%s: unknown bucket", name)
+		}
+	}
+	for _, name := range buckets {
+		if err := s.DeleteBucket([]byte(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isPersistentStateBucket returns whether name is the name of a bucket that
+// chezmoi uses.
+func isPersistentStateBucket(name string) bool {
+	for _, bucket := range persistentStateBuckets {
+		if string(bucket) == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package chezmoi
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestDumpAndResetPersistentState(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.local/state/chezmoi": &vfst.Dir{Perm: 0700},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	path := "/home/user/.local/state/chezmoi/chezmoistate.boltdb"
+	s := NewBoltPersistentState(fs, path, false)
+	defer s.Close()
+	for _, kv := range []struct {
+		bucket, key, value []byte
+	}{
+		{bucket: entryStateBucket, key: []byte(".bashrc"), value: []byte(`{"type":"file"}`)},
+		{bucket: configHashBucket, key: []byte("/home/user/.config/chezmoi/chezmoi.toml"), value: []byte("not json")},
+		{bucket: []byte("unknown"), key: []byte("key"), value: []byte(`"value"`)},
+	} {
+		if err := s.Set(kv.bucket, kv.key, kv.value); err != nil {
+			t.Fatalf("s.Set(%q, %q, _) == %v, want <nil>", kv.bucket, kv.key, err)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("s.Close() == %v, want <nil>", err)
+	}
+	dump, err := DumpPersistentState(NewBoltPersistentState(fs, path, true))
+	if err != nil {
+		t.Fatalf("DumpPersistentState(_) == _, %v, want _, <nil>", err)
+	}
+	wantDump := map[string]map[string]interface{}{
+		"configHash": {
+			"/home/user/.config/chezmoi/chezmoi.toml": "not json",
+		},
+		"contentHash": {},
+		"entryState": {
+			".bashrc": map[string]interface{}{"type": "file"},
+		},
+		"scriptOnChange": {},
+		"scriptOnce":     {},
+		"templateOutput": {},
+	}
+	if diff, equal := messagediff.PrettyDiff(wantDump, dump); !equal {
+		t.Errorf("DumpPersistentState(_) == %v, want %v, diff:\n%s", dump, wantDump, diff)
+	}
+
+	if err := ResetPersistentState(s, "unknown"); err == nil {
+		t.Errorf("ResetPersistentState(_, \"unknown\") == <nil>, want !<nil>")
+	}
+	if err := ResetPersistentState(s, "configHash"); err != nil {
+		t.Fatalf("ResetPersistentState(_, \"configHash\") == %v, want <nil>", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("s.Close() == %v, want <nil>", err)
+	}
+	readOnly := NewBoltPersistentState(fs, path, true)
+	if value, _ := readOnly.Get(configHashBucket, []byte("/home/user/.config/chezmoi/chezmoi.toml")); value != nil {
+		t.Errorf("s.Get(%q, _) == %q, want <nil>", configHashBucket, value)
+	}
+	if value, _ := readOnly.Get(entryStateBucket, []byte(".bashrc")); value == nil {
+		t.Errorf("s.Get(%q, _) == <nil>, want !<nil>", entryStateBucket)
+	}
+
+	if err := ResetPersistentState(s); err != nil {
+		t.Fatalf("ResetPersistentState(_) == %v, want <nil>", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("s.Close() == %v, want <nil>", err)
+	}
+	readOnly = NewBoltPersistentState(fs, path, true)
+	if value, _ := readOnly.Get(entryStateBucket, []byte(".bashrc")); value != nil {
+		t.Errorf("s.Get(%q, _) == %q, want <nil>", entryStateBucket, value)
+	}
+}
+
+func TestBoltPersistentStateBatch(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.config/chezmoi": &vfst.Dir{Perm: 0700},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	path := "/home/user/.config/chezmoi/chezmoistate.boltdb"
+	s := NewBoltPersistentState(fs, path, false)
+	defer s.Close()
+	if err := s.Batch(func() error {
+		value := []byte(`{}`)
+		for _, key := range []string{"a", "b", "c"} {
+			if err := s.Set(entryStateBucket, []byte(key), value); err != nil {
+				return err
+			}
+			// The batch must not be affected by later changes to value.
+			value[0] = 'x'
+		}
+		if value, _ := s.Get(entryStateBucket, []byte("a")); string(value) != `{}` {
+			t.Errorf("s.Get(%q, %q) == %q, want %q", entryStateBucket, "a", value, `{}`)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("s.Batch(_) == %v, want <nil>", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("s.Close() == %v, want <nil>", err)
+	}
+	s2 := NewBoltPersistentState(fs, path, true)
+	for _, key := range []string{"a", "b", "c"} {
+		if value, _ := s2.Get(entryStateBucket, []byte(key)); value == nil {
+			t.Errorf("s2.Get(%q, %q) == <nil>, want !<nil>", entryStateBucket, key)
+		}
+	}
+}
+
+func TestBoltPersistentStateReadOnly(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": &vfst.Dir{Perm: 0755},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	path := "/home/user/.local/state/chezmoi/chezmoistate.boltdb"
+	s := NewBoltPersistentState(fs, path, true)
+	if err := s.Set(entryStateBucket, []byte(".bashrc"), []byte(`{}`)); err != nil {
+		t.Fatalf("s.Set(%q, %q, _) == %v, want <nil>", entryStateBucket, ".bashrc", err)
+	}
+	if value, _ := s.Get(entryStateBucket, []byte(".bashrc")); value == nil {
+		t.Errorf("s.Get(%q, %q) == <nil>, want !<nil>", entryStateBucket, ".bashrc")
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.local",
+			vfst.TestDoesNotExist,
+		),
+	)
+}
+
+func TestImportJSONPersistentState(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		// {"entryState":{".bashrc":"{}"}}, with the value encoded in base64.
+		"/home/user/.config/chezmoi/chezmoistate.json": `{"entryState":{".bashrc":"e30="}}`,
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	s := NewBoltPersistentState(fs, "/home/user/.local/state/chezmoi/chezmoistate.boltdb", false)
+	defer s.Close()
+	if err := ImportJSONPersistentState(fs, "/home/user/.config/chezmoi/chezmoistate.json", s); err != nil {
+		t.Fatalf("ImportJSONPersistentState(_, _, _) == %v, want <nil>", err)
+	}
+	if value, _ := s.Get(entryStateBucket, []byte(".bashrc")); string(value) != `{}` {
+		t.Errorf("s.Get(%q, %q) == %q, want %q", entryStateBucket, ".bashrc", value, `{}`)
+	}
+}
@@ -0,0 +1,485 @@
+package chezmoi
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// An ApplyPlan is the ordered list of operations that applying a target state
+// would execute, including the old and new contents and modes of files. Plans
+// are computed without changing anything, so they can be previewed, confirmed,
+// exported as JSON, and tested without a filesystem, and are then executed
+// with TargetState.ExecutePlan. A plan reflects the destination directory at
+// the time it was computed.
+type ApplyPlan struct {
+	Operations []Operation `json:"operations" yaml:"operations"`
+}
+
+// A TargetError is an error planning or applying a target. When
+// TargetState.KeepGoing is set, TargetErrors are returned in a MultiError.
+type TargetError struct {
+	TargetName string
+	Err        error
+}
+
+// An ApplyDecision is the decision made for an operation by an ApplyDecider.
+type ApplyDecision int
+
+// ApplyDecisions.
+const (
+	ApplyDecisionApply ApplyDecision = iota // Execute the operation.
+	ApplyDecisionSkip                       // Skip the operation.
+	ApplyDecisionQuit                       // Skip the operation and all remaining operations.
+)
+
+// An ApplyDecider decides whether an operation in a plan should be executed.
+// It may show the operation to the user first, for example as a diff with
+// WriteDiff.
+type ApplyDecider func(o *Operation) (ApplyDecision, error)
+
+// ExecutePlan executes the operations in plan with mutator, in order, and
+// records the runs of run_once_ and run_onchange_ scripts. It stops at the
+// first error.
+func (ts *TargetState) ExecutePlan(plan *ApplyPlan, mutator Mutator) error {
+	return ts.ExecutePlanContext(context.Background(), plan, mutator)
+}
+
+// ExecutePlanContext is like ExecutePlan, but stops when ctx is done.
+func (ts *TargetState) ExecutePlanContext(ctx context.Context, plan *ApplyPlan, mutator Mutator) error {
+	_, err := ts.ExecutePlanFunc(ctx, plan, mutator, nil)
+	return err
+}
+
+// ExecutePlanFunc is like ExecutePlanContext, but if decide is not nil it is
+// called before each operation to decide whether to execute it. Operations
+// inside a directory whose creation was skipped are skipped without calling
+// decide. It returns the operations that were not executed.
+//
+// If ts.KeepGoing is set then failed operations do not stop the execution of
+// the plan. Operations inside a directory that could not be created are
+// skipped, and the errors are returned together at the end. Otherwise execution
+// stops at the first failed operation, and its error is returned with every
+// operation after it. Execution always stops when ctx is done, and ctx's error
+// is returned.
+//
+// If decide is nil and ts.Parallelism is greater than one then independent
+// operations are executed concurrently, see executePlanParallel.
+func (ts *TargetState) ExecutePlanFunc(ctx context.Context, plan *ApplyPlan, mutator Mutator, decide ApplyDecider) ([]Operation, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.executePlanFunc(ctx, plan, mutator, decide)
+}
+
+// Plan returns the plan for applying ts to fs, without changing anything. If
+// ts.EntryTypeFilter is set then only operations on included targets are
+// planned. If ts.KeepGoing is set then targets that cannot be planned are
+// left out, and the plan of everything else is returned together with their
+// errors.
+func (ts *TargetState) Plan(fs vfs.FS) (*ApplyPlan, error) {
+	return ts.PlanContext(context.Background(), fs)
+}
+
+// PlanContext is like Plan, but stops between entries when ctx is done.
+func (ts *TargetState) PlanContext(ctx context.Context, fs vfs.FS) (*ApplyPlan, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.planAll(ctx, fs)
+}
+
+// PlanEntries returns the plan for applying only entries, which must be
+// entries in ts, to fs, without changing anything. Missing parent directories
+// of entries are created first. Errors are handled as by Plan.
+func (ts *TargetState) PlanEntries(fs vfs.FS, entries []Entry) (*ApplyPlan, error) {
+	return ts.PlanEntriesContext(context.Background(), fs, entries)
+}
+
+// PlanEntriesContext is like PlanEntries, but stops between entries when ctx
+// is done.
+func (ts *TargetState) PlanEntriesContext(ctx context.Context, fs vfs.FS, entries []Entry) (*ApplyPlan, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.plan(ctx, fs, entries, false)
+}
+
+// plan returns the plan for applying entries to fs. If all is true then
+// entries are all the entries in ts and the targets in ts.TargetRemove are
+// removed first, otherwise the missing parent directories of entries are
+// created first. Cancellation of ctx is always returned as an error, even if
+// ts.KeepGoing is set.
+func (ts *TargetState) plan(ctx context.Context, fs vfs.FS, entries []Entry, all bool) (*ApplyPlan, error) {
+	mutator := NewDryRunMutator()
+	ignore, errs := ts.planIgnore(entries)
+	if all {
+		if err := ts.applyRemove(fs, mutator); err != nil {
+			if !ts.KeepGoing {
+				return nil, err
+			}
+			errs = append(errs, err)
+		}
+	}
+	createdDirs := make(map[string]bool)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var err error
+		if !all {
+			err = ts.planParentDirs(fs, entry.TargetName(), createdDirs, mutator)
+		}
+		if err == nil {
+			err = entry.Apply(fs, ts.DestDir, ignore, ts.Umask, mutator)
+		}
+		if err != nil {
+			if !ts.KeepGoing {
+				return nil, err
+			}
+			errs = append(errs, &TargetError{
+				TargetName: entry.TargetName(),
+				Err:        err,
+			})
+		}
+	}
+	return &ApplyPlan{
+		Operations: ts.filterOperationsByType(mutator.Operations),
+	}, errs.errorOrNil()
+}
+
+// planAll returns the plan for applying all of the entries in ts to fs.
+func (ts *TargetState) planAll(ctx context.Context, fs vfs.FS) (*ApplyPlan, error) {
+	entries := make([]Entry, 0, len(ts.Entries))
+	for _, entryName := range ts.nameCache.applyOrderNames(ts.Entries) {
+		entries = append(entries, ts.Entries[entryName])
+	}
+	return ts.plan(ctx, fs, entries, true)
+}
+
+// planIgnore returns the function that decides which targets are ignored when
+// planning entries. If ts.KeepGoing is set then the contents of every target in
+// entries are computed first, and targets whose contents cannot be computed
+// are ignored and their errors returned.
+func (ts *TargetState) planIgnore(entries []Entry) (func(string) bool, MultiError) {
+	if !ts.KeepGoing {
+		return ts.TargetIgnore.Match, nil
+	}
+	entriesMap := make(map[string]Entry)
+	for _, entry := range entries {
+		entriesMap[entry.TargetName()] = entry
+	}
+	var errs MultiError
+	failed := make(map[string]bool)
+	_ = walkEntries(entriesMap, func(entry Entry) error {
+		if _, ok := entry.(*Dir); ok || ts.TargetIgnore.Match(entry.TargetName()) {
+			return nil
+		}
+		if err := entry.Evaluate(ts.TargetIgnore.Match); err != nil {
+			failed[entry.TargetName()] = true
+			errs = append(errs, &TargetError{
+				TargetName: entry.TargetName(),
+				Err:        err,
+			})
+		}
+		return nil
+	})
+	return func(targetName string) bool {
+		return failed[targetName] || ts.TargetIgnore.Match(targetName)
+	}, errs
+}
+
+// A pendingOperation is an operation that is being executed concurrently by
+// executePlanParallel.
+type pendingOperation struct {
+	index int
+	o     *Operation
+	done  chan struct{}
+	err   error
+}
+
+// executePlanFunc implements ExecutePlanFunc.
+func (ts *TargetState) executePlanFunc(ctx context.Context, plan *ApplyPlan, mutator Mutator, decide ApplyDecider) ([]Operation, error) {
+	if decide == nil && ts.Parallelism > 1 {
+		return ts.executePlanParallel(ctx, plan, mutator)
+	}
+	var errs MultiError
+	var skipped []Operation
+	var skippedDirs []string
+	quit := false
+	for i, o := range plan.Operations {
+		if err := ctx.Err(); err != nil {
+			return append(skipped, plan.Operations[i:]...), err
+		}
+		if quit || isInDirs(o.Name, skippedDirs) {
+			skipped = append(skipped, o)
+			continue
+		}
+		if decide != nil {
+			decision, err := decide(&o)
+			if err != nil {
+				return skipped, err
+			}
+			switch decision {
+			case ApplyDecisionApply:
+			case ApplyDecisionSkip:
+				skipped = append(skipped, o)
+				if o.Type == OperationMkdir {
+					skippedDirs = append(skippedDirs, o.Name)
+				}
+				continue
+			case ApplyDecisionQuit:
+				skipped = append(skipped, o)
+				quit = true
+				continue
+			default:
+				return skipped, fmt.Errorf("%s: unknown decision %d", o.Name, decision)
+			}
+		}
+		if err := ts.executeOperation(ctx, &o, mutator); err != nil {
+			if !ts.KeepGoing {
+				return append(skipped, plan.Operations[i+1:]...), err
+			}
+			errs = append(errs, &TargetError{
+				TargetName: ts.operationTargetName(&o),
+				Err:        err,
+			})
+			skipped = append(skipped, o)
+			if o.Type == OperationMkdir {
+				skippedDirs = append(skippedDirs, o.Name)
+			}
+		}
+	}
+	return skipped, errs.errorOrNil()
+}
+
+// executePlanParallel executes plan like ExecutePlanFunc without a decider,
+// but with up to ts.Parallelism operations executing at once. An operation is
+// only started once every earlier operation that it conflicts with, as
+// determined by operationsConflict, has finished, so directories are created
+// before their contents and scripts run after everything before them.
+// Operations that are not started are returned in plan order, as are errors,
+// regardless of the order in which operations finish.
+func (ts *TargetState) executePlanParallel(ctx context.Context, plan *ApplyPlan, mutator Mutator) ([]Operation, error) {
+	ops := plan.Operations
+	started := make([]bool, len(ops))
+	errs := make([]error, len(ops))
+	failed := false
+	var skippedDirs []string
+	var pending []*pendingOperation
+	// wait waits for the pending operations for which f returns true and
+	// records their results.
+	wait := func(f func(*pendingOperation) bool) {
+		remaining := pending[:0]
+		for _, p := range pending {
+			if !f(p) {
+				remaining = append(remaining, p)
+				continue
+			}
+			<-p.done
+			errs[p.index] = p.err
+			if p.err != nil {
+				failed = true
+				if p.o.Type == OperationMkdir {
+					skippedDirs = append(skippedDirs, p.o.Name)
+				}
+			}
+		}
+		pending = remaining
+	}
+	semaphore := make(chan struct{}, ts.Parallelism)
+	var ctxErr error
+	for i := range ops {
+		o := &ops[i]
+		wait(func(p *pendingOperation) bool {
+			return operationsConflict(p.o, o) || isDone(p.done)
+		})
+		if failed && !ts.KeepGoing {
+			break
+		}
+		if ctxErr = ctx.Err(); ctxErr != nil {
+			break
+		}
+		if isInDirs(o.Name, skippedDirs) {
+			continue
+		}
+		started[i] = true
+		p := &pendingOperation{
+			index: i,
+			o:     o,
+			done:  make(chan struct{}),
+		}
+		pending = append(pending, p)
+		semaphore <- struct{}{}
+		go func() {
+			defer close(p.done)
+			p.err = ts.executeOperation(ctx, p.o, mutator)
+			<-semaphore
+		}()
+	}
+	wait(func(*pendingOperation) bool {
+		return true
+	})
+
+	var skipped []Operation
+	var firstErr error
+	var targetErrs MultiError
+	for i := range ops {
+		switch {
+		case !started[i]:
+			skipped = append(skipped, ops[i])
+		case errs[i] != nil && !ts.KeepGoing:
+			// Only the first error is returned, so later operations that
+			// failed concurrently with it are reported as skipped.
+			if firstErr == nil && ctxErr == nil {
+				firstErr = errs[i]
+			} else if ctxErr == nil {
+				skipped = append(skipped, ops[i])
+			}
+		case errs[i] != nil:
+			targetErrs = append(targetErrs, &TargetError{
+				TargetName: ts.operationTargetName(&ops[i]),
+				Err:        errs[i],
+			})
+			skipped = append(skipped, ops[i])
+		}
+	}
+	switch {
+	case ctxErr != nil:
+		return skipped, ctxErr
+	case firstErr != nil:
+		return skipped, firstErr
+	default:
+		return skipped, targetErrs.errorOrNil()
+	}
+}
+
+// executeOperation executes o with mutator and records the runs of scripts.
+// Runs are only recorded if mutator actually ran the script, so executing a
+// plan with a Mutator that does not run scripts, like NullMutator, leaves the
+// persistent state unchanged.
+func (ts *TargetState) executeOperation(ctx context.Context, o *Operation, mutator Mutator) error {
+	if o.Type != OperationRunScript {
+		return o.execute(ctx, mutator)
+	}
+	ran, err := mutator.RunScript(ctx, o.Name, o.Dir, o.Data)
+	if err != nil || !ran {
+		return err
+	}
+	entry, err := ts.findEntry(o.Name)
+	if err != nil {
+		return err
+	}
+	script, ok := entry.(*Script)
+	if !ok {
+		return fmt.Errorf("%s: not a script", o.Name)
+	}
+	return script.recordRun(o.Data)
+}
+
+// planParentDirs creates the missing parent directories of targetName with
+// mutator, recording them in createdDirs.
+func (ts *TargetState) planParentDirs(fs vfs.FS, targetName string, createdDirs map[string]bool, mutator Mutator) error {
+	names := splitPathList(targetName)
+	for i := 1; i < len(names); i++ {
+		dirName := filepath.Join(names[:i]...)
+		if createdDirs[dirName] {
+			continue
+		}
+		entry, err := ts.findEntry(dirName)
+		if err != nil {
+			return err
+		}
+		dir, ok := entry.(*Dir)
+		if !ok {
+			return nil
+		}
+		dirPath := filepath.Join(ts.DestDir, dirName)
+		_, err = fs.Lstat(dirPath)
+		switch {
+		case err == nil:
+			continue
+		case !isNotExist(err):
+			return err
+		}
+		if err := mutator.Mkdir(dirPath, dir.Perm&^ts.Umask); err != nil {
+			return err
+		}
+		createdDirs[dirName] = true
+	}
+	return nil
+}
+
+// operationTargetName returns the name of the target changed by o.
+func (ts *TargetState) operationTargetName(o *Operation) string {
+	if o.Type == OperationRunScript {
+		return o.Name
+	}
+	if targetName, err := filepath.Rel(ts.DestDir, o.Name); err == nil {
+		return targetName
+	}
+	return o.Name
+}
+
+// isDone returns true if done is closed.
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// operationsConflict returns true if a and b cannot be executed concurrently,
+// either because one of them is a script or a rename, which may depend on or
+// change anything, or because they change the same path or one changes a
+// parent directory of the other.
+func operationsConflict(a, b *Operation) bool {
+	for _, o := range []*Operation{a, b} {
+		if o.Type == OperationRunScript || o.Type == OperationRename {
+			return true
+		}
+	}
+	return a.Name == b.Name || isInDirs(a.Name, []string{b.Name}) || isInDirs(b.Name, []string{a.Name})
+}
+
+// isInDirs returns true if name is inside any of dirs.
+func isInDirs(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(name, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// execute executes o with mutator. Scripts are killed if ctx is done.
+func (o *Operation) execute(ctx context.Context, mutator Mutator) error {
+	switch o.Type {
+	case OperationChmod:
+		return mutator.Chmod(o.Name, o.Mode)
+	case OperationCreate, OperationOverwrite:
+		if o.Source != "" {
+			return mutator.CopyFile(o.Name, o.Source, o.Size, o.Mode, o.Type == OperationOverwrite)
+		}
+		return mutator.WriteFile(o.Name, o.Data, o.Mode, o.CurrData)
+	case OperationMkdir:
+		return mutator.Mkdir(o.Name, o.Mode)
+	case OperationRemove:
+		return mutator.RemoveAll(o.Name)
+	case OperationRename:
+		return mutator.Rename(o.OldName, o.Name)
+	case OperationRunScript:
+		_, err := mutator.RunScript(ctx, o.Name, o.Dir, o.Data)
+		return err
+	case OperationSymlink:
+		return mutator.WriteSymlink(o.OldName, o.Name)
+	default:
+		return fmt.Errorf("%s: unknown operation type %q", o.Name, o.Type)
+	}
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("%s: %v", e.TargetName, e.Err)
+}
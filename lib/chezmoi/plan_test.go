@@ -0,0 +1,482 @@
+package chezmoi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStatePlan(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc": "# old\n",
+			".chezmoi": map[string]interface{}{
+				"dot_bashrc":          "# new\n",
+				"dot_dir/file":        "contents",
+				"symlink_dot_link":    ".bashrc",
+				"run_once_install.sh": "#!/bin/sh\ntouch installed\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	persistentState := NewMemoryPersistentState()
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	ts.PersistentState = persistentState
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+
+	plan, err := ts.Plan(fs)
+	if err != nil {
+		t.Fatalf("ts.Plan(%+v) == _, %v, want _, <nil>", fs, err)
+	}
+	wantOperations := []Operation{
+		{Type: OperationOverwrite, Name: "/home/user/.bashrc", Mode: 0644, Data: []byte("# new\n"), CurrData: []byte("# old\n")},
+		{Type: OperationMkdir, Name: "/home/user/.dir", Mode: 0755},
+		{Type: OperationCreate, Name: "/home/user/.dir/file", Mode: 0644, Data: []byte("contents")},
+		{Type: OperationSymlink, Name: "/home/user/.link", OldName: ".bashrc"},
+		{Type: OperationRunScript, Name: "install.sh", Dir: "/home/user", Data: []byte("#!/bin/sh\ntouch installed\n")},
+	}
+	if diff, equal := messagediff.PrettyDiff(wantOperations, plan.Operations); !equal {
+		t.Errorf("ts.Plan(_).Operations == %+v, want %+v, diff:\n%s", plan.Operations, wantOperations, diff)
+	}
+	scriptRuns, err := ScriptRuns(persistentState)
+	if err != nil {
+		t.Fatalf("ScriptRuns(_) == _, %v, want _, <nil>", err)
+	}
+	if len(scriptRuns) != 0 {
+		t.Errorf("ScriptRuns(_) == %+v, want none after planning", scriptRuns)
+	}
+	vfst.RunTests(t, fs, "before",
+		vfst.TestPath("/home/user/.bashrc",
+			vfst.TestContentsString("# old\n"),
+		),
+		vfst.TestPath("/home/user/.dir",
+			vfst.TestDoesNotExist,
+		),
+	)
+
+	if err := ts.ExecutePlan(plan, NewFSMutator(fs, "/home/user")); err != nil {
+		t.Fatalf("ts.ExecutePlan(_, _) == %v, want <nil>", err)
+	}
+	vfst.RunTests(t, fs, "after",
+		vfst.TestPath("/home/user/.bashrc",
+			vfst.TestContentsString("# new\n"),
+		),
+		vfst.TestPath("/home/user/.dir/file",
+			vfst.TestModeIsRegular,
+			vfst.TestContentsString("contents"),
+		),
+		vfst.TestPath("/home/user/.link",
+			vfst.TestModeType(os.ModeSymlink),
+			vfst.TestSymlinkTarget(".bashrc"),
+		),
+		vfst.TestPath("/home/user/installed",
+			vfst.TestModeIsRegular,
+		),
+	)
+	scriptRuns, err = ScriptRuns(persistentState)
+	if err != nil {
+		t.Fatalf("ScriptRuns(_) == _, %v, want _, <nil>", err)
+	}
+	if len(scriptRuns) != 1 || scriptRuns[0].Name != "install.sh" {
+		t.Errorf("ScriptRuns(_) == %+v, want one run of install.sh", scriptRuns)
+	}
+}
+
+func TestTargetStateExecutePlanFunc(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			"dot_a":        "a",
+			"dot_dir/file": "file",
+			"dot_y":        "y",
+			"dot_z":        "z",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	plan, err := ts.Plan(fs)
+	if err != nil {
+		t.Fatalf("ts.Plan(%+v) == _, %v, want _, <nil>", fs, err)
+	}
+	var decided []string
+	decisions := map[string]ApplyDecision{
+		"/home/user/.a":   ApplyDecisionApply,
+		"/home/user/.y":   ApplyDecisionQuit,
+		"/home/user/.dir": ApplyDecisionSkip,
+	}
+	decide := func(o *Operation) (ApplyDecision, error) {
+		decided = append(decided, o.Name)
+		return decisions[o.Name], nil
+	}
+	skipped, err := ts.ExecutePlanFunc(context.Background(), plan, NewFSMutator(fs, "/home/user"), decide)
+	if err != nil {
+		t.Fatalf("ts.ExecutePlanFunc(_, _, _, _) == _, %v, want _, <nil>", err)
+	}
+	wantDecided := []string{"/home/user/.a", "/home/user/.dir", "/home/user/.y"}
+	if diff, equal := messagediff.PrettyDiff(wantDecided, decided); !equal {
+		t.Errorf("decided %v, want %v, diff:\n%s", decided, wantDecided, diff)
+	}
+	var skippedNames []string
+	for _, o := range skipped {
+		skippedNames = append(skippedNames, o.Name)
+	}
+	wantSkippedNames := []string{"/home/user/.dir", "/home/user/.dir/file", "/home/user/.y", "/home/user/.z"}
+	if diff, equal := messagediff.PrettyDiff(wantSkippedNames, skippedNames); !equal {
+		t.Errorf("ts.ExecutePlanFunc(_, _, _, _) skipped %v, want %v, diff:\n%s", skippedNames, wantSkippedNames, diff)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.a",
+			vfst.TestContentsString("a"),
+		),
+		vfst.TestPath("/home/user/.z",
+			vfst.TestDoesNotExist,
+		),
+		vfst.TestPath("/home/user/.dir",
+			vfst.TestDoesNotExist,
+		),
+	)
+}
+
+func TestTargetStateExecutePlanScriptRuns(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi/run_once_install.sh": "#!/bin/sh\necho install >> installed\n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	persistentState := NewMemoryPersistentState()
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	ts.PersistentState = persistentState
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	for _, mutator := range []Mutator{
+		NullMutator,
+		NewAnyMutator(NullMutator),
+		NewDryRunMutator(),
+	} {
+		plan, err := ts.Plan(fs)
+		if err != nil {
+			t.Fatalf("ts.Plan(%+v) == _, %v, want _, <nil>", fs, err)
+		}
+		if err := ts.ExecutePlan(plan, mutator); err != nil {
+			t.Fatalf("ts.ExecutePlan(_, %T) == %v, want <nil>", mutator, err)
+		}
+		scriptRuns, err := ScriptRuns(persistentState)
+		if err != nil {
+			t.Fatalf("ScriptRuns(_) == _, %v, want _, <nil>", err)
+		}
+		if len(scriptRuns) != 0 {
+			t.Errorf("after ts.ExecutePlan(_, %T), ScriptRuns(_) == %+v, want no runs", mutator, scriptRuns)
+		}
+	}
+	plan, err := ts.Plan(fs)
+	if err != nil {
+		t.Fatalf("ts.Plan(%+v) == _, %v, want _, <nil>", fs, err)
+	}
+	if err := ts.ExecutePlan(plan, NewFSMutator(fs, "/home/user")); err != nil {
+		t.Fatalf("ts.ExecutePlan(_, _) == %v, want <nil>", err)
+	}
+	scriptRuns, err := ScriptRuns(persistentState)
+	if err != nil {
+		t.Fatalf("ScriptRuns(_) == _, %v, want _, <nil>", err)
+	}
+	if len(scriptRuns) != 1 || scriptRuns[0].Name != "install.sh" {
+		t.Errorf("ScriptRuns(_) == %+v, want one run of install.sh", scriptRuns)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/installed",
+			vfst.TestContentsString("install\n"),
+		),
+	)
+}
+
+func TestTargetStateKeepGoing(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".blocked": "not a directory",
+			".chezmoi": map[string]interface{}{
+				"dot_a":                   "a",
+				"dot_blocked/file":        "file",
+				"dot_broken.tmpl":         "{{ .missing }}",
+				"dot_dir/dot_broken.tmpl": "{{ .missing }}",
+				"dot_dir/file":            "file",
+				"dot_z":                   "z",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", map[string]interface{}{}, nil)
+	ts.KeepGoing = true
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	plan, err := ts.Plan(fs)
+	if plan == nil {
+		t.Fatalf("ts.Plan(%+v) == <nil>, %v, want !<nil>, _", fs, err)
+	}
+	var gotTargetNames []string
+	if errs, ok := err.(MultiError); ok {
+		for _, err := range errs {
+			if te, ok := err.(*TargetError); ok {
+				gotTargetNames = append(gotTargetNames, te.TargetName)
+			}
+		}
+	}
+	wantTargetNames := []string{".broken", filepath.Join(".dir", ".broken")}
+	if diff, equal := messagediff.PrettyDiff(wantTargetNames, gotTargetNames); !equal {
+		t.Errorf("ts.Plan(_) == _, %v, want errors for %v, diff:\n%s", err, wantTargetNames, diff)
+	}
+
+	// Overwriting .blocked with a directory fails because the mutator
+	// cannot remove it, so creating the directory fails too.
+	mutator := &failingMutator{
+		Mutator: NewFSMutator(fs, "/home/user"),
+		name:    "/home/user/.blocked",
+	}
+	skipped, err := ts.ExecutePlanFunc(context.Background(), plan, mutator, nil)
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 2 || errs[0].(*TargetError).TargetName != ".blocked" || errs[1].(*TargetError).TargetName != ".blocked" {
+		t.Errorf("ts.ExecutePlanFunc(_, _, _, _) == _, %v, want two errors for .blocked", err)
+	}
+	var skippedNames []string
+	for _, o := range skipped {
+		skippedNames = append(skippedNames, o.Name)
+	}
+	wantSkippedNames := []string{"/home/user/.blocked", "/home/user/.blocked", "/home/user/.blocked/file"}
+	if diff, equal := messagediff.PrettyDiff(wantSkippedNames, skippedNames); !equal {
+		t.Errorf("ts.ExecutePlanFunc(_, _, _, _) skipped %v, want %v, diff:\n%s", skippedNames, wantSkippedNames, diff)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.a",
+			vfst.TestContentsString("a"),
+		),
+		vfst.TestPath("/home/user/.broken",
+			vfst.TestDoesNotExist,
+		),
+		vfst.TestPath("/home/user/.dir/file",
+			vfst.TestContentsString("file"),
+		),
+		vfst.TestPath("/home/user/.z",
+			vfst.TestContentsString("z"),
+		),
+	)
+}
+
+// A failingMutator is a Mutator that fails to change name.
+type failingMutator struct {
+	Mutator
+	name string
+}
+
+func (m *failingMutator) RemoveAll(name string) error {
+	if name == m.name {
+		return os.ErrPermission
+	}
+	return m.Mutator.RemoveAll(name)
+}
+
+// A concurrencyMutator is a Mutator that records the maximum number of
+// concurrent calls to WriteFile, and checks that the parent directory of each
+// file exists.
+type concurrencyMutator struct {
+	Mutator
+	mu            sync.Mutex
+	current       int
+	maxConcurrent int
+}
+
+func (m *concurrencyMutator) WriteFile(name string, data []byte, perm os.FileMode, currData []byte) error {
+	if _, err := m.Stat(filepath.Dir(name)); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.current++
+	if m.current > m.maxConcurrent {
+		m.maxConcurrent = m.current
+	}
+	m.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	m.mu.Lock()
+	m.current--
+	m.mu.Unlock()
+	return m.Mutator.WriteFile(name, data, perm, currData)
+}
+
+func TestTargetStateParallel(t *testing.T) {
+	root := map[string]interface{}{
+		"/home/user/.blocked":                  "not a directory",
+		"/home/user/.chezmoi/dot_blocked/file": "file",
+	}
+	var wantPaths []interface{}
+	for _, dir := range []string{"a", "b", "c"} {
+		for _, file := range []string{"0", "1", "2", "3", "4"} {
+			root["/home/user/.chezmoi/dot_"+dir+"/"+file] = dir + file
+			wantPaths = append(wantPaths, vfst.TestPath("/home/user/."+dir+"/"+file,
+				vfst.TestContentsString(dir+file),
+			))
+		}
+	}
+	fs, cleanup, err := vfst.NewTestFS(root)
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	ts.KeepGoing = true
+	ts.Parallelism = 4
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	plan, err := ts.Plan(fs)
+	if err != nil {
+		t.Fatalf("ts.Plan(_) == _, %v, want _, <nil>", err)
+	}
+	mutator := &concurrencyMutator{
+		Mutator: &failingMutator{
+			Mutator: NewFSMutator(fs, "/home/user"),
+			name:    "/home/user/.blocked",
+		},
+	}
+	skipped, err := ts.ExecutePlanFunc(context.Background(), plan, mutator, nil)
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 2 || errs[0].(*TargetError).TargetName != ".blocked" || errs[1].(*TargetError).TargetName != ".blocked" {
+		t.Errorf("ts.ExecutePlanFunc(_, _, _, _) == _, %v, want two errors for .blocked", err)
+	}
+	var skippedNames []string
+	for _, o := range skipped {
+		skippedNames = append(skippedNames, o.Name)
+	}
+	wantSkippedNames := []string{"/home/user/.blocked", "/home/user/.blocked", "/home/user/.blocked/file"}
+	if diff, equal := messagediff.PrettyDiff(wantSkippedNames, skippedNames); !equal {
+		t.Errorf("ts.ExecutePlanFunc(_, _, _, _) skipped %v, want %v, diff:\n%s", skippedNames, wantSkippedNames, diff)
+	}
+	if mutator.maxConcurrent < 2 || mutator.maxConcurrent > ts.Parallelism {
+		t.Errorf("ts.ExecutePlanFunc(_, _, _, _) wrote %d files concurrently, want between 2 and %d", mutator.maxConcurrent, ts.Parallelism)
+	}
+	vfst.RunTests(t, fs, "", wantPaths...)
+}
+
+func TestTargetStateExecutePlanStop(t *testing.T) {
+	for _, parallelism := range []int{1, 4} {
+		t.Run(fmt.Sprintf("parallelism_%d", parallelism), func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user/.blocked": "not a directory",
+				"/home/user/.chezmoi": map[string]interface{}{
+					"dot_a":            "a",
+					"dot_blocked/file": "file",
+					"dot_c/file":       "c",
+				},
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+			ts.Parallelism = parallelism
+			if err := ts.Populate(fs); err != nil {
+				t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+			}
+			plan, err := ts.Plan(fs)
+			if err != nil {
+				t.Fatalf("ts.Plan(_) == _, %v, want _, <nil>", err)
+			}
+			mutator := &failingMutator{
+				Mutator: NewFSMutator(fs, "/home/user"),
+				name:    "/home/user/.blocked",
+			}
+			skipped, err := ts.ExecutePlanFunc(context.Background(), plan, mutator, nil)
+			if err != os.ErrPermission {
+				t.Errorf("ts.ExecutePlanFunc(_, _, _, _) == _, %v, want _, %v", err, os.ErrPermission)
+			}
+			var skippedNames []string
+			for _, o := range skipped {
+				skippedNames = append(skippedNames, o.Name)
+			}
+			wantSkippedNames := []string{"/home/user/.blocked", "/home/user/.blocked/file", "/home/user/.c", "/home/user/.c/file"}
+			if diff, equal := messagediff.PrettyDiff(wantSkippedNames, skippedNames); !equal {
+				t.Errorf("ts.ExecutePlanFunc(_, _, _, _) skipped %v, want %v, diff:\n%s", skippedNames, wantSkippedNames, diff)
+			}
+			vfst.RunTests(t, fs, "",
+				vfst.TestPath("/home/user/.a",
+					vfst.TestContentsString("a"),
+				),
+				vfst.TestPath("/home/user/.c",
+					vfst.TestDoesNotExist,
+				),
+			)
+		})
+	}
+}
+
+func TestTargetStateContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scripts not tested on Windows")
+	}
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			"dot_a":        "a",
+			"run_sleep.sh": "#!/bin/sh\nexec sleep 10\n",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ts.PopulateContext(cancelledCtx, fs); err != context.Canceled {
+		t.Errorf("ts.PopulateContext(_, _) == %v, want %v", err, context.Canceled)
+	}
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	plan, err := ts.Plan(fs)
+	if err != nil {
+		t.Fatalf("ts.Plan(_) == _, %v, want _, <nil>", err)
+	}
+	skipped, err := ts.ExecutePlanFunc(cancelledCtx, plan, NewFSMutator(fs, "/home/user"), nil)
+	if err != context.Canceled || len(skipped) != len(plan.Operations) {
+		t.Errorf("ts.ExecutePlanFunc(_, _, _, _) == %d skipped, %v, want %d skipped, %v", len(skipped), err, len(plan.Operations), context.Canceled)
+	}
+
+	// The script is killed when the deadline passes.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := ts.ApplyContext(ctx, fs, NewFSMutator(fs, "/home/user")); err == nil {
+		t.Errorf("ts.ApplyContext(_, _, _) == <nil>, want !<nil>")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ts.ApplyContext(_, _, _) took %s, want the script to be killed", elapsed)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.a",
+			vfst.TestContentsString("a"),
+		),
+	)
+}
@@ -0,0 +1,178 @@
+package chezmoi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// An OperationType is the type of an Operation.
+type OperationType string
+
+// Operation types.
+const (
+	OperationChmod     OperationType = "chmod"
+	OperationCreate    OperationType = "create"
+	OperationMkdir     OperationType = "mkdir"
+	OperationOverwrite OperationType = "overwrite"
+	OperationRemove    OperationType = "remove"
+	OperationRename    OperationType = "rename"
+	OperationRunScript OperationType = "run script"
+	OperationSymlink   OperationType = "symlink"
+)
+
+// An Operation is a single change made by a Mutator.
+type Operation struct {
+	Type     OperationType `json:"type" yaml:"type"`
+	Name     string        `json:"name" yaml:"name"`                           // Name is the path that is changed.
+	OldName  string        `json:"oldName,omitempty" yaml:"oldName,omitempty"` // OldName is the old path of a rename or the target of a symlink.
+	Dir      string        `json:"dir,omitempty" yaml:"dir,omitempty"`         // Dir is the working directory of a script.
+	Mode     os.FileMode   `json:"mode,omitempty" yaml:"mode,omitempty"`       // Mode is the mode of a chmod or the permissions of a new directory or file.
+	Data     []byte        `json:"data,omitempty" yaml:"data,omitempty"`       // Data is the contents of a file or script.
+	CurrData []byte        `json:"currData" yaml:"currData"`                   // CurrData is the current contents of an overwritten file, and nil for a new file.
+	Source   string        `json:"source,omitempty" yaml:"source,omitempty"`   // Source is the path of the file whose contents are copied, instead of Data, to a file too large to hold in memory.
+	Size     int64         `json:"size,omitempty" yaml:"size,omitempty"`       // Size is the size of Source.
+}
+
+// A RecordingMutator wraps a Mutator and records every Operation that is
+// executed successfully. Wrapping NullMutator gives a dry run that computes
+// every operation without changing anything. It is safe for concurrent use if
+// its Mutator is, but Operations must only be read once no more operations are
+// being executed.
+type RecordingMutator struct {
+	m          Mutator
+	mu         sync.Mutex
+	Operations []Operation
+}
+
+// NewRecordingMutator returns a new RecordingMutator that wraps m.
+func NewRecordingMutator(m Mutator) *RecordingMutator {
+	return &RecordingMutator{
+		m: m,
+	}
+}
+
+// NewDryRunMutator returns a new RecordingMutator that records operations
+// without executing them.
+func NewDryRunMutator() *RecordingMutator {
+	return NewRecordingMutator(NullMutator)
+}
+
+// String returns a description of o.
+func (o *Operation) String() string {
+	switch o.Type {
+	case OperationChmod, OperationMkdir, OperationCreate, OperationOverwrite:
+		return fmt.Sprintf("%s %s (%03o)", o.Type, o.Name, o.Mode)
+	case OperationRename:
+		return fmt.Sprintf("%s %s to %s", o.Type, o.OldName, o.Name)
+	case OperationRunScript:
+		return fmt.Sprintf("%s %s in %s", o.Type, o.Name, o.Dir)
+	case OperationSymlink:
+		return fmt.Sprintf("%s %s to %s", o.Type, o.Name, o.OldName)
+	default:
+		return fmt.Sprintf("%s %s", o.Type, o.Name)
+	}
+}
+
+// Chmod implements Mutator.Chmod.
+func (m *RecordingMutator) Chmod(name string, mode os.FileMode) error {
+	return m.record(m.m.Chmod(name, mode), Operation{
+		Type: OperationChmod,
+		Name: name,
+		Mode: mode,
+	})
+}
+
+// CopyFile implements Mutator.CopyFile. Files are created unless overwrite is
+// true.
+func (m *RecordingMutator) CopyFile(name, source string, size int64, perm os.FileMode, overwrite bool) error {
+	operationType := OperationCreate
+	if overwrite {
+		operationType = OperationOverwrite
+	}
+	return m.record(m.m.CopyFile(name, source, size, perm, overwrite), Operation{
+		Type:   operationType,
+		Name:   name,
+		Mode:   perm,
+		Source: source,
+		Size:   size,
+	})
+}
+
+// Mkdir implements Mutator.Mkdir.
+func (m *RecordingMutator) Mkdir(name string, perm os.FileMode) error {
+	return m.record(m.m.Mkdir(name, perm), Operation{
+		Type: OperationMkdir,
+		Name: name,
+		Mode: perm,
+	})
+}
+
+// RemoveAll implements Mutator.RemoveAll.
+func (m *RecordingMutator) RemoveAll(name string) error {
+	return m.record(m.m.RemoveAll(name), Operation{
+		Type: OperationRemove,
+		Name: name,
+	})
+}
+
+// Rename implements Mutator.Rename.
+func (m *RecordingMutator) Rename(oldpath, newpath string) error {
+	return m.record(m.m.Rename(oldpath, newpath), Operation{
+		Type:    OperationRename,
+		Name:    newpath,
+		OldName: oldpath,
+	})
+}
+
+// RunScript implements Mutator.RunScript.
+func (m *RecordingMutator) RunScript(ctx context.Context, name, dir string, data []byte) (bool, error) {
+	ran, err := m.m.RunScript(ctx, name, dir, data)
+	return ran, m.record(err, Operation{
+		Type: OperationRunScript,
+		Name: name,
+		Dir:  dir,
+		Data: data,
+	})
+}
+
+// Stat implements Mutator.Stat.
+func (m *RecordingMutator) Stat(name string) (os.FileInfo, error) {
+	return m.m.Stat(name)
+}
+
+// WriteFile implements Mutator.WriteFile. Files are created if currData is
+// nil and overwritten otherwise.
+func (m *RecordingMutator) WriteFile(name string, data []byte, perm os.FileMode, currData []byte) error {
+	operationType := OperationOverwrite
+	if currData == nil {
+		operationType = OperationCreate
+	}
+	return m.record(m.m.WriteFile(name, data, perm, currData), Operation{
+		Type:     operationType,
+		Name:     name,
+		Mode:     perm,
+		Data:     data,
+		CurrData: currData,
+	})
+}
+
+// WriteSymlink implements Mutator.WriteSymlink.
+func (m *RecordingMutator) WriteSymlink(oldname, newname string) error {
+	return m.record(m.m.WriteSymlink(oldname, newname), Operation{
+		Type:    OperationSymlink,
+		Name:    newname,
+		OldName: oldname,
+	})
+}
+
+// record records o if err is nil, and returns err.
+func (m *RecordingMutator) record(err error, o Operation) error {
+	if err == nil {
+		m.mu.Lock()
+		m.Operations = append(m.Operations, o)
+		m.mu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package chezmoi
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateDryRun(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc": "# old contents of .bashrc\n",
+			".profile": &vfst.File{
+				Perm:     0600,
+				Contents: []byte("# contents of .profile\n"),
+			},
+			".old": "# old\n",
+			".chezmoi": map[string]interface{}{
+				".chezmoiremove": ".old\n",
+				"dot_bashrc":     "# contents of .bashrc\n",
+				"dot_profile":    "# contents of .profile\n",
+				"dot_dir": map[string]interface{}{
+					"file":      "# contents of file\n",
+					"run_hello": "#!/bin/sh\necho hello\n",
+				},
+				"symlink_dot_link": ".bashrc",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	got, err := ts.DryRun(fs)
+	if err != nil {
+		t.Fatalf("ts.DryRun(%+v) == _, %v, want _, <nil>", fs, err)
+	}
+	want := []Operation{
+		{
+			Type: OperationRemove,
+			Name: "/home/user/.old",
+		},
+		{
+			Type:     OperationOverwrite,
+			Name:     "/home/user/.bashrc",
+			Mode:     0644,
+			Data:     []byte("# contents of .bashrc\n"),
+			CurrData: []byte("# old contents of .bashrc\n"),
+		},
+		{
+			Type: OperationMkdir,
+			Name: "/home/user/.dir",
+			Mode: 0755,
+		},
+		{
+			Type: OperationCreate,
+			Name: "/home/user/.dir/file",
+			Mode: 0644,
+			Data: []byte("# contents of file\n"),
+		},
+		{
+			Type: OperationRunScript,
+			Name: ".dir/hello",
+			Dir:  "/home/user/.dir",
+			Data: []byte("#!/bin/sh\necho hello\n"),
+		},
+		{
+			Type:    OperationSymlink,
+			Name:    "/home/user/.link",
+			OldName: ".bashrc",
+		},
+		{
+			Type: OperationChmod,
+			Name: "/home/user/.profile",
+			Mode: 0644,
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(want, got); !equal {
+		t.Errorf("ts.DryRun(%+v) diff:\n%s", fs, diff)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.bashrc",
+			vfst.TestContentsString("# old contents of .bashrc\n"),
+		),
+		vfst.TestPath("/home/user/.old",
+			vfst.TestModeIsRegular,
+		),
+		vfst.TestPath("/home/user/.dir",
+			vfst.TestDoesNotExist,
+		),
+		vfst.TestPath("/home/user/.profile",
+			vfst.TestModePerm(0600),
+		),
+	)
+}
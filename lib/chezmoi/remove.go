@@ -0,0 +1,109 @@
+package chezmoi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// removeFileName is the name of the file in the source directory that lists
+// targets to be removed from the destination directory.
+const removeFileName = ".chezmoiremove"
+
+// applyRemove removes the targets in fs that match ts.TargetRemove. Targets
+// that are in the source state or are ignored are not removed.
+func (ts *TargetState) applyRemove(fs vfs.FS, mutator Mutator) error {
+	targetNames := make(map[string]struct{})
+	for pattern := range ts.TargetRemove {
+		matches, err := ts.globTargets(fs, pattern)
+		if err != nil {
+			return err
+		}
+		for _, targetName := range matches {
+			targetNames[targetName] = struct{}{}
+		}
+	}
+	sortedTargetNames := make([]string, 0, len(targetNames))
+	for targetName := range targetNames {
+		sortedTargetNames = append(sortedTargetNames, targetName)
+	}
+	sort.Strings(sortedTargetNames)
+	removedTargetName := ""
+	for _, targetName := range sortedTargetNames {
+		// Everything in a removed directory has already been removed.
+		if removedTargetName != "" && strings.HasPrefix(targetName, removedTargetName+string(os.PathSeparator)) {
+			continue
+		}
+		if ts.TargetIgnore.Match(targetName) {
+			continue
+		}
+		if entry, err := ts.findEntry(targetName); err == nil && entry != nil {
+			continue
+		}
+		// Never remove the destination directory or anything outside it.
+		if relPath, err := filepath.Rel(ts.DestDir, filepath.Join(ts.DestDir, targetName)); err != nil || relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(os.PathSeparator)) {
+			return fmt.Errorf("%s: outside target directory", targetName)
+		}
+		if err := mutator.RemoveAll(filepath.Join(ts.DestDir, targetName)); err != nil {
+			return err
+		}
+		removedTargetName = targetName
+	}
+	return nil
+}
+
+// globTargets returns the names of the targets in fs that match pattern.
+func (ts *TargetState) globTargets(fs vfs.FS, pattern string) ([]string, error) {
+	targetNames := []string{""}
+	for _, component := range splitPathList(pattern) {
+		var matches []string
+		for _, targetName := range targetNames {
+			if !strings.ContainsAny(component, `*?[\`) {
+				match := filepath.Join(targetName, component)
+				if _, err := fs.Lstat(filepath.Join(ts.DestDir, match)); err == nil {
+					matches = append(matches, match)
+				} else if !os.IsNotExist(err) {
+					return nil, err
+				}
+				continue
+			}
+			dir := filepath.Join(ts.DestDir, targetName)
+			if info, err := fs.Lstat(dir); os.IsNotExist(err) || err == nil && !info.IsDir() {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			infos, err := fs.ReadDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			for _, info := range infos {
+				if ok, _ := filepath.Match(component, info.Name()); ok {
+					matches = append(matches, filepath.Join(targetName, info.Name()))
+				}
+			}
+		}
+		targetNames = matches
+	}
+	return targetNames, nil
+}
+
+// validRemovePattern returns true if pattern, a line of a .chezmoiremove file,
+// can only match targets strictly below the directory of the file. Patterns
+// that are absolute, that are the directory itself, or that contain a ..
+// component would remove the destination directory or its parents.
+func validRemovePattern(pattern string) bool {
+	if filepath.IsAbs(pattern) || strings.HasPrefix(pattern, "/") || filepath.Clean(pattern) == "." {
+		return false
+	}
+	for _, component := range strings.Split(filepath.ToSlash(pattern), "/") {
+		if component == ".." {
+			return false
+		}
+	}
+	return true
+}
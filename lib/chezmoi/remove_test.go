@@ -0,0 +1,187 @@
+package chezmoi
+
+import (
+	"testing"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateApplyRemove(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc":      "# contents of .bashrc\n",
+			".bashrc.old":  "# old .bashrc\n",
+			".profile.old": "# old .profile\n",
+			".keep.old":    "# ignored\n",
+			".cache": map[string]interface{}{
+				"foo": map[string]interface{}{
+					"bar": "baz",
+				},
+				"keep": "keep",
+			},
+			".config": map[string]interface{}{
+				"app": map[string]interface{}{
+					"settings.old": "# old settings\n",
+					"settings":     "# settings\n",
+				},
+			},
+			".chezmoi": map[string]interface{}{
+				".chezmoiignore":  ".keep.old\n",
+				".chezmoiremove":  "*.old\n.cache/foo\n{{ if true }}.config/*/*.old{{ end }}\n.missing\n.profile.old\n",
+				"dot_bashrc":      "# contents of .bashrc\n",
+				"dot_profile.old": "# managed\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	if err := ts.Apply(fs, NewFSMutator(fs, "/home/user")); err != nil {
+		t.Fatalf("ts.Apply(%+v, _) == %v, want <nil>", fs, err)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.bashrc",
+			vfst.TestContentsString("# contents of .bashrc\n"),
+		),
+		vfst.TestPath("/home/user/.bashrc.old",
+			vfst.TestDoesNotExist,
+		),
+		vfst.TestPath("/home/user/.profile.old",
+			vfst.TestContentsString("# managed\n"),
+		),
+		vfst.TestPath("/home/user/.keep.old",
+			vfst.TestContentsString("# ignored\n"),
+		),
+		vfst.TestPath("/home/user/.cache/foo",
+			vfst.TestDoesNotExist,
+		),
+		vfst.TestPath("/home/user/.cache/keep",
+			vfst.TestContentsString("keep"),
+		),
+		vfst.TestPath("/home/user/.config/app/settings.old",
+			vfst.TestDoesNotExist,
+		),
+		vfst.TestPath("/home/user/.config/app/settings",
+			vfst.TestContentsString("# settings\n"),
+		),
+	)
+}
+
+func TestTargetStateApplyRemoveOutsideDestDir(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		path     string
+		contents string
+		wantErr  string
+	}{
+		{
+			name:     "dot",
+			path:     "/home/user/.chezmoi/.chezmoiremove",
+			contents: "# comment\n.\n",
+			wantErr:  "/home/user/.chezmoi/.chezmoiremove:2: .: outside target directory",
+		},
+		{
+			name:     "dot_slash",
+			path:     "/home/user/.chezmoi/.chezmoiremove",
+			contents: "./\n",
+			wantErr:  "/home/user/.chezmoi/.chezmoiremove:1: ./: outside target directory",
+		},
+		{
+			name:     "slash",
+			path:     "/home/user/.chezmoi/.chezmoiremove",
+			contents: "/\n",
+			wantErr:  "/home/user/.chezmoi/.chezmoiremove:1: /: outside target directory",
+		},
+		{
+			name:     "absolute",
+			path:     "/home/user/.chezmoi/.chezmoiremove",
+			contents: "/home/user/.bashrc\n",
+			wantErr:  "/home/user/.chezmoi/.chezmoiremove:1: /home/user/.bashrc: outside target directory",
+		},
+		{
+			name:     "dot_dot",
+			path:     "/home/user/.chezmoi/.chezmoiremove",
+			contents: "..\n",
+			wantErr:  "/home/user/.chezmoi/.chezmoiremove:1: ..: outside target directory",
+		},
+		{
+			name:     "nested_dot_dot",
+			path:     "/home/user/.chezmoi/dir/.chezmoiremove",
+			contents: "*.old\n../..\n",
+			wantErr:  "/home/user/.chezmoi/dir/.chezmoiremove:2: ../..: outside target directory",
+		},
+		{
+			name:     "dot_dot_component",
+			path:     "/home/user/.chezmoi/dir/.chezmoiremove",
+			contents: "sub/../../.bashrc\n",
+			wantErr:  "/home/user/.chezmoi/dir/.chezmoiremove:1: sub/../../.bashrc: outside target directory",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user": map[string]interface{}{
+					".bashrc": "# contents of .bashrc\n",
+					"dir": map[string]interface{}{
+						"file.old": "# old file\n",
+					},
+					".chezmoi": map[string]interface{}{
+						"dir/file": "# contents of dir/file\n",
+					},
+				},
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			if err := fs.WriteFile(tc.path, []byte(tc.contents), 0666); err != nil {
+				t.Fatalf("fs.WriteFile(%q, ...) == %v, want <nil>", tc.path, err)
+			}
+			ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+			if err := ts.Populate(fs); err == nil || err.Error() != tc.wantErr {
+				t.Errorf("ts.Populate(_) == %v, want %q", err, tc.wantErr)
+			}
+			vfst.RunTests(t, fs, "",
+				vfst.TestPath("/home/user/.bashrc",
+					vfst.TestContentsString("# contents of .bashrc\n"),
+				),
+			)
+		})
+	}
+}
+
+func TestTargetStateApplyRemoveDestDir(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc":  "# contents of .bashrc\n",
+			".chezmoi": &vfst.Dir{Perm: 0700},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	// Patterns that match the destination directory or its parent are never
+	// removed, even if they are added directly.
+	for _, pattern := range []string{".", ".."} {
+		ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+		if err := ts.Populate(fs); err != nil {
+			t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+		}
+		if err := ts.TargetRemove.Add(pattern); err != nil {
+			t.Fatalf("ts.TargetRemove.Add(%q) == %v, want <nil>", pattern, err)
+		}
+		if err := ts.Apply(fs, NewFSMutator(fs, "/home/user")); err == nil {
+			t.Errorf("%q: ts.Apply(_, _) == <nil>, want !<nil>", pattern)
+		}
+		vfst.RunTests(t, fs, "",
+			vfst.TestPath("/home/user/.bashrc",
+				vfst.TestContentsString("# contents of .bashrc\n"),
+			),
+		)
+	}
+}
@@ -0,0 +1,126 @@
+package chezmoi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+)
+
+// A RetryPolicy determines how operations that fail with transient errors
+// are retried.
+type RetryPolicy struct {
+	MaxAttempts int                                     // MaxAttempts is the maximum number of attempts, including the first.
+	Backoff     func(attempt int) time.Duration         // Backoff returns the delay before retrying after attempt.
+	Retryable   func(err error) bool                    // Retryable returns true if err is transient.
+	OnRetry     func(op string, attempt int, err error) // OnRetry, if not nil, is called before each retry.
+}
+
+// A RetryRecord records a failed attempt that was retried.
+type RetryRecord struct {
+	Op      string `json:"op" yaml:"op"`
+	Attempt int    `json:"attempt" yaml:"attempt"`
+	Err     string `json:"err" yaml:"err"`
+}
+
+// An HTTPStatusError is returned when an HTTP request returns an unsuccessful
+// status code.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+// DefaultRetryPolicy is the default RetryPolicy. It makes up to three attempts
+// with exponential backoff starting at 100ms, and retries the errors that
+// IsRetryableError classifies as transient.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     ExponentialBackoff(100*time.Millisecond, 5*time.Second),
+	Retryable:   IsRetryableError,
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// ExponentialBackoff returns a backoff function that starts at initial and
+// doubles after each attempt, up to max.
+func ExponentialBackoff(initial, max time.Duration) func(int) time.Duration {
+	return func(attempt int) time.Duration {
+		backoff := initial
+		for i := 1; i < attempt && backoff < max; i++ {
+			backoff *= 2
+		}
+		if backoff > max {
+			backoff = max
+		}
+		return backoff
+	}
+}
+
+// IsRetryableError returns true if err is a transient error. EINTR, EAGAIN,
+// EBUSY, and ETIMEDOUT, network timeouts, HTTP 429, and HTTP 5xx errors are
+// transient. All other errors, including EACCES, ENOSPC, and HTTP 404, are
+// not.
+func IsRetryableError(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *os.PathError:
+			err = e.Err
+		case *os.LinkError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		case syscall.Errno:
+			switch e {
+			case syscall.EINTR, syscall.EAGAIN, syscall.EBUSY, syscall.ETIMEDOUT:
+				return true
+			default:
+				return false
+			}
+		case *HTTPStatusError:
+			return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+		case net.Error:
+			return e.Timeout()
+		default:
+			return false
+		}
+	}
+}
+
+// Do calls f until it succeeds, returns an error that is not retryable, or
+// p.MaxAttempts attempts have been made. op describes the operation and is
+// passed to p.OnRetry.
+func (p RetryPolicy) Do(op string, f func() error) error {
+	return p.DoContext(context.Background(), op, f)
+}
+
+// DoContext is like Do, but stops retrying when ctx is done, in which case it
+// returns f's last error.
+func (p RetryPolicy) DoContext(ctx context.Context, op string, f func() error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryableError
+	}
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.MaxAttempts || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(op, attempt, err)
+		}
+		if p.Backoff != nil {
+			timer := time.NewTimer(p.Backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package chezmoi
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// A RetryMutator wraps another Mutator and retries operations that fail with
+// transient errors. It is safe for concurrent use if its Mutator is.
+type RetryMutator struct {
+	m       Mutator
+	policy  RetryPolicy
+	mu      sync.Mutex
+	retries []RetryRecord
+}
+
+// NewRetryMutator returns a new RetryMutator that retries operations on m
+// according to policy.
+func NewRetryMutator(m Mutator, policy RetryPolicy) *RetryMutator {
+	return &RetryMutator{
+		m:      m,
+		policy: policy,
+	}
+}
+
+// Chmod implements Mutator.Chmod.
+func (m *RetryMutator) Chmod(name string, mode os.FileMode) error {
+	return m.do("chmod "+name, func() error {
+		return m.m.Chmod(name, mode)
+	})
+}
+
+// CopyFile implements Mutator.CopyFile.
+func (m *RetryMutator) CopyFile(name, source string, size int64, perm os.FileMode, overwrite bool) error {
+	return m.do("write "+name, func() error {
+		return m.m.CopyFile(name, source, size, perm, overwrite)
+	})
+}
+
+// Mkdir implements Mutator.Mkdir.
+func (m *RetryMutator) Mkdir(name string, perm os.FileMode) error {
+	return m.do("mkdir "+name, func() error {
+		return m.m.Mkdir(name, perm)
+	})
+}
+
+// RemoveAll implements Mutator.RemoveAll.
+func (m *RetryMutator) RemoveAll(name string) error {
+	return m.do("rm -rf "+name, func() error {
+		return m.m.RemoveAll(name)
+	})
+}
+
+// Rename implements Mutator.Rename.
+func (m *RetryMutator) Rename(oldpath, newpath string) error {
+	return m.do("mv "+oldpath+" "+newpath, func() error {
+		return m.m.Rename(oldpath, newpath)
+	})
+}
+
+// Retries returns the failed attempts that were retried.
+func (m *RetryMutator) Retries() []RetryRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]RetryRecord(nil), m.retries...)
+}
+
+// RunScript implements Mutator.RunScript. Scripts are never retried, as
+// running them more than once may not be safe.
+func (m *RetryMutator) RunScript(ctx context.Context, name, dir string, data []byte) (bool, error) {
+	return m.m.RunScript(ctx, name, dir, data)
+}
+
+// Stat implements Mutator.Stat.
+func (m *RetryMutator) Stat(name string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := m.do("stat "+name, func() error {
+		var err error
+		info, err = m.m.Stat(name)
+		return err
+	})
+	return info, err
+}
+
+// WriteFile implements Mutator.WriteFile.
+func (m *RetryMutator) WriteFile(name string, data []byte, perm os.FileMode, currData []byte) error {
+	return m.do("write "+name, func() error {
+		return m.m.WriteFile(name, data, perm, currData)
+	})
+}
+
+// WriteSymlink implements Mutator.WriteSymlink.
+func (m *RetryMutator) WriteSymlink(oldname, newname string) error {
+	return m.do("ln -sf "+oldname+" "+newname, func() error {
+		return m.m.WriteSymlink(oldname, newname)
+	})
+}
+
+func (m *RetryMutator) do(op string, f func() error) error {
+	policy := m.policy
+	onRetry := policy.OnRetry
+	policy.OnRetry = func(op string, attempt int, err error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.retries = append(m.retries, RetryRecord{
+			Op:      op,
+			Attempt: attempt,
+			Err:     err.Error(),
+		})
+		if onRetry != nil {
+			onRetry(op, attempt, err)
+		}
+	}
+	return policy.Do(op, f)
+}
@@ -0,0 +1,203 @@
+package chezmoi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+)
+
+// A flakyMutator is a Mutator whose WriteFile method returns each of errs in
+// turn before succeeding.
+type flakyMutator struct {
+	nullMutator
+	errs     []error
+	attempts int
+}
+
+func (m *flakyMutator) WriteFile(name string, data []byte, perm os.FileMode, currData []byte) error {
+	m.attempts++
+	if len(m.errs) == 0 {
+		return nil
+	}
+	err := m.errs[0]
+	m.errs = m.errs[1:]
+	return err
+}
+
+func TestIsRetryableError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{err: syscall.EINTR, want: true},
+		{err: &os.PathError{Op: "write", Path: "/home/user/.bashrc", Err: syscall.ETIMEDOUT}, want: true},
+		{err: &os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.EBUSY}, want: true},
+		{err: &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{err: &HTTPStatusError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{err: &HTTPStatusError{StatusCode: http.StatusNotFound}, want: false},
+		{err: &os.PathError{Op: "open", Path: "/etc/shadow", Err: syscall.EACCES}, want: false},
+		{err: &os.PathError{Op: "write", Path: "/home/user/.bashrc", Err: syscall.ENOSPC}, want: false},
+		{err: errors.New("error"), want: false},
+	} {
+		if got := IsRetryableError(tc.err); got != tc.want {
+			t.Errorf("IsRetryableError(%v) == %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRetryPolicyDoContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	attempts := 0
+	policy := RetryPolicy{
+		MaxAttempts: 5,
+		Backoff: func(int) time.Duration {
+			return time.Hour
+		},
+		OnRetry: func(string, int, error) {
+			cancel()
+		},
+	}
+	if err := policy.DoContext(ctx, "op", func() error {
+		attempts++
+		return syscall.EINTR
+	}); err != syscall.EINTR || attempts != 1 {
+		t.Errorf("policy.DoContext(_, _, _) == %v after %d attempts, want %v after 1 attempt", err, attempts, syscall.EINTR)
+	}
+}
+
+func TestRetryMutator(t *testing.T) {
+	eintr := &os.PathError{Op: "write", Path: "/home/user/.bashrc", Err: syscall.EINTR}
+	enospc := &os.PathError{Op: "write", Path: "/home/user/.bashrc", Err: syscall.ENOSPC}
+	for _, tc := range []struct {
+		name         string
+		errs         []error
+		wantErr      error
+		wantAttempts int
+		wantRetries  []RetryRecord
+	}{
+		{
+			name:         "success",
+			wantAttempts: 1,
+		},
+		{
+			name:         "transient_then_success",
+			errs:         []error{eintr, eintr},
+			wantAttempts: 3,
+			wantRetries: []RetryRecord{
+				{Op: "write /home/user/.bashrc", Attempt: 1, Err: eintr.Error()},
+				{Op: "write /home/user/.bashrc", Attempt: 2, Err: eintr.Error()},
+			},
+		},
+		{
+			name:         "too_many_attempts",
+			errs:         []error{eintr, eintr, eintr, eintr},
+			wantErr:      eintr,
+			wantAttempts: 3,
+			wantRetries: []RetryRecord{
+				{Op: "write /home/user/.bashrc", Attempt: 1, Err: eintr.Error()},
+				{Op: "write /home/user/.bashrc", Attempt: 2, Err: eintr.Error()},
+			},
+		},
+		{
+			name:         "not_retryable",
+			errs:         []error{enospc},
+			wantErr:      enospc,
+			wantAttempts: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := &flakyMutator{errs: tc.errs}
+			policy := RetryPolicy{
+				MaxAttempts: 3,
+			}
+			m := NewRetryMutator(fm, policy)
+			if err := m.WriteFile("/home/user/.bashrc", nil, 0666, nil); err != tc.wantErr {
+				t.Errorf("m.WriteFile(...) == %v, want %v", err, tc.wantErr)
+			}
+			if fm.attempts != tc.wantAttempts {
+				t.Errorf("fm.attempts == %d, want %d", fm.attempts, tc.wantAttempts)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.wantRetries, m.Retries()); !equal {
+				t.Errorf("m.Retries() diff:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		statusCodes    []int
+		wantStatusCode int
+		wantErr        bool
+		wantRequests   int
+	}{
+		{
+			name:           "transient_then_success",
+			statusCodes:    []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			wantStatusCode: http.StatusOK,
+			wantRequests:   3,
+		},
+		{
+			name:         "too_many_attempts",
+			statusCodes:  []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			wantErr:      true,
+			wantRequests: 3,
+		},
+		{
+			name:           "not_found",
+			statusCodes:    []int{http.StatusNotFound, http.StatusOK},
+			wantStatusCode: http.StatusNotFound,
+			wantRequests:   1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statusCodes[requests])
+				requests++
+			}))
+			defer server.Close()
+			var retries int
+			client := &http.Client{
+				Transport: &RetryTransport{
+					Policy: RetryPolicy{
+						MaxAttempts: 3,
+						OnRetry: func(string, int, error) {
+							retries++
+						},
+					},
+				},
+			}
+			resp, err := client.Get(server.URL)
+			if tc.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Errorf("client.Get(%q) == _, <nil>, want _, !<nil>", server.URL)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("client.Get(%q) == _, %v, want _, <nil>", server.URL, err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != tc.wantStatusCode {
+					t.Errorf("resp.StatusCode == %d, want %d", resp.StatusCode, tc.wantStatusCode)
+				}
+			}
+			if requests != tc.wantRequests {
+				t.Errorf("requests == %d, want %d", requests, tc.wantRequests)
+			}
+			if retries != tc.wantRequests-1 {
+				t.Errorf("retries == %d, want %d", retries, tc.wantRequests-1)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package chezmoi
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// A RetryTransport is an http.RoundTripper that retries requests that fail
+// with transient errors. Only requests without a body are retried, and
+// requests are not retried once their contexts are done.
+type RetryTransport struct {
+	Transport http.RoundTripper // Transport is the underlying transport, or http.DefaultTransport if nil.
+	Policy    RetryPolicy
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return transport.RoundTrip(req)
+	}
+	retryable := t.Policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryableError
+	}
+	var resp *http.Response
+	err := t.Policy.DoContext(req.Context(), fmt.Sprintf("%s %s", req.Method, req.URL), func() error {
+		var err error
+		resp, err = transport.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode < 400 {
+			return nil
+		}
+		statusErr := &HTTPStatusError{
+			URL:        req.URL.String(),
+			StatusCode: resp.StatusCode,
+		}
+		if !retryable(statusErr) {
+			return nil
+		}
+		// Discard the body so that the connection can be reused.
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		resp = nil
+		return statusErr
+	})
+	return resp, err
+}
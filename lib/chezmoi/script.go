@@ -0,0 +1,251 @@
+package chezmoi
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// Runs of run_once_ scripts are recorded in scriptOnceBucket, keyed by the
+// SHA256 of their contents. The last runs of run_onchange_ scripts are recorded
+// in scriptOnChangeBucket, keyed by their names.
+var (
+	scriptOnceBucket     = []byte("scriptOnce")
+	scriptOnChangeBucket = []byte("scriptOnChange")
+	scriptBuckets        = [][]byte{scriptOnceBucket, scriptOnChangeBucket}
+)
+
+// A Script represents a script that is run when the target state is applied.
+// Its contents are evaluated at most once, and it is safe for concurrent use.
+type Script struct {
+	sourceName       string
+	targetName       string
+	Once             bool
+	OnChange         bool
+	Template         bool
+	mu               sync.Mutex
+	contents         []byte
+	contentsErr      error
+	evaluateContents func() ([]byte, error)
+	persistentState  PersistentState
+}
+
+// A ScriptRun records a run of a run_once_ or run_onchange_ script.
+type ScriptRun struct {
+	SHA256 string    `json:"sha256" yaml:"sha256"`
+	Name   string    `json:"name" yaml:"name"`
+	RunAt  time.Time `json:"runAt" yaml:"runAt"`
+}
+
+type scriptConcreteValue struct {
+	Type       string `json:"type" yaml:"type"`
+	SourcePath string `json:"sourcePath" yaml:"sourcePath"`
+	TargetPath string `json:"targetPath" yaml:"targetPath"`
+	Once       bool   `json:"once" yaml:"once"`
+	OnChange   bool   `json:"onChange" yaml:"onChange"`
+	Template   bool   `json:"template" yaml:"template"`
+	Contents   string `json:"contents" yaml:"contents"`
+}
+
+// ClearScriptRuns removes all recorded runs of run_once_ and run_onchange_
+// scripts from persistentState, so that they are run again.
+func ClearScriptRuns(persistentState PersistentState) error {
+	for _, bucket := range scriptBuckets {
+		if err := persistentState.DeleteBucket(bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScriptRuns returns the runs of run_once_ and run_onchange_ scripts recorded
+// in persistentState.
+func ScriptRuns(persistentState PersistentState) ([]ScriptRun, error) {
+	var scriptRuns []ScriptRun
+	for _, bucket := range scriptBuckets {
+		if err := persistentState.ForEach(bucket, func(k, v []byte) error {
+			var scriptRun ScriptRun
+			if err := json.Unmarshal(v, &scriptRun); err != nil {
+				return err
+			}
+			scriptRuns = append(scriptRuns, scriptRun)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return scriptRuns, nil
+}
+
+// Apply runs s in the directory in destDir that contains s's target. Scripts
+// whose contents are empty are not run. If s.Once is set then s is only run if
+// a script with the same contents has not already been run. If s.OnChange is
+// set then s is only run if its contents have changed since it was last run.
+// Runs of these scripts are recorded in s's persistent state when a plan
+// containing them is executed with TargetState.ExecutePlan, so applying s
+// directly, for example to compute a plan, never records a run. Scripts run
+// directly by Apply cannot be cancelled; use TargetState.ExecutePlanContext.
+func (s *Script) Apply(fs vfs.FS, destDir string, ignore func(string) bool, umask os.FileMode, mutator Mutator) error {
+	if ignore(s.targetName) {
+		return nil
+	}
+	if run, err := s.needsRun(); err != nil || !run {
+		return err
+	}
+	contents, err := s.Contents()
+	if err != nil {
+		return err
+	}
+	_, err = mutator.RunScript(context.Background(), s.targetName, filepath.Join(destDir, filepath.Dir(s.targetName)), contents)
+	return err
+}
+
+// ConcreteValue implements Entry.ConcreteValue.
+func (s *Script) ConcreteValue(destDir string, ignore func(string) bool, sourceDir string, recursive bool) (interface{}, error) {
+	if ignore(s.targetName) {
+		return nil, nil
+	}
+	contents, err := s.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return &scriptConcreteValue{
+		Type:       "script",
+		SourcePath: filepath.Join(sourceDir, s.SourceName()),
+		TargetPath: filepath.Join(destDir, s.TargetName()),
+		Once:       s.Once,
+		OnChange:   s.OnChange,
+		Template:   s.Template,
+		Contents:   string(contents),
+	}, nil
+}
+
+// Contents returns s's contents.
+func (s *Script) Contents() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.evaluateContents != nil {
+		s.contents, s.contentsErr = s.evaluateContents()
+		s.evaluateContents = nil
+	}
+	return s.contents, s.contentsErr
+}
+
+// Evaluate evaluates s's contents.
+func (s *Script) Evaluate(ignore func(string) bool) error {
+	if ignore(s.targetName) {
+		return nil
+	}
+	_, err := s.Contents()
+	return err
+}
+
+// SourceName implements Entry.SourceName.
+func (s *Script) SourceName() string {
+	return s.sourceName
+}
+
+// TargetName implements Entry.TargetName. Scripts do not create targets, so
+// this is only the name of the script relative to the destination directory.
+func (s *Script) TargetName() string {
+	return s.targetName
+}
+
+// archive writes s to w. Scripts are not part of the target state, so nothing
+// is written.
+func (s *Script) archive(w *TarWriter, ignore func(string) bool, headerTemplate *tar.Header, umask os.FileMode) error {
+	return nil
+}
+
+// applyOrder returns the names of entries, whose sorted names are sortedNames,
+// in the order in which they should be applied: entries in name order, followed
+// by scripts in name order.
+func applyOrder(sortedNames []string, entries map[string]Entry) []string {
+	entryNames := make([]string, 0, len(sortedNames))
+	var scriptNames []string
+	for _, entryName := range sortedNames {
+		if isScript(entries[entryName]) {
+			scriptNames = append(scriptNames, entryName)
+		} else {
+			entryNames = append(entryNames, entryName)
+		}
+	}
+	return append(entryNames, scriptNames...)
+}
+
+// isScript returns true if entry is a Script.
+func isScript(entry Entry) bool {
+	_, ok := entry.(*Script)
+	return ok
+}
+
+// needsRun returns true if s would be run by Apply.
+func (s *Script) needsRun() (bool, error) {
+	contents, err := s.Contents()
+	if err != nil {
+		return false, err
+	}
+	if isEmpty(contents) {
+		return false, nil
+	}
+	bucket, key, scriptRun := s.runRecord(contents)
+	if bucket == nil {
+		return true, nil
+	}
+	if s.persistentState == nil {
+		return false, fmt.Errorf("%s: no persistent state to record script runs", s.targetName)
+	}
+	value, err := s.persistentState.Get(bucket, key)
+	if err != nil {
+		return false, err
+	}
+	if value == nil {
+		return true, nil
+	}
+	var lastScriptRun ScriptRun
+	if err := json.Unmarshal(value, &lastScriptRun); err != nil {
+		return false, fmt.Errorf("%s: %v", s.targetName, err)
+	}
+	return lastScriptRun.SHA256 != scriptRun.SHA256, nil
+}
+
+// runRecord returns the bucket and key in which a run of s with contents is
+// recorded, and the ScriptRun to record. bucket is nil if runs of s are not
+// recorded.
+func (s *Script) runRecord(contents []byte) (bucket, key []byte, scriptRun *ScriptRun) {
+	scriptRun = &ScriptRun{
+		SHA256: hexSHA256(contents),
+		Name:   s.targetName,
+	}
+	switch {
+	case s.Once:
+		bucket = scriptOnceBucket
+		key = []byte(scriptRun.SHA256)
+	case s.OnChange:
+		bucket = scriptOnChangeBucket
+		key = []byte(s.targetName)
+	}
+	return bucket, key, scriptRun
+}
+
+// recordRun records a run of s with contents in s's persistent state, if runs
+// of s are recorded.
+func (s *Script) recordRun(contents []byte) error {
+	bucket, key, scriptRun := s.runRecord(contents)
+	if bucket == nil {
+		return nil
+	}
+	scriptRun.RunAt = time.Now().UTC()
+	value, err := json.Marshal(scriptRun)
+	if err != nil {
+		return err
+	}
+	return s.persistentState.Set(bucket, key, value)
+}
@@ -0,0 +1,208 @@
+package chezmoi
+
+import (
+	"testing"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestScriptApply(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		root    interface{}
+		data    map[string]interface{}
+		dryRun  bool
+		wantErr bool
+		tests   []vfst.Test
+	}{
+		{
+			name: "after_files",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/dot_dir": map[string]interface{}{
+					"file":     "# contents of file\n",
+					"run_copy": "#!/bin/sh\ncp file copy\n",
+				},
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/.dir/copy",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("# contents of file\n"),
+				),
+				vfst.TestPath("/home/user/.dir/run_copy",
+					vfst.TestDoesNotExist,
+				),
+			},
+		},
+		{
+			name: "template",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/run_hello.tmpl": "#!/bin/sh\necho {{ .name }} > hello\n",
+			},
+			data: map[string]interface{}{
+				"name": "world",
+			},
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/hello",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("world\n"),
+				),
+			},
+		},
+		{
+			name: "empty",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/run_empty": " \n",
+			},
+		},
+		{
+			name: "dry_run",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/run_hello": "#!/bin/sh\necho world > hello\n",
+			},
+			dryRun: true,
+			tests: []vfst.Test{
+				vfst.TestPath("/home/user/hello",
+					vfst.TestDoesNotExist,
+				),
+			},
+		},
+		{
+			name: "failure",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/run_fail": "#!/bin/sh\nexit 1\n",
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(tc.root)
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", tc.data, nil)
+			if err := ts.Populate(fs); err != nil {
+				t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+			}
+			var mutator Mutator = NewFSMutator(fs, "/home/user")
+			if tc.dryRun {
+				mutator = NullMutator
+			}
+			err = ts.Apply(fs, mutator)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ts.Apply(%+v, _) == <nil>, want !<nil>", fs)
+				}
+			} else if err != nil {
+				t.Errorf("ts.Apply(%+v, _) == %v, want <nil>", fs, err)
+			}
+			vfst.RunTests(t, fs, "", tc.tests)
+		})
+	}
+}
+
+func TestScriptOnce(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi/run_once_count": "#!/bin/sh\necho run >> count\n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	persistentState := NewBoltPersistentState(fs, "/home/user/.config/chezmoi/chezmoistate.boltdb", false)
+	defer persistentState.Close()
+	applyWith := func(mutator Mutator) {
+		ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+		ts.PersistentState = persistentState
+		if err := ts.Populate(fs); err != nil {
+			t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+		}
+		if err := ts.Apply(fs, mutator); err != nil {
+			t.Fatalf("ts.Apply(%+v, _) == %v, want <nil>", fs, err)
+		}
+	}
+	apply := func() {
+		applyWith(NewFSMutator(fs, "/home/user"))
+	}
+
+	// Verifying, as chezmoi verify does, must not stop the script from being
+	// run by a later apply.
+	applyWith(NewAnyMutator(NullMutator))
+	apply()
+	apply()
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/count",
+			vfst.TestContentsString("run\n"),
+		),
+	)
+
+	// The runs must be persisted.
+	if err := persistentState.Close(); err != nil {
+		t.Fatalf("persistentState.Close() == %v, want <nil>", err)
+	}
+	scriptRuns, err := ScriptRuns(NewBoltPersistentState(fs, "/home/user/.config/chezmoi/chezmoistate.boltdb", true))
+	if err != nil {
+		t.Fatalf("ScriptRuns(_) == _, %v, want _, <nil>", err)
+	}
+	if len(scriptRuns) != 1 || scriptRuns[0].Name != "count" || scriptRuns[0].SHA256 != sha256Hex("#!/bin/sh\necho run >> count\n") {
+		t.Errorf("ScriptRuns(_) == %+v, want one run of count", scriptRuns)
+	}
+
+	if err := ClearScriptRuns(persistentState); err != nil {
+		t.Fatalf("ClearScriptRuns(_) == %v, want <nil>", err)
+	}
+	apply()
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/count",
+			vfst.TestContentsString("run\nrun\n"),
+		),
+	)
+}
+
+func TestScriptOnChange(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi/run_onchange_count.tmpl": "#!/bin/sh\necho {{ .version }} >> count\n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	persistentState := NewMemoryPersistentState()
+	applyWith := func(version int, mutator Mutator) {
+		ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", map[string]interface{}{"version": version}, nil)
+		ts.PersistentState = persistentState
+		if err := ts.Populate(fs); err != nil {
+			t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+		}
+		if err := ts.Apply(fs, mutator); err != nil {
+			t.Fatalf("ts.Apply(%+v, _) == %v, want <nil>", fs, err)
+		}
+	}
+	apply := func(version int) {
+		applyWith(version, NewFSMutator(fs, "/home/user"))
+	}
+
+	// Diffing and verifying, as chezmoi diff and chezmoi verify do, must not
+	// stop changed scripts from being run by a later apply.
+	applyWith(1, NewDryRunMutator())
+	applyWith(1, NewAnyMutator(NullMutator))
+	apply(1)
+	apply(1)
+	applyWith(2, NewDryRunMutator())
+	applyWith(2, NewAnyMutator(NullMutator))
+	apply(2)
+	apply(1)
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/count",
+			vfst.TestContentsString("1\n2\n1\n"),
+		),
+	)
+
+	scriptRuns, err := ScriptRuns(persistentState)
+	if err != nil {
+		t.Fatalf("ScriptRuns(_) == _, %v, want _, <nil>", err)
+	}
+	if len(scriptRuns) != 1 || scriptRuns[0].Name != "count" || scriptRuns[0].SHA256 != sha256Hex("#!/bin/sh\necho 1 >> count\n") {
+		t.Errorf("ScriptRuns(_) == %+v, want last run of count", scriptRuns)
+	}
+}
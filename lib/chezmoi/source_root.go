@@ -0,0 +1,39 @@
+package chezmoi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// rootFileName is the name of the file in the source directory that contains
+// the path, relative to the source directory, of the directory that contains
+// the source state.
+const rootFileName = ".chezmoiroot"
+
+// readSourceRoot updates ts.SourceDir from the .chezmoiroot file in
+// ts.SourceDir, if it exists.
+func (ts *TargetState) readSourceRoot(fs vfs.FS) error {
+	path := filepath.Join(ts.SourceDir, rootFileName)
+	data, err := fs.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	root := filepath.Clean(strings.TrimSpace(string(data)))
+	if filepath.IsAbs(root) || root == ".." || strings.HasPrefix(root, ".."+string(os.PathSeparator)) {
+		return fmt.Errorf("%s: %s: outside source directory", path, root)
+	}
+	sourceDir := filepath.Join(ts.SourceDir, root)
+	if info, err := fs.Stat(sourceDir); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s: %s: not a directory", path, root)
+	}
+	ts.SourceDir = sourceDir
+	return nil
+}
@@ -0,0 +1,53 @@
+package chezmoi
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// errSparseUnsupported is returned by fileDataSegments on platforms that
+// cannot find the holes in files.
+var errSparseUnsupported = errors.New("sparse files not supported")
+
+// A dataSegment is a range of a sparse file that holds data. The ranges
+// between dataSegments are holes, which read as zeros but are not stored.
+type dataSegment struct {
+	offset int64
+	length int64
+}
+
+// copyFileContents copies the contents of src to dst. If src is sparse then
+// only its data is written, leaving holes in dst where src has holes.
+func copyFileContents(dst, src *os.File) error {
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if isSparse(info) {
+		if segments, err := fileDataSegments(src, info.Size()); err == nil {
+			return copySparse(dst, src, segments, info.Size())
+		}
+	}
+	_, err = copyPooled(dst, src)
+	return err
+}
+
+// copySparse copies segments of src to the same offsets in dst, and then
+// extends dst to size, so that the ranges of dst between segments are holes.
+func copySparse(dst, src *os.File, segments []dataSegment, size int64) error {
+	for _, segment := range segments {
+		if _, err := src.Seek(segment.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := dst.Seek(segment.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if n, err := copyPooled(dst, io.LimitReader(src, segment.length)); err != nil {
+			return err
+		} else if n != segment.length {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return dst.Truncate(size)
+}
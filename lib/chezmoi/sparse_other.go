@@ -0,0 +1,13 @@
+// +build !darwin,!freebsd,!linux
+
+package chezmoi
+
+import "os"
+
+func fileDataSegments(f *os.File, size int64) ([]dataSegment, error) {
+	return nil, errSparseUnsupported
+}
+
+func isSparse(info os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,130 @@
+package chezmoi
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestCopyFileContentsSparse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chezmoi-test-sparse")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	size := int64(64 << 20)
+	srcFile, err := os.Create(src)
+	if err != nil {
+		t.Fatalf("os.Create(%q) == _, %v, want _, <nil>", src, err)
+	}
+	defer srcFile.Close()
+	if err := srcFile.Truncate(size); err != nil {
+		t.Fatalf("srcFile.Truncate(%d) == %v, want <nil>", size, err)
+	}
+	for _, offset := range []int64{1 << 20, 32 << 20} {
+		if _, err := srcFile.WriteAt([]byte("# data\n"), offset); err != nil {
+			t.Fatalf("srcFile.WriteAt(_, %d) == _, %v, want _, <nil>", offset, err)
+		}
+	}
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		t.Fatalf("srcFile.Stat() == _, %v, want _, <nil>", err)
+	}
+	// Some filesystems and platforms do not support sparse files.
+	if !isSparse(srcInfo) {
+		t.Skipf("%s is not sparse", src)
+	}
+	segments, err := fileDataSegments(srcFile, size)
+	if err != nil {
+		t.Skipf("fileDataSegments(_, %d) == _, %v", size, err)
+	}
+	// Filesystems allocate whole blocks, so the segments contain the data
+	// but might be larger.
+	if len(segments) != 2 || segments[0].offset > 1<<20 || segments[1].offset > 32<<20 || segments[1].offset+segments[1].length >= size {
+		t.Errorf("fileDataSegments(_, %d) == %+v, want two segments containing the data", size, segments)
+	}
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		t.Fatalf("os.Create(%q) == _, %v, want _, <nil>", dst, err)
+	}
+	defer dstFile.Close()
+	if err := copyFileContents(dstFile, srcFile); err != nil {
+		t.Fatalf("copyFileContents(_, _) == %v, want <nil>", err)
+	}
+	dstInfo, err := dstFile.Stat()
+	if err != nil {
+		t.Fatalf("dstFile.Stat() == _, %v, want _, <nil>", err)
+	}
+	if dstInfo.Size() != size || !isSparse(dstInfo) {
+		t.Errorf("dstFile.Stat() == %+v, want sparse file with size %d", dstInfo, size)
+	}
+	dstSegments, err := fileDataSegments(dstFile, size)
+	if err != nil {
+		t.Fatalf("fileDataSegments(_, %d) == _, %v, want _, <nil>", size, err)
+	}
+	if diff, equal := messagediff.PrettyDiff(segments, dstSegments); !equal {
+		t.Errorf("fileDataSegments(_, %d) == %+v, want %+v, diff:\n%s", size, dstSegments, segments, diff)
+	}
+	srcData, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile(%q) == _, %v, want _, <nil>", src, err)
+	}
+	dstData, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile(%q) == _, %v, want _, <nil>", dst, err)
+	}
+	if !bytes.Equal(dstData, srcData) {
+		t.Errorf("ioutil.ReadFile(%q) != ioutil.ReadFile(%q)", dst, src)
+	}
+}
+
+func TestFileDataSegmentsError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chezmoi-test-sparse")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(_, _) == _, %v, want _, <nil>", err)
+	}
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	srcFile, err := os.Create(src)
+	if err != nil {
+		t.Fatalf("os.Create(%q) == _, %v, want _, <nil>", src, err)
+	}
+	defer srcFile.Close()
+	if err := srcFile.Truncate(16 << 20); err != nil {
+		t.Fatalf("srcFile.Truncate(_) == %v, want <nil>", err)
+	}
+	if _, err := srcFile.WriteAt([]byte("# data\n"), 8<<20); err != nil {
+		t.Fatalf("srcFile.WriteAt(_, _) == _, %v, want _, <nil>", err)
+	}
+	if srcInfo, err := srcFile.Stat(); err != nil || !isSparse(srcInfo) {
+		t.Skipf("%s is not sparse", src)
+	}
+	// A size smaller than the offset of the data, as if the file had been
+	// truncated while it was being read, makes fileDataSegments fail after
+	// it has seeked past the data.
+	if _, err := fileDataSegments(srcFile, 4<<20); err == nil {
+		t.Fatalf("fileDataSegments(_, _) == _, <nil>, want _, !<nil>")
+	}
+	if offset, err := srcFile.Seek(0, io.SeekCurrent); err != nil || offset != 0 {
+		t.Fatalf("srcFile.Seek(0, io.SeekCurrent) == %d, %v, want 0, <nil>", offset, err)
+	}
+	// Falling back to copying the whole file copies all of it.
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		t.Fatalf("os.Create(%q) == _, %v, want _, <nil>", dst, err)
+	}
+	defer dstFile.Close()
+	if _, err := copyPooled(dstFile, srcFile); err != nil {
+		t.Fatalf("copyPooled(_, _) == _, %v, want _, <nil>", err)
+	}
+	if dstInfo, err := dstFile.Stat(); err != nil || dstInfo.Size() != 16<<20 {
+		t.Errorf("dstFile.Stat() == %+v, %v, want size %d, <nil>", dstInfo, err, 16<<20)
+	}
+}
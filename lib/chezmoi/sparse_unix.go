@@ -0,0 +1,56 @@
+// +build darwin freebsd linux
+
+package chezmoi
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// fileDataSegments returns the segments of f, whose size is size, that hold
+// data, using the SEEK_DATA and SEEK_HOLE whences of lseek. It leaves the
+// offset of f at the start of the file, even if it returns an error, so that
+// callers can fall back to reading the whole file.
+func fileDataSegments(f *os.File, size int64) (segments []dataSegment, err error) {
+	defer func() {
+		if _, seekErr := f.Seek(0, io.SeekStart); seekErr != nil && err == nil {
+			segments, err = nil, seekErr
+		}
+	}()
+	seekData, seekHole := 3, 4
+	if runtime.GOOS == "darwin" {
+		seekData, seekHole = 4, 3
+	}
+	for offset := int64(0); offset < size; {
+		start, err := f.Seek(offset, seekData)
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ENXIO {
+			// There is no data after offset.
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		end, err := f.Seek(start, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		if end > size {
+			end = size
+		}
+		if end <= start {
+			// The file was truncated while it was being read.
+			return nil, io.ErrUnexpectedEOF
+		}
+		segments = append(segments, dataSegment{offset: start, length: end - start})
+		offset = end
+	}
+	return segments, nil
+}
+
+// isSparse returns true if the file with info has fewer blocks allocated than
+// its size needs, which means that it has holes.
+func isSparse(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && info.Mode().IsRegular() && int64(stat.Blocks)*512 < info.Size()
+}
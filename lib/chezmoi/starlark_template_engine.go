@@ -0,0 +1,329 @@
+package chezmoi
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"text/template"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+type starlarkTemplateEngine struct{}
+
+// StarlarkTemplateEngine is a TemplateEngine that executes source files as
+// Starlark programs. The template data is available as the global dict data,
+// template functions are available as builtins, and the output is everything
+// written with print.
+var StarlarkTemplateEngine starlarkTemplateEngine
+
+// starlarkResolveMu serializes the resolution of Starlark programs by
+// compileStarlarkProgram.
+var starlarkResolveMu sync.Mutex
+
+// Execute implements TemplateEngine.Execute.
+func (starlarkTemplateEngine) Execute(name string, source []byte, funcs template.FuncMap, data interface{}) ([]byte, error) {
+	predeclared := make(starlark.StringDict)
+	for funcName, fn := range funcs {
+		builtin, err := newStarlarkBuiltin(funcName, fn)
+		if err != nil {
+			return nil, err
+		}
+		predeclared[funcName] = builtin
+	}
+	starlarkData, err := toStarlarkValue(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	predeclared["data"] = starlarkData
+	output := getBuffer()
+	defer putBuffer(output)
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			output.WriteString(msg)
+			output.WriteByte('\n')
+		},
+	}
+	program, err := compileStarlarkProgram(name, source, predeclared.Has)
+	if err != nil {
+		return nil, newStarlarkTemplateError(name, err)
+	}
+	if _, err := program.Init(thread, predeclared); err != nil {
+		return nil, newStarlarkTemplateError(name, err)
+	}
+	return append([]byte(nil), output.Bytes()...), nil
+}
+
+// compileStarlarkProgram parses, resolves, and compiles the Starlark program
+// name with source. Templates are written at the top level, so control flow is
+// allowed outside functions, and the language features that users expect are
+// enabled. The version of go.starlark.net in use has no per-file options, only
+// the global flags of the resolve package, which are only read while a program
+// is resolved, so they are set only while source is resolved and then
+// restored, leaving the semantics of other users of go.starlark.net unchanged.
+func compileStarlarkProgram(name string, source []byte, isPredeclared func(string) bool) (*starlark.Program, error) {
+	starlarkResolveMu.Lock()
+	defer starlarkResolveMu.Unlock()
+	flags := []*bool{
+		&resolve.AllowFloat,
+		&resolve.AllowGlobalReassign,
+		&resolve.AllowLambda,
+		&resolve.AllowNestedDef,
+		&resolve.AllowSet,
+	}
+	saved := make([]bool, len(flags))
+	for i, flag := range flags {
+		saved[i] = *flag
+		*flag = true
+	}
+	defer func() {
+		for i, flag := range flags {
+			*flag = saved[i]
+		}
+	}()
+	_, program, err := starlark.SourceProgram(name, source, isPredeclared)
+	return program, err
+}
+
+// fromStarlarkValue converts v to a Go value.
+func fromStarlarkValue(v starlark.Value) interface{} {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil
+	case starlark.Bool:
+		return bool(v)
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i
+		}
+		return v.String()
+	case starlark.Float:
+		return float64(v)
+	case starlark.String:
+		return string(v)
+	case *starlark.List:
+		result := make([]interface{}, v.Len())
+		for i := range result {
+			result[i] = fromStarlarkValue(v.Index(i))
+		}
+		return result
+	case starlark.Tuple:
+		result := make([]interface{}, len(v))
+		for i, elem := range v {
+			result[i] = fromStarlarkValue(elem)
+		}
+		return result
+	case *starlark.Dict:
+		result := make(map[string]interface{})
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				key = item[0].String()
+			}
+			result[key] = fromStarlarkValue(item[1])
+		}
+		return result
+	default:
+		return v.String()
+	}
+}
+
+// newStarlarkTemplateError returns a TemplateError, or a MultiError of
+// TemplateErrors, with the location in name of err, an error returned by
+// starlark.ExecFile.
+func newStarlarkTemplateError(name string, err error) error {
+	newTemplateError := func(pos syntax.Position, msg string) *TemplateError {
+		return &TemplateError{
+			Name:   name,
+			Line:   int(pos.Line),
+			Column: int(pos.Col),
+			Err:    errors.New(msg),
+		}
+	}
+	switch err := err.(type) {
+	case *starlark.EvalError:
+		// Report the innermost call in name, not in a builtin.
+		for _, frame := range err.Stack() {
+			if pos := frame.Position(); pos.Filename() == name {
+				return newTemplateError(pos, err.Msg)
+			}
+		}
+		return &TemplateError{
+			Name: name,
+			Err:  errors.New(err.Msg),
+		}
+	case resolve.ErrorList:
+		var errs MultiError
+		for _, e := range err {
+			errs = append(errs, newTemplateError(e.Pos, e.Msg))
+		}
+		return errs
+	case syntax.Error:
+		return newTemplateError(err.Pos, err.Msg)
+	default:
+		return err
+	}
+}
+
+// newStarlarkBuiltin returns a Starlark builtin that calls the Go function fn.
+// Arguments and return values are converted with fromStarlarkValue and
+// toStarlarkValue.
+func newStarlarkBuiltin(name string, fn interface{}) (*starlark.Builtin, error) {
+	fnValue := reflect.ValueOf(fn)
+	if fnValue.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%s: not a function", name)
+	}
+	fnType := fnValue.Type()
+	return starlark.NewBuiltin(name, func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (_ starlark.Value, err error) {
+		// Return errors from ReturnTemplateFuncError to Starlark so that they
+		// are reported at the position of the call.
+		defer func() {
+			if r := recover(); r != nil {
+				if tfe, ok := r.(templateFuncError); ok {
+					err = tfe.err
+				} else {
+					panic(r)
+				}
+			}
+		}()
+		if len(kwargs) != 0 {
+			return nil, fmt.Errorf("%s: keyword arguments are not supported", name)
+		}
+		numIn := fnType.NumIn()
+		switch {
+		case fnType.IsVariadic() && len(args) < numIn-1:
+			return nil, fmt.Errorf("%s: want at least %d arguments, got %d", name, numIn-1, len(args))
+		case !fnType.IsVariadic() && len(args) != numIn:
+			return nil, fmt.Errorf("%s: want %d arguments, got %d", name, numIn, len(args))
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var argType reflect.Type
+			if fnType.IsVariadic() && i >= numIn-1 {
+				argType = fnType.In(numIn - 1).Elem()
+			} else {
+				argType = fnType.In(i)
+			}
+			argValue, err := toReflectValue(fromStarlarkValue(arg), argType)
+			if err != nil {
+				return nil, fmt.Errorf("%s: argument %d: %v", name, i+1, err)
+			}
+			in[i] = argValue
+		}
+		out := fnValue.Call(in)
+		if len(out) == 0 {
+			return starlark.None, nil
+		}
+		if len(out) == 2 {
+			if err, ok := out[1].Interface().(error); ok && err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		return toStarlarkValue(out[0].Interface())
+	}), nil
+}
+
+// toReflectValue converts v to a reflect.Value of type t.
+func toReflectValue(v interface{}, t reflect.Type) (reflect.Value, error) {
+	if v == nil {
+		return reflect.Zero(t), nil
+	}
+	value := reflect.ValueOf(v)
+	switch {
+	case value.Type().AssignableTo(t):
+		return value, nil
+	case isNumberKind(value.Kind()) && isNumberKind(t.Kind()):
+		return value.Convert(t), nil
+	case value.Kind() == reflect.String && t.Kind() == reflect.String:
+		return value.Convert(t), nil
+	case value.Kind() == reflect.Slice && t.Kind() == reflect.Slice:
+		result := reflect.MakeSlice(t, value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			elem, err := toReflectValue(value.Index(i).Interface(), t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			result.Index(i).Set(elem)
+		}
+		return result, nil
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot convert %T to %s", v, t)
+	}
+}
+
+// toStarlarkValue converts the Go value v to a Starlark value.
+func toStarlarkValue(v interface{}) (starlark.Value, error) {
+	if v == nil {
+		return starlark.None, nil
+	}
+	if b, ok := v.([]byte); ok {
+		return starlark.String(b), nil
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Bool:
+		return starlark.Bool(value.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return starlark.MakeInt64(value.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return starlark.MakeUint64(value.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return starlark.Float(value.Float()), nil
+	case reflect.String:
+		return starlark.String(value.String()), nil
+	case reflect.Array, reflect.Slice:
+		elems := make([]starlark.Value, value.Len())
+		for i := range elems {
+			elem, err := toStarlarkValue(value.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = elem
+		}
+		return starlark.NewList(elems), nil
+	case reflect.Map:
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		dict := &starlark.Dict{}
+		for _, key := range keys {
+			starlarkKey, err := toStarlarkValue(key.Interface())
+			if err != nil {
+				return nil, err
+			}
+			starlarkValue, err := toStarlarkValue(value.MapIndex(key).Interface())
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlarkKey, starlarkValue); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	case reflect.Interface, reflect.Ptr:
+		if value.IsNil() {
+			return starlark.None, nil
+		}
+		return toStarlarkValue(value.Elem().Interface())
+	default:
+		return nil, fmt.Errorf("%T: unsupported type", v)
+	}
+}
+
+// isNumberKind returns true if k is a numeric kind.
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
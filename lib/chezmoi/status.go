@@ -0,0 +1,110 @@
+package chezmoi
+
+import (
+	"path/filepath"
+	"sort"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// Status codes. Each TargetStatus has two, like git status. The first
+// describes how the target has changed since chezmoi last wrote it, and the
+// second how applying the target state would change it.
+const (
+	StatusUnchanged = ' '
+	StatusAdded     = 'A'
+	StatusDeleted   = 'D'
+	StatusModified  = 'M'
+	StatusRun       = 'R' // The script would be run.
+)
+
+// A TargetStatus is the status of a single target.
+type TargetStatus struct {
+	TargetName string `json:"targetName" yaml:"targetName"`
+	Code       string `json:"code" yaml:"code"`
+}
+
+// Status returns the status of every target in ts that has changed since it
+// was last written or that would be changed by applying ts, sorted by target
+// name, without modifying fs. Targets that have never been written by chezmoi
+// are compared with an absent target, so existing targets are reported as
+// added. Targets that would be removed by ts.TargetRemove are also included.
+func (ts *TargetState) Status(fs vfs.FS) ([]*TargetStatus, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	removeMutator := NewDryRunMutator()
+	if err := ts.applyRemove(fs, removeMutator); err != nil {
+		return nil, err
+	}
+	removed := make(map[string]bool)
+	for _, o := range removeMutator.Operations {
+		targetName, err := filepath.Rel(ts.DestDir, o.Name)
+		if err != nil {
+			return nil, err
+		}
+		removed[targetName] = true
+	}
+
+	var statuses []*TargetStatus
+	addStatus := func(targetName string, x, y byte) {
+		if x != StatusUnchanged || y != StatusUnchanged {
+			statuses = append(statuses, &TargetStatus{
+				TargetName: targetName,
+				Code:       string([]byte{x, y}),
+			})
+		}
+	}
+	if err := walkSortedEntries(ts.Entries, ts.nameCache.sortedNames(ts.Entries), func(entry Entry) error {
+		targetName := entry.TargetName()
+		if ts.TargetIgnore.Match(targetName) {
+			return nil
+		}
+		if script, ok := entry.(*Script); ok {
+			run, err := script.needsRun()
+			if err != nil {
+				return err
+			}
+			if run {
+				addStatus(targetName, StatusUnchanged, StatusRun)
+			}
+			return nil
+		}
+		lastState, err := ts.lastEntryState(targetName)
+		if err != nil {
+			return err
+		}
+		actualState, err := actualEntryState(fs, filepath.Join(ts.DestDir, targetName), lastState, ts.ContentHashCache)
+		if err != nil {
+			return err
+		}
+		targetState, err := ts.targetEntryState(fs, entry)
+		if err != nil {
+			return err
+		}
+		addStatus(targetName, statusCode(lastState, actualState), statusCode(actualState, targetState))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for targetName := range removed {
+		addStatus(targetName, StatusUnchanged, StatusDeleted)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].TargetName < statuses[j].TargetName
+	})
+	return statuses, nil
+}
+
+// statusCode returns the status code of a change from state from to state to.
+func statusCode(from, to *EntryState) byte {
+	switch {
+	case from.Equal(to):
+		return StatusUnchanged
+	case from == nil:
+		return StatusAdded
+	case to == nil:
+		return StatusDeleted
+	default:
+		return StatusModified
+	}
+}
@@ -0,0 +1,80 @@
+package chezmoi
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateStatus(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".chezmoi": map[string]interface{}{
+				"dot_bashrc":         "# contents of .bashrc\n",
+				"dot_profile":        "# contents of .profile\n",
+				"dot_zshrc":          "# contents of .zshrc\n",
+				"symlink_dot_link":   ".bashrc",
+				"run_once_hello":     "#!/bin/sh\necho hello\n",
+				"run_once_goodbye":   "#!/bin/sh\necho goodbye\n",
+				".chezmoiignore":     "goodbye\n",
+				"dot_dir/file":       "# contents of file\n",
+				"dot_unwritten":      "# contents of .unwritten\n",
+				"dot_unwritten_same": "# same\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	ts.PersistentState = NewMemoryPersistentState()
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	var entries []Entry
+	for _, targetName := range []string{".bashrc", ".profile", ".zshrc", ".link", ".dir"} {
+		entry, err := ts.Get("/home/user/" + targetName)
+		if err != nil {
+			t.Fatalf("ts.Get(%q) == _, %v, want _, <nil>", targetName, err)
+		}
+		if err := entry.Apply(fs, ts.DestDir, ts.TargetIgnore.Match, ts.Umask, NewFSMutator(fs, ts.DestDir)); err != nil {
+			t.Fatalf("entry.Apply(...) == %v, want <nil>", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := ts.SaveEntryStates(fs, entries); err != nil {
+		t.Fatalf("ts.SaveEntryStates(%+v, _) == %v, want <nil>", fs, err)
+	}
+
+	// Change the targets behind chezmoi's back.
+	if err := fs.WriteFile("/home/user/.bashrc", []byte("# local change\n"), 0644); err != nil {
+		t.Fatalf("fs.WriteFile(...) == %v, want <nil>", err)
+	}
+	if err := fs.Chmod("/home/user/.profile", 0600); err != nil {
+		t.Fatalf("fs.Chmod(...) == %v, want <nil>", err)
+	}
+	if err := fs.Remove("/home/user/.link"); err != nil {
+		t.Fatalf("fs.Remove(...) == %v, want <nil>", err)
+	}
+	if err := fs.WriteFile("/home/user/.unwritten_same", []byte("# same\n"), 0644); err != nil {
+		t.Fatalf("fs.WriteFile(...) == %v, want <nil>", err)
+	}
+
+	got, err := ts.Status(fs)
+	if err != nil {
+		t.Fatalf("ts.Status(%+v) == _, %v, want _, <nil>", fs, err)
+	}
+	want := []*TargetStatus{
+		{TargetName: ".bashrc", Code: "MM"},
+		{TargetName: ".link", Code: "DA"},
+		{TargetName: ".profile", Code: "MM"},
+		{TargetName: ".unwritten", Code: " A"},
+		{TargetName: ".unwritten_same", Code: "A "},
+		{TargetName: "hello", Code: " R"},
+	}
+	if diff, equal := messagediff.PrettyDiff(want, got); !equal {
+		t.Errorf("ts.Status(%+v) diff:\n%s", fs, diff)
+	}
+}
@@ -0,0 +1,89 @@
+package chezmoi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Summary summarizes the changes made, or that would be made, by a sequence
+// of operations.
+type Summary struct {
+	FilesAdded      int    `json:"filesAdded" yaml:"filesAdded"`
+	FilesModified   int    `json:"filesModified" yaml:"filesModified"`
+	FilesRemoved    int    `json:"filesRemoved" yaml:"filesRemoved"`
+	SymlinksWritten int    `json:"symlinksWritten" yaml:"symlinksWritten"`
+	DirsCreated     int    `json:"dirsCreated" yaml:"dirsCreated"`
+	ScriptsRun      int    `json:"scriptsRun" yaml:"scriptsRun"`
+	BytesWritten    uint64 `json:"bytesWritten" yaml:"bytesWritten"`
+}
+
+// NewSummary returns a new Summary of operations. Changes of permissions and
+// renames count as modifications, and removals of directories count as single
+// removals.
+func NewSummary(operations []Operation) *Summary {
+	s := &Summary{}
+	for _, o := range operations {
+		switch o.Type {
+		case OperationChmod, OperationRename:
+			s.FilesModified++
+		case OperationCreate:
+			s.FilesAdded++
+			s.BytesWritten += uint64(len(o.Data)) + uint64(o.Size)
+		case OperationMkdir:
+			s.DirsCreated++
+		case OperationOverwrite:
+			s.FilesModified++
+			s.BytesWritten += uint64(len(o.Data)) + uint64(o.Size)
+		case OperationRemove:
+			s.FilesRemoved++
+		case OperationRunScript:
+			s.ScriptsRun++
+		case OperationSymlink:
+			s.SymlinksWritten++
+		}
+	}
+	return s
+}
+
+// Summary returns a Summary of the operations recorded by m.
+func (m *RecordingMutator) Summary() *Summary {
+	return NewSummary(m.Operations)
+}
+
+// Empty returns true if s contains no changes.
+func (s *Summary) Empty() bool {
+	return *s == Summary{}
+}
+
+// String returns a one-line description of s, omitting zero counts.
+func (s *Summary) String() string {
+	if s.Empty() {
+		return "no changes"
+	}
+	var parts []string
+	for _, c := range []struct {
+		n                int
+		singular, plural string
+	}{
+		{s.FilesAdded, "file added", "files added"},
+		{s.FilesModified, "file modified", "files modified"},
+		{s.FilesRemoved, "file removed", "files removed"},
+		{s.SymlinksWritten, "symlink written", "symlinks written"},
+		{s.DirsCreated, "directory created", "directories created"},
+		{s.ScriptsRun, "script run", "scripts run"},
+	} {
+		switch c.n {
+		case 0:
+		case 1:
+			parts = append(parts, "1 "+c.singular)
+		default:
+			parts = append(parts, fmt.Sprintf("%d %s", c.n, c.plural))
+		}
+	}
+	if s.BytesWritten == 1 {
+		parts = append(parts, "1 byte written")
+	} else if s.BytesWritten != 0 {
+		parts = append(parts, fmt.Sprintf("%d bytes written", s.BytesWritten))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,38 @@
+package chezmoi
+
+import "testing"
+
+func TestSummaryString(t *testing.T) {
+	for _, tc := range []struct {
+		s    *Summary
+		want string
+	}{
+		{
+			s:    &Summary{},
+			want: "no changes",
+		},
+		{
+			s: &Summary{
+				FilesAdded:   1,
+				BytesWritten: 1,
+			},
+			want: "1 file added, 1 byte written",
+		},
+		{
+			s: &Summary{
+				FilesAdded:      2,
+				FilesModified:   3,
+				FilesRemoved:    4,
+				SymlinksWritten: 5,
+				DirsCreated:     6,
+				ScriptsRun:      7,
+				BytesWritten:    8,
+			},
+			want: "2 files added, 3 files modified, 4 files removed, 5 symlinks written, 6 directories created, 7 scripts run, 8 bytes written",
+		},
+	} {
+		if got := tc.s.String(); got != tc.want {
+			t.Errorf("%+v.String() == %q, want %q", tc.s, got, tc.want)
+		}
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"archive/tar"
 	"os"
 	"path/filepath"
+	"sync"
 
 	vfs "github.com/twpayne/go-vfs"
 )
 
-// A Symlink represents the target state of a symlink.
+// A Symlink represents the target state of a symlink. Its link name is
+// evaluated at most once, and it is safe for concurrent use.
 type Symlink struct {
 	sourceName       string
 	targetName       string
 	Template         bool
+	mu               sync.Mutex
 	linkname         string
 	linknameErr      error
 	evaluateLinkname func() (string, error)
@@ -46,8 +49,14 @@ func (s *Symlink) Apply(fs vfs.FS, destDir string, ignore func(string) bool, uma
 		if currentTarget == target {
 			return nil
 		}
+	case err == nil && info.IsDir():
+		// A symlink cannot atomically replace a directory, so remove it
+		// first.
+		if err := mutator.RemoveAll(targetPath); err != nil {
+			return err
+		}
 	case err == nil:
-	case os.IsNotExist(err):
+	case isNotExist(err):
 	default:
 		return err
 	}
@@ -83,6 +92,8 @@ func (s *Symlink) Evaluate(ignore func(string) bool) error {
 
 // Linkname returns s's link name.
 func (s *Symlink) Linkname() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.evaluateLinkname != nil {
 		s.linkname, s.linknameErr = s.evaluateLinkname()
 		s.evaluateLinkname = nil
@@ -101,7 +112,7 @@ func (s *Symlink) TargetName() string {
 }
 
 // archive writes s to w.
-func (s *Symlink) archive(w *tar.Writer, ignore func(string) bool, headerTemplate *tar.Header, umask os.FileMode) error {
+func (s *Symlink) archive(w *TarWriter, ignore func(string) bool, headerTemplate *tar.Header, umask os.FileMode) error {
 	if ignore(s.targetName) {
 		return nil
 	}
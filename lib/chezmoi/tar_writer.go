@@ -0,0 +1,202 @@
+package chezmoi
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Typeflags of GNU tar entries that tar.Writer cannot write.
+const (
+	tarTypeGNULongName = 'L'
+	tarTypeGNUSparse   = 'S'
+)
+
+// tarBlockSize is the size of the blocks that tar archives are made of.
+const tarBlockSize = 512
+
+// Numbers of sparse map entries in a GNU sparse header and in each of the
+// extended headers that follow it.
+const (
+	tarSparseEntries         = 4
+	tarExtendedSparseEntries = 21
+)
+
+// A TarWriter is a tar.Writer that can also write sparse files, as GNU sparse
+// entries, so that their holes are not written as zeros. tar.Writer cannot
+// write sparse files itself.
+type TarWriter struct {
+	*tar.Writer
+	w io.Writer
+}
+
+// NewTarWriter returns a new TarWriter that writes to w.
+func NewTarWriter(w io.Writer) *TarWriter {
+	return &TarWriter{
+		Writer: tar.NewWriter(w),
+		w:      w,
+	}
+}
+
+// writeSparseFile writes an entry with header, whose Size is the size of the
+// file including its holes, containing the segments of r that hold data.
+func (w *TarWriter) writeSparseFile(header *tar.Header, r io.ReadSeeker, segments []dataSegment) error {
+	// Flush the previous entry, which pads it to a whole number of blocks, so
+	// that this entry can be written directly to the underlying writer.
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// GNU tar ends the sparse map with an empty segment at the end of the
+	// file if the file ends with a hole, so that the hole is restored.
+	sparseMap := append([]dataSegment(nil), segments...)
+	dataSize := int64(0)
+	for _, segment := range segments {
+		dataSize += segment.length
+	}
+	if n := len(segments); n == 0 || segments[n-1].offset+segments[n-1].length < header.Size {
+		sparseMap = append(sparseMap, dataSegment{offset: header.Size})
+	}
+
+	name := header.Name
+	if len(name) > 100 {
+		longNameHeader := &tar.Header{
+			Name:    "././@LongLink",
+			ModTime: header.ModTime,
+		}
+		data := name + "\x00"
+		if err := w.writeBlocks(gnuTarHeaderBlock(longNameHeader, tarTypeGNULongName, int64(len(data)))); err != nil {
+			return err
+		}
+		if err := w.writeBlocks(padTarBlock([]byte(data))); err != nil {
+			return err
+		}
+		name = name[:100]
+	}
+
+	sparseHeader := *header
+	sparseHeader.Name = name
+	block := gnuTarHeaderBlock(&sparseHeader, tarTypeGNUSparse, dataSize)
+	entries := sparseMap
+	if len(entries) > tarSparseEntries {
+		entries = entries[:tarSparseEntries]
+	}
+	formatTarSparseEntries(block[386:482], entries)
+	formatTarNumber(block[483:495], header.Size)
+	blocks := [][]byte{block}
+	for rest := sparseMap[len(entries):]; len(rest) > 0; rest = rest[len(entries):] {
+		// Mark the previous block as being followed by an extended header.
+		if len(blocks) == 1 {
+			blocks[0][482] = 1
+		} else {
+			blocks[len(blocks)-1][504] = 1
+		}
+		entries = rest
+		if len(entries) > tarExtendedSparseEntries {
+			entries = entries[:tarExtendedSparseEntries]
+		}
+		extendedBlock := make([]byte, tarBlockSize)
+		formatTarSparseEntries(extendedBlock[:504], entries)
+		blocks = append(blocks, extendedBlock)
+	}
+	setTarChecksum(blocks[0])
+	if err := w.writeBlocks(blocks...); err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if _, err := r.Seek(segment.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if n, err := copyPooled(w.w, io.LimitReader(r, segment.length)); err != nil {
+			return err
+		} else if n != segment.length {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	if remainder := dataSize % tarBlockSize; remainder != 0 {
+		if _, err := w.w.Write(make([]byte, tarBlockSize-remainder)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBlocks writes blocks directly to the underlying writer.
+func (w *TarWriter) writeBlocks(blocks ...[]byte) error {
+	for _, block := range blocks {
+		if _, err := w.w.Write(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatTarNumber formats n into field as a NUL-terminated octal number, or,
+// if n is too large for that, as a base-256 number, as GNU tar does.
+func formatTarNumber(field []byte, n int64) {
+	if s := strconv.FormatInt(n, 8); n >= 0 && len(s) < len(field) {
+		copy(field, strings.Repeat("0", len(field)-1-len(s))+s)
+		field[len(field)-1] = 0
+		return
+	}
+	for i := len(field) - 1; i >= 0; i-- {
+		field[i] = byte(n)
+		n >>= 8
+	}
+	field[0] |= 0x80
+}
+
+// formatTarSparseEntries formats entries into field as the offset and length
+// of each.
+func formatTarSparseEntries(field []byte, entries []dataSegment) {
+	for i, entry := range entries {
+		formatTarNumber(field[24*i:24*i+12], entry.offset)
+		formatTarNumber(field[24*i+12:24*i+24], entry.length)
+	}
+}
+
+// gnuTarHeaderBlock returns a GNU tar header block for an entry with header
+// and typeflag with size bytes of data. Its checksum is set.
+func gnuTarHeaderBlock(header *tar.Header, typeflag byte, size int64) []byte {
+	block := make([]byte, tarBlockSize)
+	copy(block[0:100], header.Name)
+	formatTarNumber(block[100:108], header.Mode)
+	formatTarNumber(block[108:116], int64(header.Uid))
+	formatTarNumber(block[116:124], int64(header.Gid))
+	formatTarNumber(block[124:136], size)
+	formatTarNumber(block[136:148], header.ModTime.Unix())
+	block[156] = typeflag
+	copy(block[257:265], "ustar  \x00")
+	copy(block[265:297], header.Uname)
+	copy(block[297:329], header.Gname)
+	if !header.AccessTime.IsZero() {
+		formatTarNumber(block[345:357], header.AccessTime.Unix())
+	}
+	if !header.ChangeTime.IsZero() {
+		formatTarNumber(block[357:369], header.ChangeTime.Unix())
+	}
+	setTarChecksum(block)
+	return block
+}
+
+// padTarBlock returns data padded with zeros to a whole number of blocks.
+func padTarBlock(data []byte) []byte {
+	if remainder := len(data) % tarBlockSize; remainder != 0 {
+		data = append(data, make([]byte, tarBlockSize-remainder)...)
+	}
+	return data
+}
+
+// setTarChecksum sets the checksum of the header block, which is the sum of
+// its bytes with the checksum field itself counted as spaces.
+func setTarChecksum(block []byte) {
+	copy(block[148:156], "        ")
+	sum := 0
+	for _, b := range block {
+		sum += int(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+}
@@ -0,0 +1,107 @@
+package chezmoi
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestTarWriterSparseFile(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		size     int64
+		segments []dataSegment
+	}{
+		{
+			name: "empty",
+			size: 1 << 20,
+		},
+		{
+			name: "hole_at_end",
+			size: 1 << 20,
+			segments: []dataSegment{
+				{offset: 0, length: 1000},
+				{offset: 64 << 10, length: 4096},
+			},
+		},
+		{
+			name: "data_at_end",
+			size: 1 << 20,
+			segments: []dataSegment{
+				{offset: 4096, length: 1},
+				{offset: 1<<20 - 10, length: 10},
+			},
+		},
+		{
+			// Too many segments for the sparse map in the header and in
+			// the first extended header.
+			name: "extended_headers",
+			size: 1 << 20,
+			segments: func() []dataSegment {
+				var segments []dataSegment
+				for i := int64(0); i < 30; i++ {
+					segments = append(segments, dataSegment{offset: i * 8192, length: 100 + i})
+				}
+				return segments
+			}(),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			contents := make([]byte, tc.size)
+			for i, segment := range tc.segments {
+				for j := segment.offset; j < segment.offset+segment.length; j++ {
+					contents[j] = byte('a' + i)
+				}
+			}
+			longName := strings.Repeat("dir/", 30) + "sparse"
+			b := &bytes.Buffer{}
+			w := NewTarWriter(b)
+			for _, name := range []string{"sparse", longName} {
+				if err := w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "before", Mode: 0644, Size: 3}); err != nil {
+					t.Fatalf("w.WriteHeader(_) == %v, want <nil>", err)
+				}
+				if _, err := w.Write([]byte("abc")); err != nil {
+					t.Fatalf("w.Write(_) == _, %v, want _, <nil>", err)
+				}
+				header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: tc.size}
+				if err := w.writeSparseFile(header, bytes.NewReader(contents), tc.segments); err != nil {
+					t.Fatalf("w.writeSparseFile(_, _, _) == %v, want <nil>", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("w.Close() == %v, want <nil>", err)
+			}
+			// The holes are not written to the archive.
+			if int64(b.Len()) >= tc.size {
+				t.Errorf("archive size == %d, want < %d", b.Len(), tc.size)
+			}
+
+			r := tar.NewReader(b)
+			for _, name := range []string{"sparse", longName} {
+				if header, err := r.Next(); err != nil || header.Name != "before" {
+					t.Fatalf("r.Next() == %+v, %v, want {Name: %q ...}, <nil>", header, err, "before")
+				}
+				header, err := r.Next()
+				if err != nil {
+					t.Fatalf("r.Next() == _, %v, want _, <nil>", err)
+				}
+				if header.Name != name || header.Size != tc.size || header.Mode != 0644 {
+					t.Errorf("r.Next() == %+v, want {Name: %q, Mode: 0644, Size: %d ...}", header, name, tc.size)
+				}
+				data, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatalf("ioutil.ReadAll(r) == _, %v, want _, <nil>", err)
+				}
+				if !bytes.Equal(data, contents) {
+					t.Errorf("%s: contents differ", name)
+				}
+			}
+			if _, err := r.Next(); err != io.EOF {
+				t.Errorf("r.Next() == _, %v, want _, %v", err, io.EOF)
+			}
+		})
+	}
+}
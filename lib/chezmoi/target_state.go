@@ -4,23 +4,38 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
+	"github.com/Masterminds/sprig"
+	"github.com/coreos/go-semver/semver"
 	vfs "github.com/twpayne/go-vfs"
 )
 
+// maxIncludeDepth is the maximum depth of nested includeTemplate calls, which
+// stops templates that include themselves.
+const maxIncludeDepth = 32
+
+// templatesDirName is the name of the directory in the source directory that
+// contains templates that can be used by all other templates.
+const templatesDirName = ".chezmoitemplates"
+
 // An AddOptions contains options for TargetState.Add.
 type AddOptions struct {
 	Empty    bool
+	Encrypt  bool
 	Exact    bool
 	Template bool
 }
@@ -33,14 +48,110 @@ type ImportTAROptions struct {
 }
 
 // A TargetState represents the root target state.
+//
+// The methods of a TargetState are safe for concurrent use. Methods that
+// change it, namely Add, AddTemplateFunc, ImportPaths, ImportStow, ImportTAR,
+// Move, and Populate, wait for all other methods to return, and all other
+// methods, like Diff, Status, and Verify, may run concurrently with each other,
+// so, for example, a long-running process can populate a TargetState once and
+// then serve many requests with it. Entries, and the other exported fields,
+// must not be accessed directly while a method that changes the TargetState
+// might be running, and must not be changed concurrently with any method.
 type TargetState struct {
-	DestDir       string
-	TargetIgnore  PatternSet
-	Umask         os.FileMode
-	SourceDir     string
-	Data          map[string]interface{}
-	TemplateFuncs template.FuncMap
-	Entries       map[string]Entry
+	DestDir      string
+	TargetIgnore PatternSet
+	TargetRemove PatternSet
+	Umask        os.FileMode
+	SourceDir    string
+	Data         map[string]interface{}
+	// DataProvenance, if not nil, records the source of each value in Data.
+	// The caller records the sources of the values that it puts in Data,
+	// and Populate records the sources of the values that it adds.
+	DataProvenance DataProvenance
+	TemplateFuncs  template.FuncMap
+	// Decryptor decrypts the contents of encrypted files. If it is an
+	// Encryption then its DecryptFile method is used.
+	Decryptor Decryptor
+	// Encryptor encrypts the contents of files added with
+	// AddOptions.Encrypt, and of encrypted files that are updated.
+	Encryptor Encryptor
+	// Encryptions are the encryption tools that .chezmoiencryption files can
+	// select by name, overriding Decryptor and Encryptor for the encrypted
+	// files in their directories.
+	Encryptions map[string]Encryption
+	// Version is the running version, which is checked against the minimum
+	// version in .chezmoiversion. If it is nil then no check is made.
+	Version *semver.Version
+	// Templates are the templates in the .chezmoitemplates directory,
+	// keyed by their slash-separated paths relative to it.
+	Templates map[string][]byte
+	// TemplateEngine executes templates whose source names do not select a
+	// template engine. If it is nil then TextTemplateEngine is used.
+	// .chezmoiignore and .chezmoiremove files always use TextTemplateEngine.
+	TemplateEngine TemplateEngine
+	// Secrets, if not nil, tracks the secrets returned by template
+	// functions. The results of template functions called with arguments
+	// that contain secrets are added to it, so that values derived from
+	// secrets, for example with b64enc, are also secrets, in every template
+	// engine.
+	Secrets SecretTracker
+	// PersistentState records the runs of run_once_ and run_onchange_
+	// scripts. It is used by the scripts created by Populate, so it must be
+	// set before Populate is called. These scripts cannot be applied without
+	// it.
+	PersistentState PersistentState
+	// ContentHashCache, if not nil, caches the hashes of the contents of
+	// targets, so that unchanged targets are not read to compare them with
+	// their target states. Like PersistentState, it must be set before
+	// Populate is called.
+	ContentHashCache *ContentHashCache
+	// TemplateOutputCache, if not nil, caches the outputs of the templates
+	// of files, so that templates whose source files and template data have
+	// not changed are not read or executed again. Templates that call
+	// functions that depend on anything else, and encrypted templates, are
+	// never cached. It is not used when Tracer is not nil.
+	TemplateOutputCache *TemplateOutputCache
+	// MaxMemorySize, if positive, is the size of the largest plain file
+	// whose contents are held in memory. The contents of larger files are
+	// compared with their targets by size and hash, or in chunks, and are
+	// copied with Mutator.CopyFile, and their diffs only show that they
+	// differ. Like PersistentState, it must be set before Populate is
+	// called.
+	MaxMemorySize int64
+	// EntryTypeFilter, if not nil, restricts the operations in plans to
+	// those that change targets of the included types.
+	EntryTypeFilter *EntryTypeFilter
+	// KeepGoing, if true, makes plans and their execution continue after
+	// errors, so that everything that can be applied is applied. The errors
+	// are returned together as a MultiError of *TargetErrors.
+	KeepGoing bool
+	// Parallelism is the maximum number of operations that are executed
+	// concurrently when a plan is executed without a decider, and the
+	// maximum number of entries that Evaluate evaluates concurrently. Values
+	// less than two execute operations and evaluate entries one at a time.
+	Parallelism int
+	Entries     map[string]Entry
+	// Tracer, if not nil, records the data keys accessed and the branches
+	// taken by every text/template template that is executed.
+	Tracer *TemplateTracer
+	// outputCache caches the output of the output template function, keyed
+	// by the NUL-joined command and arguments. It is reset by Populate.
+	outputCache map[string][]byte
+	// outputMu guards outputCache. It is held while commands run, so
+	// templates that are executed concurrently run each command only once.
+	outputMu sync.Mutex
+	// encryptionDirs maps target directory names to the names of the
+	// encryption tools selected by their .chezmoiencryption files. It is
+	// reset by Populate.
+	encryptionDirs map[string]string
+	// dataKey is the key of the template data in TemplateOutputCache, or
+	// empty if template outputs are not cached. It is set by Populate.
+	dataKey string
+	// mu is held for writing by the methods that change ts, and for reading
+	// by all other exported methods. Unexported methods never acquire it.
+	mu sync.RWMutex
+	// nameCache caches the orderings of the names of Entries.
+	nameCache entryNameCache
 }
 
 // NewTargetState creates a new TargetState.
@@ -48,6 +159,7 @@ func NewTargetState(destDir string, umask os.FileMode, sourceDir string, data ma
 	return &TargetState{
 		DestDir:       destDir,
 		TargetIgnore:  NewPatternSet(),
+		TargetRemove:  NewPatternSet(),
 		Umask:         umask,
 		SourceDir:     sourceDir,
 		Data:          data,
@@ -58,92 +170,42 @@ func NewTargetState(destDir string, umask os.FileMode, sourceDir string, data ma
 
 // Add adds a new target to ts.
 func (ts *TargetState) Add(fs vfs.FS, addOptions AddOptions, targetPath string, info os.FileInfo, mutator Mutator) error {
-	if !filepath.HasPrefix(targetPath, ts.DestDir) {
-		return fmt.Errorf("%s: outside target directory", targetPath)
-	}
-	targetName, err := filepath.Rel(ts.DestDir, targetPath)
-	if err != nil {
-		return err
-	}
-	if info == nil {
-		var err error
-		info, err = fs.Lstat(targetPath)
-		if err != nil {
-			return err
-		}
-	}
-
-	// Add the parent directories, if needed.
-	parentDirSourceName := ""
-	entries := ts.Entries
-	if parentDirName := filepath.Dir(targetName); parentDirName != "." {
-		parentEntry, err := ts.findEntry(parentDirName)
-		if err != nil && !os.IsNotExist(err) {
-			return err
-		}
-		if parentEntry == nil {
-			if err := ts.Add(fs, addOptions, filepath.Join(ts.DestDir, parentDirName), nil, mutator); err != nil {
-				return err
-			}
-			parentEntry, err = ts.findEntry(parentDirName)
-			if err != nil {
-				return err
-			}
-		} else if _, ok := parentEntry.(*Dir); !ok {
-			return fmt.Errorf("%s: not a directory", parentDirName)
-		}
-		parentDir := parentEntry.(*Dir)
-		parentDirSourceName = parentDir.sourceName
-		entries = parentDir.Entries
-	}
-
-	switch {
-	case info.IsDir():
-		perm := info.Mode().Perm()
-		infos, err := fs.ReadDir(targetPath)
-		if err != nil {
-			return err
-		}
-		empty := len(infos) == 0
-		return ts.addDir(targetName, entries, parentDirSourceName, addOptions.Exact, perm, empty, mutator)
-	case info.Mode().IsRegular():
-		if info.Size() == 0 && !addOptions.Empty {
-			return nil
-		}
-		contents, err := fs.ReadFile(targetPath)
-		if err != nil {
-			return err
-		}
-		if addOptions.Template {
-			contents, err = autoTemplate(contents, ts.Data)
-			if err != nil {
-				return err
-			}
-		}
-		return ts.addFile(targetName, entries, parentDirSourceName, info, addOptions.Template, contents, mutator)
-	case info.Mode()&os.ModeType == os.ModeSymlink:
-		linkname, err := fs.Readlink(targetPath)
-		if err != nil {
-			return err
-		}
-		return ts.addSymlink(targetName, entries, parentDirSourceName, linkname, mutator)
-	default:
-		return fmt.Errorf("%s: not a regular file, directory, or symlink", targetName)
-	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.add(fs, addOptions, targetPath, info, mutator)
 }
 
-// Apply ensures that ts.DestDir in fs matches ts.
+// Apply ensures that ts.DestDir in fs matches ts. Targets matching
+// ts.TargetRemove are removed first, and scripts are run after the other
+// entries in the same directory. The complete plan is computed before
+// anything is changed.
 func (ts *TargetState) Apply(fs vfs.FS, mutator Mutator) error {
-	for _, entryName := range sortedEntryNames(ts.Entries) {
-		if err := ts.Entries[entryName].Apply(fs, ts.DestDir, ts.TargetIgnore.Match, ts.Umask, mutator); err != nil {
-			return err
-		}
+	return ts.ApplyContext(context.Background(), fs, mutator)
+}
+
+// ApplyContext is like Apply, but stops when ctx is done, killing any running
+// script.
+func (ts *TargetState) ApplyContext(ctx context.Context, fs vfs.FS, mutator Mutator) error {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	plan, err := ts.planAll(ctx, fs)
+	if err != nil {
+		return err
 	}
-	return nil
+	_, err = ts.executePlanFunc(ctx, plan, mutator, nil)
+	return err
 }
 
 // Archive writes ts to w.
-func (ts *TargetState) Archive(w *tar.Writer, umask os.FileMode) error {
+func (ts *TargetState) Archive(w *TarWriter, umask os.FileMode) error {
+	return ts.ArchiveContext(context.Background(), w, umask)
+}
+
+// ArchiveContext is like Archive, but stops between top-level entries when ctx
+// is done.
+func (ts *TargetState) ArchiveContext(ctx context.Context, w *TarWriter, umask os.FileMode) error {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
 	currentUser, err := user.Current()
 	if err != nil {
 		return err
@@ -170,7 +232,10 @@ func (ts *TargetState) Archive(w *tar.Writer, umask os.FileMode) error {
 		AccessTime: now,
 		ChangeTime: now,
 	}
-	for _, entryName := range sortedEntryNames(ts.Entries) {
+	for _, entryName := range ts.nameCache.sortedNames(ts.Entries) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if err := ts.Entries[entryName].archive(w, ts.TargetIgnore.Match, &headerTemplate, umask); err != nil {
 			return err
 		}
@@ -180,8 +245,10 @@ func (ts *TargetState) Archive(w *tar.Writer, umask os.FileMode) error {
 
 // ConcreteValue returns a value suitable for serialization.
 func (ts *TargetState) ConcreteValue(recursive bool) (interface{}, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
 	var entryConcreteValues []interface{}
-	for _, entryName := range sortedEntryNames(ts.Entries) {
+	for _, entryName := range ts.nameCache.sortedNames(ts.Entries) {
 		entryConcreteValue, err := ts.Entries[entryName].ConcreteValue(ts.DestDir, ts.TargetIgnore.Match, ts.SourceDir, recursive)
 		if err != nil {
 			return nil, err
@@ -193,30 +260,46 @@ func (ts *TargetState) ConcreteValue(recursive bool) (interface{}, error) {
 	return entryConcreteValues, nil
 }
 
-// Evaluate evaluates all of the entries in ts.
+// DryRun returns the operations that applying ts to fs would execute, without
+// executing them.
+func (ts *TargetState) DryRun(fs vfs.FS) ([]Operation, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	plan, err := ts.planAll(context.Background(), fs)
+	if err != nil {
+		return nil, err
+	}
+	return plan.Operations, nil
+}
+
+// Evaluate evaluates all of the entries in ts. Evaluation continues after
+// errors, and all errors are returned as a MultiError.
 func (ts *TargetState) Evaluate() error {
-	for _, entryName := range sortedEntryNames(ts.Entries) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	if ts.Parallelism > 1 {
+		return ts.evaluateParallel()
+	}
+	var errs MultiError
+	for _, entryName := range ts.nameCache.sortedNames(ts.Entries) {
 		if err := ts.Entries[entryName].Evaluate(ts.TargetIgnore.Match); err != nil {
-			return err
+			errs = appendError(errs, err)
 		}
 	}
-	return nil
+	return errs.errorOrNil()
 }
 
 // Get returns the state of the given target, or nil if no such target is found.
 func (ts *TargetState) Get(target string) (Entry, error) {
-	if !filepath.HasPrefix(target, ts.DestDir) {
-		return nil, fmt.Errorf("%s: outside target directory", target)
-	}
-	targetName, err := filepath.Rel(ts.DestDir, target)
-	if err != nil {
-		return nil, err
-	}
-	return ts.findEntry(targetName)
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.get(target)
 }
 
 // ImportTAR imports a tar archive.
 func (ts *TargetState) ImportTAR(r *tar.Reader, importTAROptions ImportTAROptions, mutator Mutator) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
 	for {
 		header, err := r.Next()
 		if err == io.EOF {
@@ -230,16 +313,65 @@ func (ts *TargetState) ImportTAR(r *tar.Reader, importTAROptions ImportTAROption
 				return err
 			}
 		case tar.TypeXGlobalHeader:
+		case tar.TypeLink:
+			return fmt.Errorf("%s: hard links are not supported", header.Name)
 		default:
-			return fmt.Errorf("%s: unspported typeflag '%c'", header.Name, header.Typeflag)
+			return fmt.Errorf("%s: unsupported typeflag '%c'", header.Name, header.Typeflag)
 		}
 	}
 	return nil
 }
 
-// Populate walks fs from ts.SourceDir to populate ts.
+// Populate walks fs from ts.SourceDir to populate ts. If ts.SourceDir contains
+// a .chezmoiroot file then ts.SourceDir is first updated to the directory that
+// it names.
 func (ts *TargetState) Populate(fs vfs.FS) error {
-	return vfs.Walk(fs, ts.SourceDir, func(path string, info os.FileInfo, _ error) error {
+	return ts.PopulateContext(context.Background(), fs)
+}
+
+// PopulateContext is like Populate, but stops between source files when ctx is
+// done.
+func (ts *TargetState) PopulateContext(ctx context.Context, fs vfs.FS) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.outputCache = nil
+	ts.encryptionDirs = nil
+	if err := ts.readSourceRoot(fs); err != nil {
+		return err
+	}
+	if err := ts.checkVersion(fs); err != nil {
+		return err
+	}
+	if err := ts.readData(fs); err != nil {
+		return err
+	}
+	if err := ts.addDefaultData(fs); err != nil {
+		return err
+	}
+	// Templates are read first so that they can be used by all templates,
+	// including .chezmoiignore and .chezmoiremove.
+	if err := ts.addTemplates(fs); err != nil {
+		return err
+	}
+	ts.dataKey = ""
+	if ts.TemplateOutputCache != nil && ts.Tracer == nil {
+		ts.dataKey = ts.templateDataKey()
+	}
+	// Errors in the templates of .chezmoiignore and .chezmoiremove files are
+	// collected so that they can all be reported at once.
+	var templateErrs MultiError
+	addPatterns := func(ps PatternSet, path, relPath string, remove bool) error {
+		err := ts.addPatterns(fs, ps, path, relPath, remove)
+		if _, ok := err.(*TemplateError); ok {
+			templateErrs = append(templateErrs, err)
+			return nil
+		}
+		return err
+	}
+	if err := vfs.Walk(fs, ts.SourceDir, func(path string, info os.FileInfo, _ error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		relPath, err := filepath.Rel(ts.SourceDir, path)
 		if err != nil {
 			return err
@@ -249,9 +381,16 @@ func (ts *TargetState) Populate(fs vfs.FS) error {
 		}
 		// Treat all files and directories beginning with "." specially.
 		if _, name := filepath.Split(relPath); strings.HasPrefix(name, ".") {
-			if info.Name() == ".chezmoiignore" {
+			switch info.Name() {
+			case ".chezmoiignore":
 				dns := dirNames(parseDirNameComponents(splitPathList(relPath)))
-				return ts.addSourceIgnore(fs, path, filepath.Join(dns...))
+				return addPatterns(ts.TargetIgnore, path, filepath.Join(dns...), false)
+			case removeFileName:
+				dns := dirNames(parseDirNameComponents(splitPathList(relPath)))
+				return addPatterns(ts.TargetRemove, path, filepath.Join(dns...), true)
+			case encryptionFileName:
+				dns := dirNames(parseDirNameComponents(splitPathList(relPath)))
+				return ts.addEncryptionDir(fs, path, filepath.Dir(filepath.Join(dns...)))
 			}
 			// Ignore all other files and directories.
 			if info.IsDir() {
@@ -281,33 +420,104 @@ func (ts *TargetState) Populate(fs vfs.FS) error {
 
 			targetName := filepath.Join(append(dns, psfp.Name)...)
 			var entry Entry
-			switch psfp.Mode & os.ModeType {
-			case 0:
+			switch {
+			case psfp.Script:
 				evaluateContents := func() ([]byte, error) {
 					return fs.ReadFile(path)
 				}
 				if psfp.Template {
+					engine, err := ts.templateEngine(psfp.Engine)
+					if err != nil {
+						return err
+					}
 					evaluateContents = func() ([]byte, error) {
-						return ts.executeTemplate(fs, path)
+						return ts.executeTemplate(fs, engine, path)
+					}
+				}
+				entry = &Script{
+					sourceName:       relPath,
+					targetName:       targetName,
+					Once:             psfp.Once,
+					OnChange:         psfp.OnChange,
+					Template:         psfp.Template,
+					evaluateContents: evaluateContents,
+					persistentState:  ts.PersistentState,
+				}
+			case psfp.Mode&os.ModeType == 0:
+				// Encrypted files are decrypted before template execution,
+				// so the plaintext of an encrypted file may itself be a
+				// template.
+				readContents := func() ([]byte, error) {
+					return fs.ReadFile(path)
+				}
+				if psfp.Encrypted {
+					readContents = func() ([]byte, error) {
+						return ts.decryptFile(fs, path, targetName)
 					}
 				}
+				var evaluateContents func() ([]byte, error)
+				var openContents func() (*os.File, error)
+				var sourceFS vfs.FS
+				var sourcePath string
+				switch {
+				case psfp.Template:
+					engine, err := ts.templateEngine(psfp.Engine)
+					if err != nil {
+						return err
+					}
+					evaluateContents = func() ([]byte, error) {
+						data, err := readContents()
+						if err != nil {
+							return nil, err
+						}
+						return ts.executeTemplateData(fs, engine, path, data)
+					}
+					if !psfp.Encrypted && ts.dataKey != "" {
+						evaluateContents = func() ([]byte, error) {
+							return ts.executeCachedTemplate(fs, engine, path)
+						}
+					}
+				case psfp.Encrypted:
+					evaluateContents = readContents
+				default:
+					// The contents of plain files are streamed from the
+					// source directory instead of being held in memory.
+					openContents = func() (*os.File, error) {
+						return fs.Open(path)
+					}
+					sourceFS, sourcePath = fs, path
+				}
 				entry = &File{
 					sourceName:       relPath,
 					targetName:       targetName,
+					Create:           psfp.Create,
 					Empty:            psfp.Empty,
+					Encrypted:        psfp.Encrypted,
 					Perm:             psfp.Mode.Perm(),
 					Template:         psfp.Template,
 					evaluateContents: evaluateContents,
+					openContents:     openContents,
+					persistentState:  ts.PersistentState,
+					contentHashCache: ts.ContentHashCache,
+					sourceFS:         sourceFS,
+					sourcePath:       sourcePath,
+					maxMemorySize:    ts.MaxMemorySize,
 				}
-			case os.ModeSymlink:
+			case psfp.Mode&os.ModeType == os.ModeSymlink:
+				// Editors and templates often add a trailing newline, which
+				// is never wanted in a link name.
 				evaluateLinkname := func() (string, error) {
 					data, err := fs.ReadFile(path)
-					return string(data), err
+					return strings.TrimSpace(string(data)), err
 				}
 				if psfp.Template {
+					engine, err := ts.templateEngine(psfp.Engine)
+					if err != nil {
+						return err
+					}
 					evaluateLinkname = func() (string, error) {
-						data, err := ts.executeTemplate(fs, path)
-						return string(data), err
+						data, err := ts.executeTemplate(fs, engine, path)
+						return strings.TrimSpace(string(data)), err
 					}
 				}
 				entry = &Symlink{
@@ -324,7 +534,91 @@ func (ts *TargetState) Populate(fs vfs.FS) error {
 			return fmt.Errorf("%s: unsupported file type", path)
 		}
 		return nil
-	})
+	}); err != nil {
+		return err
+	}
+	return templateErrs.errorOrNil()
+}
+
+// add adds a new target to ts.
+func (ts *TargetState) add(fs vfs.FS, addOptions AddOptions, targetPath string, info os.FileInfo, mutator Mutator) error {
+	if !filepath.HasPrefix(targetPath, ts.DestDir) {
+		return fmt.Errorf("%s: outside target directory", targetPath)
+	}
+	targetName, err := filepath.Rel(ts.DestDir, targetPath)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		var err error
+		info, err = fs.Lstat(targetPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Add the parent directories, if needed.
+	parentDirSourceName := ""
+	entries := ts.Entries
+	if parentDirName := filepath.Dir(targetName); parentDirName != "." {
+		parentEntry, err := ts.findEntry(parentDirName)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if parentEntry == nil {
+			if err := ts.add(fs, addOptions, filepath.Join(ts.DestDir, parentDirName), nil, mutator); err != nil {
+				return err
+			}
+			parentEntry, err = ts.findEntry(parentDirName)
+			if err != nil {
+				return err
+			}
+		} else if _, ok := parentEntry.(*Dir); !ok {
+			return fmt.Errorf("%s: not a directory", parentDirName)
+		}
+		parentDir := parentEntry.(*Dir)
+		parentDirSourceName = parentDir.sourceName
+		entries = parentDir.Entries
+	}
+
+	switch {
+	case info.IsDir():
+		perm := info.Mode().Perm()
+		infos, err := fs.ReadDir(targetPath)
+		if err != nil {
+			return err
+		}
+		empty := len(infos) == 0
+		return ts.addDir(targetName, entries, parentDirSourceName, addOptions.Exact, perm, empty, mutator)
+	case info.Mode().IsRegular():
+		if info.Size() == 0 && !addOptions.Empty {
+			return nil
+		}
+		contents, err := fs.ReadFile(targetPath)
+		if err != nil {
+			return err
+		}
+		// Apply removes files that contain only whitespace unless they have
+		// the empty attribute, so treat them as empty.
+		if isEmpty(contents) && !addOptions.Empty {
+			return nil
+		}
+		if addOptions.Template {
+			contents, err = autoTemplate(contents, ts.Data)
+			if err != nil {
+				return err
+			}
+		}
+		return ts.addFile(targetName, entries, parentDirSourceName, info, addOptions.Template, addOptions.Encrypt, contents, mutator)
+	case info.Mode()&os.ModeType == os.ModeSymlink:
+		linkname, err := fs.Readlink(targetPath)
+		if err != nil {
+			return err
+		}
+		return ts.addSymlink(targetName, entries, parentDirSourceName, linkname, mutator)
+	default:
+		return fmt.Errorf("%s: not a regular file, directory, or symlink", targetName)
+	}
 }
 
 func (ts *TargetState) addDir(targetName string, entries map[string]Entry, parentDirSourceName string, exact bool, perm os.FileMode, empty bool, mutator Mutator) error {
@@ -335,6 +629,13 @@ func (ts *TargetState) addDir(targetName string, entries map[string]Entry, paren
 		}
 		return nil
 	}
+	// Only the private attribute of perm is encoded in the source name, so
+	// normalize perm to match what Populate would produce.
+	if perm&077 == 0 {
+		perm = 0700
+	} else {
+		perm = 0777
+	}
 	sourceName := DirAttributes{
 		Name:  name,
 		Exact: exact,
@@ -359,7 +660,7 @@ func (ts *TargetState) addDir(targetName string, entries map[string]Entry, paren
 	return nil
 }
 
-func (ts *TargetState) addFile(targetName string, entries map[string]Entry, parentDirSourceName string, info os.FileInfo, template bool, contents []byte, mutator Mutator) error {
+func (ts *TargetState) addFile(targetName string, entries map[string]Entry, parentDirSourceName string, info os.FileInfo, template, encrypt bool, contents []byte, mutator Mutator) error {
 	name := filepath.Base(targetName)
 	var existingFile *File
 	var existingContents []byte
@@ -374,13 +675,31 @@ func (ts *TargetState) addFile(targetName string, entries map[string]Entry, pare
 			return err
 		}
 	}
-	perm := info.Mode().Perm()
-	empty := info.Size() == 0
+	// Only the private and executable attributes of perm are encoded in the
+	// source name, so normalize perm to match what Populate would produce.
+	perm := os.FileMode(0666)
+	if info.Mode().Perm()&0111 != 0 {
+		perm |= 0111
+	}
+	if info.Mode().Perm()&077 == 0 {
+		perm &= 0700
+	}
+	// Keep the create and encrypted attributes of an existing file, as they
+	// cannot be determined from the target.
+	create := existingFile != nil && existingFile.Create
+	encrypt = encrypt || existingFile != nil && existingFile.Encrypted
+	if encrypt {
+		// Encrypted files are always private and never executable.
+		perm = 0600
+	}
+	empty := isEmpty(contents)
 	sourceName := FileAttributes{
-		Name:     name,
-		Mode:     perm,
-		Empty:    empty,
-		Template: template,
+		Name:      name,
+		Mode:      perm,
+		Create:    create,
+		Empty:     empty,
+		Encrypted: encrypt,
+		Template:  template,
 	}.SourceName()
 	if parentDirSourceName != "" {
 		sourceName = filepath.Join(parentDirSourceName, sourceName)
@@ -388,13 +707,33 @@ func (ts *TargetState) addFile(targetName string, entries map[string]Entry, pare
 	file := &File{
 		sourceName: sourceName,
 		targetName: targetName,
+		Create:     create,
 		Empty:      empty,
+		Encrypted:  encrypt,
 		Perm:       perm,
 		Template:   template,
 		contents:   contents,
 	}
+	if existingFile != nil && existingFile.Encrypted && bytes.Equal(existingFile.contents, file.contents) {
+		return nil
+	}
+	sourceContents := contents
+	if encrypt {
+		encryptor := ts.encryptor(targetName)
+		if encryptor == nil {
+			if existingFile != nil && existingFile.Encrypted {
+				return fmt.Errorf("%s: cannot update encrypted file", targetName)
+			}
+			return fmt.Errorf("%s: cannot encrypt file: no encryptor configured", targetName)
+		}
+		var err error
+		sourceContents, err = encryptor.Encrypt(targetName, contents)
+		if err != nil {
+			return err
+		}
+	}
 	if existingFile != nil {
-		if bytes.Equal(existingFile.contents, file.contents) {
+		if bytes.Equal(existingContents, file.contents) && !encrypt {
 			if existingFile.sourceName == file.sourceName {
 				return nil
 			}
@@ -405,17 +744,21 @@ func (ts *TargetState) addFile(targetName string, entries map[string]Entry, pare
 		}
 	}
 	entries[name] = file
-	return mutator.WriteFile(filepath.Join(ts.SourceDir, sourceName), contents, 0666&^ts.Umask, existingContents)
+	return mutator.WriteFile(filepath.Join(ts.SourceDir, sourceName), sourceContents, 0666&^ts.Umask, existingContents)
 }
 
-func (ts *TargetState) addSourceIgnore(fs vfs.FS, path, relPath string) error {
-	data, err := ts.executeTemplate(fs, path)
+// addPatterns adds the patterns in the file at path, which is in the directory
+// of the source state relPath, to ps. If remove is true then they are patterns
+// of targets to remove, which must be strictly below the destination
+// directory.
+func (ts *TargetState) addPatterns(fs vfs.FS, ps PatternSet, path, relPath string, remove bool) error {
+	data, err := ts.executeTemplate(fs, TextTemplateEngine, path)
 	if err != nil {
 		return err
 	}
 	dir := filepath.Dir(relPath)
 	s := bufio.NewScanner(bytes.NewReader(data))
-	for s.Scan() {
+	for line := 1; s.Scan(); line++ {
 		text := s.Text()
 		if index := strings.IndexRune(text, '#'); index != -1 {
 			text = text[:index]
@@ -424,8 +767,11 @@ func (ts *TargetState) addSourceIgnore(fs vfs.FS, path, relPath string) error {
 		if text == "" {
 			continue
 		}
+		if remove && !validRemovePattern(text) {
+			return fmt.Errorf("%s:%d: %s: outside target directory", path, line, text)
+		}
 		pattern := filepath.Join(dir, text)
-		if err := ts.TargetIgnore.Add(pattern); err != nil {
+		if err := ps.Add(pattern); err != nil {
 			return fmt.Errorf("%s: %v", path, err)
 		}
 	}
@@ -435,6 +781,38 @@ func (ts *TargetState) addSourceIgnore(fs vfs.FS, path, relPath string) error {
 	return nil
 }
 
+// addTemplates reads the templates in the .chezmoitemplates directory, if it
+// exists.
+func (ts *TargetState) addTemplates(fs vfs.FS) error {
+	templatesDir := filepath.Join(ts.SourceDir, templatesDirName)
+	if _, err := fs.Stat(templatesDir); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return vfs.Walk(fs, templatesDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(templatesDir, path)
+		if err != nil {
+			return err
+		}
+		contents, err := fs.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if ts.Templates == nil {
+			ts.Templates = make(map[string][]byte)
+		}
+		ts.Templates[filepath.ToSlash(relPath)] = contents
+		return nil
+	})
+}
+
 func (ts *TargetState) addSymlink(targetName string, entries map[string]Entry, parentDirSourceName string, linkname string, mutator Mutator) error {
 	name := filepath.Base(targetName)
 	var existingSymlink *Symlink
@@ -477,19 +855,123 @@ func (ts *TargetState) addSymlink(targetName string, entries map[string]Entry, p
 	return mutator.WriteFile(filepath.Join(ts.SourceDir, symlink.sourceName), []byte(symlink.linkname), 0666&^ts.Umask, []byte(existingLinkname))
 }
 
-func (ts *TargetState) executeTemplate(fs vfs.FS, path string) ([]byte, error) {
+// decryptFile decrypts the source file at path with the Decryptor for the
+// target targetName.
+func (ts *TargetState) decryptFile(fs vfs.FS, path, targetName string) ([]byte, error) {
+	decryptor := ts.decryptor(targetName)
+	if decryptor == nil {
+		return nil, fmt.Errorf("%s: encrypted file but no decryptor configured", path)
+	}
+	if encryption, ok := decryptor.(Encryption); ok {
+		return encryption.DecryptFile(fs, path)
+	}
+	return decryptFile(decryptor, fs, path)
+}
+
+// ensureDirs returns the source name and entries of the directory dirName,
+// creating it and any of its parents if they do not already exist. Imported
+// archives, for example, are not required to contain explicit entries for
+// every directory.
+func (ts *TargetState) ensureDirs(dirName string, exact bool, mutator Mutator) (string, map[string]Entry, error) {
+	parentDirSourceName := ""
+	entries := ts.Entries
+	if dirName == "." {
+		return parentDirSourceName, entries, nil
+	}
+	components := splitPathList(dirName)
+	for i, name := range components {
+		targetName := filepath.Join(components[:i+1]...)
+		if _, ok := entries[name]; !ok {
+			if err := ts.addDir(targetName, entries, parentDirSourceName, exact, 0777, false, mutator); err != nil {
+				return "", nil, err
+			}
+		}
+		dir, ok := entries[name].(*Dir)
+		if !ok {
+			return "", nil, fmt.Errorf("%s: not a directory", targetName)
+		}
+		parentDirSourceName = dir.sourceName
+		entries = dir.Entries
+	}
+	return parentDirSourceName, entries, nil
+}
+
+// evaluateParallel evaluates all entries like Evaluate, but reads files and
+// executes templates with up to ts.Parallelism entries at once. Errors are
+// returned in the same order as Evaluate returns them.
+func (ts *TargetState) evaluateParallel() error {
+	entries := appendLeafEntries(nil, ts.Entries, ts.nameCache.sortedNames(ts.Entries), ts.TargetIgnore.Match)
+	errs := make([]error, len(entries))
+	semaphore := make(chan struct{}, ts.Parallelism)
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int, entry Entry) {
+			defer wg.Done()
+			errs[i] = entry.Evaluate(ts.TargetIgnore.Match)
+			<-semaphore
+		}(i, entry)
+	}
+	wg.Wait()
+	var multiErr MultiError
+	for _, err := range errs {
+		if err != nil {
+			multiErr = appendError(multiErr, err)
+		}
+	}
+	return multiErr.errorOrNil()
+}
+
+func (ts *TargetState) executeTemplate(fs vfs.FS, engine TemplateEngine, path string) ([]byte, error) {
 	data, err := fs.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return ts.executeTemplateData(path, data)
+	return ts.executeTemplateData(fs, engine, path, data)
 }
 
-func (ts *TargetState) executeTemplateData(name string, data []byte) (_ []byte, err error) {
-	tmpl, err := template.New(name).Option("missingkey=error").Funcs(ts.TemplateFuncs).Parse(string(data))
+// executeCachedTemplate executes the template in the source file at path,
+// returning its output from ts.TemplateOutputCache if neither the source file
+// nor the template data have changed since it was cached.
+func (ts *TargetState) executeCachedTemplate(fs vfs.FS, engine TemplateEngine, path string) ([]byte, error) {
+	info, err := fs.Stat(path)
 	if err != nil {
 		return nil, err
 	}
+	dataKey := ts.dataKey + "\x00" + fmt.Sprintf("%T", engine)
+	if output, ok, err := ts.TemplateOutputCache.get(path, info, dataKey); err != nil || ok {
+		return output, err
+	}
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	volatile := false
+	output, err := ts.executeTemplateDataVolatile(fs, engine, path, data, &volatile)
+	if err != nil {
+		return nil, err
+	}
+	if !volatile {
+		ts.TemplateOutputCache.set(path, info, dataKey, output)
+	}
+	return output, nil
+}
+
+func (ts *TargetState) executeTemplateData(fs vfs.FS, engine TemplateEngine, name string, data []byte) ([]byte, error) {
+	return ts.executeTemplateDataVolatile(fs, engine, name, data, nil)
+}
+
+// executeTemplateDataVolatile executes the template name with source data. If
+// volatile is not nil then *volatile is set to true if the template calls a
+// function whose result depends on more than its source and the template
+// data.
+func (ts *TargetState) executeTemplateDataVolatile(fs vfs.FS, engine TemplateEngine, name string, data []byte, volatile *bool) (_ []byte, err error) {
+	var trace *TemplateTrace
+	if _, ok := engine.(textTemplateEngine); ok && ts.Tracer != nil {
+		trace = ts.Tracer.newTrace(ts.templateSourceName(name))
+	}
+	funcs := ts.templateFuncs(fs, name, 0, trace, volatile)
 	defer func() {
 		if r := recover(); r != nil {
 			if tfe, ok := r.(templateFuncError); ok {
@@ -498,12 +980,17 @@ func (ts *TargetState) executeTemplateData(name string, data []byte) (_ []byte,
 				panic(r)
 			}
 		}
+		err = ts.templateError(err)
+		if trace != nil {
+			for i := range trace.Branches {
+				trace.Branches[i].Name = ts.templateSourceName(trace.Branches[i].Name)
+			}
+		}
 	}()
-	output := &bytes.Buffer{}
-	if err = tmpl.Execute(output, ts.Data); err != nil {
-		return nil, err
+	if _, ok := engine.(textTemplateEngine); ok {
+		return TextTemplateEngine.execute(name, data, ts.Templates, funcs, ts.Data, trace)
 	}
-	return output.Bytes(), nil
+	return engine.Execute(name, data, funcs, ts.Data)
 }
 
 func (ts *TargetState) findEntries(dirNames []string) (map[string]Entry, error) {
@@ -529,10 +1016,79 @@ func (ts *TargetState) findEntry(name string) (Entry, error) {
 	return entries[names[len(names)-1]], nil
 }
 
+// findTemplate returns the path and source of the template includeName, as
+// included from the template name. If includeName begins with ./ or ../ then it
+// is the slash-separated path of a file in the source directory relative to
+// name, otherwise it is the name of a template in .chezmoitemplates.
+func (ts *TargetState) findTemplate(fs vfs.FS, name, includeName string) (string, []byte, error) {
+	if !strings.HasPrefix(includeName, "./") && !strings.HasPrefix(includeName, "../") {
+		source, ok := ts.Templates[includeName]
+		if !ok {
+			return "", nil, fmt.Errorf("%s: template not found", includeName)
+		}
+		return filepath.Join(ts.SourceDir, templatesDirName, filepath.FromSlash(includeName)), source, nil
+	}
+	dir := filepath.Dir(name)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(ts.SourceDir, dir)
+	}
+	path := filepath.Join(dir, filepath.FromSlash(includeName))
+	if relPath, err := filepath.Rel(ts.SourceDir, path); err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(os.PathSeparator)) {
+		return "", nil, fmt.Errorf("%s: outside source directory", includeName)
+	}
+	source, err := fs.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, source, nil
+}
+
+// get returns the state of the given target, or nil if no such target is found.
+func (ts *TargetState) get(target string) (Entry, error) {
+	if !filepath.HasPrefix(target, ts.DestDir) {
+		return nil, fmt.Errorf("%s: outside target directory", target)
+	}
+	targetName, err := filepath.Rel(ts.DestDir, target)
+	if err != nil {
+		return nil, err
+	}
+	return ts.findEntry(targetName)
+}
+
+// glob returns the sorted absolute paths of the targets in the destination
+// directory that match pattern, which is relative to ts.DestDir if it is not
+// absolute.
+func (ts *TargetState) glob(fs vfs.FS, pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("glob: %s: %v", pattern, err)
+	}
+	targetPattern, err := ts.targetName(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("glob: %v", err)
+	}
+	targetNames, err := ts.globTargets(fs, targetPattern)
+	if err != nil {
+		return nil, fmt.Errorf("glob: %v", err)
+	}
+	paths := make([]string, 0, len(targetNames))
+	for _, targetName := range targetNames {
+		paths = append(paths, filepath.Join(ts.DestDir, targetName))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
 func (ts *TargetState) importHeader(r io.Reader, importTAROptions ImportTAROptions, header *tar.Header, mutator Mutator) error {
-	targetPath := header.Name
+	targetPath := strings.TrimPrefix(filepath.Clean(header.Name), "."+string(os.PathSeparator))
+	if targetPath == "." {
+		return nil
+	}
 	if importTAROptions.StripComponents > 0 {
-		targetPath = filepath.Join(strings.Split(targetPath, string(os.PathSeparator))[importTAROptions.StripComponents:]...)
+		components := strings.Split(targetPath, string(os.PathSeparator))
+		if len(components) <= importTAROptions.StripComponents {
+			return nil
+		}
+		targetPath = filepath.Join(components[importTAROptions.StripComponents:]...)
 	}
 	if importTAROptions.DestinationDir != "" {
 		targetPath = filepath.Join(importTAROptions.DestinationDir, targetPath)
@@ -543,19 +1099,9 @@ func (ts *TargetState) importHeader(r io.Reader, importTAROptions ImportTAROptio
 	if err != nil {
 		return err
 	}
-	parentDirSourceName := ""
-	entries := ts.Entries
-	if parentDirName := filepath.Dir(targetName); parentDirName != "." {
-		parentEntry, err := ts.findEntry(parentDirName)
-		if err != nil {
-			return err
-		}
-		parentDir, ok := parentEntry.(*Dir)
-		if !ok {
-			return fmt.Errorf("%s: parent is not a directory", targetName)
-		}
-		parentDirSourceName = parentDir.sourceName
-		entries = parentDir.Entries
+	parentDirSourceName, entries, err := ts.ensureDirs(filepath.Dir(targetName), importTAROptions.Exact, mutator)
+	if err != nil {
+		return err
 	}
 	switch header.Typeflag {
 	case tar.TypeDir:
@@ -568,11 +1114,152 @@ func (ts *TargetState) importHeader(r io.Reader, importTAROptions ImportTAROptio
 		if err != nil {
 			return err
 		}
-		return ts.addFile(targetName, entries, parentDirSourceName, info, false, contents, mutator)
+		return ts.addFile(targetName, entries, parentDirSourceName, info, false, false, contents, mutator)
 	case tar.TypeSymlink:
 		linkname := header.Linkname
 		return ts.addSymlink(targetName, entries, parentDirSourceName, linkname, mutator)
 	default:
-		return fmt.Errorf("%s: unspported typeflag '%c'", header.Name, header.Typeflag)
+		return fmt.Errorf("%s: unsupported typeflag '%c'", header.Name, header.Typeflag)
+	}
+}
+
+// output runs the command name with args and returns its standard output. The
+// output of each command is cached, so commands are only run once.
+func (ts *TargetState) output(name string, args ...string) string {
+	key := strings.Join(append([]string{name}, args...), "\x00")
+	ts.outputMu.Lock()
+	defer ts.outputMu.Unlock()
+	if output, ok := ts.outputCache[key]; ok {
+		return string(output)
+	}
+	output, err := commandOutput(exec.Command(name, args...))
+	if err != nil {
+		ReturnTemplateFuncError(fmt.Errorf("output: %v", err))
+	}
+	if ts.outputCache == nil {
+		ts.outputCache = make(map[string][]byte)
+	}
+	ts.outputCache[key] = output
+	return string(output)
+}
+
+// templateEngine returns the TemplateEngine registered for extension, or
+// ts.TemplateEngine if extension is empty.
+func (ts *TargetState) templateEngine(extension string) (TemplateEngine, error) {
+	if extension != "" {
+		return LookupTemplateEngine(extension)
+	}
+	if ts.TemplateEngine != nil {
+		return ts.TemplateEngine, nil
+	}
+	return TextTemplateEngine, nil
+}
+
+// templateError returns err with the names in any TemplateErrors replaced by
+// the paths of their source files relative to ts.SourceDir.
+func (ts *TargetState) templateError(err error) error {
+	switch err := err.(type) {
+	case MultiError:
+		errs := make(MultiError, len(err))
+		for i, e := range err {
+			errs[i] = ts.templateError(e)
+		}
+		return errs
+	case *TemplateError:
+		te := *err
+		te.Name = ts.templateSourceName(te.Name)
+		return &te
+	default:
+		return err
+	}
+}
+
+// templateFuncs returns the template functions for the template name, which is
+// nested depth includes deep. Included templates are traced in trace, if it is
+// not nil. If volatile is not nil then *volatile is set to true when a function
+// whose result depends on more than its arguments and the source state is
+// called.
+func (ts *TargetState) templateFuncs(fs vfs.FS, name string, depth int, trace *TemplateTrace, volatile *bool) template.FuncMap {
+	// Start with the sprig functions, excluding those that depend on the
+	// environment, the time, or randomness, so that the same source state
+	// always gives the same target state.
+	funcs := sprig.HermeticTxtFuncMap()
+	for key, value := range helperTemplateFuncs {
+		funcs[key] = value
+	}
+	funcs["glob"] = func(pattern string) []string {
+		paths, err := ts.glob(fs, pattern)
+		if err != nil {
+			ReturnTemplateFuncError(err)
+		}
+		return paths
+	}
+	funcs["include"] = func(name string) string {
+		contents, err := fs.ReadFile(filepath.Join(ts.SourceDir, name))
+		if err != nil {
+			ReturnTemplateFuncError(err)
+		}
+		return string(contents)
+	}
+	funcs["includeTemplate"] = func(includeName string, data ...interface{}) string {
+		if depth >= maxIncludeDepth {
+			ReturnTemplateFuncError(fmt.Errorf("%s: too many nested includes", includeName))
+		}
+		// Relative includes read source files other than the template's own,
+		// so their outputs cannot be cached.
+		if volatile != nil && (strings.HasPrefix(includeName, "./") || strings.HasPrefix(includeName, "../")) {
+			*volatile = true
+		}
+		path, source, err := ts.findTemplate(fs, name, includeName)
+		if err != nil {
+			ReturnTemplateFuncError(err)
+		}
+		var templateData interface{} = ts.Data
+		switch len(data) {
+		case 0:
+		case 1:
+			templateData = data[0]
+		default:
+			ReturnTemplateFuncError(fmt.Errorf("%s: too many arguments", includeName))
+		}
+		output, err := TextTemplateEngine.execute(path, source, ts.Templates, ts.templateFuncs(fs, path, depth+1, trace, volatile), templateData, trace)
+		if err != nil {
+			ReturnTemplateFuncError(ts.templateError(err))
+		}
+		return string(output)
+	}
+	funcs["output"] = ts.output
+	if volatile != nil {
+		for _, key := range []string{"glob", "include", "output"} {
+			funcs[key] = volatileTemplateFunc(funcs[key], volatile)
+		}
+	}
+	for key, value := range ts.TemplateFuncs {
+		if volatile != nil {
+			value = volatileTemplateFunc(value, volatile)
+		}
+		funcs[key] = value
+	}
+	if ts.Secrets != nil {
+		for key, value := range funcs {
+			funcs[key] = secretTemplateFunc(value, ts.Secrets)
+		}
+	}
+	return funcs
+}
+
+// templateSourceName returns the path of the source file of the template name
+// relative to ts.SourceDir, or name if it is not in the source directory.
+func (ts *TargetState) templateSourceName(name string) string {
+	if _, ok := ts.Templates[name]; ok && !filepath.IsAbs(name) {
+		return filepath.Join(templatesDirName, name)
+	}
+	if !filepath.IsAbs(name) {
+		return name
+	}
+	relPath, err := filepath.Rel(ts.SourceDir, name)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		return name
 	}
+	return relPath
 }
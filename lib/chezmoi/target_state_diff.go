@@ -0,0 +1,148 @@
+package chezmoi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+)
+
+// A TargetSummary summarizes the target state of a single target, without
+// reference to any destination filesystem.
+type TargetSummary struct {
+	Type     string `json:"type" yaml:"type"`
+	Perm     int    `json:"perm,omitempty" yaml:"perm,omitempty"`
+	Exact    bool   `json:"exact,omitempty" yaml:"exact,omitempty"`
+	Create   bool   `json:"create,omitempty" yaml:"create,omitempty"`
+	Empty    bool   `json:"empty,omitempty" yaml:"empty,omitempty"`
+	SHA256   string `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	Linkname string `json:"linkname,omitempty" yaml:"linkname,omitempty"`
+}
+
+// A TargetChange is a change to a single target. Old is nil for added
+// targets and New is nil for removed targets.
+type TargetChange struct {
+	TargetName string         `json:"targetName" yaml:"targetName"`
+	Old        *TargetSummary `json:"old,omitempty" yaml:"old,omitempty"`
+	New        *TargetSummary `json:"new,omitempty" yaml:"new,omitempty"`
+}
+
+// A TargetStateDiff is the difference between two TargetStates.
+type TargetStateDiff struct {
+	Added    []*TargetChange `json:"added" yaml:"added"`
+	Removed  []*TargetChange `json:"removed" yaml:"removed"`
+	Modified []*TargetChange `json:"modified" yaml:"modified"`
+}
+
+// Empty returns true if d contains no changes.
+func (d *TargetStateDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// Len returns the number of changed targets in d.
+func (d *TargetStateDiff) Len() int {
+	return len(d.Added) + len(d.Removed) + len(d.Modified)
+}
+
+// DiffTargetStates returns the difference between oldTS and newTS, comparing
+// targets by name. Renamed targets are reported as a removal and an addition.
+// Ignored targets are excluded.
+func DiffTargetStates(oldTS, newTS *TargetState) (*TargetStateDiff, error) {
+	oldSummaries := make(map[string]*TargetSummary)
+	if err := summarizeEntries(oldTS.Entries, oldTS.TargetIgnore.Match, oldSummaries); err != nil {
+		return nil, err
+	}
+	newSummaries := make(map[string]*TargetSummary)
+	if err := summarizeEntries(newTS.Entries, newTS.TargetIgnore.Match, newSummaries); err != nil {
+		return nil, err
+	}
+	d := &TargetStateDiff{}
+	for _, targetName := range sortedTargetNames(oldSummaries) {
+		oldSummary := oldSummaries[targetName]
+		newSummary, ok := newSummaries[targetName]
+		switch {
+		case !ok:
+			d.Removed = append(d.Removed, &TargetChange{
+				TargetName: targetName,
+				Old:        oldSummary,
+			})
+		case *oldSummary != *newSummary:
+			d.Modified = append(d.Modified, &TargetChange{
+				TargetName: targetName,
+				Old:        oldSummary,
+				New:        newSummary,
+			})
+		}
+	}
+	for _, targetName := range sortedTargetNames(newSummaries) {
+		if _, ok := oldSummaries[targetName]; !ok {
+			d.Added = append(d.Added, &TargetChange{
+				TargetName: targetName,
+				New:        newSummaries[targetName],
+			})
+		}
+	}
+	return d, nil
+}
+
+// sortedTargetNames returns the sorted keys of summaries.
+func sortedTargetNames(summaries map[string]*TargetSummary) []string {
+	targetNames := make([]string, 0, len(summaries))
+	for targetName := range summaries {
+		targetNames = append(targetNames, targetName)
+	}
+	sort.Strings(targetNames)
+	return targetNames
+}
+
+// summarizeEntries adds a TargetSummary for every entry in entries that is
+// not ignored, recursing into directories, to summaries.
+func summarizeEntries(entries map[string]Entry, ignore func(string) bool, summaries map[string]*TargetSummary) error {
+	for _, entry := range entries {
+		if ignore(entry.TargetName()) {
+			continue
+		}
+		switch entry := entry.(type) {
+		case *Dir:
+			summaries[entry.targetName] = &TargetSummary{
+				Type:  "dir",
+				Perm:  int(entry.Perm),
+				Exact: entry.Exact,
+			}
+			if err := summarizeEntries(entry.Entries, ignore, summaries); err != nil {
+				return err
+			}
+		case *File:
+			sha256, err := entry.hexSHA256()
+			if err != nil {
+				return err
+			}
+			summaries[entry.targetName] = &TargetSummary{
+				Type:   "file",
+				Perm:   int(entry.Perm),
+				Create: entry.Create,
+				Empty:  entry.Empty,
+				SHA256: sha256,
+			}
+		case *Script:
+			contents, err := entry.Contents()
+			if err != nil {
+				return err
+			}
+			sha256Sum := sha256.Sum256(contents)
+			summaries[entry.targetName] = &TargetSummary{
+				Type:   "script",
+				SHA256: hex.EncodeToString(sha256Sum[:]),
+			}
+		case *Symlink:
+			linkname, err := entry.Linkname()
+			if err != nil {
+				return err
+			}
+			summaries[entry.targetName] = &TargetSummary{
+				Type:     "symlink",
+				Linkname: linkname,
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package chezmoi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestDiffTargetStates(t *testing.T) {
+	oldFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			".chezmoiignore":    "ignored\n",
+			"dot_bashrc":        "# contents of .bashrc\n",
+			"dot_inputrc":       "# contents of .inputrc\n",
+			"dot_profile":       "# contents of .profile\n",
+			"dot_vim":           &vfst.Dir{Perm: 0755},
+			"ignored":           "old\n",
+			"symlink_dot_vimrc": ".vim/vimrc",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	newFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			".chezmoiignore":         "ignored\n",
+			"dot_bashrc":             "# new contents of .bashrc\n",
+			"dot_inputrc2":           "# contents of .inputrc\n",
+			"executable_dot_profile": "# contents of .profile\n",
+			"exact_dot_vim":          &vfst.Dir{Perm: 0755},
+			"ignored":                "new\n",
+			"symlink_dot_vimrc":      ".vim/vimrc",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	oldTS := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := oldTS.Populate(oldFS); err != nil {
+		t.Fatalf("oldTS.Populate(%+v) == %v, want <nil>", oldFS, err)
+	}
+	newTS := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := newTS.Populate(newFS); err != nil {
+		t.Fatalf("newTS.Populate(%+v) == %v, want <nil>", newFS, err)
+	}
+
+	inputrc := &TargetSummary{
+		Type:   "file",
+		Perm:   0666,
+		SHA256: sha256Hex("# contents of .inputrc\n"),
+	}
+	got, err := DiffTargetStates(oldTS, newTS)
+	if err != nil {
+		t.Fatalf("DiffTargetStates(...) == _, %v, want _, <nil>", err)
+	}
+	want := &TargetStateDiff{
+		Added: []*TargetChange{
+			{TargetName: ".inputrc2", New: inputrc},
+		},
+		Removed: []*TargetChange{
+			{TargetName: ".inputrc", Old: inputrc},
+		},
+		Modified: []*TargetChange{
+			{
+				TargetName: ".bashrc",
+				Old: &TargetSummary{
+					Type:   "file",
+					Perm:   0666,
+					SHA256: sha256Hex("# contents of .bashrc\n"),
+				},
+				New: &TargetSummary{
+					Type:   "file",
+					Perm:   0666,
+					SHA256: sha256Hex("# new contents of .bashrc\n"),
+				},
+			},
+			{
+				TargetName: ".profile",
+				Old: &TargetSummary{
+					Type:   "file",
+					Perm:   0666,
+					SHA256: sha256Hex("# contents of .profile\n"),
+				},
+				New: &TargetSummary{
+					Type:   "file",
+					Perm:   0777,
+					SHA256: sha256Hex("# contents of .profile\n"),
+				},
+			},
+			{
+				TargetName: ".vim",
+				Old: &TargetSummary{
+					Type: "dir",
+					Perm: 0777,
+				},
+				New: &TargetSummary{
+					Type:  "dir",
+					Perm:  0777,
+					Exact: true,
+				},
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(want, got); !equal {
+		t.Errorf("DiffTargetStates(...) diff:\n%s", diff)
+	}
+	if got.Len() != 5 {
+		t.Errorf("DiffTargetStates(...).Len() == %d, want 5", got.Len())
+	}
+
+	data, err := json.Marshal(got.Removed[0])
+	if err != nil {
+		t.Fatalf("json.Marshal(_) == _, %v, want _, <nil>", err)
+	}
+	wantJSON := `{"targetName":".inputrc","old":{"type":"file","perm":438,"sha256":"` + inputrc.SHA256 + `"}}`
+	if string(data) != wantJSON {
+		t.Errorf("json.Marshal(_) == %s, _, want %s, _", data, wantJSON)
+	}
+
+	same, err := DiffTargetStates(oldTS, oldTS)
+	if err != nil {
+		t.Fatalf("DiffTargetStates(oldTS, oldTS) == _, %v, want _, <nil>", err)
+	}
+	if !same.Empty() {
+		t.Errorf("DiffTargetStates(oldTS, oldTS).Empty() == false, want true")
+	}
+}
+
+func sha256Hex(s string) string {
+	sha256Sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sha256Sum[:])
+}
@@ -1,7 +1,11 @@
 package chezmoi
 
 import (
+	"archive/tar"
+	"bytes"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"text/template"
 
@@ -25,26 +29,40 @@ func TestEndToEnd(t *testing.T) {
 			root: map[string]interface{}{
 				"/home/user": map[string]interface{}{
 					".bashrc": "foo",
+					".create": &vfst.File{
+						Perm:     0644,
+						Contents: []byte("local"),
+					},
 					"dir": map[string]interface{}{
 						"foo": "foo",
 						"bar": "bar",
 						"qux": "qux",
+						"subdir": map[string]interface{}{
+							"foo": "foo",
+						},
+					},
+					"replace_dir": map[string]interface{}{
+						"foo": "foo",
 					},
 					"replace_symlink": &vfst.Symlink{Target: "foo"},
 				},
 				"/home/user/.chezmoi": map[string]interface{}{
-					".git/HEAD":                "HEAD",
-					".chezmoiignore":           "{{ .ignore }} # comment\n",
-					"README.md":                "contents of README.md\n",
-					"dot_bashrc":               "bar",
-					"dot_hgrc.tmpl":            "[ui]\nusername = {{ .name }} <{{ .email }}>\n",
-					"empty.tmpl":               "{{ if false }}foo{{ end }}",
-					"empty_foo":                "",
-					"exact_dir/foo":            "foo",
-					"exact_dir/.chezmoiignore": "qux\n",
-					"whitespace":               " ",
-					"symlink_bar":              "empty",
-					"symlink_replace_symlink":  "bar",
+					".git/HEAD":                 "HEAD",
+					".chezmoiignore":            "{{ .ignore }} # comment\n",
+					"README.md":                 "contents of README.md\n",
+					"dot_bashrc":                "bar",
+					"create_private_dot_create": "source",
+					"create_dot_new":            "new",
+					"dot_hgrc.tmpl":             "[ui]\nusername = {{ .name }} <{{ .email }}>\n",
+					"empty.tmpl":                "{{ if false }}foo{{ end }}",
+					"empty_foo":                 "",
+					"exact_dir/foo":             "foo",
+					"exact_dir/.chezmoiignore":  "qux\n",
+					"whitespace":                " ",
+					"symlink_bar":               "empty",
+					"symlink_newline":           "bar\n",
+					"symlink_replace_dir":       "bar",
+					"symlink_replace_symlink":   "bar",
 				},
 			},
 			sourceDir: "/home/user/.chezmoi",
@@ -60,6 +78,15 @@ func TestEndToEnd(t *testing.T) {
 					vfst.TestModeIsRegular,
 					vfst.TestContentsString("bar"),
 				),
+				vfst.TestPath("/home/user/.create",
+					vfst.TestModeIsRegular,
+					vfst.TestModePerm(0600),
+					vfst.TestContentsString("local"),
+				),
+				vfst.TestPath("/home/user/.new",
+					vfst.TestModeIsRegular,
+					vfst.TestContentsString("new"),
+				),
 				vfst.TestPath("/home/user/.hgrc",
 					vfst.TestModeIsRegular,
 					vfst.TestContentsString("[ui]\nusername = John Smith <hello@example.com>\n"),
@@ -74,6 +101,14 @@ func TestEndToEnd(t *testing.T) {
 				),
 				vfst.TestPath("/home/user/whitespace",
 					vfst.TestDoesNotExist),
+				vfst.TestPath("/home/user/newline",
+					vfst.TestModeType(os.ModeSymlink),
+					vfst.TestSymlinkTarget("bar"),
+				),
+				vfst.TestPath("/home/user/replace_dir",
+					vfst.TestModeType(os.ModeSymlink),
+					vfst.TestSymlinkTarget("bar"),
+				),
 				vfst.TestPath("/home/user/replace_symlink",
 					vfst.TestModeType(os.ModeSymlink),
 					vfst.TestSymlinkTarget("bar"),
@@ -81,6 +116,9 @@ func TestEndToEnd(t *testing.T) {
 				vfst.TestPath("/home/user/dir/bar",
 					vfst.TestDoesNotExist,
 				),
+				vfst.TestPath("/home/user/dir/subdir",
+					vfst.TestDoesNotExist,
+				),
 				vfst.TestPath("/home/user/dir/qux",
 					vfst.TestModeIsRegular,
 					vfst.TestContentsString("qux"),
@@ -127,6 +165,7 @@ func TestTargetStatePopulate(t *testing.T) {
 			want: &TargetState{
 				DestDir:      "/",
 				TargetIgnore: NewPatternSet(),
+				TargetRemove: NewPatternSet(),
 				Umask:        0,
 				SourceDir:    "/",
 				Entries: map[string]Entry{
@@ -148,6 +187,7 @@ func TestTargetStatePopulate(t *testing.T) {
 			want: &TargetState{
 				DestDir:      "/",
 				TargetIgnore: NewPatternSet(),
+				TargetRemove: NewPatternSet(),
 				Umask:        0,
 				SourceDir:    "/",
 				Entries: map[string]Entry{
@@ -169,6 +209,7 @@ func TestTargetStatePopulate(t *testing.T) {
 			want: &TargetState{
 				DestDir:      "/",
 				TargetIgnore: NewPatternSet(),
+				TargetRemove: NewPatternSet(),
 				Umask:        0,
 				SourceDir:    "/",
 				Entries: map[string]Entry{
@@ -190,6 +231,7 @@ func TestTargetStatePopulate(t *testing.T) {
 			want: &TargetState{
 				DestDir:      "/",
 				TargetIgnore: NewPatternSet(),
+				TargetRemove: NewPatternSet(),
 				Umask:        0,
 				SourceDir:    "/",
 				Entries: map[string]Entry{
@@ -219,6 +261,7 @@ func TestTargetStatePopulate(t *testing.T) {
 			want: &TargetState{
 				DestDir:      "/",
 				TargetIgnore: NewPatternSet(),
+				TargetRemove: NewPatternSet(),
 				Umask:        0,
 				SourceDir:    "/",
 				Entries: map[string]Entry{
@@ -251,6 +294,7 @@ func TestTargetStatePopulate(t *testing.T) {
 			want: &TargetState{
 				DestDir:      "/",
 				TargetIgnore: NewPatternSet(),
+				TargetRemove: NewPatternSet(),
 				Umask:        0,
 				SourceDir:    "/",
 				Data: map[string]interface{}{
@@ -276,6 +320,7 @@ func TestTargetStatePopulate(t *testing.T) {
 			want: &TargetState{
 				DestDir:      "/",
 				TargetIgnore: NewPatternSet(),
+				TargetRemove: NewPatternSet(),
 				Umask:        0,
 				SourceDir:    "/",
 				Entries: map[string]Entry{
@@ -305,6 +350,7 @@ func TestTargetStatePopulate(t *testing.T) {
 			want: &TargetState{
 				DestDir:      "/",
 				TargetIgnore: NewPatternSet(),
+				TargetRemove: NewPatternSet(),
 				Umask:        0,
 				SourceDir:    "/",
 				Entries: map[string]Entry{
@@ -325,6 +371,7 @@ func TestTargetStatePopulate(t *testing.T) {
 			want: &TargetState{
 				DestDir:      "/",
 				TargetIgnore: NewPatternSet(),
+				TargetRemove: NewPatternSet(),
 				Umask:        0,
 				SourceDir:    "/",
 				Entries: map[string]Entry{
@@ -348,6 +395,7 @@ func TestTargetStatePopulate(t *testing.T) {
 			want: &TargetState{
 				DestDir:      "/",
 				TargetIgnore: NewPatternSet(),
+				TargetRemove: NewPatternSet(),
 				Umask:        0,
 				SourceDir:    "/",
 				Data: map[string]interface{}{
@@ -374,6 +422,48 @@ func TestTargetStatePopulate(t *testing.T) {
 				TargetIgnore: PatternSet(map[string]struct{}{
 					"f*": {},
 				}),
+				TargetRemove: NewPatternSet(),
+				Umask:        0,
+				SourceDir:    "/",
+				Entries:      map[string]Entry{},
+			},
+		},
+		{
+			name: "source_root",
+			root: map[string]interface{}{
+				"/.chezmoiroot": "home\n",
+				"/README.md":    "# dotfiles\n",
+				"/home/dot_foo": "bar",
+			},
+			sourceDir: "/",
+			want: &TargetState{
+				DestDir:      "/",
+				TargetIgnore: NewPatternSet(),
+				TargetRemove: NewPatternSet(),
+				Umask:        0,
+				SourceDir:    "/home",
+				Entries: map[string]Entry{
+					".foo": &File{
+						sourceName: "dot_foo",
+						targetName: ".foo",
+						Perm:       0666,
+						contents:   []byte("bar"),
+					},
+				},
+			},
+		},
+		{
+			name: "remove_pattern",
+			root: map[string]interface{}{
+				"/.chezmoiremove": "{{ if true }}.old*{{ end }} # comment\n",
+			},
+			sourceDir: "/",
+			want: &TargetState{
+				DestDir:      "/",
+				TargetIgnore: NewPatternSet(),
+				TargetRemove: PatternSet(map[string]struct{}{
+					".old*": {},
+				}),
 				Umask:     0,
 				SourceDir: "/",
 				Entries:   map[string]Entry{},
@@ -390,8 +480,9 @@ func TestTargetStatePopulate(t *testing.T) {
 				TargetIgnore: PatternSet(map[string]struct{}{
 					"dir/foo": {},
 				}),
-				Umask:     0,
-				SourceDir: "/",
+				TargetRemove: NewPatternSet(),
+				Umask:        0,
+				SourceDir:    "/",
 				Entries: map[string]Entry{
 					"dir": &Dir{
 						sourceName: "dir",
@@ -416,9 +507,418 @@ func TestTargetStatePopulate(t *testing.T) {
 			if err := ts.Evaluate(); err != nil {
 				t.Errorf("ts.Evaluate() == %v, want <nil>", err)
 			}
+			loadContents(t, ts.Entries)
+			clearNameCaches(ts)
+			tc.want.Data = withDefaultData(t, tc.want.Data, tc.want.SourceDir)
 			if diff, equal := messagediff.PrettyDiff(tc.want, ts); !equal {
 				t.Errorf("ts.Populate(%+v) diff:\n%s\n", fs, diff)
 			}
 		})
 	}
 }
+
+func TestTargetStateEncryptedFile(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		decryptor Decryptor
+		wantErr   bool
+	}{
+		{
+			name:      "null_decryptor",
+			decryptor: NullDecryptor,
+		},
+		{
+			name:    "no_decryptor",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user/.chezmoi/encrypted_executable_dot_netrc.tmpl": "machine {{ .host }}\n",
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{"host": "example.com"}, nil)
+			ts.Decryptor = tc.decryptor
+			if err := ts.Populate(fs); err != nil {
+				t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+			}
+			err = ts.Evaluate()
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ts.Evaluate() == <nil>, want !<nil>")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ts.Evaluate() == %v, want <nil>", err)
+			}
+			want := &File{
+				sourceName: "encrypted_executable_dot_netrc.tmpl",
+				targetName: ".netrc",
+				Encrypted:  true,
+				Perm:       0600,
+				Template:   true,
+				contents:   []byte("machine example.com\n"),
+			}
+			if diff, equal := messagediff.PrettyDiff(want, ts.Entries[".netrc"]); !equal {
+				t.Errorf("ts.Entries[\".netrc\"] diff:\n%s\n", diff)
+			}
+		})
+	}
+}
+
+func TestTargetStateEvaluateParallel(t *testing.T) {
+	root := map[string]interface{}{
+		"/home/user/.chezmoi/.chezmoiignore":          ".ignored\n",
+		"/home/user/.chezmoi/dot_broken.tmpl":         "{{ template \"missing\" }}",
+		"/home/user/.chezmoi/dot_ignored/broken.tmpl": "{{ template \"missing\" }}",
+	}
+	for _, dir := range []string{"a", "b", "c"} {
+		for _, file := range []string{"0", "1", "2", "3", "4"} {
+			root["/home/user/.chezmoi/dot_"+dir+"/"+file+".tmpl"] = "{{ .name }}" + dir + file
+		}
+		root["/home/user/.chezmoi/dot_"+dir+"/broken.tmpl"] = "{{ template \"missing\" }}"
+	}
+	fs, cleanup, err := vfst.NewTestFS(root)
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	evaluate := func(parallelism int) (*TargetState, error) {
+		ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", map[string]interface{}{"name": "user"}, nil)
+		ts.Parallelism = parallelism
+		ts.Tracer = &TemplateTracer{}
+		if err := ts.Populate(fs); err != nil {
+			t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+		}
+		return ts, ts.Evaluate()
+	}
+	serialTS, wantErr := evaluate(0)
+	ts, err := evaluate(4)
+	if wantErr == nil || err == nil || err.Error() != wantErr.Error() {
+		t.Errorf("ts.Evaluate() == %v, want %v", err, wantErr)
+	}
+	if errs, ok := err.(MultiError); !ok || len(errs) != 4 {
+		t.Errorf("ts.Evaluate() == %v, want four errors", err)
+	}
+	if got, want := len(ts.Tracer.Traces), len(serialTS.Tracer.Traces); got != want {
+		t.Errorf("len(ts.Tracer.Traces) == %d, want %d", got, want)
+	}
+	for _, dir := range []string{"a", "b", "c"} {
+		for _, file := range []string{"0", "1", "2", "3", "4"} {
+			targetName := filepath.Join("."+dir, file)
+			entry, err := ts.findEntry(targetName)
+			if err != nil {
+				t.Fatalf("ts.findEntry(%q) == _, %v, want _, <nil>", targetName, err)
+			}
+			f := entry.(*File)
+			if f.evaluateContents != nil {
+				t.Errorf("%s: not evaluated", targetName)
+			}
+			if got, want := string(f.contents), "user"+dir+file; got != want {
+				t.Errorf("%s: contents == %q, want %q", targetName, got, want)
+			}
+		}
+	}
+}
+
+func TestTargetStateConcurrentUse(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.bashrc":                       "# old\n",
+		"/home/user/.chezmoi/dot_bashrc":           "# bashrc\n",
+		"/home/user/.chezmoi/dot_gitconfig.tmpl":   "{{ .name }}\n",
+		"/home/user/.chezmoi/dot_hgrc":             "# hgrc\n",
+		"/home/user/.chezmoi/symlink_dot_vim.tmpl": ".config/{{ .name }}\n",
+		"/home/user/.chezmoi/run_once_install.sh":  "#!/bin/sh\n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", map[string]interface{}{"name": "user"}, nil)
+	persistentState := NewBoltPersistentState(fs, "/home/user/.config/chezmoi/chezmoistate.boltdb", false)
+	defer persistentState.Close()
+	ts.PersistentState = persistentState
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+	}
+	diff := func() string {
+		b := &bytes.Buffer{}
+		if _, err := ts.Diff(fs, b, DiffOptions{}); err != nil {
+			t.Errorf("ts.Diff(...) == _, %v, want _, <nil>", err)
+		}
+		return b.String()
+	}
+	wantDiff := diff()
+	// Entries are evaluated lazily, so the entries of a new target state are
+	// first evaluated concurrently.
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			switch i % 4 {
+			case 0:
+				if got := diff(); got != wantDiff {
+					t.Errorf("ts.Diff(...) wrote %q, want %q", got, wantDiff)
+				}
+			case 1:
+				if _, err := ts.Verify(fs); err != nil {
+					t.Errorf("ts.Verify(_) == _, %v, want _, <nil>", err)
+				}
+			case 2:
+				if _, err := ts.Status(fs); err != nil {
+					t.Errorf("ts.Status(_) == _, %v, want _, <nil>", err)
+				}
+			case 3:
+				if err := ts.Populate(fs); err != nil {
+					t.Errorf("ts.Populate(_) == %v, want <nil>", err)
+				}
+				if _, err := ts.ConcreteValue(true); err != nil {
+					t.Errorf("ts.ConcreteValue(true) == _, %v, want _, <nil>", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// clearNameCaches clears the cached orderings of entry names in ts, which are
+// not part of its state, so that ts can be compared with other target states.
+func clearNameCaches(ts *TargetState) {
+	ts.nameCache = entryNameCache{}
+	clearDirNameCaches(ts.Entries)
+}
+
+// clearDirNameCaches clears the cached orderings of entry names in the
+// directories in entries, recursively.
+func clearDirNameCaches(entries map[string]Entry) {
+	for _, entry := range entries {
+		if dir, ok := entry.(*Dir); ok {
+			dir.nameCache = entryNameCache{}
+			clearDirNameCaches(dir.Entries)
+		}
+	}
+}
+
+// loadContents loads the contents of every file in entries, so that files
+// that stream their contents can be compared with files with contents.
+func loadContents(t *testing.T, entries map[string]Entry) {
+	for _, entry := range entries {
+		if err := loadEntryContents(entry); err != nil {
+			t.Fatalf("loadEntryContents(_) == %v, want <nil>", err)
+		}
+	}
+}
+
+// A reverseCrypter is a Decryptor and an Encryptor that reverses its input.
+type reverseCrypter struct{}
+
+func (reverseCrypter) Decrypt(name string, ciphertext []byte) ([]byte, error) {
+	return reverseBytes(ciphertext), nil
+}
+
+func (reverseCrypter) Encrypt(name string, plaintext []byte) ([]byte, error) {
+	return reverseBytes(plaintext), nil
+}
+
+func reverseBytes(b []byte) []byte {
+	result := make([]byte, len(b))
+	for i, c := range b {
+		result[len(b)-1-i] = c
+	}
+	return result
+}
+
+func TestTargetStateAddEncrypted(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".chezmoi":           &vfst.Dir{Perm: 0700},
+			".netrc":             &vfst.File{Perm: 0600, Contents: []byte("machine example.com\n")},
+			".ssh/id_rsa":        &vfst.File{Perm: 0600, Contents: []byte("private key\n")},
+			".config/token.conf": "token\n",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", nil, nil)
+	ts.Decryptor = reverseCrypter{}
+	ts.Encryptor = reverseCrypter{}
+	mutator := NewFSMutator(fs, ts.DestDir)
+	for _, targetPath := range []string{"/home/user/.netrc", "/home/user/.ssh/id_rsa"} {
+		if err := ts.Add(fs, AddOptions{Encrypt: true}, targetPath, nil, mutator); err != nil {
+			t.Fatalf("ts.Add(_, _, %q, nil, _) == %v, want <nil>", targetPath, err)
+		}
+	}
+
+	// Updating an encrypted file keeps it encrypted.
+	if err := fs.WriteFile("/home/user/.netrc", []byte("machine example.org\n"), 0600); err != nil {
+		t.Fatalf("fs.WriteFile(...) == %v, want <nil>", err)
+	}
+	if err := ts.Add(fs, AddOptions{}, "/home/user/.netrc", nil, mutator); err != nil {
+		t.Fatalf("ts.Add(_, _, %q, nil, _) == %v, want <nil>", "/home/user/.netrc", err)
+	}
+
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.chezmoi/encrypted_dot_netrc",
+			vfst.TestModeIsRegular,
+			vfst.TestContents(reverseBytes([]byte("machine example.org\n"))),
+		),
+		vfst.TestPath("/home/user/.chezmoi/dot_ssh/encrypted_id_rsa",
+			vfst.TestModeIsRegular,
+			vfst.TestContents(reverseBytes([]byte("private key\n"))),
+		),
+	)
+
+	ts.Encryptor = nil
+	if err := ts.Add(fs, AddOptions{Encrypt: true}, "/home/user/.config/token.conf", nil, mutator); err == nil {
+		t.Errorf("ts.Add(_, _, %q, nil, _) == <nil>, want !<nil>", "/home/user/.config/token.conf")
+	}
+
+	// The added files are decrypted when the source state is read.
+	ts = NewTargetState("/home/user", 0, "/home/user/.chezmoi", nil, nil)
+	ts.Decryptor = reverseCrypter{}
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+	}
+	if contents, err := ts.Entries[".netrc"].(*File).Contents(); err != nil || string(contents) != "machine example.org\n" {
+		t.Errorf("ts.Entries[\".netrc\"].Contents() == %q, %v, want %q, <nil>", contents, err, "machine example.org\n")
+	}
+}
+
+func TestTargetStateImportTAR(t *testing.T) {
+	srcFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			"dot_bashrc":                   "# contents of .bashrc\n",
+			"private_dot_netrc":            "# contents of .netrc\n",
+			"private_dot_ssh/config":       "# contents of .ssh/config\n",
+			"dir/executable_script":        "#!/bin/sh\n",
+			"dir/subdir/symlink_dot_vimrc": ".config/vimrc",
+			"dir2/empty_dot_hushlogin":     "",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts1 := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts1.Populate(srcFS); err != nil {
+		t.Fatalf("ts1.Populate(%+v) == %v, want <nil>", srcFS, err)
+	}
+	b := &bytes.Buffer{}
+	w := NewTarWriter(b)
+	if err := ts1.Archive(w, 022); err != nil {
+		t.Fatalf("ts1.Archive(_, 022) == %v, want <nil>", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("w.Close() == %v, want <nil>", err)
+	}
+
+	dstFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": &vfst.Dir{Perm: 0700},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts2 := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts2.ImportTAR(tar.NewReader(b), ImportTAROptions{}, NewFSMutator(dstFS, "/home/user")); err != nil {
+		t.Fatalf("ts2.ImportTAR(...) == %v, want <nil>", err)
+	}
+	vfst.RunTests(t, dstFS, "",
+		vfst.TestPath("/home/user/.chezmoi/private_dot_netrc",
+			vfst.TestModeIsRegular,
+			vfst.TestContentsString("# contents of .netrc\n"),
+		),
+		vfst.TestPath("/home/user/.chezmoi/private_dot_ssh/config",
+			vfst.TestModeIsRegular,
+			vfst.TestContentsString("# contents of .ssh/config\n"),
+		),
+		vfst.TestPath("/home/user/.chezmoi/dir/executable_script",
+			vfst.TestModeIsRegular,
+			vfst.TestContentsString("#!/bin/sh\n"),
+		),
+	)
+
+	ts3 := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts3.Populate(dstFS); err != nil {
+		t.Fatalf("ts3.Populate(%+v) == %v, want <nil>", dstFS, err)
+	}
+	want, err := ts1.ConcreteValue(true)
+	if err != nil {
+		t.Fatalf("ts1.ConcreteValue(true) == _, %v, want _, <nil>", err)
+	}
+	for name, ts := range map[string]*TargetState{
+		"imported":  ts2,
+		"populated": ts3,
+	} {
+		got, err := ts.ConcreteValue(true)
+		if err != nil {
+			t.Fatalf("%s: ts.ConcreteValue(true) == _, %v, want _, <nil>", name, err)
+		}
+		if diff, equal := messagediff.PrettyDiff(want, got); !equal {
+			t.Errorf("%s: ts.ConcreteValue(true) diff:\n%s\n", name, diff)
+		}
+	}
+}
+
+func TestTargetStateImportTARWithoutDirs(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewTarWriter(b)
+	for _, header := range []*tar.Header{
+		{
+			Typeflag: tar.TypeReg,
+			Name:     "./dir/subdir/foo",
+			Mode:     0600,
+			Size:     3,
+		},
+	} {
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatalf("w.WriteHeader(%+v) == %v, want <nil>", header, err)
+		}
+		if _, err := w.Write([]byte("bar")); err != nil {
+			t.Fatalf("w.Write(_) == _, %v, want _, <nil>", err)
+		}
+	}
+	if err := w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeLink,
+		Name:     "./dir/subdir/hardlink",
+		Linkname: "./dir/subdir/foo",
+	}); err != nil {
+		t.Fatalf("w.WriteHeader(_) == %v, want <nil>", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("w.Close() == %v, want <nil>", err)
+	}
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": &vfst.Dir{Perm: 0700},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.ImportTAR(tar.NewReader(b), ImportTAROptions{}, NewFSMutator(fs, "/home/user")); err == nil {
+		t.Errorf("ts.ImportTAR(...) == <nil>, want !<nil>")
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.chezmoi/dir/subdir",
+			vfst.TestIsDir,
+		),
+		vfst.TestPath("/home/user/.chezmoi/dir/subdir/private_foo",
+			vfst.TestModeIsRegular,
+			vfst.TestContentsString("bar"),
+		),
+		vfst.TestPath("/home/user/.chezmoi/dir/subdir/hardlink",
+			vfst.TestDoesNotExist,
+		),
+	)
+}
@@ -0,0 +1,141 @@
+package chezmoi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// A TemplateEngine executes templates.
+type TemplateEngine interface {
+	Execute(name string, source []byte, funcs template.FuncMap, data interface{}) ([]byte, error)
+}
+
+// A SecretTracker tracks the secrets returned by template functions. A
+// TargetState with a SecretTracker propagates secrets through template
+// functions, whichever TemplateEngine executes the template: the results of a
+// template function called with an argument that contains a secret are also
+// secrets.
+type SecretTracker interface {
+	AddSecret(value interface{})
+	ContainsSecret(value interface{}) bool
+}
+
+type textTemplateEngine struct{}
+
+// TextTemplateEngine is the default TemplateEngine, which uses text/template.
+// Referring to a key that is not in the data is an error, rather than
+// producing "<no value>".
+var TextTemplateEngine textTemplateEngine
+
+// textTemplateEngineName is the name of TextTemplateEngine.
+const textTemplateEngineName = "text"
+
+// templateEngines maps source file extensions to TemplateEngines. It is
+// guarded by templateEnginesMu.
+var (
+	templateEnginesMu sync.RWMutex
+	templateEngines   = map[string]TemplateEngine{
+		"star": StarlarkTemplateEngine,
+	}
+)
+
+// RegisterTemplateEngine registers engine for source files with the given
+// extension before the .tmpl suffix, for example "star" for
+// "dot_bashrc.star.tmpl". It is safe to call concurrently with parsing source
+// names, but source names that were parsed before it was called do not select
+// engine, so it should be called before any source states are populated,
+// typically from an init function.
+func RegisterTemplateEngine(extension string, engine TemplateEngine) error {
+	if extension == "" || extension == textTemplateEngineName || strings.ContainsAny(extension, "./") {
+		return fmt.Errorf("%q: invalid template engine extension", extension)
+	}
+	templateEnginesMu.Lock()
+	defer templateEnginesMu.Unlock()
+	if _, ok := templateEngines[extension]; ok {
+		return fmt.Errorf("%s: template engine already registered", extension)
+	}
+	templateEngines[extension] = engine
+	return nil
+}
+
+// registeredTemplateEngine returns the TemplateEngine registered for
+// extension, and whether there is one.
+func registeredTemplateEngine(extension string) (TemplateEngine, bool) {
+	templateEnginesMu.RLock()
+	defer templateEnginesMu.RUnlock()
+	engine, ok := templateEngines[extension]
+	return engine, ok
+}
+
+// Execute implements TemplateEngine.Execute.
+func (e textTemplateEngine) Execute(name string, source []byte, funcs template.FuncMap, data interface{}) ([]byte, error) {
+	return e.execute(name, source, nil, funcs, data, nil)
+}
+
+// execute executes source with templates available as associated templates,
+// so that they can be invoked with the template action. Errors with a location
+// are returned as TemplateErrors. If trace is not nil then the keys accessed and
+// branches taken are recorded in it.
+func (textTemplateEngine) execute(name string, source []byte, templates map[string][]byte, funcs template.FuncMap, data interface{}, trace *TemplateTrace) ([]byte, error) {
+	tmpl := template.New(name).Option("missingkey=error").Funcs(funcs)
+	for templateName, templateSource := range templates {
+		if _, err := tmpl.New(templateName).Parse(string(templateSource)); err != nil {
+			return nil, newTextTemplateError(err)
+		}
+	}
+	if _, err := tmpl.Parse(string(source)); err != nil {
+		return nil, newTextTemplateError(err)
+	}
+	if trace != nil {
+		traceTemplate(tmpl, trace)
+	}
+	output := getBuffer()
+	defer putBuffer(output)
+	if err := tmpl.Execute(output, data); err != nil {
+		return nil, newTextTemplateError(err)
+	}
+	return append([]byte(nil), output.Bytes()...), nil
+}
+
+// LookupTemplateEngine returns the TemplateEngine with the given name, which is
+// either "text", for TextTemplateEngine, or an extension registered with
+// RegisterTemplateEngine.
+func LookupTemplateEngine(name string) (TemplateEngine, error) {
+	if name == textTemplateEngineName {
+		return TextTemplateEngine, nil
+	}
+	engine, ok := registeredTemplateEngine(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown template engine", name)
+	}
+	return engine, nil
+}
+
+// secretTemplateFunc returns a function with the same type as fn that adds the
+// values that fn returns to secrets if any of its arguments contain a secret.
+func secretTemplateFunc(fn interface{}, secrets SecretTracker) interface{} {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func {
+		return fn
+	}
+	return reflect.MakeFunc(value.Type(), func(args []reflect.Value) []reflect.Value {
+		var results []reflect.Value
+		if value.Type().IsVariadic() {
+			results = value.CallSlice(args)
+		} else {
+			results = value.Call(args)
+		}
+		for _, arg := range args {
+			if secrets.ContainsSecret(arg.Interface()) {
+				for _, result := range results {
+					secrets.AddSecret(result.Interface())
+				}
+				break
+			}
+		}
+		return results
+	}).Interface()
+}
@@ -0,0 +1,418 @@
+package chezmoi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+	"go.starlark.net/resolve"
+)
+
+func TestTemplateEngineConformance(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			".greeting": "Have a nice day.",
+			"text.tmpl": strings.Join([]string{
+				"Hello, {{ upper .name }}!",
+				"{{ range .items }}- {{ . }}",
+				"{{ end }}{{ include \".greeting\" }}",
+				"",
+			}, "\n"),
+			"starlark.star.tmpl": strings.Join([]string{
+				`print("Hello, %s!" % upper(data["name"]))`,
+				`for item in data["items"]:`,
+				`    print("- " + item)`,
+				`print(include(".greeting"))`,
+			}, "\n"),
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	data := map[string]interface{}{
+		"name":  "world",
+		"items": []interface{}{"foo", "bar"},
+	}
+	funcs := template.FuncMap{
+		"upper": strings.ToUpper,
+	}
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", data, funcs)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	want := "Hello, WORLD!\n- foo\n- bar\nHave a nice day.\n"
+	for _, targetName := range []string{"text", "starlark"} {
+		t.Run(targetName, func(t *testing.T) {
+			file, ok := ts.Entries[targetName].(*File)
+			if !ok {
+				t.Fatalf("ts.Entries[%q] == %+v, want a *File", targetName, ts.Entries[targetName])
+			}
+			got, err := file.Contents()
+			if err != nil {
+				t.Fatalf("file.Contents() == _, %v, want _, <nil>", err)
+			}
+			if string(got) != want {
+				t.Errorf("file.Contents() == %q, _, want %q, _", got, want)
+			}
+		})
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			".chezmoitemplates": map[string]interface{}{
+				"header": "# {{ .name }}\n",
+				"list": map[string]interface{}{
+					"items": "{{ range . }}- {{ . }}\n{{ end }}",
+				},
+			},
+			"text.tmpl":          `{{ template "header" . }}{{ includeTemplate "list/items" .items }}`,
+			"starlark.star.tmpl": `print((includeTemplate("header") + includeTemplate("list/items", data["items"])).rstrip("\n"))`,
+			"missing.tmpl":       `{{ includeTemplate "missing" }}`,
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	data := map[string]interface{}{
+		"name":  "world",
+		"items": []interface{}{"foo", "bar"},
+	}
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", data, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	want := "# world\n- foo\n- bar\n"
+	for _, targetName := range []string{"text", "starlark"} {
+		t.Run(targetName, func(t *testing.T) {
+			got, err := ts.Entries[targetName].(*File).Contents()
+			if err != nil {
+				t.Fatalf("file.Contents() == _, %v, want _, <nil>", err)
+			}
+			if string(got) != want {
+				t.Errorf("file.Contents() == %q, _, want %q, _", got, want)
+			}
+		})
+	}
+	if _, err := ts.Entries["missing"].(*File).Contents(); err == nil {
+		t.Errorf("file.Contents() == _, <nil>, want _, !<nil>")
+	}
+}
+
+func TestIncludeTemplateRelative(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			".chezmoitemplates/header":      `{{ includeTemplate "./parts/.line" "header" }}`,
+			".chezmoitemplates/parts/.line": "# {{ . }}\n",
+			"dot_config/shell": map[string]interface{}{
+				".aliases.tmpl":  "alias ll='ls -l'\n{{ includeTemplate \"../.common.tmpl\" .name }}",
+				"dot_zshrc.tmpl": `{{ includeTemplate "header" }}{{ includeTemplate "./.aliases.tmpl" }}`,
+				"loop.tmpl":      `{{ includeTemplate "./loop.tmpl" }}`,
+				"outside.tmpl":   `{{ includeTemplate "../../../.bashrc" }}`,
+				"missing.tmpl":   `{{ includeTemplate "./.missing" }}`,
+			},
+			"dot_config/.common.tmpl": "# common for {{ . }}\n",
+		},
+		"/home/user/.bashrc": "# bashrc\n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{"name": "zsh"}, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	entries := ts.Entries[".config"].(*Dir).Entries["shell"].(*Dir).Entries
+	got, err := entries[".zshrc"].(*File).Contents()
+	if err != nil {
+		t.Fatalf("file.Contents() == _, %v, want _, <nil>", err)
+	}
+	if want := "# header\nalias ll='ls -l'\n# common for zsh\n"; string(got) != want {
+		t.Errorf("file.Contents() == %q, _, want %q, _", got, want)
+	}
+	for _, name := range []string{"loop", "outside", "missing"} {
+		if _, err := entries[name].(*File).Contents(); err == nil {
+			t.Errorf("%s: file.Contents() == _, <nil>, want _, !<nil>", name)
+		}
+	}
+}
+
+func TestSprigTemplateFuncs(t *testing.T) {
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{}, template.FuncMap{
+		"trim": strings.ToUpper,
+	})
+	for name, tc := range map[string]struct {
+		engine TemplateEngine
+		source string
+		want   string
+	}{
+		"text":     {engine: TextTemplateEngine, source: `{{ list "a" "b" | join "," | b64enc }} {{ trim " c " }}`, want: "YSxi  C "},
+		"starlark": {engine: StarlarkTemplateEngine, source: `print(b64enc(join(",", list("a", "b"))))`, want: "YSxi\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ts.executeTemplateData(nil, tc.engine, name, []byte(tc.source))
+			if err != nil {
+				t.Fatalf("ts.executeTemplateData(nil, _, %q, %q) == _, %v, want _, <nil>", name, tc.source, err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("ts.executeTemplateData(nil, _, %q, %q) == %q, <nil>, want %q, <nil>", name, tc.source, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTemplateMissingKey(t *testing.T) {
+	for name, root := range map[string]interface{}{
+		"file":         map[string]interface{}{"dot_bashrc.tmpl": "export EMAIL={{ .email }}\n"},
+		"nested":       map[string]interface{}{"dot_gitconfig.tmpl": "{{ .git.name }}\n"},
+		"symlink":      map[string]interface{}{"symlink_dot_foo.tmpl": "{{ .target }}"},
+		"starlark":     map[string]interface{}{"dot_bashrc.star.tmpl": `print(data["email"])`},
+		"ignore":       map[string]interface{}{".chezmoiignore": "{{ .ignore }}\n"},
+		"include":      map[string]interface{}{".chezmoitemplates/header": "{{ .email }}", "dot_bashrc.tmpl": `{{ includeTemplate "header" }}`},
+		"template_dir": map[string]interface{}{".chezmoitemplates/header": "{{ .email }}", "dot_bashrc.tmpl": `{{ template "header" . }}`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/home/user/.chezmoi": root,
+			})
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{}, nil)
+			if err := ts.Populate(fs); err == nil {
+				err = ts.Evaluate()
+				if err == nil {
+					t.Errorf("ts.Populate(_) and ts.Evaluate() == <nil>, want !<nil>")
+				}
+			}
+		})
+	}
+}
+
+func TestStarlarkTemplateFuncError(t *testing.T) {
+	funcs := template.FuncMap{
+		"returnTemplateError": func() string {
+			ReturnTemplateFuncError(errors.New("error"))
+			return "foo"
+		},
+	}
+	for name, source := range map[string]string{
+		"syntax_error":         "print(",
+		"unknown_key":          `print(data["unknown"])`,
+		"unknown_func":         "print(unknown())",
+		"func_returning_error": "print(returnTemplateError())",
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{}, funcs)
+			if got, err := ts.executeTemplateData(nil, StarlarkTemplateEngine, name, []byte(source)); err == nil {
+				t.Errorf("ts.executeTemplateData(nil, StarlarkTemplateEngine, %q, %q) == %q, <nil>, want _, !<nil>", name, source, got)
+			}
+		})
+	}
+}
+
+func TestRegisterTemplateEngine(t *testing.T) {
+	for _, extension := range []string{"", "star", "text", "foo.bar"} {
+		if err := RegisterTemplateEngine(extension, TextTemplateEngine); err == nil {
+			t.Errorf("RegisterTemplateEngine(%q, TextTemplateEngine) == <nil>, want !<nil>", extension)
+		}
+	}
+}
+
+func TestRegisterTemplateEngineConcurrent(t *testing.T) {
+	// Registering template engines while source names are parsed is not a
+	// data race.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			extension := fmt.Sprintf("concurrent%d", i)
+			if err := RegisterTemplateEngine(extension, StarlarkTemplateEngine); err != nil {
+				t.Errorf("RegisterTemplateEngine(%q, StarlarkTemplateEngine) == %v, want <nil>", extension, err)
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if fa := ParseFileAttributes(fmt.Sprintf("dot_file.concurrent%d.tmpl", i)); !fa.Template {
+			t.Errorf("ParseFileAttributes(_) == %+v, want {Template: true ...}", fa)
+		}
+	}
+	<-done
+	if fa, want := ParseFileAttributes("dot_file.concurrent0.tmpl"), "concurrent0"; fa.Engine != want {
+		t.Errorf("ParseFileAttributes(%q).Engine == %q, want %q", "dot_file.concurrent0.tmpl", fa.Engine, want)
+	}
+}
+
+func TestLookupTemplateEngine(t *testing.T) {
+	for name, want := range map[string]TemplateEngine{
+		"text": TextTemplateEngine,
+		"star": StarlarkTemplateEngine,
+	} {
+		if got, err := LookupTemplateEngine(name); err != nil || got != want {
+			t.Errorf("LookupTemplateEngine(%q) == %v, %v, want %v, <nil>", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"", "unknown"} {
+		if _, err := LookupTemplateEngine(name); err == nil {
+			t.Errorf("LookupTemplateEngine(%q) == _, <nil>, want _, !<nil>", name)
+		}
+	}
+}
+
+func TestTargetStateTemplateEngine(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			".chezmoiignore":  "{{ .ignore }}\n",
+			"dot_bashrc.tmpl": `print("export EMAIL=" + data["email"])`,
+			"dot_vimrc.tmpl":  `print("set tw=" + str(len(data["email"])))`,
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{
+		"email":  "user@home.org",
+		"ignore": ".zshrc",
+	}, nil)
+	ts.TemplateEngine = StarlarkTemplateEngine
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+	}
+	for name, want := range map[string]string{
+		".bashrc": "export EMAIL=user@home.org\n",
+		".vimrc":  "set tw=13\n",
+	} {
+		contents, err := ts.Entries[name].(*File).Contents()
+		if err != nil || string(contents) != want {
+			t.Errorf("ts.Entries[%q].Contents() == %q, %v, want %q, <nil>", name, contents, err, want)
+		}
+	}
+	if !ts.TargetIgnore.Match(".zshrc") {
+		t.Errorf("ts.TargetIgnore.Match(%q) == false, want true", ".zshrc")
+	}
+}
+
+func BenchmarkTextTemplateEngineExecute(b *testing.B) {
+	source := []byte("{{ range .lines }}{{ . }} {{ $.name }}\n{{ end }}")
+	lines := make([]string, 1000)
+	for i := range lines {
+		lines[i] = strings.Repeat("x", 64)
+	}
+	data := map[string]interface{}{
+		"lines": lines,
+		"name":  "user",
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := TextTemplateEngine.Execute("benchmark", source, nil, data); err != nil {
+			b.Fatalf("TextTemplateEngine.Execute(...) == _, %v, want _, <nil>", err)
+		}
+	}
+}
+
+// A testSecretTracker is a SecretTracker that tracks strings.
+type testSecretTracker map[string]bool
+
+func (t testSecretTracker) AddSecret(value interface{}) {
+	if s, ok := value.(string); ok {
+		t[s] = true
+	}
+}
+
+func (t testSecretTracker) ContainsSecret(value interface{}) bool {
+	s, ok := value.(string)
+	if !ok {
+		return false
+	}
+	for secret := range t {
+		if strings.Contains(s, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTemplateEngineSecrets(t *testing.T) {
+	secrets := make(testSecretTracker)
+	funcs := template.FuncMap{
+		"secret": func(key string) string {
+			value := "secret-" + key
+			secrets.AddSecret(value)
+			return value
+		},
+	}
+	// The values derived from secrets by template functions are also secrets,
+	// whichever engine executes the template, but the values derived from
+	// other values are not.
+	for _, tc := range []struct {
+		engine TemplateEngine
+		source string
+	}{
+		{
+			engine: TextTemplateEngine,
+			source: `{{ secret "password" | b64enc }} {{ "public" | b64enc }}`,
+		},
+		{
+			engine: StarlarkTemplateEngine,
+			source: `print(b64enc(secret("password")), b64enc("public"))`,
+		},
+	} {
+		for key := range secrets {
+			delete(secrets, key)
+		}
+		ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{}, funcs)
+		ts.Secrets = secrets
+		output, err := ts.executeTemplateData(nil, tc.engine, "template", []byte(tc.source))
+		if err != nil {
+			t.Fatalf("ts.executeTemplateData(nil, %T, _, %q) == _, %v, want _, <nil>", tc.engine, tc.source, err)
+		}
+		if got, want := strings.TrimSpace(string(output)), "c2VjcmV0LXBhc3N3b3Jk cHVibGlj"; got != want {
+			t.Errorf("ts.executeTemplateData(nil, %T, _, %q) == %q, _, want %q, _", tc.engine, tc.source, got, want)
+		}
+		for value, want := range map[string]bool{
+			"secret-password":      true,
+			"c2VjcmV0LXBhc3N3b3Jk": true,
+			"cHVibGlj":             false,
+		} {
+			if got := secrets[value]; got != want {
+				t.Errorf("%T: secrets[%q] == %v, want %v", tc.engine, value, got, want)
+			}
+		}
+	}
+}
+
+func TestStarlarkResolveFlags(t *testing.T) {
+	// Executing a Starlark template that uses floats, sets, lambdas, nested
+	// functions, and top-level control flow does not change the flags of the
+	// resolve package for other users of go.starlark.net.
+	source := strings.Join([]string{
+		`def f():`,
+		`    g = lambda x: x / 2.0`,
+		`    return g`,
+		`for x in sorted(set([2, 1])):`,
+		`    print(f()(x))`,
+	}, "\n")
+	want := []bool{resolve.AllowFloat, resolve.AllowGlobalReassign, resolve.AllowLambda, resolve.AllowNestedDef, resolve.AllowSet}
+	output, err := StarlarkTemplateEngine.Execute("template", []byte(source), nil, nil)
+	if err != nil {
+		t.Fatalf("StarlarkTemplateEngine.Execute(...) == _, %v, want _, <nil>", err)
+	}
+	if got, want := string(output), "0.5\n1\n"; got != want {
+		t.Errorf("StarlarkTemplateEngine.Execute(...) == %q, _, want %q, _", got, want)
+	}
+	got := []bool{resolve.AllowFloat, resolve.AllowGlobalReassign, resolve.AllowLambda, resolve.AllowNestedDef, resolve.AllowSet}
+	if diff, equal := messagediff.PrettyDiff(want, got); !equal {
+		t.Errorf("resolve flags == %v, want %v, diff:\n%s", got, want, diff)
+	}
+}
@@ -0,0 +1,83 @@
+package chezmoi
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// textTemplateErrorRegexp matches the location in errors returned by
+// text/template, for example
+//
+//	template: name:3:5: executing "name" at <.foo>: map has no entry for key "foo"
+var textTemplateErrorRegexp = regexp.MustCompile(`(?s)\Atemplate: (.*?):(\d+)(?::(\d+))?: (?:executing ".*?" )?(.*)\z`)
+
+// A MultiError is a list of errors, for example every template that failed
+// while evaluating a target state.
+type MultiError []error
+
+// A TemplateError is an error encountered while parsing or executing a
+// template. When returned by a TargetState, Name is the path of the source
+// file relative to the source directory. Line and Column are zero if they are
+// unknown.
+type TemplateError struct {
+	Name   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e MultiError) Error() string {
+	ss := make([]string, len(e))
+	for i, err := range e {
+		ss[i] = err.Error()
+	}
+	return strings.Join(ss, "\n")
+}
+
+// errorOrNil returns nil if errs is empty and errs otherwise.
+func (e MultiError) errorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *TemplateError) Error() string {
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %v", e.Name, e.Err)
+	case e.Column == 0:
+		return fmt.Sprintf("%s:%d: %v", e.Name, e.Line, e.Err)
+	default:
+		return fmt.Sprintf("%s:%d:%d: %v", e.Name, e.Line, e.Column, e.Err)
+	}
+}
+
+// newTextTemplateError returns a TemplateError with the location in err, an
+// error returned by text/template, or err if it contains no location.
+func newTextTemplateError(err error) error {
+	m := textTemplateErrorRegexp.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	te := &TemplateError{
+		Name: m[1],
+		Err:  errors.New(m[4]),
+	}
+	te.Line, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		te.Column, _ = strconv.Atoi(m[3])
+	}
+	return te
+}
+
+// appendError appends err to errs, flattening MultiErrors.
+func appendError(errs MultiError, err error) MultiError {
+	if me, ok := err.(MultiError); ok {
+		return append(errs, me...)
+	}
+	return append(errs, err)
+}
@@ -0,0 +1,84 @@
+package chezmoi
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTemplateErrors(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			".chezmoitemplates/header": "# {{ .email }}\n",
+			"dot_bashrc.tmpl":          "# bashrc\nexport EMAIL={{ .email }}\n",
+			"dot_config": map[string]interface{}{
+				"starship.star.tmpl": "print(\"ok\")\nprint(data[\"email\"])\n",
+			},
+			"dot_gitconfig.tmpl": "[user]\n\t{{ if .name }}\n",
+			"dot_profile.tmpl":   "{{ template \"header\" . }}",
+			"dot_vimrc.tmpl":     "\" vimrc\n{{ includeTemplate \"header\" }}",
+			"dot_zshrc.tmpl":     "{{ include \"missing\" }}",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{}, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+	}
+	errs, ok := ts.Evaluate().(MultiError)
+	if !ok {
+		t.Fatalf("ts.Evaluate() == %v, want MultiError", errs)
+	}
+	var got []TemplateError
+	for _, err := range errs {
+		te, ok := err.(*TemplateError)
+		if !ok {
+			t.Fatalf("%v (%T) is not a *TemplateError", err, err)
+		}
+		got = append(got, TemplateError{
+			Name:   te.Name,
+			Line:   te.Line,
+			Column: te.Column,
+		})
+	}
+	want := []TemplateError{
+		{Name: "dot_bashrc.tmpl", Line: 2, Column: 16},
+		{Name: "dot_config/starship.star.tmpl", Line: 2},
+		{Name: "dot_gitconfig.tmpl", Line: 3},
+		{Name: ".chezmoitemplates/header", Line: 1, Column: 5},
+		{Name: "dot_vimrc.tmpl", Line: 2, Column: 3},
+		{Name: "dot_zshrc.tmpl", Line: 1, Column: 3},
+	}
+	if diff, equal := messagediff.PrettyDiff(want, got); !equal {
+		t.Errorf("ts.Evaluate() == %v, want positions %+v, diff:\n%s", errs, want, diff)
+	}
+	// Errors in included templates report the position in the included
+	// template.
+	if got, want := errs[4].Error(), "dot_vimrc.tmpl:2:3: at <includeTemplate \"header\">: error calling includeTemplate: .chezmoitemplates/header:1:5: at <.email>: map has no entry for key \"email\""; got != want {
+		t.Errorf("errs[4].Error() == %q, want %q", got, want)
+	}
+}
+
+func TestTemplateErrorsInPatterns(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			".chezmoiignore": "{{ .ignore }}\n",
+			".chezmoiremove": "\n{{ .remove }}\n",
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{}, nil)
+	err = ts.Populate(fs)
+	want := ".chezmoiignore:1:3: at <.ignore>: map has no entry for key \"ignore\"\n" +
+		".chezmoiremove:2:3: at <.remove>: map has no entry for key \"remove\""
+	if err == nil || err.Error() != want {
+		t.Errorf("ts.Populate(_) == %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,129 @@
+package chezmoi
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"text/template"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// builtinTemplateFuncs are the names of the template functions that chezmoi
+// defines for every template and that cannot be replaced.
+var builtinTemplateFuncs = map[string]bool{
+	"glob":            true,
+	"include":         true,
+	"includeTemplate": true,
+	"output":          true,
+}
+
+// helperTemplateFuncs are template functions that build paths and that encode
+// and decode structured data. sprig already provides toJson. Like the sprig
+// functions, they can be replaced with AddTemplateFunc.
+var helperTemplateFuncs = template.FuncMap{
+	"fromJson": decodeTemplateFunc("fromJson", "json"),
+	"fromToml": decodeTemplateFunc("fromToml", "toml"),
+	"fromYaml": decodeTemplateFunc("fromYaml", "yaml"),
+	"joinPath": filepath.Join,
+	"toToml":   encodeTemplateFunc("toToml", encodeTOML),
+	"toYaml":   encodeTemplateFunc("toYaml", yaml.Marshal),
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// AddTemplateFunc makes fn available to all templates as name, replacing any
+// sprig function with the same name. It must be called before Populate. fn
+// must be a function that returns a single value, or a value and an error.
+// It is an error if name is already registered.
+func (ts *TargetState) AddTemplateFunc(name string, fn interface{}) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if !isIdentifier(name) {
+		return fmt.Errorf("%q: invalid template function name", name)
+	}
+	if _, ok := ts.TemplateFuncs[name]; ok || builtinTemplateFuncs[name] {
+		return fmt.Errorf("%s: template function already registered", name)
+	}
+	if err := checkTemplateFunc(fn); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	// Copy the existing functions, as ts.TemplateFuncs may be shared with
+	// the caller.
+	funcs := make(template.FuncMap, len(ts.TemplateFuncs)+1)
+	for key, value := range ts.TemplateFuncs {
+		funcs[key] = value
+	}
+	funcs[name] = fn
+	ts.TemplateFuncs = funcs
+	return nil
+}
+
+// checkTemplateFunc returns an error if fn cannot be used as a template
+// function.
+func checkTemplateFunc(fn interface{}) error {
+	t := reflect.TypeOf(fn)
+	if t == nil || t.Kind() != reflect.Func {
+		return fmt.Errorf("%T: not a function", fn)
+	}
+	switch {
+	case t.NumOut() == 1:
+		return nil
+	case t.NumOut() == 2 && t.Out(1) == errorType:
+		return nil
+	default:
+		return fmt.Errorf("%s: must return a single value, or a value and an error", t)
+	}
+}
+
+// decodeTemplateFunc returns a template function called name that decodes a
+// string in format.
+func decodeTemplateFunc(name, format string) func(string) interface{} {
+	return func(s string) interface{} {
+		var value interface{}
+		if err := formats[format]([]byte(s), &value); err != nil {
+			ReturnTemplateFuncError(fmt.Errorf("%s: %v", name, err))
+		}
+		return stringKeys(value)
+	}
+}
+
+// encodeTemplateFunc returns a template function called name that encodes a
+// value with marshal.
+func encodeTemplateFunc(name string, marshal func(interface{}) ([]byte, error)) func(interface{}) string {
+	return func(value interface{}) string {
+		data, err := marshal(value)
+		if err != nil {
+			ReturnTemplateFuncError(fmt.Errorf("%s: %v", name, err))
+		}
+		return string(data)
+	}
+}
+
+// encodeTOML returns value encoded as TOML.
+func encodeTOML(value interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := toml.NewEncoder(buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isIdentifier returns true if name is a valid template identifier.
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+		case i > 0 && unicode.IsDigit(r):
+		default:
+			return false
+		}
+	}
+	return true
+}
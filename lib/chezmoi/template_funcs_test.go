@@ -0,0 +1,191 @@
+package chezmoi
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestAddTemplateFunc(t *testing.T) {
+	funcs := template.FuncMap{
+		"existing": strings.ToUpper,
+	}
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{}, funcs)
+	for _, tc := range []struct {
+		name    string
+		fn      interface{}
+		wantErr bool
+	}{
+		{name: "shout", fn: func(s string) string { return strings.ToUpper(s) + "!" }},
+		{name: "lookup", fn: func(key string) (string, error) { return "", errors.New(key) }},
+		{name: "upper", fn: func(s string) string { return strings.ToLower(s) }},
+		{name: "existing", fn: strings.ToLower, wantErr: true},
+		{name: "include", fn: strings.ToLower, wantErr: true},
+		{name: "includeTemplate", fn: strings.ToLower, wantErr: true},
+		{name: "", fn: strings.ToLower, wantErr: true},
+		{name: "1st", fn: strings.ToLower, wantErr: true},
+		{name: "with-dash", fn: strings.ToLower, wantErr: true},
+		{name: "notFunc", fn: "foo", wantErr: true},
+		{name: "nilFunc", fn: nil, wantErr: true},
+		{name: "noResults", fn: func() {}, wantErr: true},
+		{name: "noError", fn: func() (string, string) { return "", "" }, wantErr: true},
+	} {
+		err := ts.AddTemplateFunc(tc.name, tc.fn)
+		if tc.wantErr && err == nil {
+			t.Errorf("ts.AddTemplateFunc(%q, _) == <nil>, want !<nil>", tc.name)
+		} else if !tc.wantErr && err != nil {
+			t.Errorf("ts.AddTemplateFunc(%q, _) == %v, want <nil>", tc.name, err)
+		}
+	}
+	if len(funcs) != 1 {
+		t.Errorf("len(funcs) == %d, want 1", len(funcs))
+	}
+
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi/foo.tmpl": `{{ shout "hello" }} {{ upper "WORLD" }} {{ existing "x" }}`,
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	got, err := ts.Entries["foo"].(*File).Contents()
+	if err != nil {
+		t.Fatalf("file.Contents() == _, %v, want _, <nil>", err)
+	}
+	if want := "HELLO! world X"; string(got) != want {
+		t.Errorf("file.Contents() == %q, _, want %q, _", got, want)
+	}
+}
+
+func TestOutputTemplateFunc(t *testing.T) {
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{}, nil)
+	for name, tc := range map[string]struct {
+		source  string
+		want    string
+		wantErr bool
+	}{
+		"echo": {source: `{{ output "echo" "hello" "world" }}`, want: "hello world\n"},
+		// $$ is the PID of the shell, so equal outputs mean that the command
+		// was only run once.
+		"cached":    {source: `{{ $a := output "sh" "-c" "echo $$" }}{{ $b := output "sh" "-c" "echo $$" }}{{ eq $a $b }}`, want: "true"},
+		"distinct":  {source: `{{ $a := output "sh" "-c" "echo $$" }}{{ $b := output "sh" "-c" "echo $$ " }}{{ eq $a $b }}`, want: "false"},
+		"failure":   {source: `{{ output "sh" "-c" "echo oops >&2; exit 1" }}`, wantErr: true},
+		"not_found": {source: `{{ output "/nonexistent" }}`, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ts.executeTemplateData(nil, TextTemplateEngine, name, []byte(tc.source))
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ts.executeTemplateData(nil, _, %q, %q) == %q, <nil>, want _, !<nil>", name, tc.source, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ts.executeTemplateData(nil, _, %q, %q) == _, %v, want _, <nil>", name, tc.source, err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("ts.executeTemplateData(nil, _, %q, %q) == %q, <nil>, want %q, <nil>", name, tc.source, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGlobTemplateFunc(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".ssh": map[string]interface{}{
+				"id_rsa":         "private",
+				"id_rsa.pub":     "public",
+				"id_ed25519.pub": "public",
+			},
+			".chezmoi/dot_ssh/authorized_keys.tmpl": `{{ range glob ".ssh/*.pub" }}{{ . }}{{ "\n" }}{{ end }}`,
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{}, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	got, err := ts.Entries[".ssh"].(*Dir).Entries["authorized_keys"].(*File).Contents()
+	if err != nil {
+		t.Fatalf("file.Contents() == _, %v, want _, <nil>", err)
+	}
+	if want := "/home/user/.ssh/id_ed25519.pub\n/home/user/.ssh/id_rsa.pub\n"; string(got) != want {
+		t.Errorf("file.Contents() == %q, _, want %q, _", got, want)
+	}
+	for name, tc := range map[string]struct {
+		source  string
+		want    string
+		wantErr bool
+	}{
+		"absolute":    {source: `{{ glob "/home/user/.ssh/id_rsa*" | join "," }}`, want: "/home/user/.ssh/id_rsa,/home/user/.ssh/id_rsa.pub"},
+		"no_match":    {source: `{{ glob ".fonts/*.ttf" | len }}`, want: "0"},
+		"bad_pattern": {source: `{{ glob "[" }}`, wantErr: true},
+		"outside":     {source: `{{ glob "/etc/*" }}`, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ts.executeTemplateData(fs, TextTemplateEngine, name, []byte(tc.source))
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ts.executeTemplateData(fs, _, %q, %q) == %q, <nil>, want _, !<nil>", name, tc.source, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ts.executeTemplateData(fs, _, %q, %q) == _, %v, want _, <nil>", name, tc.source, err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("ts.executeTemplateData(fs, _, %q, %q) == %q, <nil>, want %q, <nil>", name, tc.source, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHelperTemplateFuncs(t *testing.T) {
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{
+		"git": map[string]interface{}{
+			"name": "John Smith",
+		},
+	}, nil)
+	for name, tc := range map[string]struct {
+		source  string
+		want    string
+		wantErr bool
+	}{
+		"join_path":      {source: `{{ joinPath "/home/user" ".config" "micro/" }}`, want: "/home/user/.config/micro"},
+		"from_json":      {source: `{{ (fromJson "{\"a\": [1, {\"b\": true}]}").a | len }}`, want: "2"},
+		"from_toml":      {source: `{{ (fromToml "[git]\nname = \"John\"").git.name }}`, want: "John"},
+		"from_yaml":      {source: `{{ (fromYaml "git:\n  name: John").git.name }}`, want: "John"},
+		"to_toml":        {source: `{{ toToml . }}`, want: "[git]\n  name = \"John Smith\"\n"},
+		"to_yaml":        {source: `{{ toYaml .git }}`, want: "name: John Smith\n"},
+		"json_roundtrip": {source: `{{ (fromJson (toJson .)).git.name }}`, want: "John Smith"},
+		"invalid_json":   {source: `{{ fromJson "{" }}`, wantErr: true},
+		"invalid_toml":   {source: `{{ fromToml "[" }}`, wantErr: true},
+		"unencodable":    {source: `{{ toToml "string" }}`, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ts.executeTemplateData(nil, TextTemplateEngine, name, []byte(tc.source))
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ts.executeTemplateData(nil, _, %q, %q) == %q, <nil>, want _, !<nil>", name, tc.source, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ts.executeTemplateData(nil, _, %q, %q) == _, %v, want _, <nil>", name, tc.source, err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("ts.executeTemplateData(nil, _, %q, %q) == %q, <nil>, want %q, <nil>", name, tc.source, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package chezmoi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// templateOutputBucket caches the outputs of templates, keyed by the paths of
+// their source files.
+var templateOutputBucket = []byte("templateOutput")
+
+// maxCachedTemplateOutputSize is the size of the largest template output that
+// is cached, so that the persistent state does not grow too large.
+const maxCachedTemplateOutputSize = 64 * 1024
+
+// A templateOutput is the output of a template, with the size and modification
+// time, in Unix nanoseconds, of its source file and the key of the template
+// data when it was executed.
+type templateOutput struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	DataKey string `json:"dataKey"`
+	Output  []byte `json:"output"`
+}
+
+// A TemplateOutputCache caches the outputs of templates, keyed by the paths,
+// sizes, and modification times of their source files and by the template
+// data, so that templates that have not changed are not read or executed
+// again, even by later commands. Outputs are read from a PersistentState and
+// new outputs are held in memory until they are written with Flush. It is safe
+// for concurrent use.
+type TemplateOutputCache struct {
+	mu              sync.Mutex
+	persistentState PersistentState
+	outputs         map[string]*templateOutput
+}
+
+// NewTemplateOutputCache returns a new TemplateOutputCache that reads outputs
+// from persistentState.
+func NewTemplateOutputCache(persistentState PersistentState) *TemplateOutputCache {
+	return &TemplateOutputCache{
+		persistentState: persistentState,
+		outputs:         make(map[string]*templateOutput),
+	}
+}
+
+// Dirty returns true if c has new outputs that have not been written.
+func (c *TemplateOutputCache) Dirty() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.outputs) != 0
+}
+
+// Flush writes the new outputs in c to persistentState, all at once if
+// persistentState supports it.
+func (c *TemplateOutputCache) Flush(persistentState PersistentState) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	paths := make([]string, 0, len(c.outputs))
+	for path := range c.outputs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if err := batchPersistentState(persistentState, func() error {
+		for _, path := range paths {
+			value, err := json.Marshal(c.outputs[path])
+			if err != nil {
+				return err
+			}
+			if err := persistentState.Set(templateOutputBucket, []byte(path), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	c.outputs = make(map[string]*templateOutput)
+	return nil
+}
+
+// get returns the cached output of the template in the source file at path,
+// whose os.FileInfo is info, executed with the template data with key
+// dataKey, and whether it was found.
+func (c *TemplateOutputCache) get(path string, info os.FileInfo, dataKey string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	output, ok := c.outputs[path]
+	if !ok && c.persistentState != nil {
+		value, err := c.persistentState.Get(templateOutputBucket, []byte(path))
+		if err != nil {
+			return nil, false, err
+		}
+		if value != nil {
+			output = &templateOutput{}
+			if err := json.Unmarshal(value, output); err != nil {
+				return nil, false, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+	if output == nil || output.Size != info.Size() || output.ModTime != info.ModTime().UnixNano() || output.DataKey != dataKey {
+		return nil, false, nil
+	}
+	return output.Output, true, nil
+}
+
+// set caches output as the output of the template in the source file at path,
+// whose os.FileInfo is info, executed with the template data with key dataKey.
+// Source files modified in the current second might be modified again without
+// changing their modification time, and large outputs would make the
+// persistent state too large, so neither are cached.
+func (c *TemplateOutputCache) set(path string, info os.FileInfo, dataKey string, output []byte) {
+	if len(output) > maxCachedTemplateOutputSize || info.ModTime().UnixNano() >= time.Now().Truncate(time.Second).UnixNano() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.outputs[path] = &templateOutput{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		DataKey: dataKey,
+		Output:  output,
+	}
+}
+
+// templateDataKey returns a key that changes whenever anything other than its
+// source file that can change the output of a hermetic template changes: the
+// template data, the templates in the .chezmoitemplates directory, and the
+// version. It returns the empty string if the template data cannot be
+// hashed, in which case outputs are not cached.
+func (ts *TargetState) templateDataKey() string {
+	data, err := json.Marshal(ts.Data)
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write(data)
+	names := make([]string, 0, len(ts.Templates))
+	for name := range ts.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "\x00%s\x00%d\x00", name, len(ts.Templates[name]))
+		h.Write(ts.Templates[name])
+	}
+	if ts.Version != nil {
+		fmt.Fprintf(h, "\x00%s", ts.Version)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// volatileTemplateFunc returns a function with the same type as fn that sets
+// *volatile to true before calling fn, so that the outputs of templates that
+// call it are not cached.
+func volatileTemplateFunc(fn interface{}, volatile *bool) interface{} {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func {
+		return fn
+	}
+	return reflect.MakeFunc(value.Type(), func(args []reflect.Value) []reflect.Value {
+		*volatile = true
+		if value.Type().IsVariadic() {
+			return value.CallSlice(args)
+		}
+		return value.Call(args)
+	}).Interface()
+}
@@ -0,0 +1,90 @@
+package chezmoi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTemplateOutputCache(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi/dot_bashrc.tmpl": "# {{ .name }}\n",
+		"/home/user/.chezmoi/dot_hgrc.tmpl":   "{{ include \"hgrc\" }}",
+		"/home/user/.chezmoi/hgrc":            "# hgrc\n",
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	modTime := time.Now().Add(-time.Hour)
+	for _, path := range []string{
+		"/home/user/.chezmoi/dot_bashrc.tmpl",
+		"/home/user/.chezmoi/dot_hgrc.tmpl",
+	} {
+		if err := fs.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("fs.Chtimes(%q, ...) == %v, want <nil>", path, err)
+		}
+	}
+	persistentState := NewMemoryPersistentState()
+	contents := func(name string) map[string]string {
+		ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", map[string]interface{}{"name": name}, nil)
+		ts.TemplateOutputCache = NewTemplateOutputCache(persistentState)
+		if err := ts.Populate(fs); err != nil {
+			t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+		}
+		contents := make(map[string]string)
+		for _, targetName := range []string{".bashrc", ".hgrc"} {
+			data, err := ts.Entries[targetName].(*File).Contents()
+			if err != nil {
+				t.Fatalf("%s: Contents() == _, %v, want _, <nil>", targetName, err)
+			}
+			contents[targetName] = string(data)
+		}
+		if err := ts.TemplateOutputCache.Flush(persistentState); err != nil {
+			t.Fatalf("ts.TemplateOutputCache.Flush(_) == %v, want <nil>", err)
+		}
+		return contents
+	}
+	check := func(name string, want map[string]string) {
+		got := contents(name)
+		for targetName := range want {
+			if got[targetName] != want[targetName] {
+				t.Errorf("contents(%q)[%q] == %q, want %q", name, targetName, got[targetName], want[targetName])
+			}
+		}
+	}
+
+	check("user", map[string]string{".bashrc": "# user\n", ".hgrc": "# hgrc\n"})
+	if value, _ := persistentState.Get(templateOutputBucket, []byte("/home/user/.chezmoi/dot_hgrc.tmpl")); value != nil {
+		t.Errorf("persistentState.Get(%q, %q) == %q, want <nil>", templateOutputBucket, "/home/user/.chezmoi/dot_hgrc.tmpl", value)
+	}
+
+	// Change the sources without changing their sizes or modification times,
+	// so that cached outputs are used without reading the sources. Templates
+	// that include files are always executed.
+	if err := fs.WriteFile("/home/user/.chezmoi/dot_bashrc.tmpl", []byte("; {{ .name }}\n"), 0666); err != nil {
+		t.Fatalf("fs.WriteFile(...) == %v, want <nil>", err)
+	}
+	if err := fs.WriteFile("/home/user/.chezmoi/hgrc", []byte("; hgrc\n"), 0666); err != nil {
+		t.Fatalf("fs.WriteFile(...) == %v, want <nil>", err)
+	}
+	if err := fs.Chtimes("/home/user/.chezmoi/dot_bashrc.tmpl", modTime, modTime); err != nil {
+		t.Fatalf("fs.Chtimes(...) == %v, want <nil>", err)
+	}
+	check("user", map[string]string{".bashrc": "# user\n", ".hgrc": "; hgrc\n"})
+
+	// Changing the template data makes the template be executed again.
+	check("other", map[string]string{".bashrc": "; other\n"})
+
+	// Changing the source and its modification time makes the template be
+	// executed again.
+	if err := fs.WriteFile("/home/user/.chezmoi/dot_bashrc.tmpl", []byte("# {{ .name }}\n"), 0666); err != nil {
+		t.Fatalf("fs.WriteFile(...) == %v, want <nil>", err)
+	}
+	modTime = modTime.Add(time.Minute)
+	if err := fs.Chtimes("/home/user/.chezmoi/dot_bashrc.tmpl", modTime, modTime); err != nil {
+		t.Fatalf("fs.Chtimes(...) == %v, want <nil>", err)
+	}
+	check("other", map[string]string{".bashrc": "# other\n"})
+}
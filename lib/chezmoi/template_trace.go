@@ -0,0 +1,237 @@
+package chezmoi
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// traceFuncName is the name of the template function called by the actions
+// that are added to templates when they are traced.
+const traceFuncName = "_chezmoiTrace"
+
+// A TemplateBranch is a branch taken by a template.
+type TemplateBranch struct {
+	Name   string // The name of the template containing the action.
+	Line   int
+	Column int
+	Action string // "if", "range", or "with".
+	Taken  string // "then" or "else".
+}
+
+// A TemplateTrace records the data keys accessed and the branches taken while
+// executing a template.
+type TemplateTrace struct {
+	Name     string
+	Keys     []string
+	Branches []TemplateBranch
+	keys     map[string]bool
+}
+
+// A TemplateTracer records a TemplateTrace for every template executed by a
+// TargetState. Only text/template templates are traced.
+// It is safe for concurrent use.
+type TemplateTracer struct {
+	Traces []*TemplateTrace
+	mu     sync.Mutex
+}
+
+// A templateTracer adds actions that record a TemplateTrace to the trees of
+// a template.
+type templateTracer struct {
+	trace  *TemplateTrace
+	events []func()
+}
+
+// WriteReport writes a report of the traces in t to w.
+func (t *TemplateTracer) WriteReport(w io.Writer) error {
+	for _, trace := range t.Traces {
+		if _, err := fmt.Fprintln(w, trace.Name); err != nil {
+			return err
+		}
+		if len(trace.Keys) != 0 {
+			if _, err := fmt.Fprintf(w, "  keys: %s\n", strings.Join(trace.Keys, ", ")); err != nil {
+				return err
+			}
+		}
+		// Report each branch once, in the order in which it was first taken,
+		// with the number of times that it was taken.
+		var branches []TemplateBranch
+		count := make(map[TemplateBranch]int)
+		for _, branch := range trace.Branches {
+			if count[branch] == 0 {
+				branches = append(branches, branch)
+			}
+			count[branch]++
+		}
+		for _, branch := range branches {
+			suffix := ""
+			if n := count[branch]; n > 1 {
+				suffix = fmt.Sprintf(" (%d times)", n)
+			}
+			if _, err := fmt.Fprintf(w, "  %s:%d:%d: %s: %s%s\n", branch.Name, branch.Line, branch.Column, branch.Action, branch.Taken, suffix); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newTrace returns a new TemplateTrace for the template name and adds it to t.
+func (t *TemplateTracer) newTrace(name string) *TemplateTrace {
+	trace := &TemplateTrace{
+		Name: name,
+		keys: make(map[string]bool),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Traces = append(t.Traces, trace)
+	return trace
+}
+
+// addKey records that key was accessed.
+func (t *TemplateTrace) addKey(key string) {
+	if t.keys[key] {
+		return
+	}
+	t.keys[key] = true
+	t.Keys = append(t.Keys, key)
+	sort.Strings(t.Keys)
+}
+
+// traceTemplate adds actions to all of the trees associated with tmpl that
+// record the keys accessed and branches taken in trace.
+func traceTemplate(tmpl *template.Template, trace *TemplateTrace) {
+	tt := &templateTracer{
+		trace: trace,
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && t.Tree.Root != nil {
+			tt.traceList(t.Tree, t.Tree.Root, true)
+		}
+	}
+	tmpl.Funcs(template.FuncMap{
+		traceFuncName: tt.record,
+	})
+}
+
+// record records event i.
+func (tt *templateTracer) record(i string) string {
+	if n, err := strconv.Atoi(i); err == nil && n < len(tt.events) {
+		tt.events[n]()
+	}
+	return ""
+}
+
+// newEvent returns a new list containing a single action that calls event
+// when executed. The list is parsed, rather than constructed, so that its
+// nodes can be printed in error messages.
+func (tt *templateTracer) newEvent(event func()) *parse.ListNode {
+	id := len(tt.events)
+	tt.events = append(tt.events, event)
+	trees, err := parse.Parse(traceFuncName, fmt.Sprintf("{{ %s %q }}", traceFuncName, strconv.Itoa(id)), "", "", map[string]interface{}{
+		traceFuncName: tt.record,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return trees[traceFuncName].Root
+}
+
+// newBranchEvent returns a new list containing a single action that records
+// that the branch taken of the action node was taken.
+func (tt *templateTracer) newBranchEvent(tree *parse.Tree, node parse.Node, action, taken string) *parse.ListNode {
+	branch := TemplateBranch{
+		Name:   tree.ParseName,
+		Action: action,
+		Taken:  taken,
+	}
+	location, _ := tree.ErrorContext(node)
+	if components := strings.Split(location, ":"); len(components) >= 3 {
+		branch.Line, _ = strconv.Atoi(components[len(components)-2])
+		branch.Column, _ = strconv.Atoi(components[len(components)-1])
+		branch.Name = strings.Join(components[:len(components)-2], ":")
+	}
+	return tt.newEvent(func() {
+		tt.trace.Branches = append(tt.trace.Branches, branch)
+	})
+}
+
+// traceBranch adds an action to the start of list, creating it if needed,
+// that records that the branch was taken.
+func (tt *templateTracer) traceBranch(tree *parse.Tree, node parse.Node, list *parse.ListNode, action, taken string, rootDot bool) *parse.ListNode {
+	event := tt.newBranchEvent(tree, node, action, taken)
+	if list == nil {
+		return event
+	}
+	tt.traceList(tree, list, rootDot)
+	list.Nodes = append(event.Nodes, list.Nodes...)
+	return list
+}
+
+// traceList adds tracing actions to list. rootDot is true if dot is the
+// template data.
+func (tt *templateTracer) traceList(tree *parse.Tree, list *parse.ListNode, rootDot bool) {
+	var nodes []parse.Node
+	for _, node := range list.Nodes {
+		var pipe *parse.PipeNode
+		switch node := node.(type) {
+		case *parse.ActionNode:
+			pipe = node.Pipe
+		case *parse.IfNode:
+			pipe = node.Pipe
+			node.List = tt.traceBranch(tree, node, node.List, "if", "then", rootDot)
+			node.ElseList = tt.traceBranch(tree, node, node.ElseList, "if", "else", rootDot)
+		case *parse.RangeNode:
+			pipe = node.Pipe
+			node.List = tt.traceBranch(tree, node, node.List, "range", "then", false)
+			node.ElseList = tt.traceBranch(tree, node, node.ElseList, "range", "else", rootDot)
+		case *parse.TemplateNode:
+			pipe = node.Pipe
+		case *parse.WithNode:
+			pipe = node.Pipe
+			node.List = tt.traceBranch(tree, node, node.List, "with", "then", false)
+			node.ElseList = tt.traceBranch(tree, node, node.ElseList, "with", "else", rootDot)
+		}
+		if keys := pipeKeys(pipe, rootDot); len(keys) != 0 {
+			nodes = append(nodes, tt.newEvent(func() {
+				for _, key := range keys {
+					tt.trace.addKey(key)
+				}
+			}).Nodes...)
+		}
+		nodes = append(nodes, node)
+	}
+	list.Nodes = nodes
+}
+
+// pipeKeys returns the data keys accessed by pipe. Fields of dot are only
+// data keys if rootDot is true.
+func pipeKeys(pipe *parse.PipeNode, rootDot bool) []string {
+	if pipe == nil {
+		return nil
+	}
+	var keys []string
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			switch arg := arg.(type) {
+			case *parse.FieldNode:
+				if rootDot {
+					keys = append(keys, strings.Join(arg.Ident, "."))
+				}
+			case *parse.VariableNode:
+				if len(arg.Ident) > 1 && arg.Ident[0] == "$" {
+					keys = append(keys, strings.Join(arg.Ident[1:], "."))
+				}
+			case *parse.PipeNode:
+				keys = append(keys, pipeKeys(arg, rootDot)...)
+			}
+		}
+	}
+	return keys
+}
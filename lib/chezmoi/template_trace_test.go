@@ -0,0 +1,99 @@
+package chezmoi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTemplateTracer(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": map[string]interface{}{
+			".chezmoitemplates/header": "# {{ .email }}\n",
+			"dot_bashrc.tmpl": strings.Join([]string{
+				`{{ template "header" . }}`,
+				`{{ if eq .chezmoi.os "darwin" }}`,
+				`export BROWSER=open`,
+				`{{ else if .work }}`,
+				`export BROWSER=chrome`,
+				`{{ end }}`,
+				`{{ range .paths }}export PATH={{ . }}:$PATH`,
+				`{{ end }}`,
+				`{{ with .editor }}export EDITOR={{ .name }}{{ $.email }}{{ end }}`,
+			}, "\n"),
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	data := map[string]interface{}{
+		"chezmoi": map[string]interface{}{
+			"os": "linux",
+		},
+		"editor": map[string]interface{}{
+			"name": "vim",
+		},
+		"email": "john.smith@company.com",
+		"paths": []string{"/usr/local/bin", "/opt/bin"},
+		"work":  true,
+	}
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", data, nil)
+	ts.Tracer = &TemplateTracer{}
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(_) == %v, want <nil>", err)
+	}
+	if err := ts.Evaluate(); err != nil {
+		t.Fatalf("ts.Evaluate() == %v, want <nil>", err)
+	}
+	if got, want := len(ts.Tracer.Traces), 1; got != want {
+		t.Fatalf("len(ts.Tracer.Traces) == %d, want %d", got, want)
+	}
+	trace := ts.Tracer.Traces[0]
+	want := &TemplateTrace{
+		Name: "dot_bashrc.tmpl",
+		Keys: []string{"chezmoi.os", "editor", "email", "paths", "work"},
+		Branches: []TemplateBranch{
+			{Name: "dot_bashrc.tmpl", Line: 2, Column: 6, Action: "if", Taken: "else"},
+			{Name: "dot_bashrc.tmpl", Line: 4, Column: 11, Action: "if", Taken: "then"},
+			{Name: "dot_bashrc.tmpl", Line: 7, Column: 9, Action: "range", Taken: "then"},
+			{Name: "dot_bashrc.tmpl", Line: 7, Column: 9, Action: "range", Taken: "then"},
+			{Name: "dot_bashrc.tmpl", Line: 9, Column: 8, Action: "with", Taken: "then"},
+		},
+	}
+	trace.keys = nil
+	if diff, equal := messagediff.PrettyDiff(want, trace); !equal {
+		t.Errorf("ts.Tracer.Traces[0] == %+v, want %+v, diff:\n%s", trace, want, diff)
+	}
+	b := &bytes.Buffer{}
+	if err := ts.Tracer.WriteReport(b); err != nil {
+		t.Fatalf("ts.Tracer.WriteReport(_) == %v, want <nil>", err)
+	}
+	wantReport := strings.Join([]string{
+		"dot_bashrc.tmpl",
+		"  keys: chezmoi.os, editor, email, paths, work",
+		"  dot_bashrc.tmpl:2:6: if: else",
+		"  dot_bashrc.tmpl:4:11: if: then",
+		"  dot_bashrc.tmpl:7:9: range: then (2 times)",
+		"  dot_bashrc.tmpl:9:8: with: then",
+		"",
+	}, "\n")
+	if got := b.String(); got != wantReport {
+		t.Errorf("ts.Tracer.WriteReport(_) wrote %q, want %q", got, wantReport)
+	}
+}
+
+func TestTemplateTracerError(t *testing.T) {
+	ts := NewTargetState("/home/user", 0, "/home/user/.chezmoi", map[string]interface{}{}, nil)
+	ts.Tracer = &TemplateTracer{}
+	source := "{{ if true }}{{ if .missing }}{{ end }}{{ end }}"
+	if got, err := ts.executeTemplateData(nil, TextTemplateEngine, "/home/user/.chezmoi/dot_bashrc.tmpl", []byte(source)); err == nil {
+		t.Errorf("ts.executeTemplateData(nil, TextTemplateEngine, _, %q) == %q, <nil>, want _, !<nil>", source, got)
+	}
+	if got, want := len(ts.Tracer.Traces[0].Branches), 1; got != want {
+		t.Errorf("len(ts.Tracer.Traces[0].Branches) == %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,155 @@
+package chezmoi
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// undoLogName is the name of the undo log in a backup directory.
+const undoLogName = "undo.json"
+
+// An UndoLog records the previous state of every target changed by a
+// BackupMutator, in the order in which they were changed.
+type UndoLog struct {
+	DestDir string      `json:"destDir" yaml:"destDir"`
+	Entries []UndoEntry `json:"entries" yaml:"entries"`
+}
+
+// An UndoEntry is the previous state of a target. If Existed is true then the
+// target is restored from the backup directory, unless PermOnly is true, in
+// which case only its permissions changed. Otherwise the target was created
+// and is removed.
+type UndoEntry struct {
+	TargetName string      `json:"targetName" yaml:"targetName"`
+	Existed    bool        `json:"existed" yaml:"existed"`
+	PermOnly   bool        `json:"permOnly,omitempty" yaml:"permOnly,omitempty"`
+	Perm       os.FileMode `json:"perm,omitempty" yaml:"perm,omitempty"`
+}
+
+// LastUndoDir returns the newest backup directory in root that can be undone,
+// or the empty string if there is none.
+func LastUndoDir(fs vfs.FS, root string) (string, error) {
+	infos, err := fs.ReadDir(root)
+	switch {
+	case os.IsNotExist(err):
+		return "", nil
+	case err != nil:
+		return "", err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for _, name := range names {
+		dir := filepath.Join(root, name)
+		if _, err := fs.Lstat(filepath.Join(dir, undoLogName)); err == nil {
+			return dir, nil
+		}
+	}
+	return "", nil
+}
+
+// Rollback undoes the changes recorded in the undo log in backupDir with
+// mutator, restoring their targets to their states before the changes, and
+// then removes the undo log so that the changes cannot be undone twice. Scripts
+// that were run are not undone.
+func Rollback(fs vfs.FS, backupDir string, mutator Mutator) error {
+	data, err := fs.ReadFile(filepath.Join(backupDir, undoLogName))
+	if err != nil {
+		return err
+	}
+	var undoLog UndoLog
+	if err := json.Unmarshal(data, &undoLog); err != nil {
+		return err
+	}
+	for i := len(undoLog.Entries) - 1; i >= 0; i-- {
+		entry := undoLog.Entries[i]
+		targetPath := filepath.Join(undoLog.DestDir, entry.TargetName)
+		switch {
+		case !entry.Existed:
+			if err := mutator.RemoveAll(targetPath); err != nil {
+				return err
+			}
+		case entry.PermOnly:
+			if err := mutator.Chmod(targetPath, entry.Perm); err != nil {
+				return err
+			}
+		default:
+			if err := mutator.RemoveAll(targetPath); err != nil {
+				return err
+			}
+			if err := restore(fs, filepath.Join(backupDir, entry.TargetName), targetPath, mutator); err != nil {
+				return err
+			}
+			if info, err := fs.Lstat(filepath.Join(backupDir, entry.TargetName)); err == nil && info.IsDir() {
+				if err := mutator.Chmod(targetPath, entry.Perm); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return mutator.RemoveAll(filepath.Join(backupDir, undoLogName))
+}
+
+// WriteUndoLog writes the undo log to the backup directory, if anything was
+// changed.
+func (m *BackupMutator) WriteUndoLog() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.undoLog.Entries) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.undoLog, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := vfs.MkdirAll(m.fs, m.backupDir, 0700); err != nil {
+		return err
+	}
+	return m.fs.WriteFile(filepath.Join(m.backupDir, undoLogName), append(data, '\n'), 0600)
+}
+
+// restore copies src, recursively, from a backup directory to dst with mutator.
+func restore(fs vfs.FS, src, dst string, mutator Mutator) error {
+	info, err := fs.Lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Mode().IsRegular():
+		data, err := fs.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		return mutator.WriteFile(dst, data, info.Mode().Perm(), nil)
+	case info.IsDir():
+		if err := mutator.Mkdir(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		infos, err := fs.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if err := restore(fs, filepath.Join(src, info.Name()), filepath.Join(dst, info.Name()), mutator); err != nil {
+				return err
+			}
+		}
+		return nil
+	case info.Mode()&os.ModeType == os.ModeSymlink:
+		linkname, err := fs.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return mutator.WriteSymlink(linkname, dst)
+	default:
+		return nil
+	}
+}
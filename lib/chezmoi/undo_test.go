@@ -0,0 +1,73 @@
+package chezmoi
+
+import (
+	"testing"
+
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestRollback(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".dir": &vfst.Dir{
+				Perm: 0750,
+				Entries: map[string]interface{}{
+					"file": "file",
+				},
+			},
+			".script": &vfst.File{
+				Perm:     0600,
+				Contents: []byte("#!/bin/sh\n"),
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	backupRoot := "/home/user/.cache/chezmoi/undo"
+	if dir, err := LastUndoDir(fs, backupRoot); err != nil || dir != "" {
+		t.Errorf("LastUndoDir(_, %q) == %q, %v, want \"\", <nil>", backupRoot, dir, err)
+	}
+	backupDir := backupRoot + "/20200102T030405.000"
+	m := NewBackupMutator(NewFSMutator(fs, "/home/user"), fs, "/home/user", backupDir)
+	for _, f := range []func() error{
+		func() error { return m.Chmod("/home/user/.script", 0700) },
+		func() error { return m.RemoveAll("/home/user/.dir") },
+		func() error { return m.WriteFile("/home/user/.dir", []byte("not a dir"), 0644, nil) },
+		func() error { return m.Mkdir("/home/user/.new", 0755) },
+		func() error { return m.WriteFile("/home/user/.new/file", []byte("new"), 0644, nil) },
+		m.WriteUndoLog,
+	} {
+		if err := f(); err != nil {
+			t.Fatalf("got %v, want <nil>", err)
+		}
+	}
+	if dir, err := LastUndoDir(fs, backupRoot); err != nil || dir != backupDir {
+		t.Errorf("LastUndoDir(_, %q) == %q, %v, want %q, <nil>", backupRoot, dir, err, backupDir)
+	}
+	if err := Rollback(fs, backupDir, NewFSMutator(fs, "/home/user")); err != nil {
+		t.Fatalf("Rollback(_, %q, _) == %v, want <nil>", backupDir, err)
+	}
+	vfst.RunTests(t, fs, "",
+		vfst.TestPath("/home/user/.dir",
+			vfst.TestIsDir,
+			vfst.TestModePerm(0750),
+		),
+		vfst.TestPath("/home/user/.dir/file",
+			vfst.TestContentsString("file"),
+		),
+		vfst.TestPath("/home/user/.script",
+			vfst.TestModePerm(0600),
+		),
+		vfst.TestPath("/home/user/.new",
+			vfst.TestDoesNotExist,
+		),
+		vfst.TestPath(backupDir+"/"+undoLogName,
+			vfst.TestDoesNotExist,
+		),
+	)
+	if dir, err := LastUndoDir(fs, backupRoot); err != nil || dir != "" {
+		t.Errorf("LastUndoDir(_, %q) == %q, %v, want \"\", <nil>", backupRoot, dir, err)
+	}
+}
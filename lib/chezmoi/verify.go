@@ -0,0 +1,359 @@
+package chezmoi
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// A DriftType is a way in which a target differs from its target state.
+type DriftType string
+
+// Drift types.
+const (
+	DriftMissing       DriftType = "missing"  // The target does not exist.
+	DriftWrongContents DriftType = "contents" // The target has the wrong contents or symlink target.
+	DriftWrongMode     DriftType = "mode"     // The target has the wrong permissions.
+	DriftWrongType     DriftType = "type"     // The target is the wrong type, e.g. a directory instead of a file.
+	DriftExtra         DriftType = "extra"    // The target exists but should not.
+)
+
+// A Drift is a difference between a target and its target state. Want and Got
+// describe the expected and actual types, permissions, or symlink targets.
+// They are never set to the contents of files, which may contain secrets.
+type Drift struct {
+	TargetName string    `json:"targetName" yaml:"targetName"`
+	Type       DriftType `json:"type" yaml:"type"`
+	Want       string    `json:"want,omitempty" yaml:"want,omitempty"`
+	Got        string    `json:"got,omitempty" yaml:"got,omitempty"`
+}
+
+// A VerifyReport is the result of verifying every target in a TargetState.
+type VerifyReport struct {
+	Total  int      `json:"total" yaml:"total"` // Total is the number of targets checked.
+	Drifts []*Drift `json:"drifts" yaml:"drifts"`
+}
+
+// String returns a one-line description of d.
+func (d *Drift) String() string {
+	if d.Want == "" && d.Got == "" {
+		return fmt.Sprintf("%s: %s", d.TargetName, d.Type)
+	}
+	return fmt.Sprintf("%s: %s: want %s, got %s", d.TargetName, d.Type, d.Want, d.Got)
+}
+
+// OK returns true if r contains no drifts.
+func (r *VerifyReport) OK() bool {
+	return len(r.Drifts) == 0
+}
+
+// A VerifySampleOptions contains options for TargetState.VerifySample.
+type VerifySampleOptions struct {
+	Percent  float64          // Percent is the percentage of entries to verify.
+	Seed     int64            // Seed seeds the random sample. Zero means use a random seed.
+	Priority func(Entry) bool // Priority entries are always verified.
+}
+
+// A SampledVerifyResult is the result of verifying a random sample of the
+// entries in a TargetState. A SampledVerifyResult with no differences does not
+// mean that the destination directory matches the full target state.
+type SampledVerifyResult struct {
+	Percent     float64  `json:"percent" yaml:"percent"`
+	Seed        int64    `json:"seed" yaml:"seed"`
+	Total       int      `json:"total" yaml:"total"`
+	SampleSize  int      `json:"sampleSize" yaml:"sampleSize"`
+	Priority    int      `json:"priority" yaml:"priority"`
+	Differences []string `json:"differences" yaml:"differences"`
+}
+
+// Verify checks every file, directory, and symlink in ts against fs without
+// modifying fs and returns a report of the targets that differ, sorted by
+// target name. Targets that would be removed by ts.TargetRemove or from exact
+// directories are reported as extra. Only missing directories, and not their
+// contents, are reported.
+func (ts *TargetState) Verify(fs vfs.FS) (*VerifyReport, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	r := &VerifyReport{
+		Drifts: []*Drift{},
+	}
+	// The entries in missing directories, or in directories that are
+	// another type, are not reported separately.
+	absentDirs := make(map[string]bool)
+	if err := walkSortedEntries(ts.Entries, ts.nameCache.sortedNames(ts.Entries), func(entry Entry) error {
+		if ts.TargetIgnore.Match(entry.TargetName()) {
+			return nil
+		}
+		if parentDir := filepath.Dir(entry.TargetName()); absentDirs[parentDir] {
+			if _, ok := entry.(*Dir); ok {
+				absentDirs[entry.TargetName()] = true
+			}
+			return nil
+		}
+		var drifts []*Drift
+		var err error
+		switch entry := entry.(type) {
+		case *Dir:
+			drifts, err = ts.verifyDir(fs, entry)
+			if len(drifts) != 0 && (drifts[0].Type == DriftMissing || drifts[0].Type == DriftWrongType) {
+				absentDirs[entry.targetName] = true
+			}
+		case *File:
+			drifts, err = ts.verifyFile(fs, entry)
+		case *Symlink:
+			drifts, err = ts.verifySymlink(fs, entry)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r.Total++
+		r.Drifts = append(r.Drifts, drifts...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	removeMutator := NewDryRunMutator()
+	if err := ts.applyRemove(fs, removeMutator); err != nil {
+		return nil, err
+	}
+	for _, o := range removeMutator.Operations {
+		targetName, err := filepath.Rel(ts.DestDir, o.Name)
+		if err != nil {
+			return nil, err
+		}
+		r.Drifts = append(r.Drifts, &Drift{
+			TargetName: targetName,
+			Type:       DriftExtra,
+		})
+	}
+	sort.SliceStable(r.Drifts, func(i, j int) bool {
+		return r.Drifts[i].TargetName < r.Drifts[j].TargetName
+	})
+	return r, nil
+}
+
+// VerifySample verifies a random sample of the files and symlinks in ts
+// against fs without modifying fs. Private files and entries for which
+// verifySampleOptions.Priority returns true are always verified.
+func (ts *TargetState) VerifySample(fs vfs.FS, verifySampleOptions VerifySampleOptions) (*SampledVerifyResult, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	seed := verifySampleOptions.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	var priorityEntries, otherEntries []Entry
+	if err := walkSortedEntries(ts.Entries, ts.nameCache.sortedNames(ts.Entries), func(entry Entry) error {
+		if _, ok := entry.(*Dir); ok || ts.TargetIgnore.Match(entry.TargetName()) {
+			return nil
+		}
+		if isPriorityEntry(entry) || verifySampleOptions.Priority != nil && verifySampleOptions.Priority(entry) {
+			priorityEntries = append(priorityEntries, entry)
+		} else {
+			otherEntries = append(otherEntries, entry)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	n := int(math.Ceil(float64(len(otherEntries)) * verifySampleOptions.Percent / 100))
+	if n > len(otherEntries) {
+		n = len(otherEntries)
+	}
+	sample := priorityEntries
+	for _, i := range rand.New(rand.NewSource(seed)).Perm(len(otherEntries))[:n] {
+		sample = append(sample, otherEntries[i])
+	}
+
+	result := &SampledVerifyResult{
+		Percent:    verifySampleOptions.Percent,
+		Seed:       seed,
+		Total:      len(priorityEntries) + len(otherEntries),
+		SampleSize: len(sample),
+		Priority:   len(priorityEntries),
+	}
+	for _, entry := range sample {
+		mutator := NewAnyMutator(NullMutator)
+		if err := entry.Apply(fs, ts.DestDir, ts.TargetIgnore.Match, ts.Umask, mutator); err != nil {
+			return nil, err
+		}
+		if mutator.Mutated() {
+			result.Differences = append(result.Differences, entry.TargetName())
+		}
+	}
+	sort.Strings(result.Differences)
+	return result, nil
+}
+
+// isPriorityEntry returns true if entry should always be verified.
+func isPriorityEntry(entry Entry) bool {
+	file, ok := entry.(*File)
+	return ok && file.Private()
+}
+
+// fileTypeName returns the name of the type of the file with mode.
+func fileTypeName(mode os.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode.IsDir():
+		return "dir"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "other"
+	}
+}
+
+// verifyDir returns the drifts of d's target in fs. Entries in exact
+// directories that are not in d are extra.
+func (ts *TargetState) verifyDir(fs vfs.FS, d *Dir) ([]*Drift, error) {
+	info, err := fs.Lstat(filepath.Join(ts.DestDir, d.targetName))
+	switch {
+	case os.IsNotExist(err):
+		return []*Drift{{TargetName: d.targetName, Type: DriftMissing}}, nil
+	case err != nil:
+		return nil, err
+	case !info.IsDir():
+		return []*Drift{{TargetName: d.targetName, Type: DriftWrongType, Want: "dir", Got: fileTypeName(info.Mode())}}, nil
+	}
+	var drifts []*Drift
+	if drift := ts.verifyPerm(d.targetName, info, d.Perm); drift != nil {
+		drifts = append(drifts, drift)
+	}
+	if d.Exact {
+		infos, err := fs.ReadDir(filepath.Join(ts.DestDir, d.targetName))
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			targetName := filepath.Join(d.targetName, info.Name())
+			if entry, ok := d.Entries[info.Name()]; ok && !isScript(entry) || ts.TargetIgnore.Match(targetName) {
+				continue
+			}
+			drifts = append(drifts, &Drift{
+				TargetName: targetName,
+				Type:       DriftExtra,
+			})
+		}
+	}
+	return drifts, nil
+}
+
+// verifyFile returns the drifts of f's target in fs.
+func (ts *TargetState) verifyFile(fs vfs.FS, f *File) ([]*Drift, error) {
+	large, sourceInfo, err := f.large()
+	if err != nil {
+		return nil, err
+	}
+	var contents []byte
+	var empty bool
+	if large {
+		empty, err = f.empty()
+	} else {
+		contents, err = f.Contents()
+		empty = isEmpty(contents)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Empty files that are not marked as empty should not exist.
+	wantExists := !empty || f.Empty
+	targetPath := filepath.Join(ts.DestDir, f.targetName)
+	info, err := fs.Lstat(targetPath)
+	switch {
+	case os.IsNotExist(err) && wantExists:
+		return []*Drift{{TargetName: f.targetName, Type: DriftMissing}}, nil
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case !info.Mode().IsRegular():
+		return []*Drift{{TargetName: f.targetName, Type: DriftWrongType, Want: "file", Got: fileTypeName(info.Mode())}}, nil
+	case !wantExists && !f.Create:
+		return []*Drift{{TargetName: f.targetName, Type: DriftExtra}}, nil
+	}
+	var drifts []*Drift
+	// The contents of create-only files are never checked once they exist,
+	// and the contents of unchanged files are not read.
+	if !f.Create {
+		equal, err := ts.verifyFileContents(fs, f, targetPath, info, contents, large, sourceInfo)
+		if err != nil {
+			return nil, err
+		}
+		if !equal {
+			drifts = append(drifts, &Drift{
+				TargetName: f.targetName,
+				Type:       DriftWrongContents,
+			})
+		}
+	}
+	if drift := ts.verifyPerm(f.targetName, info, f.Perm); drift != nil {
+		drifts = append(drifts, drift)
+	}
+	return drifts, nil
+}
+
+// verifyFileContents returns true if the contents of f's target at targetPath
+// in fs, whose Lstat is info, are f's contents. If large is true then f's
+// contents are large and are read from its source file, whose os.FileInfo is
+// sourceInfo, otherwise they are contents.
+func (ts *TargetState) verifyFileContents(fs vfs.FS, f *File, targetPath string, info os.FileInfo, contents []byte, large bool, sourceInfo os.FileInfo) (bool, error) {
+	if large {
+		return f.largeTargetEqual(fs, targetPath, info, sourceInfo)
+	}
+	if unchanged, err := f.unchanged(info, contents, ts.Umask); err != nil || unchanged {
+		return unchanged, err
+	}
+	return f.targetContentsEqual(fs, targetPath, info, contents)
+}
+
+// verifyPerm returns a drift if the permissions in info are not perm with
+// ts.Umask applied, or nil otherwise.
+func (ts *TargetState) verifyPerm(targetName string, info os.FileInfo, perm os.FileMode) *Drift {
+	want := perm &^ ts.Umask
+	if got := info.Mode().Perm(); got != want {
+		return &Drift{
+			TargetName: targetName,
+			Type:       DriftWrongMode,
+			Want:       fmt.Sprintf("%03o", want),
+			Got:        fmt.Sprintf("%03o", got),
+		}
+	}
+	return nil
+}
+
+// verifySymlink returns the drifts of s's target in fs.
+func (ts *TargetState) verifySymlink(fs vfs.FS, s *Symlink) ([]*Drift, error) {
+	linkname, err := s.Linkname()
+	if err != nil {
+		return nil, err
+	}
+	targetPath := filepath.Join(ts.DestDir, s.targetName)
+	info, err := fs.Lstat(targetPath)
+	switch {
+	case os.IsNotExist(err):
+		return []*Drift{{TargetName: s.targetName, Type: DriftMissing}}, nil
+	case err != nil:
+		return nil, err
+	case info.Mode()&os.ModeSymlink == 0:
+		return []*Drift{{TargetName: s.targetName, Type: DriftWrongType, Want: "symlink", Got: fileTypeName(info.Mode())}}, nil
+	}
+	currLinkname, err := fs.Readlink(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	if currLinkname != linkname {
+		return []*Drift{{TargetName: s.targetName, Type: DriftWrongContents, Want: linkname, Got: currLinkname}}, nil
+	}
+	return nil, nil
+}
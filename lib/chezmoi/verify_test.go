@@ -0,0 +1,162 @@
+package chezmoi
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateVerifySample(t *testing.T) {
+	sourceFiles := map[string]interface{}{
+		"private_dot_netrc": "# contents of .netrc\n",
+	}
+	for i := 0; i < 20; i++ {
+		sourceFiles[fmt.Sprintf("file%02d", i)] = fmt.Sprintf("contents of file%02d\n", i)
+	}
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user/.chezmoi": sourceFiles,
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+
+	for _, tc := range []struct {
+		name                string
+		verifySampleOptions VerifySampleOptions
+		wantSampleSize      int
+		wantPriority        int
+	}{
+		{
+			name: "priority_only",
+			verifySampleOptions: VerifySampleOptions{
+				Percent: 0,
+				Seed:    1,
+			},
+			wantSampleSize: 1,
+			wantPriority:   1,
+		},
+		{
+			name: "quarter",
+			verifySampleOptions: VerifySampleOptions{
+				Percent: 25,
+				Seed:    1,
+			},
+			wantSampleSize: 6,
+			wantPriority:   1,
+		},
+		{
+			name: "custom_priority",
+			verifySampleOptions: VerifySampleOptions{
+				Percent: 10,
+				Seed:    1,
+				Priority: func(entry Entry) bool {
+					return entry.TargetName() == "file00"
+				},
+			},
+			wantSampleSize: 4,
+			wantPriority:   2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result1, err := ts.VerifySample(fs, tc.verifySampleOptions)
+			if err != nil {
+				t.Fatalf("ts.VerifySample(...) == _, %v, want _, <nil>", err)
+			}
+			if result1.Total != 21 || result1.SampleSize != tc.wantSampleSize || result1.Priority != tc.wantPriority {
+				t.Errorf("ts.VerifySample(...) == %+v, want Total 21, SampleSize %d, Priority %d", result1, tc.wantSampleSize, tc.wantPriority)
+			}
+			if len(result1.Differences) != tc.wantSampleSize {
+				t.Errorf("len(result1.Differences) == %d, want %d", len(result1.Differences), tc.wantSampleSize)
+			}
+			if !containsString(result1.Differences, ".netrc") {
+				t.Errorf("result1.Differences == %v, want to include .netrc", result1.Differences)
+			}
+			result2, err := ts.VerifySample(fs, tc.verifySampleOptions)
+			if err != nil {
+				t.Fatalf("ts.VerifySample(...) == _, %v, want _, <nil>", err)
+			}
+			if diff, equal := messagediff.PrettyDiff(result1, result2); !equal {
+				t.Errorf("ts.VerifySample(...) is not deterministic, diff:\n%s", diff)
+			}
+		})
+	}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTargetStateVerify(t *testing.T) {
+	fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/home/user": map[string]interface{}{
+			".bashrc": "# contents of .bashrc\n",
+			".profile": &vfst.File{
+				Perm:     0600,
+				Contents: []byte("# old contents of .profile\n"),
+			},
+			".dir":  "# .dir should be a directory\n",
+			".link": &vfst.Symlink{Target: ".profile"},
+			".old":  "# old\n",
+			".exact": map[string]interface{}{
+				"file":  "# contents of file\n",
+				"extra": "# extra\n",
+			},
+			".chezmoi": map[string]interface{}{
+				".chezmoiremove":   ".old\n",
+				"dot_bashrc":       "# contents of .bashrc\n",
+				"dot_profile":      "# contents of .profile\n",
+				"dot_missing":      "# contents of .missing\n",
+				"symlink_dot_link": ".bashrc",
+				"dot_dir": map[string]interface{}{
+					"file": "# contents of file\n",
+				},
+				"exact_dot_exact": map[string]interface{}{
+					"file": "# contents of file\n",
+				},
+				"run_hello": "#!/bin/sh\necho hello\n",
+			},
+		},
+	})
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+	}
+	ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+	if err := ts.Populate(fs); err != nil {
+		t.Fatalf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+	}
+	got, err := ts.Verify(fs)
+	if err != nil {
+		t.Fatalf("ts.Verify(%+v) == _, %v, want _, <nil>", fs, err)
+	}
+	want := &VerifyReport{
+		Total: 7,
+		Drifts: []*Drift{
+			{TargetName: ".dir", Type: DriftWrongType, Want: "dir", Got: "file"},
+			{TargetName: ".exact/extra", Type: DriftExtra},
+			{TargetName: ".link", Type: DriftWrongContents, Want: ".bashrc", Got: ".profile"},
+			{TargetName: ".missing", Type: DriftMissing},
+			{TargetName: ".old", Type: DriftExtra},
+			{TargetName: ".profile", Type: DriftWrongContents},
+			{TargetName: ".profile", Type: DriftWrongMode, Want: "644", Got: "600"},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(want, got); !equal {
+		t.Errorf("ts.Verify(%+v) diff:\n%s", fs, diff)
+	}
+	if got.OK() {
+		t.Errorf("ts.Verify(%+v).OK() == true, want false", fs)
+	}
+}
@@ -0,0 +1,48 @@
+package chezmoi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+	vfs "github.com/twpayne/go-vfs"
+)
+
+// versionFileName is the name of the file in the source directory that
+// contains the minimum version required to use the source state.
+const versionFileName = ".chezmoiversion"
+
+// A TooOldError is returned when the source state requires a newer version.
+type TooOldError struct {
+	Have semver.Version
+	Need semver.Version
+}
+
+func (e *TooOldError) Error() string {
+	return fmt.Sprintf("source state requires version %s or later, running version %s", e.Need, e.Have)
+}
+
+// checkVersion returns an error if ts.Version is older than the version in
+// the .chezmoiversion file in ts.SourceDir.
+func (ts *TargetState) checkVersion(fs vfs.FS) error {
+	path := filepath.Join(ts.SourceDir, versionFileName)
+	data, err := fs.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	need, err := semver.NewVersion(strings.TrimPrefix(strings.TrimSpace(string(data)), "v"))
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if ts.Version != nil && ts.Version.LessThan(*need) {
+		return &TooOldError{
+			Have: *ts.Version,
+			Need: *need,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package chezmoi
+
+import (
+	"testing"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+func TestTargetStateCheckVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		root       interface{}
+		version    *semver.Version
+		wantErr    bool
+		wantTooOld bool
+	}{
+		{
+			name: "no_version_file",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/dot_bashrc": "# contents of .bashrc\n",
+			},
+			version: semver.New("1.0.0"),
+		},
+		{
+			name: "new_enough",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/.chezmoiversion": "1.2.0\n",
+			},
+			version: semver.New("1.2.0"),
+		},
+		{
+			name: "too_old",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/.chezmoiversion": "v1.2.0\n",
+			},
+			version:    semver.New("1.1.9"),
+			wantErr:    true,
+			wantTooOld: true,
+		},
+		{
+			name: "no_running_version",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/.chezmoiversion": "99.0.0\n",
+			},
+		},
+		{
+			name: "invalid",
+			root: map[string]interface{}{
+				"/home/user/.chezmoi/.chezmoiversion": "latest\n",
+			},
+			version: semver.New("1.0.0"),
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, cleanup, err := vfst.NewTestFS(tc.root)
+			defer cleanup()
+			if err != nil {
+				t.Fatalf("vfst.NewTestFS(_) == _, _, %v, want _, _, <nil>", err)
+			}
+			ts := NewTargetState("/home/user", 022, "/home/user/.chezmoi", nil, nil)
+			ts.Version = tc.version
+			err = ts.Populate(fs)
+			if tc.wantErr && err == nil {
+				t.Errorf("ts.Populate(%+v) == <nil>, want !<nil>", fs)
+			} else if !tc.wantErr && err != nil {
+				t.Errorf("ts.Populate(%+v) == %v, want <nil>", fs, err)
+			}
+			if _, gotTooOld := err.(*TooOldError); gotTooOld != tc.wantTooOld {
+				t.Errorf("ts.Populate(%+v) == %v, want a *TooOldError: %v", fs, err, tc.wantTooOld)
+			}
+		})
+	}
+}